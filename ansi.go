@@ -0,0 +1,113 @@
+// This file contains the builtins that style text for terminals with ANSI
+// escape sequences.
+
+package gjson_template
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ansiColors are the colors of the color and style builtins, in the order
+// of their ANSI codes.
+var ansiColors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ansiAttrs are the SGR codes of the other styles.
+var ansiAttrs = map[string]int{
+	"bold":      1,
+	"dim":       2,
+	"italic":    3,
+	"underline": 4,
+	"reverse":   7,
+}
+
+// ansiCode returns the SGR code of a style: an attribute such as "bold", a
+// color such as "red", a bright color such as "bright-red", or a
+// background color such as "bg-red".
+func ansiCode(style string) (int, error) {
+	if code, ok := ansiAttrs[style]; ok {
+		return code, nil
+	}
+	base := 30
+	name := style
+	if c, ok := strings.CutPrefix(name, "bright-"); ok {
+		base, name = 90, c
+	} else if c, ok := strings.CutPrefix(name, "bg-"); ok {
+		base, name = 40, c
+	}
+	for i, color := range ansiColors {
+		if color == name {
+			return base + i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown style %q", style)
+}
+
+// ansiStyle returns text wrapped in the escape sequences applying the
+// styles, or text unchanged if it is empty or if enabled is false. The
+// styles are checked in either case.
+func ansiStyle(styles []string, text string, enabled bool) (string, error) {
+	codes := make([]string, len(styles))
+	for i, style := range styles {
+		code, err := ansiCode(style)
+		if err != nil {
+			return "", err
+		}
+		codes[i] = strconv.Itoa(code)
+	}
+	if !enabled || text == "" || len(codes) == 0 {
+		return text, nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m" + text + "\x1b[0m", nil
+}
+
+// colorEnabled reports whether the styling builtins apply their styles,
+// according to the color option and, by default, the NO_COLOR environment
+// variable.
+func (t *Template) colorEnabled() bool {
+	switch t.option.color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return os.Getenv("NO_COLOR") == ""
+}
+
+// evalStyle implements the styling builtins: {{color "red" x}}, {{bold x}}
+// and {{style "bold,red" x}}, where x may also be piped in.
+func (s *state) evalStyle(name string, vals []gjson.Result) gjson.Result {
+	want := 2
+	if name == "bold" {
+		want = 1
+	}
+	if len(vals) != want {
+		s.errorf("wrong number of args for %s: want %d got %d", name, want, len(vals))
+	}
+	var styles []string
+	switch name {
+	case "bold":
+		styles = []string{"bold"}
+	case "color", "style":
+		if vals[0].Type != gjson.String {
+			s.errorf("%s requires a string style; got %s", name, describe(vals[0]))
+		}
+		styles = strings.FieldsFunc(vals[0].Str, func(r rune) bool { return r == ',' || r == ' ' })
+		if name == "color" && len(styles) != 1 {
+			s.errorf("color requires a single color; got %q", vals[0].Str)
+		}
+	}
+	text, _ := gjsonPrintableValue(vals[len(vals)-1])
+	if !vals[len(vals)-1].Exists() {
+		text = ""
+	}
+	out, err := ansiStyle(styles, text, s.tmpl.colorEnabled())
+	if err != nil {
+		s.errorf("%s: %s", name, err)
+	}
+	return gjson.Parse(jsonString(out))
+}
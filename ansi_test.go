@@ -0,0 +1,68 @@
+package gjson_template
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStyle(t *testing.T) {
+	data := []byte(`{"level":"error","msg":"disk full","code":28}`)
+	tests := []struct {
+		name, option, noColor, input, output string
+		ok                                   bool
+	}{
+		{"color", "", "", `{{color "red" .level}}`, "\x1b[31merror\x1b[0m", true},
+		{"bold", "", "", `{{bold .msg}}`, "\x1b[1mdisk full\x1b[0m", true},
+		{"style", "", "", `{{style "bold, underline bright-yellow bg-blue" .code}}`, "\x1b[1;4;93;44m28\x1b[0m", true},
+		{"pipeline", "", "", `{{.msg | color "green"}}`, "\x1b[32mdisk full\x1b[0m", true},
+		{"empty", "", "", `{{bold .nope}}{{color "red" ""}}`, "", true},
+		{"NO_COLOR", "", "1", `{{color "red" .level}} {{bold .msg}}`, "error disk full", true},
+		{"never", "color=never", "", `{{style "bold,red" .level}}`, "error", true},
+		{"always", "color=always", "1", `{{bold .level}}`, "\x1b[1merror\x1b[0m", true},
+		{"unknown color", "", "", `{{color "pink" .level}}`, "", false},
+		{"unknown color disabled", "color=never", "", `{{style "bold,shiny" .level}}`, "", false},
+		{"two colors", "", "", `{{color "red,blue" .level}}`, "", false},
+		{"missing value", "", "", `{{color "red"}}`, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", test.noColor)
+			tmpl := New(test.name)
+			if test.option != "" {
+				tmpl.Option(test.option)
+			}
+			tmpl = Must(tmpl.Parse(test.input))
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, data)
+			switch {
+			case !test.ok && err == nil:
+				t.Errorf("expected error; got %q", buf.String())
+			case test.ok && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.ok && buf.String() != test.output:
+				t.Errorf("expected %q; got %q", test.output, buf.String())
+			}
+		})
+	}
+}
+
+// TestStyleShadowed tests that functions named color, bold or style added
+// to the template or given to the execution replace the builtins, whatever
+// the color option.
+func TestStyleShadowed(t *testing.T) {
+	hex := map[string]string{"red": "#ff0000"}
+	funcs := FuncMap{"color": func(name string) string { return hex[name] }}
+	for _, option := range []string{"color=always", "color=never"} {
+		tmpl := Must(New("shadowed").Option(option).Funcs(funcs).Parse(`{{color .c}}`))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, []byte(`{"c":"red"}`)); err != nil || buf.String() != "#ff0000" {
+			t.Errorf("%s: expected %q; got %q, %v", option, "#ff0000", buf.String(), err)
+		}
+	}
+	tmpl := Must(New("exec").Option("color=always").Parse(`{{bold .c}}`))
+	var buf bytes.Buffer
+	err := tmpl.ExecuteWithOptions(&buf, []byte(`{"c":"red"}`), ExecOptions{Funcs: FuncMap{"bold": func(s string) string { return "**" + s + "**" }}})
+	if err != nil || buf.String() != "**red**" {
+		t.Errorf("ExecOptions.Funcs: expected %q; got %q, %v", "**red**", buf.String(), err)
+	}
+}
@@ -258,3 +258,51 @@ func BenchmarkParseStdTemplate(b *testing.B) {
 		}
 	}
 }
+
+// Benchmark: Function added with Funcs, called through reflection
+func BenchmarkReflectFunc(b *testing.B) {
+	tmpl := gjsontemplate.Must(gjsontemplate.New("reflect").Funcs(gjsontemplate.FuncMap{
+		"double": func(n int) int { return 2 * n },
+	}).Parse(`{{double .age}}`))
+	var buf bytes.Buffer
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, simpleJSON); err != nil {
+			b.Fatalf("Template execution failed: %v", err)
+		}
+	}
+}
+
+// Benchmark: Function added with RegisterFunc1, called without reflection
+func BenchmarkTypedFunc(b *testing.B) {
+	tmpl := gjsontemplate.New("typed")
+	gjsontemplate.RegisterFunc1(tmpl, "double", func(n int) int { return 2 * n })
+	tmpl = gjsontemplate.Must(tmpl.Parse(`{{double .age}}`))
+	var buf bytes.Buffer
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, simpleJSON); err != nil {
+			b.Fatalf("Template execution failed: %v", err)
+		}
+	}
+}
+
+// Benchmark: Templates invoked concurrently from one set
+func BenchmarkTemplateParallel(b *testing.B) {
+	tmpl := gjsontemplate.Must(gjsontemplate.New("set").Parse(`{{define "name"}}{{.name}}{{end}}{{template "name" .}}`))
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var buf bytes.Buffer
+		for pb.Next() {
+			buf.Reset()
+			if err := tmpl.Lookup("set").Execute(&buf, simpleJSON); err != nil {
+				b.Fatalf("Template execution failed: %v", err)
+			}
+		}
+	})
+}
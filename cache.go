@@ -0,0 +1,145 @@
+// This file contains the caches of parsed templates and compiled
+// expressions.
+
+package gjson_template
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// A Cache holds parsed templates keyed by a hash of their text, so that
+// services rendering many user-supplied templates parse each distinct text
+// once. When it is full, the least recently used template is evicted. A
+// Cache may be used concurrently by multiple goroutines.
+type Cache struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	lru     list.List // of *cacheEntry, most recently used first
+}
+
+type cacheEntry struct {
+	key  [sha256.Size]byte
+	tmpl *Template
+}
+
+// NewCache returns a Cache holding at most capacity templates, which must
+// be positive.
+func NewCache(capacity int) *Cache {
+	if capacity <= 0 {
+		panic("template: NewCache: capacity must be positive")
+	}
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// GetOrParse returns the template parsed from source, parsing it with the
+// functions funcs if it is not in the cache. The template is named after
+// the hash of source. Templates are keyed by their text only, so a Cache
+// should be used with one set of functions. Parse errors are returned and
+// not cached.
+func (c *Cache) GetOrParse(source string, funcs FuncMap) (*Template, error) {
+	key := sha256.Sum256([]byte(source))
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		tmpl := e.Value.(*cacheEntry).tmpl
+		c.mu.Unlock()
+		return tmpl, nil
+	}
+	c.mu.Unlock()
+
+	// Parse without the lock. If another goroutine parses the same text
+	// meanwhile, the first template stored wins.
+	tmpl := New(fmt.Sprintf("%x", key[:8]))
+	if funcs != nil {
+		tmpl.Funcs(funcs)
+	}
+	if _, err := tmpl.Parse(source); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).tmpl, nil
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, tmpl: tmpl})
+	for c.lru.Len() > c.capacity {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.entries, last.Value.(*cacheEntry).key)
+	}
+	return tmpl, nil
+}
+
+// Len returns the number of templates in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// An lruCache holds at most capacity values keyed by strings, such as
+// compiled expressions keyed by their source, evicting the least recently
+// used. It may be used concurrently by multiple goroutines.
+type lruCache[V any] struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // of *lruEntry[V], most recently used first
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](capacity int) *lruCache[V] {
+	return &lruCache[V]{capacity: capacity, entries: make(map[string]*list.Element)}
+}
+
+// get returns the value of key, and whether there is one.
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*lruEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// add sets the value of key, evicting the least recently used value if the
+// cache is full.
+func (c *lruCache[V]) add(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry[V]).value = value
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&lruEntry[V]{key, value})
+	for c.lru.Len() > c.capacity {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.entries, last.Value.(*lruEntry[V]).key)
+	}
+}
+
+// len returns the number of values in the cache.
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,113 @@
+package gjson_template
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	funcs := FuncMap{"shout": strings.ToUpper}
+	a, err := c.GetOrParse(`{{shout .name}}`, funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := a.ExecuteString([]byte(`{"name":"ann"}`)); err != nil || out != "ANN" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	if again, _ := c.GetOrParse(`{{shout .name}}`, funcs); again != a {
+		t.Error("expected the cached template")
+	}
+	b, _ := c.GetOrParse(`b`, nil)
+	// a is more recently used than b, so b is evicted.
+	c.GetOrParse(`{{shout .name}}`, funcs)
+	c.GetOrParse(`c`, nil)
+	if c.Len() != 2 {
+		t.Errorf("expected 2 templates; got %d", c.Len())
+	}
+	if again, _ := c.GetOrParse(`{{shout .name}}`, funcs); again != a {
+		t.Error("recently used template was evicted")
+	}
+	if again, _ := c.GetOrParse(`b`, nil); again == b {
+		t.Error("least recently used template was not evicted")
+	}
+
+	if _, err := c.GetOrParse(`{{.x`, nil); err == nil {
+		t.Error("expected parse error")
+	}
+	if c.Len() != 2 {
+		t.Errorf("parse error cached: %d templates", c.Len())
+	}
+}
+
+func TestCacheParallel(t *testing.T) {
+	c := NewCache(4)
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := fmt.Sprintf("{{.n}}-%d", i%8)
+			tmpl, err := c.GetOrParse(src, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if out, err := tmpl.ExecuteString([]byte(`{"n":1}`)); err != nil || out != fmt.Sprintf("1-%d", i%8) {
+				t.Errorf("got %q, %v", out, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Len() > 4 {
+		t.Errorf("cache exceeds capacity: %d", c.Len())
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("a: got %d, %v", v, ok)
+	}
+	// a is more recently used than b, so b is evicted.
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used value was not evicted")
+	}
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("recently used value was evicted: %d, %v", v, ok)
+	}
+	c.add("c", 4)
+	if v, _ := c.get("c"); v != 4 || c.len() != 2 {
+		t.Errorf("replaced value: got %d with %d values", v, c.len())
+	}
+}
+
+func TestJMESPathCacheBounded(t *testing.T) {
+	data := gjson.Parse(`{"a":[1,2,3]}`)
+	for i := range 2 * jmespathCache.capacity {
+		if _, err := jmespathSearch(fmt.Sprintf("length(a) > `%d`", i), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := jmespathCache.len(); n > jmespathCache.capacity {
+		t.Errorf("cache holds %d expressions; want at most %d", n, jmespathCache.capacity)
+	}
+}
+
+func TestMaskPatternsBounded(t *testing.T) {
+	for i := range 2 * maskPatterns.capacity {
+		if _, err := maskPattern(fmt.Sprintf("x{%d}", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := maskPatterns.len(); n > maskPatterns.capacity {
+		t.Errorf("cache holds %d patterns; want at most %d", n, maskPatterns.capacity)
+	}
+}
@@ -0,0 +1,346 @@
+// This file contains the conversion of CBOR data to JSON.
+
+package gjson_template
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CBOR is a Decoder converting CBOR (RFC 8949) data, such as IoT payloads,
+// to JSON as section 6.1 of the RFC recommends:
+//
+//   - integers and floats become numbers, and big numbers (tags 2 and 3)
+//     numbers of as many digits as needed; NaN and infinities become null;
+//   - byte strings become strings in base64url without padding, or in
+//     base64 or base16 when tagged with the expected encoding (tags 22
+//     and 23);
+//   - maps become objects, whose keys must be text strings or integers;
+//   - undefined becomes null, and other tags are dropped.
+//
+// The data must be exactly one CBOR data item.
+var CBOR Decoder = DecoderFunc(cborToJSON)
+
+// maxCBORDepth bounds the nesting of CBOR arrays, maps and tags.
+const maxCBORDepth = 1000
+
+// Expected encodings of byte strings, set by tags 21 to 23.
+const (
+	cborBase64URL = iota
+	cborBase64
+	cborBase16
+)
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cborToJSON converts a CBOR data item to JSON.
+func cborToJSON(data []byte) ([]byte, error) {
+	d := &cborDecoder{data: data}
+	if err := d.item(0, cborBase64URL); err != nil {
+		return nil, err
+	}
+	if d.off != len(data) {
+		return nil, fmt.Errorf("cbor: unexpected data after the item at offset %d", d.off)
+	}
+	return d.out, nil
+}
+
+// A cborDecoder converts CBOR data items to JSON.
+type cborDecoder struct {
+	data []byte
+	off  int    // offset of the next byte to read
+	out  []byte // JSON written so far
+}
+
+// head reads the head of a data item: its major type, additional
+// information and argument. For indefinite lengths, the argument is 0.
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	if d.off >= len(d.data) {
+		return 0, 0, 0, errCBORTruncated
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info = b>>5, b&0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		n = 1 << (info - 24)
+	case info == 31:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: invalid additional information %d at offset %d", info, d.off-1)
+	}
+	if len(d.data)-d.off < n {
+		return 0, 0, 0, errCBORTruncated
+	}
+	var buf [8]byte
+	copy(buf[8-n:], d.data[d.off:d.off+n])
+	d.off += n
+	return major, info, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// bytes returns the next n bytes of the data.
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.off) < n {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// isBreak reports whether the next byte is the break of an indefinite
+// length item, and consumes it if so.
+func (d *cborDecoder) isBreak() (bool, error) {
+	if d.off >= len(d.data) {
+		return false, errCBORTruncated
+	}
+	if d.data[d.off] == 0xff {
+		d.off++
+		return true, nil
+	}
+	return false, nil
+}
+
+// item converts the next data item, nested depth levels deep, with the
+// expected encoding enc of its byte strings.
+func (d *cborDecoder) item(depth, enc int) error {
+	if depth > maxCBORDepth {
+		return fmt.Errorf("cbor: items nested more than %d levels deep", maxCBORDepth)
+	}
+	start := d.off
+	major, info, arg, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case 0:
+		d.out = strconv.AppendUint(d.out, arg, 10)
+	case 1:
+		d.out = append(d.out, negativeInt(new(big.Int).SetUint64(arg)).String()...)
+	case 2, 3:
+		s, err := d.str(major, info, arg)
+		if err != nil {
+			return err
+		}
+		if major == 2 {
+			d.out = strconv.AppendQuote(d.out, encodeCBORBytes(s, enc))
+			break
+		}
+		if !utf8.Valid(s) {
+			return fmt.Errorf("cbor: invalid UTF-8 in text string at offset %d", start)
+		}
+		d.out = append(d.out, jsonString(string(s))...)
+	case 4:
+		d.out = append(d.out, '[')
+		for i := uint64(0); info == 31 || i < arg; i++ {
+			if info == 31 {
+				end, err := d.isBreak()
+				if err != nil {
+					return err
+				}
+				if end {
+					break
+				}
+			}
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			if err := d.item(depth+1, enc); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, ']')
+	case 5:
+		d.out = append(d.out, '{')
+		for i := uint64(0); info == 31 || i < arg; i++ {
+			if info == 31 {
+				end, err := d.isBreak()
+				if err != nil {
+					return err
+				}
+				if end {
+					break
+				}
+			}
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			if err := d.key(); err != nil {
+				return err
+			}
+			d.out = append(d.out, ':')
+			if err := d.item(depth+1, enc); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, '}')
+	case 6:
+		return d.tagged(depth, enc, arg)
+	case 7:
+		return d.simple(start, info, arg)
+	}
+	return nil
+}
+
+// str returns the content of a byte or text string with the given head,
+// joining the chunks of indefinite length strings.
+func (d *cborDecoder) str(major, info byte, arg uint64) ([]byte, error) {
+	if info != 31 {
+		return d.bytes(arg)
+	}
+	var s []byte
+	for {
+		if end, err := d.isBreak(); err != nil || end {
+			return s, err
+		}
+		at := d.off
+		m, i, n, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || i == 31 {
+			return nil, fmt.Errorf("cbor: invalid chunk of indefinite length string at offset %d", at)
+		}
+		chunk, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+// key converts the next map key, which must be a text string or an
+// integer, to a JSON string.
+func (d *cborDecoder) key() error {
+	start, n := d.off, len(d.out)
+	if d.off < len(d.data) {
+		switch d.data[d.off] >> 5 {
+		case 0, 1:
+			if err := d.item(0, cborBase64URL); err != nil {
+				return err
+			}
+			d.out = strconv.AppendQuote(d.out[:n], string(d.out[n:]))
+			return nil
+		case 3:
+			return d.item(0, cborBase64URL)
+		}
+	}
+	if d.off >= len(d.data) {
+		return errCBORTruncated
+	}
+	return fmt.Errorf("cbor: unsupported map key at offset %d: only text strings and integers are allowed", start)
+}
+
+// tagged converts the content of an item with the given tag.
+func (d *cborDecoder) tagged(depth, enc int, tag uint64) error {
+	switch tag {
+	case 2, 3: // big numbers
+		major, info, arg, err := d.head()
+		if err != nil {
+			return err
+		}
+		if major != 2 {
+			return fmt.Errorf("cbor: big number is not a byte string")
+		}
+		s, err := d.str(major, info, arg)
+		if err != nil {
+			return err
+		}
+		n := new(big.Int).SetBytes(s)
+		if tag == 3 {
+			n = negativeInt(n)
+		}
+		d.out = append(d.out, n.String()...)
+		return nil
+	case 21:
+		enc = cborBase64URL
+	case 22:
+		enc = cborBase64
+	case 23:
+		enc = cborBase16
+	}
+	return d.item(depth+1, enc)
+}
+
+// simple converts a simple value or float.
+func (d *cborDecoder) simple(start int, info byte, arg uint64) error {
+	var f float64
+	switch {
+	case info == 20:
+		d.out = append(d.out, "false"...)
+		return nil
+	case info == 21:
+		d.out = append(d.out, "true"...)
+		return nil
+	case info == 22, info == 23: // null, undefined
+		d.out = append(d.out, "null"...)
+		return nil
+	case info == 25:
+		f = halfToFloat(uint16(arg))
+	case info == 26:
+		f = float64(math.Float32frombits(uint32(arg)))
+	case info == 27:
+		f = math.Float64frombits(arg)
+	default:
+		return fmt.Errorf("cbor: unsupported simple value at offset %d", start)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		d.out = append(d.out, "null"...)
+		return nil
+	}
+	bits := 64
+	if info == 26 {
+		bits = 32
+	}
+	d.out = strconv.AppendFloat(d.out, f, 'g', -1, bits)
+	return nil
+}
+
+// negativeInt returns -1-n, the value of a CBOR negative integer with the
+// argument n.
+func negativeInt(n *big.Int) *big.Int {
+	return n.Neg(n.Add(n, big.NewInt(1)))
+}
+
+// halfToFloat returns the value of an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// encodeCBORBytes encodes the content of a byte string with the expected
+// encoding enc.
+func encodeCBORBytes(b []byte, enc int) string {
+	switch enc {
+	case cborBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	case cborBase16:
+		return fmt.Sprintf("%x", b)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
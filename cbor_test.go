@@ -0,0 +1,102 @@
+package gjson_template
+
+import (
+	hexenc "encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	// Examples from appendix A of RFC 8949.
+	tests := []struct {
+		cbor, json string
+	}{
+		{"00", "0"},
+		{"17", "23"},
+		{"1903e8", "1000"},
+		{"1bffffffffffffffff", "18446744073709551615"},
+		{"c249010000000000000000", "18446744073709551616"},
+		{"3bffffffffffffffff", "-18446744073709551616"},
+		{"c349010000000000000000", "-18446744073709551617"},
+		{"3903e7", "-1000"},
+		{"f93c00", "1"},
+		{"f93e00", "1.5"},
+		{"f90001", "5.960464477539063e-08"},
+		{"fa47c35000", "100000"},
+		{"fb3ff199999999999a", "1.1"},
+		{"f97c00", "null"},
+		{"fb7ff8000000000000", "null"},
+		{"f4", "false"},
+		{"f5", "true"},
+		{"f6", "null"},
+		{"f7", "null"},
+		{"c074323031332d30332d32315432303a30343a30305a", `"2013-03-21T20:04:00Z"`},
+		{"4401020304", `"AQIDBA"`},
+		{"d74401020304", `"01020304"`},
+		{"d6820102", "[1,2]"},
+		{"d6814401020304", `["AQIDBA=="]`},
+		{"6449455446", `"IETF"`},
+		{"62225c", `"\"\\"`},
+		{"83010203", "[1,2,3]"},
+		{"8301820203820405", "[1,[2,3],[4,5]]"},
+		{"a201020304", `{"1":2,"3":4}`},
+		{"a26161016162820203", `{"a":1,"b":[2,3]}`},
+		{"5f42010243030405ff", `"AQIDBAU"`},
+		{"7f657374726561646d696e67ff", `"streaming"`},
+		{"9f018202039f0405ffff", "[1,[2,3],[4,5]]"},
+		{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+	}
+	for _, test := range tests {
+		data, _ := hexenc.DecodeString(test.cbor)
+		got, err := CBOR.DecodeJSON(data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.cbor, err)
+		} else if string(got) != test.json {
+			t.Errorf("%s: expected %s; got %s", test.cbor, test.json, got)
+		}
+	}
+
+	errs := []struct {
+		cbor, err string
+	}{
+		{"", "unexpected end"},
+		{"1903", "unexpected end"},
+		{"8301", "unexpected end"},
+		{"0000", "unexpected data after the item at offset 1"},
+		{"1c", "invalid additional information"},
+		{"a1f601", "unsupported map key"},
+		{"62c328", "invalid UTF-8"},
+		{"5f6161ff", "invalid chunk"},
+		{"f0", "unsupported simple value"},
+		{strings.Repeat("81", maxCBORDepth+2) + "00", "nested more than"},
+		{"5bffffffffffffffff", "unexpected end"},
+	}
+	for _, test := range errs {
+		data, _ := hexenc.DecodeString(test.cbor)
+		if _, err := CBOR.DecodeJSON(data); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q; got %v", test.cbor, test.err, err)
+		}
+	}
+}
+
+func TestExecuteDecoded(t *testing.T) {
+	tmpl := Must(New("sensor").Parse(`{{.id}}: {{.temp}}`))
+	data, _ := hexenc.DecodeString("a2626964637331326474656d70fb4036800000000000") // {"id":"s12","temp":22.5}
+	var buf strings.Builder
+	if err := tmpl.ExecuteDecoded(&buf, data, CBOR); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "s12: 22.5" {
+		t.Errorf("expected %q; got %q", "s12: 22.5", buf.String())
+	}
+	upper := DecoderFunc(func(data []byte) ([]byte, error) {
+		return []byte(strings.ToUpper(string(data))), nil
+	})
+	buf.Reset()
+	if err := Must(New("upper").Parse(`{{.ID}}`)).ExecuteDecoded(&buf, []byte(`{"id":"x"}`), upper); err != nil || buf.String() != "X" {
+		t.Errorf("DecoderFunc: got %q, %v", buf.String(), err)
+	}
+	if err := tmpl.ExecuteDecoded(&buf, []byte{0xff}, CBOR); err == nil || !strings.Contains(err.Error(), "template: sensor: decoding data: cbor:") {
+		t.Errorf("expected a decoding error; got %v", err)
+	}
+}
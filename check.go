@@ -0,0 +1,249 @@
+// This file contains the checks run on parsed templates.
+
+package gjson_template
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/higress-group/gjson_template/parse"
+)
+
+// checkTree runs the parse-time checks on tree, returning the first error.
+// Calls of builtins replaced by added functions, as added reports, are not
+// checked.
+func checkTree(tree *parse.Tree, added func(name string) bool) error {
+	var err error
+	walkCommands(tree.Root, func(cmd *parse.CommandNode, piped bool) {
+		if fn, ok := cmd.Args[0].(*parse.IdentifierNode); ok && added(fn.Ident) {
+			return
+		}
+		if err == nil {
+			err = checkPrintf(tree, cmd, piped)
+		}
+	})
+	return err
+}
+
+// Check reports an error if a template associated with t calls a function
+// that is neither a builtin nor added with [Template.Funcs] or
+// [RegisterFunc1] and its variants. Parse reports such calls itself unless
+// the latefuncs option is set, so Check is meant for templates parsed with
+// it, once all functions are added.
+func (t *Template) Check() error {
+	if t.common == nil {
+		return nil
+	}
+	tmpls := t.templates()
+	trees := make([]*parse.Tree, 0, len(tmpls))
+	for _, tmpl := range tmpls {
+		if tmpl.Tree != nil {
+			trees = append(trees, tmpl.Tree)
+		}
+	}
+	slices.SortFunc(trees, func(a, b *parse.Tree) int { return strings.Compare(a.Name, b.Name) })
+	builtin := builtins()
+	t.muFuncs.RLock()
+	defer t.muFuncs.RUnlock()
+	for _, tree := range trees {
+		var err error
+		walkCommands(tree.Root, func(cmd *parse.CommandNode, _ bool) {
+			for _, arg := range cmd.Args {
+				fn, ok := arg.(*parse.IdentifierNode)
+				if !ok || err != nil || t.parseFuncs[fn.Ident] != nil || builtin[fn.Ident] != nil {
+					continue
+				}
+				location, _ := tree.ErrorContext(fn)
+				err = errorOfKind(ErrUnknownFunction, fmt.Errorf("template: %s: function %q not defined", location, fn.Ident))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkCommands calls fn for each command in node and the nodes within it.
+// piped reports whether the command receives the value of the previous
+// command of its pipeline as its final argument.
+func walkCommands(node parse.Node, fn func(cmd *parse.CommandNode, piped bool)) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			walkCommands(n, fn)
+		}
+	case *parse.ActionNode:
+		walkCommands(node.Pipe, fn)
+	case *parse.IfNode:
+		walkCommands(node.Pipe, fn)
+		walkCommands(node.List, fn)
+		walkCommands(node.ElseList, fn)
+	case *parse.RangeNode:
+		walkCommands(node.Pipe, fn)
+		walkCommands(node.List, fn)
+		walkCommands(node.ElseList, fn)
+	case *parse.WithNode:
+		walkCommands(node.Pipe, fn)
+		walkCommands(node.List, fn)
+		walkCommands(node.ElseList, fn)
+	case *parse.ForNode:
+		walkCommands(node.Pipe, fn)
+		walkCommands(node.List, fn)
+		walkCommands(node.ElseList, fn)
+	case *parse.WhileNode:
+		walkCommands(node.Pipe, fn)
+		walkCommands(node.List, fn)
+		walkCommands(node.ElseList, fn)
+	case *parse.CaptureNode:
+		walkCommands(node.List, fn)
+	case *parse.TryNode:
+		walkCommands(node.List, fn)
+		walkCommands(node.CatchList, fn)
+	case *parse.TemplateNode:
+		walkCommands(node.Pipe, fn)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for i, cmd := range node.Cmds {
+			fn(cmd, i > 0)
+			for _, arg := range cmd.Args {
+				walkCommands(arg, fn)
+			}
+		}
+	case *parse.ChainNode:
+		walkCommands(node.Node, fn)
+	}
+}
+
+// checkPrintf checks a call of printf or sprintf with a constant format
+// against its arguments: their number, and the types of the constant
+// ones. Numbers with an integral value are passed to the formatting as
+// ints, and others as float64s.
+func checkPrintf(tree *parse.Tree, cmd *parse.CommandNode, piped bool) error {
+	fn, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || fn.Ident != "printf" && fn.Ident != "sprintf" || len(cmd.Args) < 2 {
+		return nil
+	}
+	format, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		return nil
+	}
+	errorf := func(n parse.Node, f string, args ...any) error {
+		location, _ := tree.ErrorContext(n)
+		return fmt.Errorf("template: %s: %s: %s", location, fn.Ident, fmt.Sprintf(f, args...))
+	}
+	verbs, indexed, err := printfVerbs(format.Text)
+	if err != nil {
+		return errorf(format, "format %q: %s", format.Text, err)
+	}
+	if indexed {
+		// Explicit argument indexes may use arguments in any order.
+		return nil
+	}
+	args := cmd.Args[2:]
+	n := len(args)
+	if piped {
+		// The piped value is the last argument.
+		n++
+	}
+	if len(verbs) != n {
+		return errorf(format, "format %q needs %d args; got %d", format.Text, len(verbs), n)
+	}
+	for i, arg := range args {
+		kind := constKind(arg)
+		if kind != "" && !verbAccepts(verbs[i], kind) {
+			return errorf(arg, "format %q: %%%c can't print %s %s", format.Text, verbs[i], kind, arg)
+		}
+	}
+	return nil
+}
+
+// printfVerbs returns the verbs of the printf format string, with '*' for
+// a width or precision taken from an argument, and whether the format uses
+// explicit argument indexes.
+func printfVerbs(format string) (verbs []rune, indexed bool, err error) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		// Flags, argument index, width and precision.
+	flags:
+		for ; i < len(format); i++ {
+			switch c := format[i]; {
+			case c == '+' || c == '-' || c == '#' || c == ' ' || c == '0' || c == '.' || '1' <= c && c <= '9':
+			case c == '*':
+				verbs = append(verbs, '*')
+			case c == '[':
+				indexed = true
+			case c == ']' && indexed:
+			default:
+				break flags
+			}
+		}
+		if i == len(format) {
+			return nil, false, fmt.Errorf("missing verb at end of format")
+		}
+		r, size := utf8.DecodeRuneInString(format[i:])
+		if !verbAccepts(r, "") {
+			return nil, false, fmt.Errorf("unknown verb %%%c", r)
+		}
+		verbs = append(verbs, r)
+		i += size - 1
+	}
+	return verbs, indexed, nil
+}
+
+// constKind returns the kind of value the constant n is formatted as:
+// "int", "float", "string" or "bool", or "" if n is not a constant.
+func constKind(n parse.Node) string {
+	switch n := n.(type) {
+	case *parse.NumberNode:
+		if n.IsInt {
+			return "int"
+		}
+		return "float"
+	case *parse.StringNode:
+		return "string"
+	case *parse.BoolNode:
+		return "bool"
+	}
+	return ""
+}
+
+// verbAccepts reports whether the printf verb can format a value of the
+// given kind, or, if kind is empty, whether verb is a known verb.
+func verbAccepts(verb rune, kind string) bool {
+	var kinds string
+	switch verb {
+	case 'v', 'T':
+		return true
+	case 't':
+		kinds = "bool"
+	case 'd', 'o', 'O', 'c', 'U', '*':
+		kinds = "int"
+	case 'b':
+		kinds = "int float"
+	case 'x', 'X':
+		kinds = "int float string"
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		kinds = "float"
+	case 's':
+		kinds = "string"
+	case 'q':
+		kinds = "int string"
+	default:
+		return false
+	}
+	return kind == "" || slices.Contains(strings.Fields(kinds), kind)
+}
@@ -0,0 +1,177 @@
+// Gjsontpl renders a template with JSON data, for debugging templates such
+// as Higress transformation rules offline.
+//
+// Usage:
+//
+//	gjsontpl [flags] template [data.json]
+//	gjsontpl check [-left delim] [-right delim] template...
+//
+// The data is read from the named file, or from the standard input if it
+// is omitted or "-". The flags are:
+//
+//	-strict
+//		make missing paths errors, as the missingkey=error option does
+//	-left, -right
+//		the action delimiters, {{ and }} by default
+//	-name
+//		the name of the template to execute, such as one defined with
+//		{{define}}; the template file itself by default
+//	-o file
+//		write the output to file instead of the standard output
+//
+// The check subcommand parses the templates without executing them and
+// reports, one per line, calls of undefined functions, obviously invalid
+// constant gjson paths, and {{if}} and {{with}} branches that can never
+// run because their condition is a constant. The functions available are
+// the builtins.
+//
+// The exit status is 1 if a template fails to parse or execute, or check
+// finds problems, and 2 for invalid usage, so that the command can gate CI
+// pipelines.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	template "github.com/higress-group/gjson_template"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+var (
+	// errUsage reports invalid usage, with the message already printed.
+	errUsage = errors.New("usage")
+
+	// errIssues reports that check found problems, already printed.
+	errIssues = errors.New("issues found")
+)
+
+// run runs the command with the given arguments and returns its exit
+// status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var err error
+	if len(args) > 0 && args[0] == "check" {
+		err = check(args[1:], stdout, stderr)
+	} else {
+		err = render(args, stdin, stdout, stderr)
+	}
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	case errors.Is(err, errIssues):
+		return 1
+	}
+	fmt.Fprintf(stderr, "gjsontpl: %v\n", err)
+	var execErr template.ExecError
+	if errors.As(err, &execErr) && execErr.Excerpt != "" {
+		fmt.Fprintf(stderr, "\n%s\n", execErr.Excerpt)
+	}
+	return 1
+}
+
+// render implements the command.
+func render(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("gjsontpl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	strict := fs.Bool("strict", false, "make missing paths errors")
+	left := fs.String("left", "", "left action `delimiter`")
+	right := fs.String("right", "", "right action `delimiter`")
+	name := fs.String("name", "", "`name` of the template to execute")
+	output := fs.String("o", "", "write the output to `file`")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: gjsontpl [flags] template [data.json]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	tmpl := template.New(filepath.Base(fs.Arg(0))).Delims(*left, *right)
+	if *strict {
+		tmpl.Option("missingkey=error")
+	}
+	if _, err := tmpl.ParseFiles(fs.Arg(0)); err != nil {
+		return err
+	}
+	if *name != "" {
+		if tmpl = tmpl.Lookup(*name); tmpl == nil {
+			return fmt.Errorf("no template %q in %s", *name, fs.Arg(0))
+		}
+	}
+
+	data, err := readData(fs.Arg(1), stdin)
+	if err != nil {
+		return err
+	}
+	// Render before writing, so that a failed execution leaves no
+	// partial output file.
+	out, err := tmpl.ExecuteBytes(data)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return os.WriteFile(*output, out, 0o666)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// check implements the check subcommand.
+func check(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("gjsontpl check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	left := fs.String("left", "", "left action `delimiter`")
+	right := fs.String("right", "", "right action `delimiter`")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: gjsontpl check [flags] template...\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	var failed bool
+	for _, file := range fs.Args() {
+		// Undefined functions are reported by Lint, with the others, rather
+		// than stopping the parse.
+		tmpl := template.New(filepath.Base(file)).Delims(*left, *right).Option("latefuncs")
+		if _, err := tmpl.ParseFiles(file); err != nil {
+			fmt.Fprintln(stdout, err)
+			failed = true
+			continue
+		}
+		for _, issue := range tmpl.Lint() {
+			fmt.Fprintln(stdout, issue)
+			failed = true
+		}
+	}
+	if failed {
+		return errIssues
+	}
+	return nil
+}
+
+// readData reads the JSON data from the named file, or from stdin if the
+// name is empty or "-".
+func readData(name string, stdin io.Reader) ([]byte, error) {
+	if name == "" || name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tmpl := write("greet.tmpl", `{{define "short"}}hi {{.name}}{{end}}hello {{.name}}{{.missing}}`)
+	delims := write("delims.tmpl", `hello [[.name]]`)
+	data := write("data.json", `{"name":"Ann"}`)
+	out := filepath.Join(dir, "out.txt")
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		status int
+		stdout string
+		stderr string
+	}{
+		{"file", []string{tmpl, data}, "", 0, "hello Ann", ""},
+		{"stdin", []string{tmpl}, `{"name":"Bob"}`, 0, "hello Bob", ""},
+		{"dash", []string{tmpl, "-"}, `{"name":"Bob"}`, 0, "hello Bob", ""},
+		{"name", []string{"-name", "short", tmpl, data}, "", 0, "hi Ann", ""},
+		{"no such name", []string{"-name", "long", tmpl, data}, "", 1, "", `no template "long"`},
+		{"delims", []string{"-left", "[[", "-right", "]]", delims, data}, "", 0, "hello Ann", ""},
+		{"strict", []string{"-strict", tmpl, data}, "", 1, "", `"missing" not found`},
+		{"excerpt", []string{"-strict", tmpl, data}, "", 1, "", "...hi {{.name}}{{end}}hello {{.name}}{{.missing}}\n" + strings.Repeat(" ", 39) + "^"},
+		{"bad data", []string{tmpl}, `nope`, 1, "", "must be a valid JSON"},
+		{"no template", nil, "", 2, "", "usage:"},
+		{"bad flag", []string{"-nope", tmpl}, "", 2, "", "not defined"},
+	}
+	for _, test := range tests {
+		var stdout, stderr strings.Builder
+		status := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr)
+		if status != test.status || stdout.String() != test.stdout || !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%s: got status %d, output %q, errors %q", test.name, status, stdout.String(), stderr.String())
+		}
+	}
+
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-o", out, tmpl, data}, nil, &stdout, &stderr); status != 0 {
+		t.Fatalf("-o: status %d: %s", status, stderr.String())
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "hello Ann" || stdout.Len() != 0 {
+		t.Errorf("-o: got %q, %v; stdout %q", b, err, stdout.String())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	good := write("good.tmpl", `{{if .a}}{{upper .a}}{{else}}none{{end}}`)
+	bad := write("bad.tmpl", "{{frob .a}}{{if true}}x{{else}}y{{end}}{{gjson `a.#(b`}}")
+	broken := write("broken.tmpl", `{{.a`)
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stdout []string
+	}{
+		{"good", []string{"check", good}, 0, nil},
+		{"bad", []string{"check", good, bad}, 1, []string{
+			`bad.tmpl:1:2: function "frob" not defined`,
+			`bad.tmpl:1:31: {{else}} branch is unreachable: the condition of if is always true`,
+			`bad.tmpl:1:47: invalid gjson path "a.#(b": unclosed '('`,
+		}},
+		{"broken", []string{"check", broken}, 1, []string{`template: broken.tmpl:1: unclosed action`}},
+		{"no files", []string{"check"}, 2, nil},
+	}
+	for _, test := range tests {
+		var stdout, stderr strings.Builder
+		status := run(test.args, nil, &stdout, &stderr)
+		got := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+		if stdout.Len() == 0 {
+			got = nil
+		}
+		if status != test.status || strings.Join(got, "|") != strings.Join(test.stdout, "|") {
+			t.Errorf("%s: got status %d, output %q, errors %q", test.name, status, stdout.String(), stderr.String())
+		}
+	}
+}
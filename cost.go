@@ -0,0 +1,181 @@
+// This file contains the static estimation of the cost of executing a
+// template.
+
+package gjson_template
+
+import (
+	"github.com/higress-group/gjson_template/parse"
+)
+
+// A Cost is a static estimate of the work of executing a template,
+// computed from its parse trees without data. It includes the templates
+// invoked with {{template}} and the macros invoked with call and a
+// constant name; the counts include each of them once, however often it
+// is invoked.
+type Cost struct {
+	Nodes     int  // number of nodes, including commands and their arguments
+	Depth     int  // deepest nesting of control actions and invocations
+	Loops     int  // number of range, for and while actions
+	Calls     int  // number of function calls, builtins included
+	Recursive bool // whether a template or macro may invoke itself
+
+	// Score combines the counts into a single figure for comparing
+	// templates: every node counts 1, and every function call 2, times
+	// loopWeight for each loop enclosing it. The score of an invoked
+	// template counts at every invocation, weighted likewise. Recursion,
+	// bounded only by the maxdepth option, is not reflected in the score.
+	Score int
+}
+
+// loopWeight is the factor by which each enclosing loop multiplies the
+// score of a node, assuming loops run about this many times.
+const loopWeight = 10
+
+// maxLoopWeight caps the weight of nodes in deeply nested loops, so the
+// score does not overflow.
+const maxLoopWeight = 1_000_000_000
+
+// EstimateCost returns a static estimate of the cost of executing t, so
+// that programs accepting templates from users can reject or deprioritize
+// expensive ones before executing them. The actual cost depends on the
+// data: the number of iterations of loops in particular.
+func (t *Template) EstimateCost() Cost {
+	c := &coster{tmpl: t, active: make(map[string]bool), memo: make(map[string]Cost)}
+	if t.Tree != nil && t.Root != nil {
+		c.walk(t.Root, 0, 1)
+	}
+	return c.cost
+}
+
+// A coster accumulates the cost of the nodes it walks.
+type coster struct {
+	tmpl   *Template
+	cost   Cost
+	active map[string]bool // templates and macros being walked
+	memo   map[string]Cost // cost of the templates and macros walked
+}
+
+// walk adds the cost of node, at the given nesting depth and with each of
+// its nodes counting weight.
+func (c *coster) walk(node parse.Node, depth, weight int) {
+	if node == nil {
+		return
+	}
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			c.walk(n, depth, weight)
+		}
+		return
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+	}
+	c.node(depth, weight)
+	switch node := node.(type) {
+	case *parse.ActionNode:
+		c.walk(node.Pipe, depth, weight)
+	case *parse.IfNode:
+		c.walkControl(node.Pipe, node.List, node.ElseList, depth, weight, false)
+	case *parse.WithNode:
+		c.walkControl(node.Pipe, node.List, node.ElseList, depth, weight, false)
+	case *parse.RangeNode:
+		c.walkControl(node.Pipe, node.List, node.ElseList, depth, weight, true)
+	case *parse.ForNode:
+		c.walkControl(node.Pipe, node.List, node.ElseList, depth, weight, true)
+	case *parse.WhileNode:
+		c.walkControl(node.Pipe, node.List, node.ElseList, depth, weight, true)
+	case *parse.CaptureNode:
+		c.walk(node.List, depth+1, weight)
+	case *parse.TryNode:
+		c.walk(node.List, depth+1, weight)
+		c.walk(node.CatchList, depth+1, weight)
+	case *parse.TemplateNode:
+		c.walk(node.Pipe, depth, weight)
+		c.invoke(node.Name, depth+1, weight)
+	case *parse.PipeNode:
+		for _, cmd := range node.Cmds {
+			c.walk(cmd, depth, weight)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			c.walk(arg, depth, weight)
+		}
+		if fn, ok := node.Args[0].(*parse.IdentifierNode); ok {
+			c.cost.Calls++
+			c.add(1, weight)
+			if fn.Ident == "call" && len(node.Args) > 1 {
+				if macro, ok := node.Args[1].(*parse.StringNode); ok {
+					c.invoke(macro.Text, depth+1, weight)
+				}
+			}
+		}
+	case *parse.ChainNode:
+		c.walk(node.Node, depth, weight)
+	}
+}
+
+// walkControl adds the cost of a control action and its branches.
+func (c *coster) walkControl(pipe *parse.PipeNode, list, elseList *parse.ListNode, depth, weight int, loop bool) {
+	c.walk(pipe, depth, weight)
+	body := weight
+	if loop {
+		c.cost.Loops++
+		body = min(weight*loopWeight, maxLoopWeight)
+	}
+	c.walk(list, depth+1, body)
+	c.walk(elseList, depth+1, weight)
+}
+
+// invoke adds the cost of invoking the template or macro with the given
+// name. Each one is walked once, with its score then scaled by the weight
+// of every invocation.
+func (c *coster) invoke(name string, depth, weight int) {
+	if c.active[name] {
+		c.cost.Recursive = true
+		return
+	}
+	m, ok := c.memo[name]
+	if !ok {
+		tmpl := c.tmpl.Lookup(name)
+		if tmpl == nil || tmpl.Tree == nil {
+			return
+		}
+		sub := &coster{tmpl: c.tmpl, active: c.active, memo: c.memo}
+		c.active[name] = true
+		sub.walk(tmpl.Root, 0, 1)
+		c.active[name] = false
+		m = sub.cost
+		c.memo[name] = m
+		c.cost.Nodes += m.Nodes
+		c.cost.Loops += m.Loops
+		c.cost.Calls += m.Calls
+		c.cost.Recursive = c.cost.Recursive || m.Recursive
+	}
+	c.cost.Depth = max(c.cost.Depth, depth+m.Depth)
+	c.add(m.Score, weight)
+}
+
+// node counts a node at the given depth and weight.
+func (c *coster) node(depth, weight int) {
+	c.cost.Nodes++
+	c.cost.Depth = max(c.cost.Depth, depth)
+	c.add(1, weight)
+}
+
+// add adds n times weight to the score, saturating at maxCostScore
+// instead of overflowing.
+func (c *coster) add(n, weight int) {
+	if n > (maxCostScore-c.cost.Score)/weight {
+		c.cost.Score = maxCostScore
+		return
+	}
+	c.cost.Score += n * weight
+}
+
+// maxCostScore is the largest score reported.
+const maxCostScore = 1 << 53
@@ -0,0 +1,55 @@
+package gjson_template
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		name, input string
+		cost        Cost
+	}{
+		{"text", `hello`, Cost{Nodes: 1, Score: 1}},
+		{"field", `{{.a}}`, Cost{Nodes: 4, Score: 4}},
+		{"call", `{{len .a}}`, Cost{Nodes: 5, Calls: 1, Score: 6}},
+		{"range", `{{range .a}}x{{end}}`, Cost{Nodes: 5, Depth: 1, Loops: 1, Score: 14}},
+		{"nested range", `{{range .a}}{{range .b}}x{{end}}{{end}}`, Cost{Nodes: 9, Depth: 2, Loops: 2, Score: 144}},
+		{"template", `{{define "t"}}{{len .}}{{end}}{{range .a}}{{template "t" .}}{{end}}{{template "t" .}}`,
+			Cost{Nodes: 17, Depth: 2, Loops: 1, Calls: 1, Score: 114}},
+		{"recursive", `{{define "r"}}{{template "r" .}}{{end}}{{template "r" .}}`, Cost{Nodes: 8, Depth: 1, Recursive: true, Score: 8}},
+		{"macro", `{{macro "m" x}}{{$x}}{{end}}{{call "m" 1}}`, Cost{Nodes: 10, Depth: 1, Calls: 1, Score: 11}},
+	}
+	for _, test := range tests {
+		tmpl, err := New(test.name).Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		if got := tmpl.EstimateCost(); got != test.cost {
+			t.Errorf("%s: expected %+v; got %+v", test.name, test.cost, got)
+		}
+	}
+}
+
+func TestEstimateCostSharedTemplates(t *testing.T) {
+	// Each template invokes the next one twice: the score doubles at each
+	// level, while each template is walked once.
+	var b strings.Builder
+	const levels = 80
+	for i := range levels {
+		fmt.Fprintf(&b, `{{define "t%d"}}{{template "t%d" .}}{{template "t%d" .}}{{end}}`, i, i+1, i+1)
+	}
+	fmt.Fprintf(&b, `{{define "t%d"}}x{{end}}{{template "t0" .}}`, levels)
+	cost := Must(New("shared").Parse(b.String())).EstimateCost()
+	if cost.Score != maxCostScore {
+		t.Errorf("expected saturated score %d; got %d", maxCostScore, cost.Score)
+	}
+	if want := 1 + levels*8 + 4; cost.Nodes != want {
+		t.Errorf("expected %d nodes; got %d", want, cost.Nodes)
+	}
+	if cost.Depth != levels+1 || cost.Recursive {
+		t.Errorf("expected depth %d, not recursive; got %+v", levels+1, cost)
+	}
+}
@@ -0,0 +1,41 @@
+// This file contains the debug output of executions.
+
+package gjson_template
+
+import (
+	"fmt"
+
+	"github.com/higress-group/gjson_template/parse"
+	"github.com/tidwall/gjson"
+)
+
+// maxDebugValue is the length beyond which values are elided in the debug
+// output.
+const maxDebugValue = 80
+
+// debugAction writes a line describing the evaluation of the action node
+// to the debug writer: its location and text, the last path of the data it
+// resolved, dot, and the value of its pipeline.
+func (s *state) debugAction(node *parse.ActionNode, dot, val gjson.Result) {
+	location, context := s.tmpl.ErrorContext(node)
+	line := fmt.Sprintf("%s: %s", location, context)
+	if s.debugPath != "" {
+		line += fmt.Sprintf(" path=%q", s.debugPath)
+	}
+	line += " dot=" + debugValue(dot) + " value=" + debugValue(val) + "\n"
+	if _, err := s.debug.Write([]byte(line)); err != nil {
+		s.writeError(err)
+	}
+}
+
+// debugValue returns the JSON text of v for the debug output, elided if
+// long, or <missing> if v does not exist.
+func debugValue(v gjson.Result) string {
+	if !v.Exists() {
+		return "<missing>"
+	}
+	if len(v.Raw) > maxDebugValue {
+		return v.Raw[:maxDebugValue] + "..."
+	}
+	return v.Raw
+}
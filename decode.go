@@ -0,0 +1,36 @@
+// This file contains the execution of templates with data in formats
+// other than JSON.
+
+package gjson_template
+
+import (
+	"fmt"
+	"io"
+)
+
+// A Decoder converts data in another format, such as CBOR, to JSON, so
+// that templates can render it. See [Template.ExecuteDecoded].
+type Decoder interface {
+	DecodeJSON(data []byte) ([]byte, error)
+}
+
+// The DecoderFunc type is an adapter to allow the use of ordinary
+// functions as Decoders.
+type DecoderFunc func(data []byte) ([]byte, error)
+
+// DecodeJSON calls f(data).
+func (f DecoderFunc) DecodeJSON(data []byte) ([]byte, error) {
+	return f(data)
+}
+
+// ExecuteDecoded converts data to JSON with dec and applies the template
+// to the result, as Execute does:
+//
+//	err := tmpl.ExecuteDecoded(w, payload, template.CBOR)
+func (t *Template) ExecuteDecoded(wr io.Writer, data []byte, dec Decoder) error {
+	doc, err := dec.DecodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("template: %s: decoding data: %w", t.Name(), err)
+	}
+	return t.execute(wr, doc, execOptions{})
+}
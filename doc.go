@@ -113,13 +113,23 @@ data, defined in detail in the corresponding sections that follow.
 		T0 is executed; otherwise, dot is set to the successive elements
 		of the array, slice, or map and T1 is executed.
 
+	{{range gjson "path"}} T1 {{end}}
+		The range is over the value of the GJSON path in dot. When the
+		path is a constant with a query for all matches, such as
+		"items.#(active==true)#.name", the matching elements are visited
+		in place, without building the array of matches. A string
+		constant, such as a raw string `items.#(active==true)#`, is not a
+		path: the range is over a string, as for any other string value,
+		so queries must be written as calls of gjson.
+
 	{{break}}
-		The innermost {{range pipeline}} loop is ended early, stopping the
-		current iteration and bypassing all remaining iterations.
+		The innermost {{range}}, {{for}}, or {{while}} loop is ended early,
+		stopping the current iteration and bypassing all remaining
+		iterations.
 
 	{{continue}}
-		The current iteration of the innermost {{range pipeline}} loop is
-		stopped, and the loop starts the next iteration.
+		The current iteration of the innermost {{range}}, {{for}}, or
+		{{while}} loop is stopped, and the loop starts the next iteration.
 
 	{{template "name"}}
 		The template with the specified name is executed with nil data.
@@ -152,6 +162,50 @@ data, defined in detail in the corresponding sections that follow.
 		the same as writing
 			{{with pipeline}} T1 {{else}}{{with pipeline}} T0 {{end}}{{end}}
 
+	{{for start end}} T1 {{end}}
+	{{for start end step}} T1 {{end}}
+		The arguments, which must evaluate to integers, define a counted
+		loop from start up to but not including end, in increments of
+		step (default 1); a negative step counts down. Dot is set to the
+		counter and T1 is executed for each value. As with range, the
+		counter may be assigned to a variable, as in
+			{{for $i := 0 10 2}} T1 {{end}}
+		and {{break}} and {{continue}} may be used within T1.
+
+	{{for start end step}} T1 {{else}} T0 {{end}}
+		If the loop has no iterations, dot is unaffected and T0 is
+		executed; otherwise T1 is executed for each value.
+
+	{{while pipeline max=N}} T1 {{end}}
+		The pipeline is evaluated before each iteration and, while its
+		value is non-empty, T1 is executed with dot unaffected. The body
+		typically advances the condition by assigning to a variable.
+		The iteration limit N is mandatory; a loop that would run more
+		than N times stops execution with an error. {{break}} and
+		{{continue}} may be used within T1.
+
+	{{while pipeline max=N}} T1 {{else}} T0 {{end}}
+		If the value of the pipeline is initially empty, T0 is executed.
+
+	{{capture $variable}} T1 {{end}}
+		T1 is executed and its output, instead of being written, is
+		stored as a string in the variable, which is declared in the
+		enclosing scope as if by {{$variable := ...}}. The variable is
+		not visible within T1 itself.
+
+	{{try}} T1 {{end}}
+	{{try}} T1 {{catch}} T0 {{end}}
+	{{try}} T1 {{catch $variable}} T0 {{end}}
+		T1 is executed and, if it succeeds, its output is written. If
+		execution of T1 fails, its output is discarded and T0, if
+		present, is executed instead, with the variable (if any) set to
+		the error message. Errors writing the output are not caught.
+
+The keywords break, continue, for, while, capture, try, catch, macro and
+delims are names of functions instead in templates parsed with functions
+of these names, added with Funcs, so that templates written for such
+functions keep working.
+
 
 Arguments
 
@@ -243,8 +297,7 @@ Arguments may evaluate to any type; if they are pointers the implementation
 automatically indirects to the base type when required.
 If an evaluation yields a function value, such as a function-valued
 field of a struct, the function is not invoked automatically, but it
-can be used as a truth value for an if action and the like. To invoke
-it, use the call function, defined below.
+can be used as a truth value for an if action and the like.
 
 Pipelines
 
@@ -352,16 +405,11 @@ Predefined global functions are named as follows.
 		Evaluation proceeds through the arguments left to right
 		and returns when the result is determined.
 	call
-		Returns the result of calling the first argument, which
-		must be a function, with the remaining arguments as parameters.
-		Thus "call .X.Y 1 2" is, in Go notation, dot.X.Y(1, 2) where
-		Y is a func-valued field, map entry, or the like.
-		The first argument must be the result of an evaluation
-		that yields a value of function type (as distinct from
-		a predefined function such as print). The function must
-		return either one or two result values, the second of which
-		is of type error. If the arguments don't match the function
-		or the returned error value is non-nil, execution stops.
+		Executes the macro named by the first argument with the
+		remaining arguments as its parameters and returns its output
+		as a string. Thus "call "badge" "warn" .msg" renders the macro
+		badge with $level set to "warn" and $text to .msg. See Macros
+		below.
 	html
 		Returns the escaped HTML equivalent of the textual
 		representation of its arguments. This function is unavailable
@@ -475,7 +523,8 @@ values, or must be copied with [Template.Clone] or [Template.AddParseTree].
 
 Parse may be called multiple times to assemble the various associated templates;
 see [ParseFiles], [ParseGlob], [Template.ParseFiles] and [Template.ParseGlob]
-for simple ways to parse related templates stored in files.
+for simple ways to parse related templates stored in files, and [ParseReader]
+and [Template.ParseReader] for templates read from an [io.Reader].
 
 A template may be executed directly or through [Template.ExecuteTemplate], which executes
 an associated template identified by name. To invoke our example above, we
@@ -493,5 +542,41 @@ or to invoke a particular template explicitly by name,
 		log.Fatalf("execution failed: %s", err)
 	}
 
+Delimiters
+
+The action delimiters default to "{{" and "}}" and may be changed for a whole
+set of templates with [Template.Delims]. A template text may also set its own
+delimiters with a delims action, which applies to the rest of that text,
+including templates defined in it:
+
+	{{delims "[[" "]]" -}}
+	{"name": "[[.name]]", "greeting": "{{ name }}"}
+
+This lets templates with different kinds of output share one set, for example
+when files parsed with ParseGlob themselves contain "{{" and "}}" literally.
+The delimiters change where the delims action is in the text, so one within
+the body of a define, block or macro applies to its {{end}} and beyond:
+
+	{{define "json"}}{{delims "[[" "]]"}}{"name": "[[.name]]"}[[end]]
+
+Macros
+
+A macro is a named template that declares parameters. It is defined at the
+top level like a template, with a "macro" action listing the names of its
+parameters, and is available in its body as variables:
+
+	{{macro "badge" level text}}[{{$level}}] {{$text}}{{end}}
+
+A macro is executed with the call function, which returns its output as a
+string so that it can be printed or passed on:
+
+	{{call "badge" "warn" .msg}}
+	{{.msg | call "badge" "warn" | upper}}
+
+The number of arguments must match the number of parameters. As in a template
+invocation, the macro does not see the caller's variables, but dot and $ are
+those of the caller. Macros share the namespace of the templates defined with
+"define".
+
 */
 package gjson_template
@@ -0,0 +1,52 @@
+// This file contains the execution of templates over streams of JSON
+// documents.
+
+package gjson_template
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// SetSeparator sets the text that ExecuteEach writes between the outputs
+// of consecutive documents, such as "\n" to render one line per document
+// from templates that do not end with a newline. By default nothing is
+// written between them. The return value is the template, so calls can be
+// chained.
+func (t *Template) SetSeparator(sep string) *Template {
+	t.init()
+	t.separator = sep
+	return t
+}
+
+// ExecuteEach reads newline-delimited JSON (JSON Lines) from r and applies
+// the template to each document in turn, writing the outputs to wr,
+// separated by the text set with [Template.SetSeparator]. Blank lines are
+// skipped. Each document is one execution, as with Execute. Execution
+// stops at the first error, which names the line of the document that
+// caused it, or at the first error reading r.
+func (t *Template) ExecuteEach(wr io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for line, n := 1, 0; ; line++ {
+		doc, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if doc = bytes.TrimSpace(doc); len(doc) > 0 {
+			if n > 0 && t.common != nil && t.separator != "" {
+				if _, err := io.WriteString(wr, t.separator); err != nil {
+					return err
+				}
+			}
+			if err := t.execute(wr, doc, execOptions{}); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			n++
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package gjson_template
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExecuteEach(t *testing.T) {
+	input := "{\"level\":\"info\",\"msg\":\"up\"}\n\n  {\"level\":\"warn\",\"msg\":\"slow\"}\r\n[1,2]"
+	tests := []struct {
+		sep, output string
+	}{
+		{"", "info: upwarn: slow[1,2]: "},
+		{"\n", "info: up\nwarn: slow\n[1,2]: "},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("log").SetSeparator(test.sep).Parse(`{{with .level}}{{.}}{{else}}{{$}}{{end}}: {{.msg}}`))
+		var buf strings.Builder
+		if err := tmpl.ExecuteEach(&buf, strings.NewReader(input)); err != nil {
+			t.Fatalf("sep %q: %v", test.sep, err)
+		}
+		if buf.String() != test.output {
+			t.Errorf("sep %q: expected %q; got %q", test.sep, test.output, buf.String())
+		}
+	}
+
+	tmpl := Must(New("strict").Option("missingkey=error").Parse(`{{.msg}};`))
+	var buf strings.Builder
+	err := tmpl.ExecuteEach(&buf, strings.NewReader("{\"msg\":\"a\"}\n{}\n{\"msg\":\"c\"}\n"))
+	if !errors.Is(err, ErrMissingPath) || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("expected a missing path on line 2; got %v", err)
+	}
+	if buf.String() != "a;" {
+		t.Errorf("expected output of the first document; got %q", buf.String())
+	}
+	if err := tmpl.ExecuteEach(&buf, strings.NewReader("nope\n")); err == nil || !strings.Contains(err.Error(), "line 1: ") {
+		t.Errorf("expected invalid data on line 1; got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// This file contains the kinds of execution errors.
+
+package gjson_template
+
+import "errors"
+
+// The kinds of errors of executions. The errors returned by the Execute
+// methods are ExecErrors whose error, with its own message, matches one of
+// these with errors.Is when it is of that kind:
+//
+//	var execErr template.ExecError
+//	if errors.As(err, &execErr) && errors.Is(err, template.ErrMissingPath) {
+//		// Fall back to the default rendering.
+//	}
+var (
+	// ErrMissingPath reports a path, key or value missing from the data
+	// with the missingkey=error option.
+	ErrMissingPath = errors.New("missing path")
+
+	// ErrUnknownFunction reports a call of a function, template or macro
+	// that is not defined.
+	ErrUnknownFunction = errors.New("unknown function")
+
+	// ErrTypeMismatch reports a value of the wrong type for its use, such
+	// as a number given to range or a string to a function taking an int.
+	ErrTypeMismatch = errors.New("type mismatch")
+
+	// ErrBudgetExceeded reports an execution exceeding a resource limit,
+	// such as the iterations of a while or range loop or the size of the
+	// result of repeat.
+	ErrBudgetExceeded = errors.New("budget exceeded")
+
+	// ErrMaxDepth reports template invocations nested deeper than the
+	// maxdepth option allows.
+	ErrMaxDepth = errors.New("maximum template depth exceeded")
+)
+
+// A kindError is an error of one of the kinds above, with its own message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+// errorOfKind returns err marked as an error of the given kind.
+func errorOfKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
@@ -0,0 +1,85 @@
+package gjson_template
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	kinds := []error{ErrMissingPath, ErrUnknownFunction, ErrTypeMismatch, ErrBudgetExceeded, ErrMaxDepth}
+	tests := []struct {
+		name, input string
+		opts        []string
+		kind        error
+	}{
+		{"missing field", `{{.nope}}`, []string{"missingkey=error"}, ErrMissingPath},
+		{"missing gjson path", `{{gjson "a.b.c"}}`, []string{"missingkey=error"}, ErrMissingPath},
+		{"missing message", `{{t "hello"}}`, []string{"missingkey=error"}, ErrMissingPath},
+		{"unknown template", `{{template "nope" .}}`, nil, ErrUnknownFunction},
+		{"unknown macro", `{{call "nope"}}`, nil, ErrUnknownFunction},
+		{"unknown function", `{{nope 1}}`, []string{"latefuncs"}, ErrUnknownFunction},
+		{"range number", `{{range $i, $v := .n}}{{end}}`, nil, ErrTypeMismatch},
+		{"index string", `{{index .n 0}}`, nil, ErrTypeMismatch},
+		{"builtin arg", `{{padLeft .s "x"}}`, nil, ErrTypeMismatch},
+		{"while budget", `{{while true max=3}}x{{end}}`, nil, ErrBudgetExceeded},
+		{"repeat budget", `{{repeat "x" 1000000000}}`, nil, ErrBudgetExceeded},
+		{"recursion", `{{define "r"}}{{template "r" .}}{{end}}{{template "r" .}}`, []string{"maxdepth=10"}, ErrMaxDepth},
+		{"macro recursion", `{{macro "m"}}{{call "m"}}{{end}}{{call "m"}}`, []string{"maxdepth=10"}, ErrMaxDepth},
+	}
+	for _, test := range tests {
+		tmpl, err := New(test.name).Option(test.opts...).Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		err = tmpl.Execute(io.Discard, []byte(`{"n": 1, "s": "str"}`))
+		var execErr ExecError
+		if !errors.As(err, &execErr) {
+			t.Errorf("%s: expected an ExecError; got %v", test.name, err)
+			continue
+		}
+		for _, kind := range kinds {
+			if got, want := errors.Is(err, kind), kind == test.kind; got != want {
+				t.Errorf("%s: errors.Is(%v, %v) = %t", test.name, err, kind, got)
+			}
+		}
+	}
+
+	// Other errors are of no kind.
+	err := Must(New("fail").Parse(`{{fail "boom"}}`)).Execute(io.Discard, []byte(`{}`))
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			t.Errorf("fail: errors.Is(%v, %v) = true", err, kind)
+		}
+	}
+	err = Must(New("check").Option("latefuncs").Parse(`{{nope}}`)).Check()
+	if !errors.Is(err, ErrUnknownFunction) {
+		t.Errorf("Check: expected ErrUnknownFunction; got %v", err)
+	}
+}
+
+func TestExecErrorExcerpt(t *testing.T) {
+	tests := []struct {
+		name, input  string
+		line, column int
+		excerpt      string
+	}{
+		{"first line", `a {{.x | fail}} b`, 1, 9, "a {{.x | fail}} b\n         ^"},
+		{"later line", "a\n\t{{if .x}}\n\t\t{{fail .x}}{{end}}", 3, 9, "\t\t{{fail .x}}{{end}}\n\t\t       ^"},
+		{"long line", strings.Repeat("x", 50) + `{{fail "y"}}` + strings.Repeat("z", 50), 1, 57,
+			"..." + strings.Repeat("x", 29) + `{{fail "y"}}` + strings.Repeat("z", 31) + "...\n" + strings.Repeat(" ", 39) + "^"},
+	}
+	for _, test := range tests {
+		err := Must(New(test.name).Parse(test.input)).Execute(io.Discard, []byte(`{"x":"boom"}`))
+		var execErr ExecError
+		if !errors.As(err, &execErr) {
+			t.Errorf("%s: expected an ExecError; got %v", test.name, err)
+			continue
+		}
+		if execErr.Line != test.line || execErr.Column != test.column || execErr.Excerpt != test.excerpt {
+			t.Errorf("%s: got %d:%d\n%s\nwant %d:%d\n%s", test.name, execErr.Line, execErr.Column, execErr.Excerpt, test.line, test.column, test.excerpt)
+		}
+	}
+}
@@ -5,19 +5,25 @@
 package gjson_template
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/url"
 	"os"
 	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/higress-group/gjson_template/parse"
 
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 // maxExecDepth specifies the maximum stack depth of templates within
@@ -39,11 +45,24 @@ func initMaxExecDepth() int {
 type state struct {
 	tmpl       *Template
 	wr         io.Writer
-	node       parse.Node   // current node, for errors
-	vars       []variable   // push-down stack of variable values.
-	depth      int          // the height of the stack of executing templates.
-	jsonData   gjson.Result // root JSON data
-	strictMode bool         // whether to error on missing paths
+	node       parse.Node               // current node, for errors
+	vars       []variable               // push-down stack of variable values.
+	depth      int                      // the height of the stack of executing templates.
+	jsonData   gjson.Result             // root JSON data
+	rand       *rand.Rand               // source for shuffle and sample, created on first use
+	ctx        context.Context          // passed to functions taking a context
+	doc        *string                  // document built by emit, or nil
+	smap       *sourceMap               // source map of the output, or nil
+	errKind    error                    // kind of the next error, set by kindErrorf
+	strict     *bool                    // whether missing paths are errors, or nil for the option
+	depthLimit int                      // maximum depth, if positive, set for the execution
+	funcs      map[string]reflect.Value // functions set for the execution, or nil
+	iterations *int                     // range iterations so far, with the maxiterations option
+	debug      io.Writer                // where to describe each action, or nil
+	debugPath  string                   // last path resolved, with debug
+	errs       *[]error                 // errors collected, or nil to stop at the first
+	extra      []variable               // variables of the execution, defined in all templates
+	lang       string                   // language of the t builtin, if set for the execution
 }
 
 // variable holds the dynamic value of a variable such as $, $x etc.
@@ -52,6 +71,47 @@ type variable struct {
 	value gjson.Result
 }
 
+// maxDepth returns the maximum depth of template invocations, set for the
+// execution or by the maxdepth option.
+func (s *state) maxDepth() int {
+	if s.depthLimit > 0 {
+		return s.depthLimit
+	}
+	if n := s.tmpl.option.maxDepth; n > 0 {
+		return n
+	}
+	return maxExecDepth
+}
+
+// language returns the language of the t builtin, set for the execution
+// or by the lang option.
+func (s *state) language() string {
+	if s.lang != "" {
+		return s.lang
+	}
+	return s.tmpl.option.lang
+}
+
+// missingKey returns the action for a missing path: that of the missingkey
+// option, unless the StrictMode of the execution says otherwise.
+func (s *state) missingKey() missingKeyAction {
+	switch {
+	case s.strict == nil:
+		return s.tmpl.option.missingKey
+	case *s.strict:
+		return mapError
+	case s.tmpl.option.missingKey == mapError:
+		return mapInvalid
+	}
+	return s.tmpl.option.missingKey
+}
+
+// strictMissing reports whether a missing path is an error, with the
+// missingkey=error option or the StrictMode of the execution.
+func (s *state) strictMissing() bool {
+	return s.missingKey() == mapError
+}
+
 // push pushes a new variable on the stack.
 func (s *state) push(name string, value gjson.Result) {
 	s.vars = append(s.vars, variable{name, value})
@@ -107,6 +167,19 @@ func isMissing(v reflect.Value) bool {
 	return v.IsValid() && v.Type() == missingValReflectType
 }
 
+// random returns the execution's random source, seeded from the seed option
+// if one is set.
+func (s *state) random() *rand.Rand {
+	if s.rand == nil {
+		seed := rand.Uint64()
+		if s.tmpl.option.seeded {
+			seed = s.tmpl.option.seed
+		}
+		s.rand = rand.New(rand.NewPCG(seed, 0))
+	}
+	return s.rand
+}
+
 // at marks the state to be on node n, for error reporting.
 func (s *state) at(node parse.Node) {
 	s.node = node
@@ -128,6 +201,14 @@ func doublePercent(str string) string {
 type ExecError struct {
 	Name string // Name of template.
 	Err  error  // Pre-formatted error.
+
+	// Line and Column locate the failing action in the template text,
+	// counting lines from 1 and bytes within the line from 0, as the
+	// location in the error message does. Excerpt is the line of the
+	// action followed by a line with a caret under it, for showing to
+	// template authors. They are zero if the error has no location.
+	Line, Column int
+	Excerpt      string
 }
 
 func (e ExecError) Error() string {
@@ -140,17 +221,30 @@ func (e ExecError) Unwrap() error {
 
 // errorf records an ExecError and terminates processing.
 func (s *state) errorf(format string, args ...any) {
+	kind := s.errKind
+	s.errKind = nil
 	name := doublePercent(s.tmpl.Name())
+	execErr := ExecError{Name: s.tmpl.Name()}
 	if s.node == nil {
 		format = fmt.Sprintf("template: %s: %s", name, format)
 	} else {
 		location, context := s.tmpl.ErrorContext(s.node)
 		format = fmt.Sprintf("template: %s: executing %q at <%s>: %s", location, name, doublePercent(context), format)
+		execErr.Line, execErr.Column, execErr.Excerpt = s.tmpl.ErrorExcerpt(s.node)
 	}
-	panic(ExecError{
-		Name: s.tmpl.Name(),
-		Err:  fmt.Errorf(format, args...),
-	})
+	err := fmt.Errorf(format, args...)
+	if kind != nil {
+		err = errorOfKind(kind, err)
+	}
+	execErr.Err = err
+	panic(execErr)
+}
+
+// kindErrorf is like errorf, but marks the error as one of the given kind,
+// such as ErrMissingPath.
+func (s *state) kindErrorf(kind error, format string, args ...any) {
+	s.errKind = kind
+	s.errorf(format, args...)
 }
 
 // writeError is the wrapper type used internally when Execute has an
@@ -189,6 +283,42 @@ func errRecover(errp *error) {
 	}
 }
 
+// ExecInfo describes the state of an execution at the call of a function.
+// A function of a FuncMap whose first parameter, or first after a
+// [context.Context], has type *ExecInfo receives it; the template
+// arguments fill its remaining parameters:
+//
+//	"rel": func(info *ExecInfo, path string) gjson.Result {
+//		if v := info.Dot.Get(path); v.Exists() {
+//			return v
+//		}
+//		return info.Root.Get(path)
+//	}
+type ExecInfo struct {
+	Template string                  // Name of the executing template.
+	Location string                  // Position of the call, as "name:line:col".
+	Root     gjson.Result            // The data the execution started with.
+	Dot      gjson.Result            // The value of dot at the call.
+	Vars     map[string]gjson.Result // The variables in scope, such as "$" and "$x".
+}
+
+// execInfo returns the ExecInfo of a function call with the given dot.
+func (s *state) execInfo(dot gjson.Result) *ExecInfo {
+	info := &ExecInfo{
+		Template: s.tmpl.Name(),
+		Root:     s.jsonData,
+		Dot:      dot,
+		Vars:     make(map[string]gjson.Result, len(s.vars)),
+	}
+	if s.node != nil {
+		info.Location, _ = s.tmpl.ErrorContext(s.node)
+	}
+	for _, v := range s.vars {
+		info.Vars[v.name] = v.value
+	}
+	return info
+}
+
 // ExecuteTemplate applies the template associated with t that has the given name
 // to the specified JSON data and writes the output to wr.
 // If an error occurs executing the template or writing its output,
@@ -197,7 +327,10 @@ func errRecover(errp *error) {
 // A template may be executed safely in parallel, although if parallel
 // executions share a Writer the output may be interleaved.
 func (t *Template) ExecuteTemplate(wr io.Writer, name string, data []byte) error {
-	tmpl := t.Lookup(name)
+	tmpl, err := t.lookupOrLoad(context.Background(), name)
+	if err != nil {
+		return err
+	}
 	if tmpl == nil {
 		return fmt.Errorf("template: no template %q associated with template %q", name, t.name)
 	}
@@ -212,34 +345,341 @@ func (t *Template) ExecuteTemplate(wr io.Writer, name string, data []byte) error
 // A template may be executed safely in parallel, although if parallel
 // executions share a Writer the output may be interleaved.
 func (t *Template) Execute(wr io.Writer, data []byte) error {
-	return t.execute(wr, data)
+	return t.execute(wr, data, execOptions{})
+}
+
+// ExecuteResult is like Execute, but applies the template to JSON data
+// that has already been parsed, so that a document can be parsed once and
+// rendered with several templates. The data is used as is: unlike with
+// Execute, it need not be an object or array.
+func (t *Template) ExecuteResult(wr io.Writer, data gjson.Result) error {
+	return t.execute(wr, nil, execOptions{dot: &data})
 }
 
-func (t *Template) execute(wr io.Writer, data []byte) (err error) {
+// ExecuteBytes is like Execute, but returns the output instead of writing
+// it. If an error occurs, it returns no output.
+func (t *Template) ExecuteBytes(data []byte) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := t.execute(buf, data, execOptions{}); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// ExecuteString is like ExecuteBytes, but returns the output as a string.
+func (t *Template) ExecuteString(data []byte) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := t.execute(buf, data, execOptions{}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// bufferPool holds the buffers of ExecuteBytes and ExecuteString.
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the capacity above which a buffer is not returned to
+// the pool, so that one large output does not stay in memory.
+const maxPooledBuffer = 64 << 10
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// ExecuteContext is like Execute, but passes ctx to the functions of the
+// template's FuncMap whose first parameter is a [context.Context], so that
+// functions doing lookups can honor its deadline and read its values. The
+// template arguments of such a function fill its remaining parameters:
+//
+//	"flag": func(ctx context.Context, name string) (bool, error)
+//
+// is called as {{flag "beta"}}.
+func (t *Template) ExecuteContext(ctx context.Context, wr io.Writer, data []byte) error {
+	return t.execute(wr, data, execOptions{ctx: ctx})
+}
+
+// ExecuteBlock applies the template associated with t that has the given
+// name, typically one defined with {{define}} or {{block}}, to the
+// specified JSON data, so that one parsed text holding several parts, such
+// as the subject, text and HTML bodies of a notification, can be rendered
+// piecemeal. If the top level of t invokes the template, as in
+//
+//	{{block "subject" .order}}Order {{.id}} shipped{{end}}
+//
+// the block is executed with the dot the invocation passes it, here the
+// value of .order; otherwise dot is the data, as with ExecuteTemplate.
+func (t *Template) ExecuteBlock(wr io.Writer, name string, data []byte) error {
+	tmpl := t.Lookup(name)
+	if tmpl == nil {
+		return fmt.Errorf("template: no template %q associated with template %q", name, t.name)
+	}
+	if t.Tree != nil && t.Root != nil {
+		for _, n := range t.Root.Nodes {
+			if n, ok := n.(*parse.TemplateNode); ok && n.Name == name {
+				return t.execute(wr, data, execOptions{node: n})
+			}
+		}
+	}
+	return tmpl.Execute(wr, data)
+}
+
+// ExecuteJSON applies the template to the specified JSON data and returns
+// the JSON document built by its emit actions, instead of writing its text:
+//
+//	{{emit "response.headers.x-id" .trace}}
+//	{{range .items}}{{emit "ids.-1" .id}}{{end}}
+//
+// sets the member x-id of the headers object of the response object to the
+// value of .trace, and appends the id of each item to the ids array, as
+// [sjson] paths do. The document starts as {} and is valid JSON whatever the
+// values, so this is an alternative to writing JSON as text. The text of
+// the template is discarded. The postprocess option applies to the
+// document; output filters do not.
+//
+// [sjson]: https://github.com/tidwall/sjson
+func (t *Template) ExecuteJSON(data []byte) ([]byte, error) {
+	doc := "{}"
+	if err := t.execute(io.Discard, data, execOptions{doc: &doc}); err != nil {
+		return nil, err
+	}
+	return t.postprocess([]byte(doc))
+}
+
+// Validate executes the template with the specified JSON data as a dry
+// run, discarding the output, and returns the first error, so that data
+// can be checked against a template before it is used, as in an admission
+// webhook. The data must be valid JSON, as with the strictjson option.
+// Missing paths are errors, as with the missingkey=error option,
+// and the branches the data leads to are executed with their functions,
+// including ones doing I/O such as httpGet. Emit actions build a document
+// that is discarded, and output filters and the postprocess and encoding
+// options are not applied. Validations are not reported to the observer.
+func (t *Template) Validate(data []byte) error {
+	doc, strict := "{}", true
+	return t.execute(io.Discard, data, execOptions{strict: &strict, doc: &doc, raw: true, seen: true, valid: true})
+}
+
+// ExecuteNode applies the part of the template selected by nodePath to the
+// specified JSON data and writes the output to wr, so that tools can
+// preview or incrementally render sections of a large document.
+//
+// The path is a list of slash-separated steps. The first is the name of a
+// template associated with t, such as one defined with {{define}} or
+// {{block}}, or empty for t itself. Each following step selects within the
+// current node: a number n selects the nth node (counting from 0) of a list
+// of nodes, such as the top level of the template or the body of an
+// {{if}}, {{range}}, or other control action, and "else" or "catch" select
+// the {{else}} or {{catch}} branch of a control action. For example,
+// "/2/else" selects the else branch of the third node of t, and
+// "header/0" the first node of the template named header.
+//
+// The selected part is executed with dot and $ set to the data. Variables
+// declared outside of it are not available.
+func (t *Template) ExecuteNode(wr io.Writer, data []byte, nodePath string) error {
+	name, steps, _ := strings.Cut(nodePath, "/")
+	tmpl := t
+	if name != "" {
+		if tmpl = t.Lookup(name); tmpl == nil {
+			return fmt.Errorf("template: no template %q associated with template %q", name, t.name)
+		}
+	}
+	if tmpl.Tree == nil || tmpl.Root == nil {
+		return fmt.Errorf("template: %q is an incomplete or empty template", tmpl.Name())
+	}
+	var node parse.Node = tmpl.Root
+	if steps != "" {
+		for _, step := range strings.Split(steps, "/") {
+			if node = childNode(node, step); node == nil {
+				return fmt.Errorf("template: %s: no node at %q", tmpl.Name(), nodePath)
+			}
+		}
+	}
+	return tmpl.execute(wr, data, execOptions{node: node})
+}
+
+// childNode returns the node selected by one step of an ExecuteNode path
+// within n, or nil if there is none.
+func childNode(n parse.Node, step string) parse.Node {
+	var list, elseList *parse.ListNode
+	switch n := n.(type) {
+	case *parse.ListNode:
+		i, err := strconv.Atoi(step)
+		if err != nil || i < 0 || i >= len(n.Nodes) {
+			return nil
+		}
+		return n.Nodes[i]
+	case *parse.IfNode:
+		list, elseList = n.List, n.ElseList
+	case *parse.RangeNode:
+		list, elseList = n.List, n.ElseList
+	case *parse.WithNode:
+		list, elseList = n.List, n.ElseList
+	case *parse.ForNode:
+		list, elseList = n.List, n.ElseList
+	case *parse.WhileNode:
+		list, elseList = n.List, n.ElseList
+	case *parse.CaptureNode:
+		list = n.List
+	case *parse.TryNode:
+		if step == "catch" {
+			if n.CatchList == nil {
+				return nil
+			}
+			return n.CatchList
+		}
+		list = n.List
+	default:
+		return nil
+	}
+	if step == "else" {
+		if elseList == nil {
+			return nil
+		}
+		return elseList
+	}
+	return childNode(list, step)
+}
+
+// execOptions holds the settings of one execution.
+type execOptions struct {
+	ctx        context.Context // passed to functions; context.Background if nil
+	node       parse.Node      // part of the template to execute; all of it if nil
+	doc        *string         // document built by emit; nil outside ExecuteJSON
+	raw        bool            // do not apply the output filters
+	smap       *sourceMap      // source map to record; wr must be its writer
+	seen       bool            // the execution is being reported to the observer
+	traced     bool            // the execution is being traced
+	dot        *gjson.Result   // data already parsed, used instead of the bytes
+	valid      bool            // check that the data is valid JSON, as strictjson does
+	vars       []variable      // variables given to the execution, besides $
+	inc        *Incremental    // incremental execution to render, or nil
+	iterations *int            // range iterations so far, shared by executions, or nil
+
+	// Settings of ExecuteWithOptions.
+	strict    *bool                    // whether missing paths are errors, or nil
+	maxDepth  int                      // maximum depth, if positive
+	maxOutput int64                    // maximum output size, if positive
+	funcs     map[string]reflect.Value // functions taking precedence, or nil
+	debug     io.Writer                // where to describe each action, or nil
+	collect   bool                     // collect the errors of actions
+	lang      string                   // language of the t builtin, or "" for the option
+}
+
+// execute applies the template with the settings x.
+func (t *Template) execute(wr io.Writer, data []byte, x execOptions) (err error) {
+	if t.common != nil && t.observer != nil && !x.seen {
+		return t.observe(wr, data, x)
+	}
+	if t.common != nil && t.tracer != nil && !x.traced {
+		return t.trace(wr, data, x)
+	}
+	if x.inc != nil {
+		return x.inc.render(wr, data, x)
+	}
+	if t.hasFilters() && x.doc == nil && !x.raw {
+		var buf bytes.Buffer
+		x.raw = true
+		if err := t.execute(&buf, data, x); err != nil {
+			return err
+		}
+		out, err := t.filter(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if x.maxOutput > 0 {
+			wr = &limitWriter{w: wr, max: x.maxOutput}
+		}
+		_, err = wr.Write(out)
+		return err
+	}
+	var errs *[]error
+	if x.collect {
+		errs = new([]error)
+		defer func() {
+			if len(*errs) > 0 {
+				err = errors.Join(append(*errs, err)...)
+			}
+		}()
+	}
 	defer errRecover(&err)
 
 	// Parse JSON data
-	jsonResult := gjson.ParseBytes(data)
-	if !jsonResult.IsObject() && !jsonResult.IsArray() {
+	var jsonResult gjson.Result
+	if t.option.jsonc && x.dot == nil {
+		data = stripJSONC(data)
+	}
+	if x.dot != nil {
+		jsonResult = *x.dot
+	} else if err := t.checkJSON(data, x.valid); err != nil {
+		return err
+	} else if jsonResult = gjson.ParseBytes(data); !jsonResult.IsObject() && !jsonResult.IsArray() {
 		return fmt.Errorf("template: %s: data must be a valid JSON object or array", t.Name())
 	}
 
+	if x.ctx == nil {
+		x.ctx = context.Background()
+	}
+	extra := t.execVars(x.vars)
 	state := &state{
 		tmpl:       t,
 		wr:         wr,
 		jsonData:   jsonResult,
-		vars:       []variable{{"$", jsonResult}},
-		strictMode: false, // Default to non-strict mode
+		vars:       append([]variable{{"$", jsonResult}}, extra...),
+		extra:      extra,
+		ctx:        x.ctx,
+		doc:        x.doc,
+		smap:       x.smap,
+		strict:     x.strict,
+		depthLimit: x.maxDepth,
+		funcs:      x.funcs,
+		debug:      x.debug,
+		errs:       errs,
+		lang:       x.lang,
+	}
+	if x.maxOutput > 0 {
+		state.wr = &limitWriter{w: wr, max: x.maxOutput}
+	}
+	if state.iterations = x.iterations; state.iterations == nil && t.option.maxIterations > 0 {
+		state.iterations = new(int)
 	}
 
 	if t.Tree == nil || t.Root == nil {
 		state.errorf("%q is an incomplete or empty template", t.Name())
 	}
 
-	state.walk(jsonResult, t.Root)
+	node := x.node
+	if node == nil {
+		node = t.Root
+	}
+	state.walk(jsonResult, node)
 	return
 }
 
+// checkJSON returns an error giving the byte offset of the problem if
+// data is not valid JSON, when the strictjson option or force asks for
+// the check.
+func (t *Template) checkJSON(data []byte, force bool) error {
+	if !force && !t.option.strictJSON || gjson.ValidBytes(data) {
+		return nil
+	}
+	var raw json.RawMessage
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal(data, &raw); errors.As(err, &syntaxErr) {
+		return fmt.Errorf("template: %s: data is not valid JSON: %v at byte offset %d", t.Name(), syntaxErr, syntaxErr.Offset)
+	}
+	return fmt.Errorf("template: %s: data is not valid JSON", t.Name())
+}
+
 // DefinedTemplates returns a string listing the defined templates,
 // prefixed by the string "; defined templates are: ". If there are none,
 // it returns the empty string. For generating an error message here
@@ -249,9 +689,7 @@ func (t *Template) DefinedTemplates() string {
 		return ""
 	}
 	var b strings.Builder
-	t.muTmpl.RLock()
-	defer t.muTmpl.RUnlock()
-	for name, tmpl := range t.tmpl {
+	for name, tmpl := range t.templates() {
 		if tmpl.Tree == nil || tmpl.Root == nil {
 			continue
 		}
@@ -279,15 +717,30 @@ func (s *state) walk(dot gjson.Result, node parse.Node) {
 	case *parse.ActionNode:
 		// Do not pop variables so they persist until next end.
 		// Also, if the action declares variables, don't print the result.
-		val := s.evalPipeline(dot, node.Pipe)
-		if len(node.Pipe.Decl) == 0 {
+		start := s.mapStart()
+		s.debugPath = ""
+		val, ok := gjson.Result{}, true
+		if s.errs != nil {
+			val, ok = s.evalCollected(dot, node.Pipe)
+		} else {
+			val = s.evalPipeline(dot, node.Pipe)
+		}
+		if s.debug != nil {
+			s.debugAction(node, dot, val)
+		}
+		if ok && len(node.Pipe.Decl) == 0 {
 			s.printValue(node, val)
 		}
+		s.mapEnd(node, start)
 	case *parse.BreakNode:
 		panic(walkBreak)
+	case *parse.CaptureNode:
+		s.walkCapture(dot, node)
 	case *parse.CommentNode:
 	case *parse.ContinueNode:
 		panic(walkContinue)
+	case *parse.ForNode:
+		s.walkFor(dot, node)
 	case *parse.IfNode:
 		s.walkIfOrWith(parse.NodeIf, dot, node.Pipe, node.List, node.ElseList)
 	case *parse.ListNode:
@@ -299,9 +752,15 @@ func (s *state) walk(dot gjson.Result, node parse.Node) {
 	case *parse.TemplateNode:
 		s.walkTemplate(dot, node)
 	case *parse.TextNode:
+		start := s.mapStart()
 		if _, err := s.wr.Write(node.Text); err != nil {
 			s.writeError(err)
 		}
+		s.mapEnd(node, start)
+	case *parse.TryNode:
+		s.walkTry(dot, node)
+	case *parse.WhileNode:
+		s.walkWhile(dot, node)
 	case *parse.WithNode:
 		s.walkIfOrWith(parse.NodeWith, dot, node.Pipe, node.List, node.ElseList)
 	default:
@@ -313,10 +772,15 @@ func (s *state) walk(dot gjson.Result, node parse.Node) {
 // are identical in behavior except that 'with' sets dot.
 func (s *state) walkIfOrWith(typ parse.NodeType, dot gjson.Result, pipe *parse.PipeNode, list, elseList *parse.ListNode) {
 	defer s.pop(s.mark())
-	val := s.evalPipeline(dot, pipe)
+	var val gjson.Result
+	if s.errs != nil {
+		val, _ = s.evalCollected(dot, pipe)
+	} else {
+		val = s.evalPipeline(dot, pipe)
+	}
 	truth, ok := isGjsonTrue(val)
 	if !ok {
-		s.errorf("if/with can't use %v", val)
+		s.kindErrorf(ErrTypeMismatch, "if/with can't use %v", val)
 	}
 	if truth {
 		if typ == parse.NodeWith {
@@ -329,6 +793,198 @@ func (s *state) walkIfOrWith(typ parse.NodeType, dot gjson.Result, pipe *parse.P
 	}
 }
 
+// walkCapture renders the body of a capture node into a buffer and declares
+// its variable as the resulting string. Like an action declaration, the
+// variable persists until the next end.
+func (s *state) walkCapture(dot gjson.Result, c *parse.CaptureNode) {
+	var buf strings.Builder
+	func() {
+		defer s.pop(s.mark())
+		wr := s.wr
+		defer func() { s.wr = wr }()
+		s.wr = &buf
+		s.walk(dot, c.List)
+	}()
+	s.push(c.Variable.Ident[0], gjson.Parse(jsonString(buf.String())))
+}
+
+// walkTry executes the body of a try node, buffering its output. If the body
+// fails with an execution error, the output is discarded and the catch
+// branch, if any, is executed with its variable set to the error message.
+func (s *state) walkTry(dot gjson.Result, t *parse.TryNode) {
+	var buf strings.Builder
+	inner, err := s.tryWalk(dot, t.List, &buf)
+	if err == nil {
+		s.writeBuffered(buf.String(), inner)
+		return
+	}
+	if t.CatchList == nil {
+		return
+	}
+	defer s.pop(s.mark())
+	if t.Variable != nil {
+		s.push(t.Variable.Ident[0], gjson.Parse(jsonString(err.Error())))
+	}
+	s.walk(dot, t.CatchList)
+}
+
+// tryWalk walks list writing to buf and returns the execution error that
+// stopped it, if any. Other panics propagate; when they unwind a {{break}} or
+// {{continue}}, the output so far is kept. If the output is being mapped,
+// the spans of buf are recorded in the returned source map.
+func (s *state) tryWalk(dot gjson.Result, list *parse.ListNode, buf *strings.Builder) (inner *sourceMap, err error) {
+	mark, wr, smap := s.mark(), s.wr, s.smap
+	defer func() {
+		s.pop(mark)
+		s.wr, s.smap = wr, smap
+		if r := recover(); r != nil {
+			if e, ok := r.(ExecError); ok {
+				err = e
+				return
+			}
+			if r == walkBreak || r == walkContinue {
+				s.writeBuffered(buf.String(), inner)
+			}
+			panic(r)
+		}
+	}()
+	s.wr = buf
+	if smap != nil && wr == smap.w {
+		inner = &sourceMap{w: &countingWriter{w: buf}}
+		s.wr, s.smap = inner.w, inner
+	}
+	s.walk(dot, list)
+	return inner, nil
+}
+
+// walkFor walks a 'for' node, counting from start up to but not including
+// end by step, which defaults to 1 and may be negative to count down. Dot
+// and the declared variable, if any, are set to the counter.
+func (s *state) walkFor(dot gjson.Result, f *parse.ForNode) {
+	s.at(f)
+	defer func() {
+		if r := recover(); r != nil && r != walkBreak {
+			panic(r)
+		}
+	}()
+	defer s.pop(s.mark())
+	args := f.Pipe.Cmds[0].Args
+	bounds := []int{0, 0, 1}
+	for i, arg := range args {
+		v := s.evalArg(dot, arg)
+		if v.Type != gjson.Number || v.Num != float64(int(v.Num)) {
+			s.errorf("for bounds must be integers; got %s", describe(v))
+		}
+		bounds[i] = int(v.Num)
+	}
+	start, end, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		s.errorf("for step must not be zero")
+	}
+	if len(f.Pipe.Decl) > 0 && !f.Pipe.IsAssign {
+		s.push(f.Pipe.Decl[0].Ident[0], gjson.Result{})
+	}
+	// mark top of stack before any variables in the body are pushed.
+	mark := s.mark()
+	oneIteration := func(i int) {
+		elem := gjson.Parse(strconv.Itoa(i))
+		if len(f.Pipe.Decl) > 0 {
+			if f.Pipe.IsAssign {
+				s.setVar(f.Pipe.Decl[0].Ident[0], elem)
+			} else {
+				s.setTopVar(1, elem)
+			}
+		}
+		defer s.pop(mark)
+		defer func() {
+			// Consume panic(walkContinue)
+			if r := recover(); r != nil && r != walkContinue {
+				panic(r)
+			}
+		}()
+		s.walk(elem, f.List)
+	}
+	n := 0
+	for i := start; step > 0 && i < end || step < 0 && i > end; i += step {
+		oneIteration(i)
+		n++
+	}
+	if n == 0 && f.ElseList != nil {
+		s.walk(dot, f.ElseList)
+	}
+}
+
+// walkWhile walks a 'while' node, executing its body as long as the
+// condition is true. Exceeding the node's iteration limit is an error.
+func (s *state) walkWhile(dot gjson.Result, w *parse.WhileNode) {
+	s.at(w)
+	defer func() {
+		if r := recover(); r != nil && r != walkBreak {
+			panic(r)
+		}
+	}()
+	mark := s.mark()
+	oneIteration := func() {
+		defer s.pop(mark)
+		defer func() {
+			// Consume panic(walkContinue)
+			if r := recover(); r != nil && r != walkContinue {
+				panic(r)
+			}
+		}()
+		s.walk(dot, w.List)
+	}
+	for n := 0; ; n++ {
+		val := s.evalPipeline(dot, w.Pipe)
+		truth, ok := isGjsonTrue(val)
+		if !ok {
+			s.kindErrorf(ErrTypeMismatch, "while can't use %v", val)
+		}
+		if !truth {
+			if n == 0 && w.ElseList != nil {
+				s.walk(dot, w.ElseList)
+			}
+			return
+		}
+		if n == w.Max {
+			s.at(w)
+			s.kindErrorf(ErrBudgetExceeded, "while loop exceeded %d iterations", w.Max)
+		}
+		oneIteration()
+	}
+}
+
+// callMacro executes the macro named by name with its parameters set to
+// args and returns its output as a string. As in a template invocation, no
+// variables are inherited, but dot and $ are those of the caller.
+func (s *state) callMacro(dot, name gjson.Result, args []gjson.Result) gjson.Result {
+	if name.Type != gjson.String {
+		s.errorf("call requires a macro name; got %s", describe(name))
+	}
+	tmpl := s.tmpl.Lookup(name.Str)
+	if tmpl == nil || tmpl.Tree == nil || tmpl.Tree.Params == nil {
+		s.kindErrorf(ErrUnknownFunction, "macro %q not defined", name.Str)
+	}
+	params := tmpl.Tree.Params
+	if len(args) != len(params) {
+		s.errorf("wrong number of args for macro %q: want %d got %d", name.Str, len(params), len(args))
+	}
+	if max := s.maxDepth(); s.depth >= max {
+		s.kindErrorf(ErrMaxDepth, "exceeded maximum template depth (%v)", max)
+	}
+	var buf strings.Builder
+	newState := *s
+	newState.depth++
+	newState.tmpl = tmpl
+	newState.wr = &buf
+	newState.vars = append([]variable{s.vars[0]}, s.extra...)
+	for i, param := range params {
+		newState.push("$"+param, args[i])
+	}
+	newState.walk(dot, tmpl.Root)
+	return gjson.Parse(jsonString(buf.String()))
+}
+
 // isGjsonTrue reports whether the gjson.Result value is 'true', in the sense of not the zero of its type,
 // and whether the value has a meaningful truth value.
 func isGjsonTrue(val gjson.Result) (truth, ok bool) {
@@ -390,6 +1046,20 @@ func isTrue(val reflect.Value) (truth, ok bool) {
 	return truth, true
 }
 
+// countIteration counts an iteration of a range loop, the nth of its loop,
+// against the limits of the maxrange and maxiterations options.
+func (s *state) countIteration(n int) {
+	if max := s.tmpl.option.maxRange; max > 0 && n > max {
+		s.kindErrorf(ErrBudgetExceeded, "range exceeded %d iterations", max)
+	}
+	if s.iterations != nil {
+		*s.iterations++
+		if max := s.tmpl.option.maxIterations; *s.iterations > max {
+			s.kindErrorf(ErrBudgetExceeded, "range loops exceeded %d iterations in total", max)
+		}
+	}
+}
+
 func (s *state) walkRange(dot gjson.Result, r *parse.RangeNode) {
 	s.at(r)
 	defer func() {
@@ -398,10 +1068,21 @@ func (s *state) walkRange(dot gjson.Result, r *parse.RangeNode) {
 		}
 	}()
 	defer s.pop(s.mark())
-	val := s.evalPipeline(dot, r.Pipe)
+	// The matches of a constant gjson query are visited in place rather
+	// than collected into an array, so the pipeline is not evaluated.
+	q, lazy := s.lazyQuery(r.Pipe)
+	var val gjson.Result
+	if lazy {
+		s.declare(r.Pipe, val)
+	} else {
+		val = s.evalPipeline(dot, r.Pipe)
+	}
 	// mark top of stack before any variables in the body are pushed.
 	mark := s.mark()
+	count := 0
 	oneIteration := func(index, elem gjson.Result) {
+		count++
+		s.countIteration(count)
 		if len(r.Pipe.Decl) > 0 {
 			if r.Pipe.IsAssign {
 				// With two variables, index comes first.
@@ -436,18 +1117,23 @@ func (s *state) walkRange(dot gjson.Result, r *parse.RangeNode) {
 		s.walk(elem, r.List)
 	}
 
-	// Handle array/slice iteration
-	if val.IsArray() {
-		if val.Array() == nil || len(val.Array()) == 0 {
-			if r.ElseList != nil {
-				s.walk(dot, r.ElseList)
-			}
-			return
+	if lazy {
+		if s.rangeQuery(dot, q, oneIteration) == 0 && r.ElseList != nil {
+			s.walk(dot, r.ElseList)
 		}
+		return
+	}
 
-		for i, elem := range val.Array() {
-			indexResult := gjson.Parse(fmt.Sprintf("%d", i))
-			oneIteration(indexResult, elem)
+	// Handle array/slice iteration
+	if val.IsArray() {
+		i := 0
+		val.ForEach(func(_, elem gjson.Result) bool {
+			oneIteration(gjson.Parse(strconv.Itoa(i)), elem)
+			i++
+			return true
+		})
+		if i == 0 && r.ElseList != nil {
+			s.walk(dot, r.ElseList)
 		}
 		return
 	}
@@ -471,7 +1157,7 @@ func (s *state) walkRange(dot gjson.Result, r *parse.RangeNode) {
 	// Handle primitive types (numbers, strings, etc.)
 	if val.Type == gjson.Number {
 		if len(r.Pipe.Decl) > 1 {
-			s.errorf("can't use %v to iterate over more than one variable", val.Raw)
+			s.kindErrorf(ErrTypeMismatch, "can't use %v to iterate over more than one variable", val.Raw)
 		}
 
 		num := int(val.Int())
@@ -509,7 +1195,7 @@ func (s *state) walkRange(dot gjson.Result, r *parse.RangeNode) {
 	}
 
 	// Default case - can't iterate
-	s.errorf("range can't iterate over %v", val.Raw)
+	s.kindErrorf(ErrTypeMismatch, "range can't iterate over %v", val.Raw)
 
 	if r.ElseList != nil {
 		s.walk(dot, r.ElseList)
@@ -518,20 +1204,28 @@ func (s *state) walkRange(dot gjson.Result, r *parse.RangeNode) {
 
 func (s *state) walkTemplate(dot gjson.Result, t *parse.TemplateNode) {
 	s.at(t)
-	tmpl := s.tmpl.Lookup(t.Name)
+	tmpl, err := s.tmpl.lookupOrLoad(s.ctx, t.Name)
+	if err != nil {
+		s.errorf("%w", err)
+	}
 	if tmpl == nil {
-		s.errorf("template %q not defined", t.Name)
+		s.kindErrorf(ErrUnknownFunction, "template %q not defined", t.Name)
 	}
-	if s.depth == maxExecDepth {
-		s.errorf("exceeded maximum template depth (%v)", maxExecDepth)
+	if max := s.maxDepth(); s.depth >= max {
+		s.kindErrorf(ErrMaxDepth, "exceeded maximum template depth (%v)", max)
 	}
 	// Variables declared by the pipeline persist.
 	dot = s.evalPipeline(dot, t.Pipe)
 	newState := *s
 	newState.depth++
 	newState.tmpl = tmpl
-	// No dynamic scoping: template invocations inherit no variables.
-	newState.vars = []variable{{"$", dot}}
+	// No dynamic scoping: template invocations inherit only the variables
+	// of the execution.
+	newState.vars = append([]variable{{"$", dot}}, s.extra...)
+	if s.tmpl.tracer != nil {
+		newState.traceTemplate(dot, tmpl, tmpl.Root)
+		return
+	}
 	newState.walk(dot, tmpl.Root)
 }
 
@@ -552,6 +1246,12 @@ func (s *state) evalPipeline(dot gjson.Result, pipe *parse.PipeNode) (value gjso
 	for _, cmd := range pipe.Cmds {
 		value = s.evalCommand(dot, cmd, value) // previous value is this one's final arg.
 	}
+	s.declare(pipe, value)
+	return value
+}
+
+// declare sets the variables declared or assigned by pipe to value.
+func (s *state) declare(pipe *parse.PipeNode, value gjson.Result) {
 	for _, variable := range pipe.Decl {
 		if pipe.IsAssign {
 			s.setVar(variable.Ident[0], value)
@@ -559,7 +1259,31 @@ func (s *state) evalPipeline(dot gjson.Result, pipe *parse.PipeNode) (value gjso
 			s.push(variable.Ident[0], value)
 		}
 	}
-	return value
+}
+
+// evalCollected evaluates pipe as evalPipeline does when the errors of
+// the execution are collected. It records an error of the evaluation
+// instead of stopping, declares the variables of pipe with empty values,
+// and reports false. Errors of resource limits and of the context still
+// stop the execution. Callers check s.errs themselves, to keep the stack
+// of recursive invocations small.
+func (s *state) evalCollected(dot gjson.Result, pipe *parse.PipeNode) (val gjson.Result, ok bool) {
+	mark := s.mark()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err, isExec := r.(ExecError)
+		if !isExec || errors.Is(err, ErrBudgetExceeded) || errors.Is(err, ErrMaxDepth) || s.ctx.Err() != nil {
+			panic(r)
+		}
+		*s.errs = append(*s.errs, err)
+		s.pop(mark)
+		s.declare(pipe, gjson.Result{})
+		val, ok = gjson.Result{}, false
+	}()
+	return s.evalPipeline(dot, pipe), true
 }
 
 func (s *state) notAFunction(args []parse.Node, final gjson.Result) {
@@ -580,8 +1304,8 @@ func (s *state) evalCommand(dot gjson.Result, cmd *parse.CommandNode, final gjso
 		result := dot.Get(path)
 
 		// Check if the result exists
-		if !result.Exists() && s.tmpl.option.missingKey == mapError {
-			s.errorf("gjson path %q not found in data", path)
+		if !result.Exists() && s.strictMissing() {
+			s.kindErrorf(ErrMissingPath, "gjson path %q not found in data", path)
 		}
 
 		// Check if there are arguments (method call)
@@ -638,6 +1362,9 @@ func (s *state) idealConstantGjson(constant *parse.NumberNode) gjson.Result {
 		if constant.Float64 == float64(int64(constant.Float64)) {
 			return gjson.Parse(fmt.Sprintf("%d", int64(constant.Float64)))
 		}
+		if f := s.tmpl.option.floatFmt; f != nil {
+			return gjson.Parse(f.format(constant.Float64))
+		}
 		return gjson.Parse(fmt.Sprintf("%f", constant.Float64))
 	case constant.IsInt:
 		return gjson.Parse(fmt.Sprintf("%d", constant.Int64))
@@ -720,14 +1447,18 @@ func (s *state) evalVariableNode(dot gjson.Result, variable *parse.VariableNode,
 // receiver is the value being walked along the chain.
 func (s *state) evalFieldChain(dot, receiver gjson.Result, node parse.Node, ident []string, args []parse.Node, final gjson.Result) gjson.Result {
 	// Build a gjson path from the identifiers
-	path := strings.Join(ident, ".")
+	path := fieldPath(ident)
 
 	// Use gjson's native Get method to retrieve the value
-	result := receiver.Get(path)
+	resolved := resolveNegative(receiver, ident)
+	result := receiver.Get(fieldPath(resolved))
+	if s.debug != nil {
+		s.debugPath = fieldPath(resolved)
+	}
 
 	// Check if the result exists
-	if !result.Exists() && s.tmpl.option.missingKey == mapError {
-		s.errorf("path %q not found in data", path)
+	if !result.Exists() && s.strictMissing() && !s.safeMissing(receiver, resolved) {
+		s.kindErrorf(ErrMissingPath, "path %q not found in data", path)
 	}
 
 	// Check if there are arguments (method call)
@@ -738,12 +1469,78 @@ func (s *state) evalFieldChain(dot, receiver gjson.Result, node parse.Node, iden
 	return result
 }
 
+// fieldPath joins the identifiers of a field chain into a gjson path,
+// escaping keys such as "fav.movie" given in bracket form so that each
+// matches only itself.
+func fieldPath(ident []string) string {
+	comps := make([]string, len(ident))
+	for i, id := range ident {
+		comps[i] = gjson.Escape(id)
+	}
+	return strings.Join(comps, ".")
+}
+
+// resolveNegative returns ident with each negative index, as in .items.-1,
+// replaced by the array length plus the index. Negative indexes into values
+// that are not arrays, or that reach before the first element, are left
+// alone and so select nothing. ident itself is not modified.
+func resolveNegative(receiver gjson.Result, ident []string) []string {
+	resolved, copied := ident, false
+	for i, id := range ident {
+		if len(id) < 2 || id[0] != '-' {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		arr := receiver
+		if i > 0 {
+			arr = receiver.Get(fieldPath(resolved[:i]))
+		}
+		if !arr.IsArray() {
+			continue
+		}
+		n += int(arr.Get("#").Int())
+		if n < 0 {
+			continue
+		}
+		if !copied {
+			resolved = append([]string(nil), ident...)
+			copied = true
+		}
+		resolved[i] = strconv.Itoa(n)
+	}
+	return resolved
+}
+
+// safeMissing reports whether, with the safenav option, the missing value
+// at the field chain ident within receiver is allowed in strict mode: it is
+// when the value holding the last field is itself missing or null.
+func (s *state) safeMissing(receiver gjson.Result, ident []string) bool {
+	if !s.tmpl.option.safeNav {
+		return false
+	}
+	parent := receiver
+	if len(ident) > 1 {
+		parent = receiver.Get(fieldPath(ident[:len(ident)-1]))
+	}
+	return !parent.Exists() || parent.Type == gjson.Null
+}
+
 func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd parse.Node, args []parse.Node, final gjson.Result) gjson.Result {
 	s.at(node)
 	name := node.Ident
 
+	// Functions added to the template or given to the execution take
+	// precedence over the builtins evaluated here, as over the others.
+	builtin := name
+	if s.userFunc(name) {
+		builtin = ""
+	}
+
 	// Handle built-in functions for gjson
-	switch name {
+	switch builtin {
 	case "gjson":
 		if len(args) != 2 {
 			s.errorf("wrong number of args for %s: want 1 got %d", name, len(args)-1)
@@ -760,12 +1557,139 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 		result := dot.Get(path)
 
 		// Check if the result exists
-		if !result.Exists() && s.tmpl.option.missingKey == mapError {
-			s.errorf("gjson path %q not found in data", path)
+		if !result.Exists() && s.strictMissing() {
+			s.kindErrorf(ErrMissingPath, "gjson path %q not found in data", path)
 		}
 
 		return result
 
+	case "jmespath", "jsonptr":
+		if len(args) < 2 || len(args) > 3 {
+			s.errorf("wrong number of args for %s: want 1 or 2 got %d", name, len(args)-1)
+		}
+		exprArg := s.evalArg(dot, args[1])
+		if exprArg.Type != gjson.String {
+			s.errorf("%s requires a string expression argument", name)
+		}
+		// The data defaults to dot, but may be given explicitly or piped in.
+		data := dot
+		if len(args) == 3 {
+			data = s.evalArg(dot, args[2])
+		} else if final.Exists() {
+			data = final
+		}
+		var result gjson.Result
+		var err error
+		if name == "jmespath" {
+			result, err = jmespathSearch(exprArg.String(), data)
+		} else {
+			result, err = jsonPointer(exprArg.String(), data)
+		}
+		if err != nil {
+			s.errorf("%s: %s", name, err)
+		}
+		if !result.Exists() && s.strictMissing() {
+			s.kindErrorf(ErrMissingPath, "%s %q not found in data", name, exprArg.String())
+		}
+		return result
+
+	case "call":
+		if len(args) < 2 {
+			s.errorf("call requires a macro name")
+		}
+		return s.callMacro(dot, s.evalArg(dot, args[1]), s.evalArgs(dot, args[2:], final))
+
+	case "t":
+		if len(args) < 2 {
+			s.errorf("t requires a message key")
+		}
+		key := s.evalArg(dot, args[1])
+		if key.Type != gjson.String {
+			s.errorf("t requires a string message key; got %s", describe(key))
+		}
+		vals := s.evalArgs(dot, args[2:], final)
+		lang := s.language()
+		msg, ok := s.tmpl.message(lang, key.Str)
+		if !ok {
+			if s.strictMissing() {
+				s.kindErrorf(ErrMissingPath, "message %q not found for language %q", key.Str, lang)
+			}
+			msg = key.Str
+		}
+		return gjson.Parse(jsonString(formatMessage(msg, vals)))
+
+	case "color", "bold", "style":
+		return s.evalStyle(name, s.evalArgs(dot, args[1:], final))
+
+	case "httpGet":
+		return s.evalHTTPGet(s.evalArgs(dot, args[1:], final))
+
+	case "readFile":
+		return s.evalReadFile(s.evalArgs(dot, args[1:], final))
+
+	case "b64decWrite", "hexdecWrite":
+		// The bytes are written as they are, since a JSON string result
+		// cannot hold invalid UTF-8.
+		vals := s.evalArgs(dot, args[1:], final)
+		if len(vals) != 1 {
+			s.errorf("wrong number of args for %s: want 1 got %d", name, len(vals))
+		}
+		b, err := decodeBinary(name, vals[0])
+		if err != nil {
+			s.errorf("%s", err)
+		}
+		if _, err := s.wr.Write(b); err != nil {
+			s.writeError(err)
+		}
+		return gjson.Result{}
+
+	case "emit":
+		if s.doc == nil {
+			s.errorf("emit is only allowed in templates executed with ExecuteJSON")
+		}
+		vals := s.evalArgs(dot, args[1:], final)
+		if len(vals) != 2 {
+			s.errorf("wrong number of args for %s: want 2 got %d", name, len(vals))
+		}
+		path, value := vals[0], vals[1]
+		if path.Type != gjson.String {
+			s.errorf("emit requires a string path; got %s", describe(path))
+		}
+		raw := value.Raw
+		if !value.Exists() {
+			if s.strictMissing() {
+				s.kindErrorf(ErrMissingPath, "emit %q: missing value", path.Str)
+			}
+			raw = "null"
+		}
+		doc, err := sjson.SetRaw(*s.doc, path.Str, raw)
+		if err != nil {
+			s.errorf("emit %q: %s", path.Str, err)
+		}
+		*s.doc = doc
+		return gjson.Result{}
+
+	case "shuffle", "sample":
+		// These need the execution's random source, so the seed option applies.
+		vals := s.evalArgs(dot, args[1:], final)
+		var result gjson.Result
+		var err error
+		if name == "shuffle" {
+			if len(vals) != 1 {
+				s.errorf("wrong number of args for %s: want 1 got %d", name, len(vals))
+			}
+			result, err = shuffleJSON(s.random(), vals[0])
+		} else {
+			if len(vals) != 2 {
+				s.errorf("wrong number of args for %s: want 2 got %d", name, len(vals))
+			}
+			result, err = sampleJSON(s.random(), vals[0], vals[1])
+		}
+		if err != nil {
+			s.errorf("%s: %s", name, err)
+		}
+		return result
+
 	case "len":
 		if len(args) != 2 {
 			s.errorf("wrong number of args for %s: want 1 got %d", name, len(args)-1)
@@ -807,7 +1731,7 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 			keyStr := key.String()
 			return container.Get(keyStr)
 		}
-		s.errorf("can't index %s", container.Type)
+		s.kindErrorf(ErrTypeMismatch, "can't index %s", container.Type)
 		return gjson.Result{}
 
 	case "print", "println":
@@ -837,7 +1761,7 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 			lastArg = arg
 			truth, ok := isGjsonTrue(arg)
 			if !ok {
-				s.errorf("%s can't use %v", name, arg.Raw)
+				s.kindErrorf(ErrTypeMismatch, "%s can't use %v", name, arg.Raw)
 			}
 
 			if name == "and" {
@@ -861,7 +1785,7 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 		arg := s.evalArg(dot, args[1])
 		truth, ok := isGjsonTrue(arg)
 		if !ok {
-			s.errorf("not can't use %v", arg.Raw)
+			s.kindErrorf(ErrTypeMismatch, "not can't use %v", arg.Raw)
 		}
 		return gjson.Parse(fmt.Sprintf("%t", !truth))
 
@@ -956,7 +1880,7 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 	}
 
 	// Special case for printf/sprintf
-	if name == "printf" || name == "sprintf" {
+	if builtin == "printf" || builtin == "sprintf" {
 		if len(args) < 2 {
 			s.errorf("wrong number of args for %s: want at least 1 got %d", name, len(args)-1)
 		}
@@ -968,11 +1892,11 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 
 		format := formatArg.String()
 
-		// Convert remaining arguments to Go values
-		goArgs := make([]interface{}, 0)
-		for i := 2; i < len(args); i++ {
-			arg := s.evalArg(dot, args[i])
-
+		// Convert remaining arguments, and the final value of the pipeline
+		// if there is one, to Go values
+		vals := s.evalArgs(dot, args[2:], final)
+		goArgs := make([]interface{}, 0, len(vals))
+		for _, arg := range vals {
 			// Convert gjson.Result to appropriate Go value
 			switch arg.Type {
 			case gjson.Null:
@@ -998,49 +1922,50 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 		}
 
 		// Format the string
-		var result string
+		result := format
 		if len(goArgs) > 0 {
 			result = fmt.Sprintf(format, goArgs...)
-		} else if final.Exists() {
-			// Handle pipeline case where the final value is passed as an argument
-			switch final.Type {
-			case gjson.Null:
-				result = fmt.Sprintf(format, nil)
-			case gjson.False, gjson.True:
-				result = fmt.Sprintf(format, final.Bool())
-			case gjson.Number:
-				// Check if it's an integer
-				if final.Num == float64(int64(final.Num)) {
-					result = fmt.Sprintf(format, int(final.Int()))
-				} else {
-					result = fmt.Sprintf(format, final.Float())
-				}
-			case gjson.String:
-				result = fmt.Sprintf(format, final.String())
-			case gjson.JSON:
-				if final.IsArray() || final.IsObject() {
-					result = fmt.Sprintf(format, final.Raw)
-				} else {
-					result = fmt.Sprintf(format, final.Value())
-				}
-			}
-		} else {
-			// No arguments provided
-			result = format
 		}
 
 		return gjson.Parse(fmt.Sprintf("%q", result))
 	}
 
+	// Functions registered with RegisterFunc1 and its variants are called
+	// without reflection, unless the execution replaces them.
+	_, replaced := s.funcs[name]
+	if fn := s.tmpl.findTypedFunc(name); fn != nil && !replaced {
+		vals := s.evalArgs(dot, args[1:], final)
+		result, err := safeTypedCall(fn, vals)
+		if err != nil {
+			s.errorf("%s: %w", name, err)
+		}
+		return s.typedResult(result)
+	}
+
 	// Try to find the function in the template's function map or builtins
-	fn, _, found := findFunction(name, s.tmpl)
-	if found && name != "printf" && name != "sprintf" {
+	fn, found := s.funcs[name]
+	if !found {
+		fn, _, found = findFunction(name, s.tmpl)
+	}
+	if found && builtin != "printf" && builtin != "sprintf" {
 		// Convert gjson.Result arguments to reflect.Value
 		reflectArgs := make([]reflect.Value, 0)
+		if typ := fn.Type(); typ.NumIn() > 0 && typ.In(0) == contextType {
+			reflectArgs = append(reflectArgs, reflect.ValueOf(&s.ctx).Elem())
+		}
+		if typ := fn.Type(); typ.NumIn() > len(reflectArgs) && typ.In(len(reflectArgs)) == execInfoType {
+			reflectArgs = append(reflectArgs, reflect.ValueOf(s.execInfo(dot)))
+		}
 		for i := 1; i < len(args); i++ {
 			arg := s.evalArg(dot, args[i])
 			var reflectArg reflect.Value
 
+			// Functions that declare a gjson.Result parameter get the value as is.
+			if paramType(fn.Type(), len(reflectArgs)) == gjsonResultType {
+				reflectArgs = append(reflectArgs, reflect.ValueOf(arg))
+				continue
+			}
+
 			// Convert gjson.Result to appropriate reflect.Value based on type
 			switch arg.Type {
 			case gjson.Null:
@@ -1087,16 +2012,22 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 					finalArg = reflect.ValueOf(final.Value())
 				}
 			}
+			if paramType(fn.Type(), len(reflectArgs)) == gjsonResultType {
+				finalArg = reflect.ValueOf(final)
+			}
 			reflectArgs = append(reflectArgs, finalArg)
 		}
 
 		// Call the function
 		result, err := safeCall(fn, reflectArgs)
 		if err != nil {
-			s.errorf("%s: %s", name, err)
+			s.errorf("%s: %w", name, err)
 		}
 
 		// Convert the result back to gjson.Result
+		if result.IsValid() && result.Type() == gjsonResultType {
+			return result.Interface().(gjson.Result)
+		}
 		switch result.Kind() {
 		case reflect.Bool:
 			return gjson.Parse(fmt.Sprintf("%t", result.Bool()))
@@ -1105,6 +2036,9 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			return gjson.Parse(fmt.Sprintf("%d", result.Uint()))
 		case reflect.Float32, reflect.Float64:
+			if f := s.tmpl.option.floatFmt; f != nil {
+				return gjson.Parse(f.format(result.Float()))
+			}
 			return gjson.Parse(fmt.Sprintf("%f", result.Float()))
 		case reflect.String:
 			return gjson.Parse(fmt.Sprintf("%q", result.String()))
@@ -1121,17 +2055,30 @@ func (s *state) evalFunction(dot gjson.Result, node *parse.IdentifierNode, cmd p
 	}
 
 	// If we get here, the function was not found
-	s.errorf("function %q not implemented for gjson", name)
+	s.kindErrorf(ErrUnknownFunction, "function %q not implemented for gjson", name)
 	return gjson.Result{}
 }
 
+// evalArgs evaluates the arguments args of a builtin, followed by the final
+// value of the pipeline if there is one.
+func (s *state) evalArgs(dot gjson.Result, args []parse.Node, final gjson.Result) []gjson.Result {
+	vals := make([]gjson.Result, 0, len(args)+1)
+	for _, arg := range args {
+		vals = append(vals, s.evalArg(dot, arg))
+	}
+	if final.Exists() {
+		vals = append(vals, final)
+	}
+	return vals
+}
+
 // evalField evaluates an expression like (.Field) or (.Field arg1 arg2).
 // The 'final' argument represents the return value from the preceding
 // value of the pipeline, if any.
 func (s *state) evalField(dot gjson.Result, fieldName string, node parse.Node, args []parse.Node, final, receiver gjson.Result) gjson.Result {
 	if !receiver.Exists() {
-		if s.tmpl.option.missingKey == mapError { // Treat invalid value as missing map key.
-			s.errorf("nil data; no entry for key %q", fieldName)
+		if s.strictMissing() && !s.tmpl.option.safeNav { // Treat invalid value as missing map key.
+			s.kindErrorf(ErrMissingPath, "nil data; no entry for key %q", fieldName)
 		}
 		return gjson.Result{}
 	}
@@ -1147,14 +2094,14 @@ func (s *state) evalField(dot gjson.Result, fieldName string, node parse.Node, a
 
 	// Check if the result exists
 	if !result.Exists() {
-		switch s.tmpl.option.missingKey {
+		switch s.missingKey() {
 		case mapInvalid:
 			// Just use the invalid value.
 		case mapZeroValue:
 			// Return empty result
 			return gjson.Result{}
 		case mapError:
-			s.errorf("path %q not found in data", fieldName)
+			s.kindErrorf(ErrMissingPath, "path %q not found in data", fieldName)
 		}
 	}
 
@@ -1200,8 +2147,39 @@ var (
 	errorType        = reflect.TypeFor[error]()
 	fmtStringerType  = reflect.TypeFor[fmt.Stringer]()
 	reflectValueType = reflect.TypeFor[reflect.Value]()
+	gjsonResultType  = reflect.TypeFor[gjson.Result]()
+	contextType      = reflect.TypeFor[context.Context]()
+	execInfoType     = reflect.TypeFor[*ExecInfo]()
 )
 
+// paramType returns the type of the i'th parameter of the function type typ,
+// taking variadic parameters into account. It returns nil if the function
+// does not accept that many arguments.
+func paramType(typ reflect.Type, i int) reflect.Type {
+	numIn := typ.NumIn()
+	if typ.IsVariadic() && i >= numIn-1 {
+		return typ.In(numIn - 1).Elem()
+	}
+	if i < numIn {
+		return typ.In(i)
+	}
+	return nil
+}
+
+// checkArgTypes returns an error of kind ErrTypeMismatch if one of args
+// cannot be passed to a function of type typ. The number of arguments is
+// checked by the call.
+func checkArgTypes(typ reflect.Type, args []reflect.Value) error {
+	for i, arg := range args {
+		want := paramType(typ, i)
+		if want == nil || !arg.IsValid() || arg.Type().AssignableTo(want) {
+			continue
+		}
+		return errorOfKind(ErrTypeMismatch, fmt.Errorf("wrong type for arg %d: expected %s; got %s", i, want, arg.Type()))
+	}
+	return nil
+}
+
 // 删除旧的反射相关方法，因为我们已经使用gjson替代了它们
 
 // printValue writes the textual representation of the value to the output of
@@ -1212,8 +2190,8 @@ func (s *state) printValue(n parse.Node, v gjson.Result) {
 
 	// Special case for missing values
 	if !v.Exists() {
-		// For compatibility with the original template package, return empty string
-		output = ""
+		// Empty unless the novalue option says otherwise.
+		output = s.tmpl.option.noValue
 	} else {
 		switch v.Type {
 		case gjson.String:
@@ -1226,6 +2204,16 @@ func (s *state) printValue(n parse.Node, v gjson.Result) {
 			} else {
 				output = v.String()
 			}
+		case gjson.Number:
+			output = v.Raw
+			if f := s.tmpl.option.floatFmt; f != nil && strings.ContainsAny(v.Raw, ".eE") {
+				output = f.format(v.Num)
+			}
+		case gjson.Null:
+			output = v.Raw
+			if null := s.tmpl.option.null; null != nil {
+				output = *null
+			}
 		default:
 			// For other types, just use the raw value
 			output = v.Raw
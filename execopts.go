@@ -0,0 +1,134 @@
+// This file contains the settings of single executions.
+
+package gjson_template
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// ExecOptions holds settings for one execution with ExecuteWithOptions.
+// They apply to that execution only, so callers sharing a parsed template
+// can choose their own strictness and limits without changing the
+// template. The zero value keeps the settings of the template.
+type ExecOptions struct {
+	// StrictMode, if not nil, says whether a missing path is an error, in
+	// place of the missingkey option: true makes it one, as
+	// missingkey=error does, and false makes it not one even if the
+	// template was parsed with missingkey=error.
+	StrictMode *bool
+
+	// MaxDepth, if positive, limits how deeply templates and macros may
+	// invoke each other, in place of the maxdepth option.
+	MaxDepth int
+
+	// MaxOutputBytes, if positive, limits the size of the output.
+	// Execution stops with an ErrBudgetExceeded error at the write that
+	// would exceed it. With output filters, it limits both the output
+	// of the template and the filtered output.
+	MaxOutputBytes int64
+
+	// Funcs adds functions for this execution, in place of functions of
+	// the template and builtins with the same names. Templates calling functions that
+	// are defined only here must be parsed with the latefuncs option.
+	Funcs FuncMap
+
+	// Debug writes a line to DebugWriter, or to standard error if it is
+	// nil, for every action evaluated: its location and text, the last
+	// path of the data it resolved, the value of dot, and the value of
+	// the action, so that empty output can be traced to its cause.
+	Debug       bool
+	DebugWriter io.Writer
+
+	// CollectErrors makes the errors of actions, such as missing paths
+	// with StrictMode or failing functions, not stop the execution: the
+	// failed action renders nothing, a failed {{if}} or {{with}}
+	// condition is false, and the errors are returned together, joined
+	// with errors.Join, once the whole template is rendered. Errors of
+	// resource limits and of the context still stop the execution.
+	CollectErrors bool
+
+	// Lang, if not empty, is the language of the t builtin, in place of
+	// the lang option, so that one template serves the language of each
+	// request.
+	Lang string
+
+	// Vars defines variables for this execution, such as per-request
+	// values like a trace ID or tenant, without them being added to the
+	// data. The keys are the names of variables declared with
+	// [Template.DeclareVars], with or without their $, and the values are
+	// converted to JSON with encoding/json, unless they are
+	// [gjson.Result]s. Other keys are errors.
+	Vars map[string]any
+}
+
+// ExecuteWithOptions is like Execute, but with the settings of opts for
+// this execution.
+func (t *Template) ExecuteWithOptions(wr io.Writer, data []byte, opts ExecOptions) error {
+	funcs, err := execFuncs(opts.Funcs)
+	if err != nil {
+		return err
+	}
+	vars, err := t.givenVars(opts.Vars)
+	if err != nil {
+		return err
+	}
+	var debug io.Writer
+	if opts.Debug {
+		if debug = opts.DebugWriter; debug == nil {
+			debug = os.Stderr
+		}
+	}
+	return t.execute(wr, data, execOptions{
+		strict:    opts.StrictMode,
+		maxDepth:  opts.MaxDepth,
+		maxOutput: opts.MaxOutputBytes,
+		funcs:     funcs,
+		debug:     debug,
+		collect:   opts.CollectErrors,
+		lang:      opts.Lang,
+		vars:      vars,
+	})
+}
+
+// execFuncs checks the functions of fm and returns them as values, as
+// Funcs does, but reports problems as errors instead of panicking.
+func execFuncs(fm FuncMap) (map[string]reflect.Value, error) {
+	if len(fm) == 0 {
+		return nil, nil
+	}
+	funcs := make(map[string]reflect.Value, len(fm))
+	for name, fn := range fm {
+		if !goodName(name) {
+			return nil, fmt.Errorf("template: function name %q is not a valid identifier", name)
+		}
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func {
+			return nil, fmt.Errorf("template: value for %s not a function", name)
+		}
+		if err := goodFunc(name, v.Type()); err != nil {
+			return nil, fmt.Errorf("template: %w", err)
+		}
+		funcs[name] = v
+	}
+	return funcs, nil
+}
+
+// limitWriter writes to w until max bytes have been written, and fails the
+// write that would exceed them.
+type limitWriter struct {
+	w   io.Writer
+	n   int64
+	max int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.n+int64(len(p)) > l.max {
+		return 0, errorOfKind(ErrBudgetExceeded, fmt.Errorf("template: output exceeds %d bytes", l.max))
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
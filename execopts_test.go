@@ -0,0 +1,206 @@
+package gjson_template
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExecuteWithOptions(t *testing.T) {
+	data := []byte(`{"name":"Ann","items":[1,2,3]}`)
+	strict := true
+	tests := []struct {
+		name   string
+		input  string
+		opts   ExecOptions
+		output string
+		kind   error // kind of the expected error, or nil
+	}{
+		{"zero", `{{.name}}{{.nope}}`, ExecOptions{}, "Ann", nil},
+		{"strict", `{{.name}}{{.nope}}`, ExecOptions{StrictMode: &strict}, "", ErrMissingPath},
+		{"strict present", `{{.name}}`, ExecOptions{StrictMode: &strict}, "Ann", nil},
+		{"max output", `{{range .items}}abcd{{end}}`, ExecOptions{MaxOutputBytes: 10}, "", ErrBudgetExceeded},
+		{"max output fits", `{{range .items}}abcd{{end}}`, ExecOptions{MaxOutputBytes: 12}, "abcdabcdabcd", nil},
+		{"max depth", `{{define "r"}}{{template "r" .}}{{end}}{{template "r" .}}`, ExecOptions{MaxDepth: 5}, "", ErrMaxDepth},
+		{"funcs", `{{greet .name}}`, ExecOptions{Funcs: FuncMap{"greet": func(s string) string { return "hi " + s }}}, "hi Ann", nil},
+		{"funcs override", `{{upper .name}}`, ExecOptions{Funcs: FuncMap{"upper": func(s string) string { return "<" + s + ">" }}}, "<Ann>", nil},
+		{"funcs override builtin", `{{gjson "name"}}`, ExecOptions{Funcs: FuncMap{"gjson": func(s string) string { return "<" + s + ">" }}}, "<name>", nil},
+		{"funcs override printf", `{{printf "%d" 1}}`, ExecOptions{Funcs: FuncMap{"printf": func(f string, n int) string { return f }}}, "%d", nil},
+	}
+	for _, test := range tests {
+		tmpl := Must(New(test.name).Option("latefuncs").Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.ExecuteWithOptions(&buf, data, test.opts)
+		switch {
+		case test.kind != nil:
+			if !errors.Is(err, test.kind) {
+				t.Errorf("%s: expected error of kind %v; got %v", test.name, test.kind, err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.name, test.output, buf.String())
+		}
+	}
+}
+
+// TestExecuteWithOptionsShared tests that the settings of one execution
+// do not leak into the template or other executions.
+func TestExecuteWithOptionsShared(t *testing.T) {
+	tmpl := Must(New("shared").Parse(`{{.a}}{{.b}}`))
+	data := []byte(`{"a":"x"}`)
+	strict := true
+	if err := tmpl.ExecuteWithOptions(io.Discard, data, ExecOptions{StrictMode: &strict}); !errors.Is(err, ErrMissingPath) {
+		t.Fatalf("strict execution: expected ErrMissingPath; got %v", err)
+	}
+	out, err := tmpl.ExecuteString(data)
+	if err != nil || out != "x" {
+		t.Errorf("later execution: got %q, %v", out, err)
+	}
+}
+
+// TestExecuteWithOptionsLenient tests that StrictMode false turns off the
+// missingkey=error option of the template for one execution.
+func TestExecuteWithOptionsLenient(t *testing.T) {
+	tmpl := Must(New("lenient").Option("missingkey=error").Parse(`{{.a}}{{.b}}{{gjson "c"}}`))
+	data := []byte(`{"a":"x"}`)
+	lenient := false
+	var buf bytes.Buffer
+	err := tmpl.ExecuteWithOptions(&buf, data, ExecOptions{StrictMode: &lenient})
+	if err != nil || buf.String() != "x" {
+		t.Errorf("lenient execution: got %q, %v", buf.String(), err)
+	}
+	if _, err := tmpl.ExecuteString(data); !errors.Is(err, ErrMissingPath) {
+		t.Errorf("later execution: expected ErrMissingPath; got %v", err)
+	}
+}
+
+// TestExecuteWithOptionsTypedFuncs tests that the functions of an execution
+// replace typed functions of the same names.
+func TestExecuteWithOptionsTypedFuncs(t *testing.T) {
+	tmpl := New("typed")
+	RegisterFunc1(tmpl, "shout", func(s string) string { return strings.ToUpper(s) })
+	Must(tmpl.Parse(`{{shout .name}}`))
+	var buf bytes.Buffer
+	err := tmpl.ExecuteWithOptions(&buf, []byte(`{"name":"ann"}`), ExecOptions{Funcs: FuncMap{"shout": func(s string) string { return s + "!" }}})
+	if err != nil || buf.String() != "ann!" {
+		t.Errorf("got %q, %v", buf.String(), err)
+	}
+}
+
+func TestExecuteWithOptionsFilters(t *testing.T) {
+	tmpl := Must(New("filtered").Option("postprocess=pretty").Parse(`{"a":[1,2,3]}`))
+	var buf bytes.Buffer
+	err := tmpl.ExecuteWithOptions(&buf, []byte(`{}`), ExecOptions{MaxOutputBytes: 20})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded for the filtered output; got %v, %q", err, buf.String())
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no output; got %q", buf.String())
+	}
+}
+
+func TestExecuteWithOptionsBadFuncs(t *testing.T) {
+	tmpl := Must(New("bad").Parse(`x`))
+	for _, fm := range []FuncMap{
+		{"not a name": strings.ToUpper},
+		{"notFunc": 1},
+		{"noResult": func() {}},
+	} {
+		if err := tmpl.ExecuteWithOptions(io.Discard, []byte(`{}`), ExecOptions{Funcs: fm}); err == nil {
+			t.Errorf("%v: expected error", fm)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tmpl := Must(New("v").Funcs(FuncMap{
+		"check": func(n int) (int, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+			return n, nil
+		},
+	}).Parse(`{{.name}}{{if .admin}}{{.role}}{{end}}{{check .n}}{{emit "x" .name}}`))
+	tests := []struct {
+		data string
+		kind error // kind of the expected error, or nil
+		msg  string
+	}{
+		{`{"name":"a","admin":false,"n":1}`, nil, ""},
+		{`{"name":"a","admin":true,"role":"r","n":1}`, nil, ""},
+		{`{"admin":false,"n":1}`, ErrMissingPath, `path "name" not found`},
+		{`{"name":"a","admin":true,"n":1}`, ErrMissingPath, `path "role" not found`},
+		{`{"name":"a","admin":false,"n":-1}`, nil, "check: negative"},
+		{`"x"`, nil, "must be a valid JSON"},
+		{`{"name":"a","admin":false,"n":1`, nil, "not valid JSON: unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		err := tmpl.Validate([]byte(test.data))
+		switch {
+		case test.msg == "":
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.data, err)
+			}
+		case err == nil || !strings.Contains(err.Error(), test.msg):
+			t.Errorf("%s: expected error %q; got %v", test.data, test.msg, err)
+		case test.kind != nil && !errors.Is(err, test.kind):
+			t.Errorf("%s: expected error of kind %v; got %v", test.data, test.kind, err)
+		}
+	}
+}
+
+func TestExecDebug(t *testing.T) {
+	tmpl := Must(New("page").Parse(`{{range .items}}{{.name}},{{end}}{{$.title}}`))
+	var out, debug strings.Builder
+	err := tmpl.ExecuteWithOptions(&out, []byte(`{"items":[{"name":"a"},{"id":2}]}`), ExecOptions{Debug: true, DebugWriter: &debug})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a,," {
+		t.Errorf("got output %q", out.String())
+	}
+	want := `page:1:18: {{.name}} path="name" dot={"name":"a"} value="a"
+page:1:18: {{.name}} path="name" dot={"id":2} value=<missing>
+page:1:35: {{$.title}} path="title" dot={"items":[{"name":"a"},{"id":2}]} value=<missing>
+`
+	if debug.String() != want {
+		t.Errorf("got debug output:\n%s\nwant:\n%s", debug.String(), want)
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	tmpl := Must(New("page").Parse(`{{.a}}-{{.nope}}-{{fail "boom"}}-{{$x := .gone}}{{$x}}-{{if .none}}y{{else}}n{{end}}-{{.b}}`))
+	var out strings.Builder
+	strict := true
+	err := tmpl.ExecuteWithOptions(&out, []byte(`{"a":1,"b":2}`), ExecOptions{StrictMode: &strict, CollectErrors: true})
+	if out.String() != "1----n-2" {
+		t.Errorf("got output %q", out.String())
+	}
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	msgs := strings.Split(err.Error(), "\n")
+	want := []string{`"nope" not found`, "boom", `"gone" not found`, `"none" not found`}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(msgs), len(want), err)
+	}
+	for i, w := range want {
+		if !strings.Contains(msgs[i], w) {
+			t.Errorf("error %d: got %q, want it to contain %q", i, msgs[i], w)
+		}
+	}
+	if !errors.Is(err, ErrMissingPath) {
+		t.Errorf("errors.Is(%v, ErrMissingPath) = false", err)
+	}
+
+	// Resource limits still stop the execution.
+	tmpl = Must(New("loop").Parse(`{{.nope}}{{while true max=3}}x{{end}}after`))
+	out.Reset()
+	err = tmpl.ExecuteWithOptions(&out, []byte(`{}`), ExecOptions{StrictMode: &strict, CollectErrors: true})
+	if !errors.Is(err, ErrBudgetExceeded) || !errors.Is(err, ErrMissingPath) || strings.Contains(out.String(), "after") {
+		t.Errorf("got %q, %v", out.String(), err)
+	}
+}
@@ -0,0 +1,155 @@
+// This file contains the httpGet builtin, which fetches remote data when
+// enabled with EnableHTTP.
+
+package gjson_template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// HTTPConfig configures the httpGet builtin.
+type HTTPConfig struct {
+	// Hosts lists the hosts that may be fetched, such as "api.example.com",
+	// which allows any port, or "api.example.com:8443". Redirects are only
+	// followed to allowed hosts.
+	Hosts []string
+
+	// Timeout bounds each request, response body included. It defaults to
+	// 5 seconds.
+	Timeout time.Duration
+
+	// MaxSize bounds the size of response bodies, in bytes. It defaults to
+	// 1 MiB.
+	MaxSize int64
+
+	// Client sends the requests; http.DefaultClient if nil.
+	Client *http.Client
+}
+
+const (
+	defaultHTTPTimeout = 5 * time.Second
+	defaultHTTPMaxSize = 1 << 20
+)
+
+// EnableHTTP enables the httpGet builtin in the template and the templates
+// associated with it, which is disabled by default:
+//
+//	{{with httpGet (printf "https://api.example.com/users/%s" .id)}}{{.name}}{{end}}
+//
+// fetches a URL with GET and returns the response body, parsed as JSON if
+// it is valid JSON and as a string otherwise. Only http and https URLs of
+// the hosts listed in cfg may be fetched, and responses other than 2xx are
+// errors. Requests are canceled with the context of ExecuteContext. The
+// return value is the template, so calls can be chained.
+func (t *Template) EnableHTTP(cfg HTTPConfig) *Template {
+	t.init()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHTTPTimeout
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultHTTPMaxSize
+	}
+	cfg.Hosts = slices.Clone(cfg.Hosts)
+	t.http = &cfg
+	return t
+}
+
+// httpAllowed reports whether the URL u may be fetched under cfg.
+func (cfg *HTTPConfig) httpAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	for _, h := range cfg.Hosts {
+		if _, _, err := net.SplitHostPort(h); err == nil {
+			if strings.EqualFold(h, u.Host) {
+				return true
+			}
+		} else if strings.EqualFold(h, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpGet fetches rawURL under cfg, returning the response body.
+func (cfg *HTTPConfig) httpGet(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.httpAllowed(u) {
+		return nil, fmt.Errorf("URL %q is not allowed", rawURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.DefaultClient
+	if cfg.Client != nil {
+		client = cfg.Client
+	}
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !cfg.httpAllowed(req.URL) {
+			return fmt.Errorf("redirect to %q is not allowed", req.URL)
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cfg.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > cfg.MaxSize {
+		return nil, errorOfKind(ErrBudgetExceeded, fmt.Errorf("GET %s: response exceeds %d bytes", rawURL, cfg.MaxSize))
+	}
+	return body, nil
+}
+
+// evalHTTPGet implements {{httpGet url}}.
+func (s *state) evalHTTPGet(vals []gjson.Result) gjson.Result {
+	if len(vals) != 1 {
+		s.errorf("wrong number of args for httpGet: want 1 got %d", len(vals))
+	}
+	cfg := s.tmpl.http
+	if cfg == nil {
+		s.errorf("httpGet is not enabled; see Template.EnableHTTP")
+	}
+	if vals[0].Type != gjson.String {
+		s.errorf("httpGet requires a string URL; got %s", describe(vals[0]))
+	}
+	body, err := cfg.httpGet(s.ctx, vals[0].Str)
+	if err != nil {
+		s.errorf("httpGet: %w", err)
+	}
+	if gjson.ValidBytes(body) {
+		return gjson.ParseBytes(body)
+	}
+	return gjson.Parse(jsonString(string(body)))
+}
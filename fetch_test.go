@@ -0,0 +1,78 @@
+package gjson_template
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"name":"Ada","roles":["admin"]}`))
+		case "/text":
+			w.Write([]byte("plain text"))
+		case "/big":
+			w.Write(bytes.Repeat([]byte("x"), 100))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/away":
+			http.Redirect(w, r, "http://elsewhere.example/", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/user", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	cfg := HTTPConfig{Hosts: []string{u.Hostname()}, MaxSize: 64, Timeout: 100 * time.Millisecond}
+	data := []byte(`{"base": "` + srv.URL + `"}`)
+
+	tests := []struct {
+		input, output string
+		ok            bool
+	}{
+		{`{{with httpGet (printf "%s/user" .base)}}{{.name}} {{len .roles}}{{end}}`, "Ada 1", true},
+		{`{{printf "%s/user" .base | httpGet}}`, `{"name":"Ada","roles":["admin"]}`, true},
+		{`{{httpGet (printf "%s/text" .base)}}`, "plain text", true},
+		{`{{(httpGet (printf "%s/here" .base)).name}}`, "Ada", true},
+		{`{{httpGet (printf "%s/missing" .base)}}`, "", false},
+		{`{{httpGet (printf "%s/big" .base)}}`, "", false},
+		{`{{httpGet (printf "%s/slow" .base)}}`, "", false},
+		{`{{httpGet (printf "%s/away" .base)}}`, "", false},
+		{`{{httpGet "http://elsewhere.example/"}}`, "", false},
+		{`{{httpGet "file:///etc/passwd"}}`, "", false},
+		{`{{httpGet 1}}`, "", false},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("http").EnableHTTP(cfg).Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got none", test.input)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.input, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.input, test.output, buf.String())
+		}
+	}
+
+	// Disabled by default.
+	err := Must(New("off").Parse(`{{httpGet .base}}`)).Execute(&bytes.Buffer{}, data)
+	if err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("expected httpGet to be disabled; got %v", err)
+	}
+	// A host with a port allows only that port.
+	other := HTTPConfig{Hosts: []string{u.Hostname() + ":1"}}
+	err = Must(New("port").EnableHTTP(other).Parse(`{{httpGet (printf "%s/user" .base)}}`)).Execute(&bytes.Buffer{}, data)
+	if err == nil {
+		t.Error("expected a host with another port to be rejected")
+	}
+}
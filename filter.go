@@ -0,0 +1,176 @@
+// This file contains the output filters applied to executed templates.
+
+package gjson_template
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// An OutputFilter transforms the output of an execution before it is
+// written. It may modify and return its argument.
+type OutputFilter func(out []byte) ([]byte, error)
+
+// AddOutputFilter adds f to the filters applied, in the order they were
+// added, to the output of the template and the templates associated with
+// it. When there are filters, the output of an execution is buffered and
+// written once the filters have run; if the execution or a filter fails,
+// nothing is written. Filters must be added before the templates are
+// executed. The return value is the template, so calls can be chained.
+func (t *Template) AddOutputFilter(f OutputFilter) *Template {
+	t.init()
+	t.filters = append(t.filters, f)
+	return t
+}
+
+// hasFilters reports whether the output of t must be filtered.
+func (t *Template) hasFilters() bool {
+	return t.common != nil && (len(t.filters) > 0 || t.option.postprocess != "" || t.option.encoding != nil)
+}
+
+// filter applies the output filters of t to out, then the postprocess and
+// encoding options.
+func (t *Template) filter(out []byte) ([]byte, error) {
+	for _, f := range t.filters {
+		var err error
+		if out, err = f(out); err != nil {
+			return nil, fmt.Errorf("template: %s: output filter: %w", t.Name(), err)
+		}
+	}
+	out, err := t.postprocess(out)
+	if err != nil || t.option.encoding == nil {
+		return out, err
+	}
+	if out, err = t.option.encoding.NewEncoder().Bytes(out); err != nil {
+		return nil, fmt.Errorf("template: %s: encoding output: %w", t.Name(), err)
+	}
+	return out, nil
+}
+
+// postprocess reformats the JSON output out according to the postprocess
+// option.
+func (t *Template) postprocess(out []byte) ([]byte, error) {
+	if t.common == nil || t.option.postprocess == "" {
+		return out, nil
+	}
+	if !gjson.ValidBytes(out) {
+		return nil, fmt.Errorf("template: %s: postprocess=%s: output is not valid JSON", t.Name(), t.option.postprocess)
+	}
+	if t.option.postprocess == "minify" {
+		return pretty.Ugly(out), nil
+	}
+	return pretty.Pretty(out), nil
+}
+
+// RemoveBlankLines is an OutputFilter that removes the lines consisting
+// only of spaces and tabs, such as those left by actions on lines of their
+// own.
+func RemoveBlankLines(out []byte) ([]byte, error) {
+	var b bytes.Buffer
+	for line := range bytes.Lines(out) {
+		if len(bytes.Trim(line, " \t\r\n")) > 0 {
+			b.Write(line)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// TrimTrailingSpace is an OutputFilter that removes the spaces and tabs at
+// the end of each line.
+func TrimTrailingSpace(out []byte) ([]byte, error) {
+	var b bytes.Buffer
+	for line := range bytes.Lines(out) {
+		body, eol := line, []byte(nil)
+		if i := bytes.IndexAny(line, "\r\n"); i >= 0 {
+			body, eol = line[:i], line[i:]
+		}
+		b.Write(bytes.TrimRight(body, " \t"))
+		b.Write(eol)
+	}
+	return b.Bytes(), nil
+}
+
+// rawElements are the HTML elements whose content MinifyHTML leaves as is.
+var rawElements = []string{"pre", "textarea", "script", "style"}
+
+// MinifyHTML is an OutputFilter that removes insignificant white space
+// from HTML: runs of white space become a single space, runs containing a
+// newline between two tags are removed, and white space at the start and
+// end of the output is removed. The content of pre, textarea, script and
+// style elements is left unchanged.
+func MinifyHTML(out []byte) ([]byte, error) {
+	var b bytes.Buffer
+	for i := 0; i < len(out); {
+		c := out[i]
+		if c == '<' {
+			if end := rawElementEnd(out, i); end > i {
+				b.Write(out[i:end])
+				i = end
+				continue
+			}
+		}
+		if !isHTMLSpace(c) {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		j := i
+		for j < len(out) && isHTMLSpace(out[j]) {
+			j++
+		}
+		switch {
+		case b.Len() == 0 || j == len(out):
+			// Leading or trailing white space.
+		case bytes.ContainsAny(out[i:j], "\r\n") && out[i-1] == '>' && out[j] == '<':
+			// Layout between tags.
+		default:
+			b.WriteByte(' ')
+		}
+		i = j
+	}
+	return b.Bytes(), nil
+}
+
+// rawElementEnd returns the end of the raw element starting at out[i], just
+// after its end tag or at the end of out, or i if there is none.
+func rawElementEnd(out []byte, i int) int {
+	for _, name := range rawElements {
+		rest := out[i+1:]
+		if len(rest) <= len(name) || !bytes.EqualFold(rest[:len(name)], []byte(name)) {
+			continue
+		}
+		if c := rest[len(name)]; c != '>' && !isHTMLSpace(c) {
+			continue
+		}
+		closing := []byte("</" + name)
+		end := indexFold(out[i:], closing)
+		if end < 0 {
+			return len(out)
+		}
+		end += i + len(closing)
+		if gt := bytes.IndexByte(out[end:], '>'); gt >= 0 {
+			return end + gt + 1
+		}
+		return len(out)
+	}
+	return i
+}
+
+// indexFold returns the index of the first instance of the ASCII string
+// sep in s, ignoring case, or -1 if it is not present.
+func indexFold(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isHTMLSpace reports whether c is HTML white space.
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
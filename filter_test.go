@@ -0,0 +1,175 @@
+package gjson_template
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestOutputFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  OutputFilter
+		in, out string
+	}{
+		{"blank lines", RemoveBlankLines, "a\n  \n\t\nb\n\n c \n", "a\nb\n c \n"},
+		{"blank lines crlf", RemoveBlankLines, "a\r\n \r\nb", "a\r\nb"},
+		{"trailing space", TrimTrailingSpace, "a  \nb\t\r\n c", "a\nb\r\n c"},
+		{"html", MinifyHTML, "\n<ul>\n  <li>a  b</li>\n  <li><b>x</b> <i>y</i></li>\n</ul>\n", "<ul><li>a b</li><li><b>x</b> <i>y</i></li></ul>"},
+		{"html text", MinifyHTML, "<p>\n  Hello,\n  world\n</p>", "<p> Hello, world </p>"},
+		{"html raw", MinifyHTML, "<div>\n<PRE>  a\n  b</pre>\n<script>if (a  <  b) {}</script>\n</div>", "<div><PRE>  a\n  b</pre><script>if (a  <  b) {}</script></div>"},
+		{"html unclosed raw", MinifyHTML, "<p> x </p>\n<textarea>  a  ", "<p> x </p><textarea>  a  "},
+		{"html prefix", MinifyHTML, "<preview>  a  </preview>", "<preview> a </preview>"},
+	}
+	for _, test := range tests {
+		out, err := test.filter([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if string(out) != test.out {
+			t.Errorf("%s: expected %q; got %q", test.name, test.out, out)
+		}
+	}
+}
+
+func TestAddOutputFilter(t *testing.T) {
+	const text = `{{define "items"}}
+{{range .Array}}
+  <li>{{.}}</li>
+{{end}}
+{{end}}<ul>{{template "items" .}}</ul>`
+	tmpl := Must(New("page").AddOutputFilter(RemoveBlankLines).AddOutputFilter(MinifyHTML).Parse(text))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<ul><li>1</li><li>2</li><li>3</li></ul>"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+
+	// Associated templates share the filters.
+	buf.Reset()
+	if err := tmpl.ExecuteTemplate(&buf, "items", baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<li>1</li><li>2</li><li>3</li>"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+
+	// A failing filter stops the output.
+	failing := errors.New("too long")
+	tmpl.AddOutputFilter(func(out []byte) ([]byte, error) { return nil, failing })
+	buf.Reset()
+	if err := tmpl.Execute(&buf, baseTestJSON); !errors.Is(err, failing) {
+		t.Errorf("expected filter error; got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no output; got %q", buf.String())
+	}
+}
+
+func TestPostprocess(t *testing.T) {
+	const text = `{
+  "items": [
+    {{range $i, $e := .Array}}{{if $i}},{{end}}
+      {{$e}}
+    {{end}}
+  ],
+  {{if .Bool}}
+  "ok":   true
+  {{end}}
+}`
+	tests := []struct {
+		opt, out string
+		ok       bool
+	}{
+		{"postprocess=minify", `{"items":[1,2,3],"ok":true}`, true},
+		{"postprocess=pretty", "{\n  \"items\": [1, 2, 3],\n  \"ok\": true\n}\n", true},
+		{"postprocess=none", "", true},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("json").Option(test.opt).Parse(text))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.opt, err)
+			continue
+		}
+		if test.out != "" && buf.String() != test.out {
+			t.Errorf("%s: expected %q; got %q", test.opt, test.out, buf.String())
+		}
+	}
+
+	// The output must be JSON.
+	tmpl := Must(New("text").Option("postprocess=minify").Parse(`{"a": {{.String}}}`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, baseTestJSON); err == nil {
+		t.Errorf("expected error for invalid JSON; got %q", buf.String())
+	}
+
+	// The document built by ExecuteJSON is reformatted too.
+	tmpl = Must(New("doc").Option("postprocess=pretty").Parse(`{{emit "a.b" .Number}}`))
+	out, err := tmpl.ExecuteJSON(baseTestJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"a\": {\n    \"b\": 42\n  }\n}\n"; string(out) != want {
+		t.Errorf("ExecuteJSON: expected %q; got %q", want, out)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unknown postprocess value")
+		}
+	}()
+	New("bad").Option("postprocess=compact")
+}
+
+func TestEncodingOption(t *testing.T) {
+	data := []byte(`{"city":"北京","name":"Zoë"}`)
+	tests := []struct {
+		encoding, input string
+		out             []byte
+		ok              bool
+	}{
+		{"GBK", `{{.city}}`, []byte{0xb1, 0xb1, 0xbe, 0xa9}, true},
+		{"Shift_JIS", `{{.city}}!`, []byte{0x96, 0x6b, 0x8b, 0x9e, '!'}, true},
+		{"iso-8859-1", `{{.name}}`, []byte{'Z', 'o', 0xeb}, true},
+		{"latin1", `{{.city}}`, nil, false},
+		{"UTF-8", `{{.name}}`, []byte("Zoë"), true},
+	}
+	for _, test := range tests {
+		tmpl := Must(New(test.encoding).Option("encoding=" + test.encoding).Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got %x", test.encoding, buf.Bytes())
+		case !test.ok && buf.Len() > 0:
+			t.Errorf("%s: expected no output; got %x", test.encoding, buf.Bytes())
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.encoding, err)
+		case test.ok && !bytes.Equal(buf.Bytes(), test.out):
+			t.Errorf("%s: expected %x; got %x", test.encoding, test.out, buf.Bytes())
+		}
+	}
+
+	// Output filters run on the UTF-8 output, before the encoding.
+	tmpl := Must(New("filtered").Option("encoding=GBK").AddOutputFilter(func(out []byte) ([]byte, error) {
+		return append(out, "市"...), nil
+	}).Parse(`{{.city}}`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0xb1, 0xb1, 0xbe, 0xa9, 0xca, 0xd0}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("filtered: expected %x; got %x", want, buf.Bytes())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unknown encoding")
+		}
+	}()
+	New("bad").Option("encoding=klingon")
+}
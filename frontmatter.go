@@ -0,0 +1,112 @@
+// This file contains the front matter of template files.
+
+package gjson_template
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Metadata is the front matter of a template file: a block at the top of
+// the file, between lines of three dashes, of "key: value" lines.
+//
+//	---
+//	name: welcome-email
+//	version: 1.2.0
+//	schema: schemas/user.json
+//	options: missingkey=error, trimblocks
+//	owner: growth-team
+//	---
+//	Hello {{.name}}!
+//
+// Blank lines and lines starting with # are ignored. With the frontmatter
+// option, ParseFiles, ParseGlob, ParseFS and ParseReader read the front
+// matter of each file, which is not part of the template text, and make it
+// available through [Template.Metadata]. Without it, files are parsed as
+// they are, so templates of YAML documents starting with "---" keep their
+// first line. The line numbers of errors still count the lines of the
+// front matter.
+type Metadata struct {
+	Name    string            // Name of the template, for registries; the template is still named after its file.
+	Version string            // Version of the template.
+	Schema  string            // Reference to the schema of the data the template requires.
+	Options []string          // Options the template requires, for Template.Option; they are not applied.
+	Extra   map[string]string // Other keys.
+}
+
+// Metadata returns the front matter of the file t was parsed from, or nil
+// if it had none.
+func (t *Template) Metadata() *Metadata {
+	return t.meta
+}
+
+// frontMatterDelim is the line opening and closing front matter.
+const frontMatterDelim = "---"
+
+// splitFrontMatter returns the front matter at the top of the text of the
+// file name, if any, and the text with the front matter replaced by a
+// comment spanning as many lines, using the delimiters left and right.
+func splitFrontMatter(name, text, left, right string) (*Metadata, string, error) {
+	first, rest, ok := strings.Cut(text, "\n")
+	if !ok || strings.TrimRight(first, "\r") != frontMatterDelim {
+		return nil, text, nil
+	}
+	meta := &Metadata{}
+	lines := 1
+	for {
+		line, after, ok := strings.Cut(rest, "\n")
+		lines++
+		line = strings.TrimRight(line, "\r")
+		if line == frontMatterDelim {
+			rest = after
+			break
+		}
+		if !ok {
+			return nil, "", fmt.Errorf("template: %s: unterminated front matter", name)
+		}
+		rest = after
+		if err := meta.set(line); err != nil {
+			return nil, "", fmt.Errorf("template: %s:%d: front matter: %v", name, lines, err)
+		}
+	}
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	return meta, left + "/*" + strings.Repeat("\n", lines) + "*/" + right + rest, nil
+}
+
+// set sets the field of meta given by a line of front matter.
+func (meta *Metadata) set(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	key, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return fmt.Errorf("expected key: value; got %q", line)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	switch key {
+	case "name":
+		meta.Name = value
+	case "version":
+		meta.Version = value
+	case "schema":
+		meta.Schema = value
+	case "options":
+		for opt := range strings.SplitSeq(value, ",") {
+			if opt = strings.TrimSpace(opt); opt != "" {
+				meta.Options = append(meta.Options, opt)
+			}
+		}
+	default:
+		if meta.Extra == nil {
+			meta.Extra = make(map[string]string)
+		}
+		meta.Extra[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package gjson_template
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFrontMatter(t *testing.T) {
+	fsys := fstest.MapFS{
+		"welcome.tmpl": {Data: []byte(`---
+name: welcome-email
+version: 1.2.0
+# The data must match the schema.
+schema: schemas/user.json
+options: missingkey=error, trimblocks
+
+owner: growth-team
+---
+Hello {{.name}}!`)},
+		"plain.tmpl":  {Data: []byte("no front matter\n---\n")},
+		"broken.tmpl": {Data: []byte("---\nname: x\n---\n{{.a}\n")},
+		"delims.tmpl": {Data: []byte("---\nversion: 2\n---\n<<.name>>")},
+	}
+	tmpl, err := New("welcome.tmpl").Option("frontmatter").ParseFS(fsys, "welcome.tmpl", "plain.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, []byte(`{"name":"Ada"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello Ada!"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+	want := &Metadata{
+		Name:    "welcome-email",
+		Version: "1.2.0",
+		Schema:  "schemas/user.json",
+		Options: []string{"missingkey=error", "trimblocks"},
+		Extra:   map[string]string{"owner": "growth-team"},
+	}
+	if got := tmpl.Metadata(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected metadata %+v; got %+v", want, got)
+	}
+	if m := tmpl.Lookup("plain.tmpl").Metadata(); m != nil {
+		t.Errorf("plain: expected no metadata; got %+v", m)
+	}
+	clone := Must(tmpl.Clone())
+	if clone.Metadata() == nil || clone.Metadata().Version != "1.2.0" {
+		t.Errorf("clone: expected the metadata to be kept; got %+v", clone.Metadata())
+	}
+
+	// Error lines count the front matter.
+	_, err = New("broken.tmpl").Option("frontmatter").ParseFS(fsys, "broken.tmpl")
+	if err == nil || !strings.Contains(err.Error(), "broken.tmpl:4:") {
+		t.Errorf("expected error at line 4; got %v", err)
+	}
+
+	// The front matter is replaced by a comment in the delimiters of the
+	// template.
+	d, err := New("delims.tmpl").Delims("<<", ">>").Option("frontmatter").ParseFS(fsys, "delims.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := d.Execute(&buf, []byte(`{"name":"Ada"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Ada" || d.Metadata().Version != "2" {
+		t.Errorf("delims: got %q, %+v", buf.String(), d.Metadata())
+	}
+
+	for _, text := range []string{"---\nname: x\n", "---\nnot a key value\n---\n"} {
+		if _, err := New("bad").Option("frontmatter").ParseReader("bad", strings.NewReader(text)); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+
+	// Without the option, front matter is template text.
+	y, err := ParseFS(fstest.MapFS{"doc.yaml": {Data: []byte("---\nname: {{.name}}\n---\n")}}, "doc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := y.Execute(&buf, []byte(`{"name":"Ada"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "---\nname: Ada\n---\n"; buf.String() != want || y.Metadata() != nil {
+		t.Errorf("without frontmatter: expected %q and no metadata; got %q, %+v", want, buf.String(), y.Metadata())
+	}
+}
@@ -5,8 +5,12 @@
 package gjson_template
 
 import (
+	"encoding/base64"
+	hexenc "encoding/hex"
 	"errors"
 	"fmt"
+	"hash/adler32"
+	"hash/crc32"
 	"io"
 	"net/url"
 	"reflect"
@@ -33,6 +37,12 @@ import (
 // apply to arguments of arbitrary type can use parameters of type interface{} or
 // of type [reflect.Value]. Similarly, functions meant to return a result of arbitrary
 // type can return interface{} or [reflect.Value].
+//
+// A function whose first parameter has type [context.Context] receives the
+// context passed to [Template.ExecuteContext], or [context.Background] for
+// the other Execute methods; the template arguments fill its remaining
+// parameters. Similarly, a function whose first parameter, or first after
+// the context, has type *[ExecInfo] receives the state of the execution.
 type FuncMap map[string]any
 
 // builtins returns the FuncMap.
@@ -41,20 +51,78 @@ type FuncMap map[string]any
 // TODO: revert this back to a global map once golang.org/issue/2559 is fixed.
 func builtins() FuncMap {
 	f := FuncMap{
-		"and":      and,
-		"call":     emptyCall,
-		"html":     HTMLEscaper,
-		"index":    index,
-		"slice":    slice,
-		"js":       JSEscaper,
-		"len":      length,
-		"not":      not,
-		"or":       or,
-		"print":    fmt.Sprint,
-		"printf":   fmt.Sprintf,
-		"println":  fmt.Sprintln,
-		"urlquery": URLQueryEscaper,
-		"gjson":    gjsonFunc, // Add gjson function
+		"and":        and,
+		"call":       callFunc,
+		"html":       HTMLEscaper,
+		"index":      index,
+		"slice":      slice,
+		"js":         JSEscaper,
+		"len":        length,
+		"not":        not,
+		"or":         or,
+		"print":      fmt.Sprint,
+		"printf":     fmt.Sprintf,
+		"println":    fmt.Sprintln,
+		"urlquery":   URLQueryEscaper,
+		"gjson":      gjsonFunc, // Add gjson function
+		"jmespath":   jmespathFunc,
+		"jsonptr":    jsonptrFunc,
+		"path":       buildPath,
+		"pathEscape": pathEscape,
+		"t":          translateFunc,
+		"emit":       emitFunc,
+		"httpGet":    httpGetFunc,
+		"readFile":   readFileFunc,
+
+		// Terminal styling
+		"bold":  boldFunc,
+		"color": colorFunc,
+		"style": styleFunc,
+
+		// Binary output
+		"b64decWrite": b64decWriteFunc,
+		"hexdecWrite": hexdecWriteFunc,
+
+		// Checksums
+		"adler32": adler32Func,
+		"crc32":   crc32Func,
+
+		// MIME types
+		"extByMime": extByMime,
+		"mimeByExt": mimeByExt,
+
+		// JSON transformation
+		"defaults":     defaults,
+		"defaultsDeep": defaultsDeep,
+		"diff":         jsonDiff,
+		"entries":      entries,
+		"fromEntries":  fromEntries,
+		"jsonPatch":    jsonPatch,
+		"mergePatch":   mergePatch,
+		"page":         page,
+		"pageCount":    pageCount,
+		"renameKeys":   renameKeys,
+
+		// Text formatting
+		"deaccent":     deaccent,
+		"joinNatural":  joinNatural,
+		"levenshtein":  levenshtein,
+		"mask":         mask,
+		"normalize":    normalize,
+		"numberFormat": numberFormat,
+		"padLeft":      padLeft,
+		"padRight":     padRight,
+		"similarity":   similarity,
+		"table":        table,
+		"titleLocale":  titleLocale,
+		"wordwrap":     wordwrap,
+
+		// Dates and times
+		"dateAdd":    dateAdd,
+		"dateDiff":   dateDiff,
+		"dateLocale": dateLocale,
+		"dateSub":    dateSub,
+		"inTimezone": inTimezone,
 
 		// Comparisons
 		"eq": eq, // ==
@@ -70,6 +138,12 @@ func builtins() FuncMap {
 	for k, v := range extra {
 		f[k] = v
 	}
+	// Builtins that replace the Sprig function of the same name.
+	f["get"] = getValue
+	f["repeat"] = repeat
+	f["semverCompare"] = semverCompare
+	f["shuffle"] = shuffleFunc
+	f["sample"] = sampleFunc
 	return f
 }
 
@@ -78,6 +152,120 @@ func gjsonFunc(path string, dot gjson.Result) gjson.Result {
 	panic("unreachable") // implemented as a special case in evalCall
 }
 
+// jmespathFunc evaluates a JMESPath expression against dot or the given value
+func jmespathFunc(expr string, data ...gjson.Result) gjson.Result {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// translateFunc returns the localized message for key
+func translateFunc(key string, args ...gjson.Result) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// jsonptrFunc looks up an RFC 6901 JSON Pointer in dot or the given value
+func jsonptrFunc(ptr string, data ...gjson.Result) gjson.Result {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// shuffleFunc returns its array argument in random order
+func shuffleFunc(arr gjson.Result) gjson.Result {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// sampleFunc returns n random elements of its array argument
+func sampleFunc(arr, n gjson.Result) gjson.Result {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// emitFunc sets the value at a path of the document built by ExecuteJSON
+func emitFunc(path string, value gjson.Result) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// boldFunc returns its argument in bold for terminals
+func boldFunc(value gjson.Result) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// colorFunc returns its argument in a color for terminals
+func colorFunc(color string, value gjson.Result) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// styleFunc returns its argument with styles for terminals
+func styleFunc(styles string, value gjson.Result) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// httpGetFunc fetches a URL from an allowed host
+func httpGetFunc(url string) gjson.Result {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// readFileFunc returns the contents of a file below the file root
+func readFileFunc(path string) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// b64decWriteFunc writes the bytes encoded in base64 by its argument
+func b64decWriteFunc(value string) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// hexdecWriteFunc writes the bytes encoded in hexadecimal by its argument
+func hexdecWriteFunc(value string) string {
+	panic("unreachable") // implemented as a special case in evalCall
+}
+
+// decodeBinary decodes the string value for b64decWrite or hexdecWrite.
+// White space is ignored, and base64 may use the standard or URL alphabet,
+// with or without padding.
+func decodeBinary(name string, value gjson.Result) ([]byte, error) {
+	if value.Type != gjson.String {
+		return nil, fmt.Errorf("%s of non-string %s", name, describe(value))
+	}
+	s := strings.Join(strings.Fields(value.Str), "")
+	if name == "hexdecWrite" {
+		return hexenc.DecodeString(s)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: illegal base64 data", name)
+}
+
+// crc32Func returns the CRC-32 checksum of the textual representation of
+// value as 8 hexadecimal digits, with the IEEE polynomial or the one named
+// by the optional argument: "ieee", "castagnoli" or "koopman".
+func crc32Func(value gjson.Result, poly ...string) (string, error) {
+	if len(poly) > 1 {
+		return "", fmt.Errorf("crc32 takes at most 1 polynomial; got %d", len(poly))
+	}
+	table := crc32.IEEETable
+	if len(poly) == 1 {
+		switch strings.ToLower(poly[0]) {
+		case "ieee":
+		case "castagnoli":
+			table = crc32.MakeTable(crc32.Castagnoli)
+		case "koopman":
+			table = crc32.MakeTable(crc32.Koopman)
+		default:
+			return "", fmt.Errorf("crc32: unknown polynomial %q", poly[0])
+		}
+	}
+	s, _ := gjsonPrintableValue(value)
+	return fmt.Sprintf("%08x", crc32.Checksum([]byte(s), table)), nil
+}
+
+// adler32Func returns the Adler-32 checksum of the textual representation
+// of value as 8 hexadecimal digits.
+func adler32Func(value gjson.Result) string {
+	s, _ := gjsonPrintableValue(value)
+	return fmt.Sprintf("%08x", adler32.Checksum([]byte(s)))
+}
+
 var builtinFuncsOnce struct {
 	sync.Once
 	v map[string]reflect.Value
@@ -171,6 +359,20 @@ func findFunction(name string, tmpl *Template) (v reflect.Value, isBuiltin, ok b
 	return reflect.Value{}, false, false
 }
 
+// userFunc reports whether name is a function given to the execution or
+// added to the template, with Funcs or RegisterFunc1 and its variants.
+func (s *state) userFunc(name string) bool {
+	if _, ok := s.funcs[name]; ok {
+		return true
+	}
+	if s.tmpl == nil || s.tmpl.common == nil {
+		return false
+	}
+	s.tmpl.muFuncs.RLock()
+	defer s.tmpl.muFuncs.RUnlock()
+	return s.tmpl.parseFuncs[name] != nil
+}
+
 // prepareArg checks if value can be used as an argument of type argType, and
 // converts an invalid value to appropriate zero if possible.
 func prepareArg(value reflect.Value, argType reflect.Type) (reflect.Value, error) {
@@ -328,7 +530,8 @@ func length(item reflect.Value) (int, error) {
 
 // Function invocation
 
-func emptyCall(fn reflect.Value, args ...reflect.Value) reflect.Value {
+// callFunc executes the named macro with the given arguments
+func callFunc(name string, args ...gjson.Result) string {
 	panic("unreachable") // implemented as a special case in evalCall
 }
 
@@ -388,6 +591,9 @@ func safeCall(fun reflect.Value, args []reflect.Value) (val reflect.Value, err e
 			}
 		}
 	}()
+	if err := checkArgTypes(fun.Type(), args); err != nil {
+		return reflect.Value{}, err
+	}
 	ret := fun.Call(args)
 	if len(ret) == 2 && !ret[1].IsNil() {
 		return ret[0], ret[1].Interface().(error)
@@ -6,9 +6,15 @@ package gjson_template
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/tidwall/gjson"
 )
 
 // gjsonExecTest defines a template execution test using JSON data
@@ -36,6 +42,11 @@ var baseTestJSON = []byte(`{
 	"Null": null
 }`)
 
+// Linked list test JSON data
+var listTestJSON = []byte(`{
+	"List": {"value": "a", "next": {"value": "b", "next": {"value": "c", "next": null}}}
+}`)
+
 // Complex test JSON data, simulating the original tVal structure
 var complexTestJSON = []byte(`{
 	"True": true,
@@ -187,6 +198,30 @@ var gjsonExecTests = []gjsonExecTest{
 	{"nested object", "{{.Nested.Level1.Level2.Value}}", "nested", baseTestJSON, true},
 	{"array element", "{{index .Array 1}}", "2", baseTestJSON, true},
 	{"object property", "{{.Object.Name}}", "test", baseTestJSON, true},
+	{"numeric field index", "{{.Array.1}}", "2", baseTestJSON, true},
+	{"numeric index in chain", "{{.Nested.Level1.Level2.Value}}{{.Array.0}}", "nested1", baseTestJSON, true},
+	{"numeric index on variable", "{{$a := .Array}}{{$a.2}}", "3", baseTestJSON, true},
+	{"numeric index on root variable", "{{$.Array.0}}", "1", baseTestJSON, true},
+	{"numeric index on parens", "{{(.Array).1}}", "2", baseTestJSON, true},
+	{"numeric index out of range", "{{.Array.9}}", "", baseTestJSON, true},
+	{"negative index", "{{.Array.-1}}", "3", baseTestJSON, true},
+	{"negative index in chain", "{{.Items.-1.name}}", "c", []byte(`{"Items":[{"name":"a"},{"name":"b"},{"name":"c"}]}`), true},
+	{"negative index on parens", "{{(.Array).-1}}", "3", baseTestJSON, true},
+	{"negative index first", "{{.Array.-3}}", "1", baseTestJSON, true},
+	{"negative index on variable", "{{$a := .Array}}{{$a.-2}}", "2", baseTestJSON, true},
+	{"negative index out of range", "{{.Array.-4}}", "", baseTestJSON, true},
+	{"negative index on object", "{{.Object.-1}}", "", baseTestJSON, true},
+	{"negative number argument", "{{printf \"%v\" -1}}", "-1", baseTestJSON, true},
+	{"bracket index", "{{.Array[1]}}", "2", baseTestJSON, true},
+	{"bracket negative index", "{{.Array[-1]}}", "3", baseTestJSON, true},
+	{"bracket key", "{{.Object[\"Name\"]}}", "test", baseTestJSON, true},
+	{"bracket key with dot", "{{.m[\"fav.movie\"]}}", "Dune", []byte(`{"m":{"fav.movie":"Dune"}}`), true},
+	{"bracket key with spaces", "{{.m[`key with spaces`].x}}", "1", []byte(`{"m":{"key with spaces":{"x":1}}}`), true},
+	{"bracket key with wildcard", "{{.m[\"a*\"]}}", "", []byte(`{"m":{"ab":1}}`), true},
+	{"bracket on dot", "{{.[\"a b\"]}}", "1", []byte(`{"a b":1}`), true},
+	{"bracket on variable", "{{$o := .Object}}{{$o[\"Value\"]}}", "123", baseTestJSON, true},
+	{"bracket chain", "{{.Items[0][\"a.b\"][1]}}", "y", []byte(`{"Items":[{"a.b":["x","y"]}]}`), true},
+	{"dot number still a number", "{{printf \"%v\" .5}}", "0.5", baseTestJSON, true},
 
 	// Conditional tests
 	{"if true", "{{if .Bool}}YES{{end}}", "YES", baseTestJSON, true},
@@ -203,6 +238,16 @@ var gjsonExecTests = []gjsonExecTest{
 	{"range object", "{{range $k, $v := .Object}}{{$k}}={{$v}},{{end}}", "Name=test,Value=123,", baseTestJSON, true},
 	{"range empty", "{{range .Empty.Array}}{{.}}{{else}}EMPTY{{end}}", "EMPTY", baseTestJSON, true},
 
+	// Capture tests
+	{"capture", "{{capture $x}}<{{.String}}>{{end}}{{$x}}{{$x}}", "<hello><hello>", baseTestJSON, true},
+	{"capture len", "{{capture $x}}{{range .Array}}{{.}},{{end}}{{end}}{{$x}} has {{len $x}} bytes", "1,2,3, has 6 bytes", baseTestJSON, true},
+	{"capture pipe", "{{capture $x}} {{.Object.Name}} {{end}}{{$x | trim | upper}}", "TEST", baseTestJSON, true},
+	{"capture scope", "{{with .Object}}{{capture $x}}{{.Name}}{{end}}{{$x}}{{end}}", "test", baseTestJSON, true},
+	{"capture nested", "{{capture $x}}{{capture $y}}b{{end}}a{{$y}}{{end}}{{$x}}", "ab", baseTestJSON, true},
+	{"capture in range", "{{range .Array}}{{capture $x}}{{.}}{{.}}{{end}}[{{$x}}]{{end}}", "[11][22][33]", baseTestJSON, true},
+	{"capture break", "{{range .Array}}{{capture $x}}{{.}}{{if eq . 2}}{{break}}{{end}}{{end}}{{end}}done", "done", baseTestJSON, true},
+	{"capture error", "{{capture $x}}{{.String | printf \"%d\" | fail}}{{end}}{{$x}}", "", baseTestJSON, false},
+
 	// With statement tests
 	{"with", "{{with .Object}}{{.Name}}{{end}}", "test", baseTestJSON, true},
 	{"with else", "{{with .Null}}{{.}}{{else}}NULL{{end}}", "NULL", baseTestJSON, true},
@@ -279,6 +324,55 @@ var gjsonExecTests = []gjsonExecTest{
 	{"with true", "{{with .Object}}{{.Name}}{{end}}", "test", baseTestJSON, true},
 	{"with false", "{{with .Empty.Array}}{{.}}{{else}}EMPTY{{end}}", "EMPTY", baseTestJSON, true},
 
+	// Try tests
+	{"try ok", "{{try}}<{{.String}}>{{catch}}failed{{end}}", "<hello>", baseTestJSON, true},
+	{"try catch", "a{{try}}<{{fail \"boom\"}}>{{catch}}failed{{end}}b", "afailedb", baseTestJSON, true},
+	{"try catch var", "{{try}}{{fail \"boom\"}}{{catch $err}}{{contains \"boom\" $err}}{{end}}", "true", baseTestJSON, true},
+	{"try no catch", "a{{try}}x{{fail \"boom\"}}{{end}}b", "ab", baseTestJSON, true},
+	{"try nested", "{{try}}{{try}}{{fail \"inner\"}}{{catch}}1{{end}}{{fail \"outer\"}}{{catch}}2{{end}}", "2", baseTestJSON, true},
+	{"try rethrow", "{{try}}{{fail \"boom\"}}{{catch}}{{fail \"again\"}}{{end}}", "", baseTestJSON, false},
+	{"try in range", "{{range .Array}}{{try}}{{if eq . 2}}{{fail \"two\"}}{{end}}{{.}}{{catch}}-{{end}}{{end}}", "1-3", baseTestJSON, true},
+	{"try break", "{{range .Array}}{{try}}{{.}}{{if eq . 2}}{{break}}{{end}}{{end}}{{end}}", "12", baseTestJSON, true},
+
+	// For tests
+	{"for", "{{for 0 5}}{{.}},{{end}}", "0,1,2,3,4,", baseTestJSON, true},
+	{"for step", "{{for 1 10 3}}{{.}},{{end}}", "1,4,7,", baseTestJSON, true},
+	{"for down", "{{for 5 0 -2}}{{.}},{{end}}", "5,3,1,", baseTestJSON, true},
+	{"for var", "{{for $i := 0 3}}{{$i}}{{end}}", "012", baseTestJSON, true},
+	{"for assign", "{{$i := 9}}{{for $i = 0 3}}{{end}}{{$i}}", "2", baseTestJSON, true},
+	{"for data bounds", "{{for $i := 1 (len .Array)}}{{index $.Array $i}}{{end}}", "23", baseTestJSON, true},
+	{"for else", "{{for 3 3}}x{{else}}none{{end}}", "none", baseTestJSON, true},
+	{"for break", "{{for 0 10}}{{if eq . 3}}{{break}}{{end}}{{.}}{{end}}", "012", baseTestJSON, true},
+	{"for continue", "{{for 0 5}}{{if eq . 2}}{{continue}}{{end}}{{.}}{{end}}", "0134", baseTestJSON, true},
+	{"for zero step", "{{for 0 5 0}}x{{end}}", "", baseTestJSON, false},
+	{"for non-integer", "{{for 0 .String}}x{{end}}", "", baseTestJSON, false},
+
+	// While tests
+	{"while", "{{$i := 0}}{{while lt $i 3 max=10}}{{$i}}{{$i = add $i 1}}{{end}}", "012", baseTestJSON, true},
+	{"while cursor", "{{$n := .List}}{{while $n max=10}}{{$n.value}}{{$n = $n.next}}{{end}}", "abc", listTestJSON, true},
+	{"while dot", "{{with .Object}}{{$i := 0}}{{while lt $i 2 max=5}}{{.Name}}{{$i = add $i 1}}{{end}}{{end}}", "testtest", baseTestJSON, true},
+	{"while else", "{{while .Null max=5}}x{{else}}none{{end}}", "none", baseTestJSON, true},
+	{"while break", "{{$i := 0}}{{while true max=10}}{{if eq $i 2}}{{break}}{{end}}{{$i}}{{$i = add $i 1}}{{end}}", "01", baseTestJSON, true},
+	{"while continue", "{{$i := 0}}{{while lt $i 4 max=10}}{{$i = add $i 1}}{{if eq $i 2}}{{continue}}{{end}}{{$i}}{{end}}", "134", baseTestJSON, true},
+	{"while limit", "{{while true max=3}}x{{end}}", "", baseTestJSON, false},
+	{"while limit exact", "{{$i := 0}}{{while lt $i 3 max=3}}{{$i = add $i 1}}{{end}}{{$i}}", "3", baseTestJSON, true},
+
+	// Macro tests
+	{"macro", `{{macro "badge" level text}}[{{$level}}] {{$text}}{{end}}{{call "badge" "warn" .String}}`, "[warn] hello", baseTestJSON, true},
+	{"macro dollar params", `{{macro "pair" $k $v}}{{$k}}={{$v}}{{end}}{{call "pair" "n" .Number}}`, "n=42", baseTestJSON, true},
+	{"macro twice", `{{macro "b" x}}<{{$x}}>{{end}}{{call "b" 1}}{{call "b" 2}}`, "<1><2>", baseTestJSON, true},
+	{"macro pipe in", `{{macro "b" x}}<{{$x}}>{{end}}{{.String | call "b"}}`, "<hello>", baseTestJSON, true},
+	{"macro pipe out", `{{macro "b" x}}<{{$x}}>{{end}}{{call "b" .String | upper}}`, "<HELLO>", baseTestJSON, true},
+	{"macro object param", `{{macro "name" o}}{{$o.Name}}{{end}}{{call "name" .Object}}`, "test", baseTestJSON, true},
+	{"macro dot", `{{macro "n" }}{{.Name}}{{end}}{{with .Object}}{{call "n"}}{{end}}`, "test", baseTestJSON, true},
+	{"macro root", `{{macro "s"}}{{$.String}}{{end}}{{with .Object}}{{call "s"}}{{end}}`, "hello", baseTestJSON, true},
+	{"macro in range", `{{macro "b" x}}<{{$x}}>{{end}}{{range .Array}}{{call "b" .}}{{end}}`, "<1><2><3>", baseTestJSON, true},
+	{"macro nested call", `{{macro "a" x}}({{$x}}){{end}}{{macro "b" x}}[{{call "a" $x}}]{{end}}{{call "b" 1}}`, "[(1)]", baseTestJSON, true},
+	{"macro undefined", `{{call "nope" 1}}`, "", baseTestJSON, false},
+	{"macro not a macro", `{{define "t"}}x{{end}}{{call "t"}}`, "", baseTestJSON, false},
+	{"macro arg count", `{{macro "b" x}}{{$x}}{{end}}{{call "b" 1 2}}`, "", baseTestJSON, false},
+	{"macro recursion", `{{macro "r" x}}{{call "r" $x}}{{end}}{{call "r" 1}}`, "", baseTestJSON, false},
+
 	// Built-in function tests
 	{"len2", "{{len .Array}}", "3", baseTestJSON, true},
 	{"print", "{{print \"hello\"}}", "hello", baseTestJSON, true},
@@ -313,7 +407,13 @@ var gjsonExecTests = []gjsonExecTest{
 
 // TestGjsonExecute tests template execution using gjson implementation
 func TestGjsonExecute(t *testing.T) {
-	for _, test := range gjsonExecTests {
+	testGjsonExecute(t, gjsonExecTests)
+}
+
+// testGjsonExecute parses and executes each test, comparing the output.
+func testGjsonExecute(t *testing.T, tests []gjsonExecTest) {
+	t.Helper()
+	for _, test := range tests {
 		tmpl, err := New(test.name).Parse(test.input)
 		if err != nil {
 			t.Errorf("%s: parse error: %s", test.name, err)
@@ -342,6 +442,806 @@ func TestGjsonExecute(t *testing.T) {
 	}
 }
 
+// parseErrorTests are templates that must fail to parse with an error
+// containing the given text.
+var parseErrorTests = []struct {
+	name, input, err string
+}{
+	{"capture no variable", "{{capture}}x{{end}}", "unexpected"},
+	{"capture dollar", "{{capture $}}x{{end}}", "invalid variable"},
+	{"capture self", "{{capture $x}}{{$x}}{{end}}", "undefined variable"},
+	{"capture body scope", "{{capture $x}}{{$y := 1}}{{end}}{{$y}}", "undefined variable"},
+	{"capture no end", "{{capture $x}}x", "unexpected EOF"},
+	{"try no end", "{{try}}x", "unexpected EOF"},
+	{"try else", "{{try}}x{{else}}y{{end}}", "unexpected {{else}}"},
+	{"try two catches", "{{try}}x{{catch}}y{{catch}}z{{end}}", "unexpected {{catch}}"},
+	{"catch outside try", "{{catch}}", "unexpected {{catch}}"},
+	{"catch in if", "{{try}}{{if 1}}x{{catch}}y{{end}}{{end}}", "unexpected {{catch}}"},
+	{"catch var scope", "{{try}}x{{catch $e}}{{end}}{{$e}}", "undefined variable"},
+	{"for no bounds", "{{for}}x{{end}}", "missing value"},
+	{"for one bound", "{{for 5}}x{{end}}", "for requires"},
+	{"for too many bounds", "{{for 0 1 2 3}}x{{end}}", "for requires"},
+	{"for function", "{{for len .Array}}x{{end}}", "for requires"},
+	{"for pipeline", "{{for 0 1 | print}}x{{end}}", "single command"},
+	{"for two vars", "{{for $i, $j := 0 1}}x{{end}}", "too many declarations"},
+	{"while no limit", "{{while true}}x{{end}}", "requires an iteration limit"},
+	{"while zero limit", "{{while true max=0}}x{{end}}", "invalid while limit"},
+	{"while bad limit", "{{while true max=.N}}x{{end}}", "unexpected"},
+	{"while limit not last", "{{while max=3 true}}x{{end}}", "unexpected"},
+	{"while no condition", "{{while max=3}}x{{end}}", "empty command"},
+	{"while declare", "{{while $x := true max=3}}x{{end}}", "cannot declare"},
+	{"macro no name", "{{macro}}x{{end}}", "macro clause"},
+	{"macro duplicate param", "{{macro \"m\" a a}}x{{end}}", "duplicate parameter"},
+	{"macro bad param", "{{macro \"m\" 1}}x{{end}}", "unexpected"},
+	{"macro caller vars", "{{$y := 1}}{{macro \"m\" a}}{{$y}}{{end}}", "undefined variable"},
+	{"macro not top level", "{{if 1}}{{macro \"m\"}}x{{end}}{{end}}", "unexpected"},
+	{"macro redefined", "{{macro \"m\"}}x{{end}}{{macro \"m\"}}y{{end}}", "multiple definition"},
+	{"delims one", "{{delims \"[[\"}}", "delims clause"},
+	{"delims empty", "{{delims \"\" \"]]\"}}", "empty delimiter"},
+	{"delims nested", "{{if 1}}{{delims \"[[\" \"]]\"}}{{end}}", "unexpected"},
+	{"printf too few", "{{printf \"%s-%d\" 1}}", "needs 2 args; got 1"},
+	{"printf too many", "{{printf \"%s\" \"a\" \"b\"}}", "needs 1 args; got 2"},
+	{"printf piped", "{{1 | printf \"%d %d\"}}", "needs 2 args; got 1"},
+	{"printf star", "{{printf \"%*d\" 3}}", "needs 2 args; got 1"},
+	{"printf int verb", "{{printf \"%d\" \"x\"}}", "%d can't print string"},
+	{"printf float verb", "{{printf \"%.2f\" 3}}", "%f can't print int"},
+	{"printf string verb", "{{printf \"%s\" true}}", "%s can't print bool"},
+	{"printf nested", "{{len (printf \"%t\" 1)}}", "%t can't print int"},
+	{"printf unknown verb", "{{printf \"%y\" 1}}", "unknown verb %y"},
+	{"printf no verb", "{{printf \"100%\"}}", "missing verb"},
+	{"try body scope", "{{try}}{{$x := 1}}{{catch}}{{$x}}{{end}}", "undefined variable"},
+	{"bracket bad index", "{{.Array[x]}}", "bad index"},
+	{"bracket unterminated", "{{.Array[0}}", "bad index"},
+	{"bracket unterminated key", "{{.Object[\"Name]}}", "bad index"},
+	{"bracket trailing name", "{{.Array[0]x}}", "bad character"},
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range parseErrorTests {
+		_, err := New(test.name).Parse(test.input)
+		if err == nil {
+			t.Errorf("%s: expected parse error; got none", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q; got %q", test.name, test.err, err)
+		}
+	}
+}
+
+// TestBlockTrimming tests the trimblocks and lstripblocks options.
+func TestBlockTrimming(t *testing.T) {
+	tests := []struct {
+		name, opts, input, output string
+	}{
+		{"none", "", "{{range .Array}}\n  - {{.}}\n{{end}}\n", "\n  - 1\n\n  - 2\n\n  - 3\n\n"},
+		{"trim", "trimblocks", "{{range .Array}}\n  - {{.}}\n{{end}}\n", "  - 1\n  - 2\n  - 3\n"},
+		{"trim keeps values", "trimblocks", "{{.String}}\n{{.Number}}\n", "hello\n42\n"},
+		{"trim one newline", "trimblocks", "{{if true}}\n\nx{{end}}", "\nx"},
+		{"trim crlf", "trimblocks", "{{if true}}\r\nx{{end}}", "x"},
+		{"trim comment", "trimblocks", "{{/* note */}}\nx", "x"},
+		{"trim declaration", "trimblocks", "{{$x := 1}}\n{{$x = 2}}\n{{$x}}", "2"},
+		{"trim marker", "trimblocks", "a\n{{- if true -}}\n\n b{{end}}", "ab"},
+		{"lstrip", "lstripblocks", "a\n  {{if true}}x{{end}}", "a\nx"},
+		{"lstrip tabs first line", "lstripblocks", "\t{{if true}}x{{end}}", "x"},
+		{"lstrip not at line start", "lstripblocks", "a  {{if true}}x{{end}}", "a  x"},
+		{"lstrip after action", "lstripblocks", "{{.String}}  {{if true}}x{{end}}", "hello  x"},
+		{"lstrip keeps values", "lstripblocks", "  {{.String}}", "  hello"},
+		{"both", "trimblocks lstripblocks", "items:\n  {{range .Array}}\n  - {{.}}\n  {{end}}\ndone\n", "items:\n  - 1\n  - 2\n  - 3\ndone\n"},
+		{"both nested", "trimblocks lstripblocks", "{{with .Object}}\n    {{if .Name}}\n    name: {{.Name}}\n    {{end}}\n{{end}}\n", "    name: test\n"},
+	}
+	for _, test := range tests {
+		tmpl, err := New(test.name).Option(strings.Fields(test.opts)...).Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+			t.Errorf("%s: execute error: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.output {
+			t.Errorf("%s: expected %q; got %q", test.name, test.output, buf.String())
+		}
+	}
+}
+
+// TestFloatFormat tests the floatfmt option.
+func TestFloatFormat(t *testing.T) {
+	data := []byte(`{"pi": 3.14159265, "half": 0.50, "big": 1.5e22, "tiny": 0.0000123, "n": 42}`)
+	tests := []struct {
+		opt, input, output string
+	}{
+		{"", "{{.pi}} {{.half}} {{.big}} {{.n}} {{divf 1 4}}", "3.14159265 0.50 1.5e22 42 0.250000"},
+		{"floatfmt=prec:2", "{{.pi}} {{.half}} {{.n}} {{divf 1 4}} {{3.14159}}", "3.14 0.50 42 0.25 3.14"},
+		{"floatfmt=prec:3,trim", "{{.pi}} {{.half}} {{divf 1 4}} {{addf 1.5 0.5}}", "3.142 0.5 0.25 2"},
+		{"floatfmt=trim", "{{.pi}} {{.half}} {{divf 1 3}}", "3.14159265 0.5 0.3333333333333333"},
+		{"floatfmt=sci:4", "{{.big}} {{.tiny}} {{.pi}}", "1.5e+22 1.23e-05 3.14159265"},
+		{"floatfmt=prec:2,sci:3", "{{.big}} {{.tiny}} {{.pi}}", "1.50e+22 1.23e-05 3.14"},
+		{"floatfmt=prec:4,trim,sci:4", "{{.big}} {{mulf .pi 1000}}", "1.5e+22 3141.5927"},
+		{"floatfmt=prec:0", "{{.pi}} {{.n}}", "3 42"},
+	}
+	for _, test := range tests {
+		tmpl := New("floatfmt")
+		if test.opt != "" {
+			tmpl.Option(test.opt)
+		}
+		tmpl = Must(tmpl.Parse(test.input))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Errorf("%s: execute error: %s", test.opt, err)
+			continue
+		}
+		if buf.String() != test.output {
+			t.Errorf("%s: %s: expected %q; got %q", test.opt, test.input, test.output, buf.String())
+		}
+	}
+	for _, opt := range []string{"floatfmt=", "floatfmt=prec:-1", "floatfmt=sci:0", "floatfmt=trim:1", "floatfmt=round"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", opt)
+				}
+			}()
+			New("bad").Option(opt)
+		}()
+	}
+}
+
+type flagsKey struct{}
+
+// TestExecuteContext tests passing the context to functions.
+func TestExecuteContext(t *testing.T) {
+	tmpl := Must(New("ctx").Funcs(FuncMap{
+		"flag": func(ctx context.Context, name string) (bool, error) {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			flags, _ := ctx.Value(flagsKey{}).(map[string]bool)
+			return flags[name], nil
+		},
+		"tag": func(ctx context.Context, v gjson.Result) string {
+			return v.Get("name").String()
+		},
+	}).Parse(`{{if flag "beta"}}beta{{else}}stable{{end}} {{.user | tag}} {{flag .name}}`))
+	data := []byte(`{"name": "beta", "user": {"name": "Ada"}}`)
+
+	ctx := context.WithValue(context.Background(), flagsKey{}, map[string]bool{"beta": true})
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteContext(ctx, &buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := "beta Ada true"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := "stable Ada false"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf.Reset()
+	if err := tmpl.ExecuteContext(canceled, &buf, data); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled; got %v", err)
+	}
+}
+
+// TestExecInfo tests passing the execution state to functions.
+func TestExecInfo(t *testing.T) {
+	tmpl := Must(New("info").Funcs(FuncMap{
+		"rel": func(info *ExecInfo, path string) gjson.Result {
+			if v := info.Dot.Get(path); v.Exists() {
+				return v
+			}
+			return info.Root.Get(path)
+		},
+		"where": func(info *ExecInfo) string {
+			return info.Template + "@" + info.Location
+		},
+		"vars": func(ctx context.Context, info *ExecInfo, name string) string {
+			return info.Vars[name].String()
+		},
+	}).Parse(`{{define "sub"}}{{where}}{{end}}` +
+		`{{range $i, $u := .users}}{{rel "name"}}/{{rel "org"}}/{{vars "$i"}} {{end}}{{where}} {{template "sub" .}}`))
+	data := []byte(`{"org": "acme", "users": [{"name": "Ada"}, {"name": "Bob", "org": "other"}]}`)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Ada/acme/0 Bob/other/1 info@info:1:110 sub@info:1:18"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+}
+
+// TestSafeNav tests the safenav option.
+func TestSafeNav(t *testing.T) {
+	data := []byte(`{"user": {"name": "Ada", "address": null, "tags": []}}`)
+	tests := []struct {
+		input, output string
+		safe, ok      bool
+	}{
+		{`{{.user.name}}`, "Ada", true, true},
+		{`[{{.user.profile.bio}}]`, "[]", true, true},
+		{`[{{.account.owner.name}}]`, "[]", true, true},
+		{`[{{.user.address.city}}]`, "[]", true, true},
+		{`[{{$u := .account.owner}}{{$u.name}}]`, "[]", true, true},
+		{`[{{(.account.owner).name}}]`, "[]", true, true},
+		{`{{with .user.profile.bio}}{{.}}{{else}}none{{end}}`, "none", true, true},
+		{`{{.user.age}}`, "", true, false},
+		{`{{.nobody}}`, "", true, false},
+		{`{{.user.profile.bio}}`, "", false, false},
+		{`{{.user.address.city}}`, "", false, false},
+	}
+	for _, test := range tests {
+		opts := []string{"missingkey=error"}
+		if test.safe {
+			opts = append(opts, "safenav")
+		}
+		tmpl := Must(New("safenav").Option(opts...).Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s (safenav=%t): expected error; got %q", test.input, test.safe, buf.String())
+		case test.ok && err != nil:
+			t.Errorf("%s (safenav=%t): unexpected error: %s", test.input, test.safe, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s (safenav=%t): expected %q; got %q", test.input, test.safe, test.output, buf.String())
+		}
+	}
+}
+
+// TestPrintfCheck tests printf calls that pass the parse-time check.
+func TestPrintfCheck(t *testing.T) {
+	tests := []struct{ input, output string }{
+		{`{{printf "%d%%" 50}}`, "50%"},
+		{`{{printf "%5.1f|%-4s|%x|%v" 2.5 "ab" "hi" true}}`, "  2.5|ab  |6869|true"},
+		{`{{printf "%*d" 4 7}}`, "   7"},
+		{`{{printf "%[2]s %[1]s" "a" "b"}}`, "b a"},
+		{`{{printf "%d" .String}}`, "%!d(string=hello)"},
+		{`{{"put" | printf "%s%s" "out" | printf "%q"}}`, `"output"`},
+		{`{{.Number | printf "n=%d"}}`, "n=42"},
+		{`{{printf "%T|%T" 1 "a"}}`, "int|string"},
+	}
+	for _, test := range tests {
+		tmpl, err := New("printf").Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected parse error: %s", test.input, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+			t.Errorf("%s: unexpected execute error: %s", test.input, err)
+			continue
+		}
+		if buf.String() != test.output {
+			t.Errorf("%s: expected %q; got %q", test.input, test.output, buf.String())
+		}
+	}
+}
+
+// TestPrintfCheckReplaced tests that calls of a printf added with Funcs
+// are not checked against the verbs of the builtin.
+func TestPrintfCheckReplaced(t *testing.T) {
+	tmpl, err := New("printf").Funcs(FuncMap{
+		"printf": func(format string, n int) string { return strings.Repeat(format, n) },
+	}).Parse(`{{printf "%d" 2}} {{printf "%z" 1}}`)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %s", err)
+	}
+	out, err := tmpl.ExecuteString(baseTestJSON)
+	if err != nil || out != "%d%d %z" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	if _, err := New("printf").Parse(`{{printf "%z" 1}}`); err == nil {
+		t.Error("expected the builtin printf to be checked")
+	}
+}
+
+// TestLateFuncs tests parsing with functions added later, and Check.
+func TestLateFuncs(t *testing.T) {
+	const text = `{{define "sub"}}{{shout .String}}{{end}}{{template "sub" .}} {{len (greet)}}`
+	if _, err := New("early").Parse(text); err == nil || !strings.Contains(err.Error(), "not defined") {
+		t.Fatalf("expected undefined function error; got %v", err)
+	}
+	tmpl := Must(New("late").Option("latefuncs").Parse(text))
+	err := tmpl.Check()
+	if err == nil || !strings.Contains(err.Error(), `late:1:68: function "greet" not defined`) {
+		t.Errorf("expected greet to be undefined; got %v", err)
+	}
+	RegisterFunc0(tmpl, "greet", func() string { return "hi" })
+	err = tmpl.Check()
+	if err == nil || !strings.Contains(err.Error(), `function "shout" not defined`) {
+		t.Errorf("expected shout to be undefined; got %v", err)
+	}
+	tmpl.Funcs(FuncMap{"shout": strings.ToUpper})
+	if err := tmpl.Check(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if want := "HELLO 2"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+}
+
+// TestFuncsShadowBuiltins tests that functions added to the template take
+// precedence over the builtins evaluated specially.
+func TestFuncsShadowBuiltins(t *testing.T) {
+	user := func(name string) func(...any) string {
+		return func(args ...any) string { return fmt.Sprint(append([]any{name}, args...)...) }
+	}
+	names := []string{"t", "call", "emit", "httpGet", "readFile", "shuffle", "sample", "color", "bold", "style", "gjson", "len", "printf"}
+	funcs := FuncMap{}
+	for _, name := range names {
+		funcs[name] = user(name)
+	}
+	for _, name := range names {
+		tmpl := Must(New(name).Funcs(funcs).Parse(`{{` + name + ` "x"}}`))
+		out, err := tmpl.ExecuteString([]byte(`{}`))
+		if want := name + "x"; err != nil || out != want {
+			t.Errorf("%s: expected %q; got %q, %v", name, want, out, err)
+		}
+	}
+	tmpl := New("typed")
+	RegisterFunc1(tmpl, "bold", func(s string) string { return "*" + s + "*" })
+	if out, err := Must(tmpl.Parse(`{{bold "x"}}`)).ExecuteString([]byte(`{}`)); err != nil || out != "*x*" {
+		t.Errorf("typed: expected %q; got %q, %v", "*x*", out, err)
+	}
+}
+
+// TestKeywordFuncs tests that keywords added by this package are names of
+// functions in templates parsed with functions of these names.
+func TestKeywordFuncs(t *testing.T) {
+	for _, name := range []string{"break", "continue", "for", "while", "capture", "try", "catch", "macro", "delims"} {
+		tmpl := New(name).Funcs(FuncMap{name: func(s string) string { return name + ":" + s }})
+		tmpl, err := tmpl.Parse(`{{range .a}}{{` + name + ` .}} {{end}}`)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		out, err := tmpl.ExecuteString([]byte(`{"a":["x","y"]}`))
+		if want := name + ":x " + name + ":y "; err != nil || out != want {
+			t.Errorf("%s: expected %q; got %q, %v", name, want, out, err)
+		}
+	}
+}
+
+// TestStrictVars tests the variable checks of the strictvars option.
+func TestStrictVars(t *testing.T) {
+	tests := []struct {
+		name, input string
+		err         string // empty if Parse succeeds
+	}{
+		{"declare and assign", `{{$x := 1}}{{if .a}}{{$x = 2}}{{end}}{{$x}}`, ""},
+		{"sibling declarations", `{{if .a}}{{$x := 1}}{{else}}{{$x := 2}}{{end}}{{$x := 3}}`, ""},
+		{"redeclare", `{{$x := 1}}{{$x := 2}}`, "variable $x is already declared; use = to assign to it"},
+		{"shadow dollar", `{{with $ := .a}}{{end}}`, "variable $ is already declared"},
+		{"shadow loop variable", `{{range $i, $v := .a}}{{range $i := .b}}{{end}}{{end}}`, "variable $i is already declared"},
+		{"assign undeclared", `{{$y = 1}}`, "assignment to undeclared variable $y"},
+		{"range assign", `{{$i := 0}}{{range $i = .a}}{{end}}`, ""},
+		{"capture", `{{$out := 1}}{{capture $out}}x{{end}}`, "variable $out is already declared"},
+		{"ended scope", `{{range .a}}{{$x := .}}{{end}}{{$x}}`, `variable "$x" is out of scope`},
+		{"sibling scope", `{{if .a}}{{$x := 1}}{{else}}{{$x}}{{end}}`, `variable "$x" is out of scope`},
+		{"pipeline in else", `{{with $v := .a}}{{$v}}{{else}}{{$v}}{{end}}`, ""},
+		{"else with", `{{with $x := .a}}{{$x}}{{else with $x := .b}}{{$x}}{{else with $x := .c}}{{$x}}{{end}}`, ""},
+		{"else if", `{{if $x := .a}}{{$x}}{{else if $x := .b}}{{$x}}{{else}}{{$x}}{{end}}`, ""},
+		{"else with shadowing", `{{$x := 1}}{{with .a}}{{else with $x := .b}}{{end}}`, "variable $x is already declared"},
+		{"else with ended", `{{with $x := .a}}{{else with .b}}{{$x}}{{end}}`, `variable "$x" is out of scope`},
+	}
+	for _, test := range tests {
+		_, err := New(test.name).Option("strictvars").Parse(test.input)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error containing %q; got %v", test.name, test.err, err)
+		}
+		if test.err != "" && test.name != "ended scope" {
+			// Without the option, only reads after a block are rejected.
+			if _, err := New(test.name).Parse(test.input); err != nil {
+				t.Errorf("%s: unexpected error without strictvars: %s", test.name, err)
+			}
+		}
+	}
+}
+
+// TestMaxDepth tests the maxdepth option.
+func TestMaxDepth(t *testing.T) {
+	const text = `{{define "node"}}{{.n}}{{with .child}}({{template "node" .}}){{end}}{{end}}{{template "node" .}}`
+	data := []byte(`{"n":1,"child":{"n":2,"child":{"n":3,"child":{"n":4}}}}`)
+	for _, test := range []struct {
+		depth  string
+		output string
+		err    bool
+	}{
+		{"", "1(2(3(4)))", false},
+		{"maxdepth=4", "1(2(3(4)))", false},
+		{"maxdepth=3", "", true},
+		{"maxdepth=0", "1(2(3(4)))", false},
+	} {
+		tmpl := New("depth")
+		if test.depth != "" {
+			tmpl.Option(test.depth)
+		}
+		tmpl = Must(tmpl.Parse(text))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		if test.err {
+			if err == nil || !strings.Contains(err.Error(), "exceeded maximum template depth (3)") {
+				t.Errorf("%q: expected depth error; got %v", test.depth, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.depth, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%q: expected %q; got %q", test.depth, test.output, buf.String())
+		}
+	}
+	tmpl := Must(New("method").MaxDepth(3).Parse(text))
+	if err := tmpl.Execute(&bytes.Buffer{}, data); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("MaxDepth(3): expected ErrMaxDepth; got %v", err)
+	}
+	if out, err := tmpl.MaxDepth(0).ExecuteString(data); err != nil || out != "1(2(3(4)))" {
+		t.Errorf("MaxDepth(0): got %q, %v", out, err)
+	}
+	for _, set := range []func(){
+		func() { New("x").MaxDepth(-1) },
+		func() { New("x").Option("maxdepth=-1") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("negative limit: expected panic")
+				}
+			}()
+			set()
+		}()
+	}
+	macro := Must(New("macro").Option("maxdepth=2").Parse(`{{macro "m" $n}}{{if $n}}{{call "m" (sub $n 1)}}{{end}}{{end}}{{call "m" 5}}`))
+	if err := macro.Execute(&bytes.Buffer{}, data); err == nil || !strings.Contains(err.Error(), "exceeded maximum template depth (2)") {
+		t.Errorf("macro: expected depth error; got %v", err)
+	}
+}
+
+// TestRangeQuery tests ranging over backquoted GJSON paths, which must
+// produce the same output as ranging over the gjson function's result.
+func TestRangeQuery(t *testing.T) {
+	data := []byte(`{"items":[
+		{"name":"a","active":true,"tags":["x"]},
+		{"name":"b (1)","active":false},
+		{"name":"c","active":true,"meta":{"n":1}},
+		{"active":true}
+	],"groups":{"list":[{"k":"p"},{"k":"q"}]},"nums":[1,2,3,"4",true,null]}`)
+	for _, path := range []string{
+		`items.#(active==true)#`,
+		`items.#(active==true)#.name`,
+		`items.#(name=="b (1)")#.name`,
+		`items.#(name%"*")#.meta.n`,
+		`items.#(active==false)#.tags`,
+		`items.#(name=="zzz")#`,
+		`groups.list.#(k!="p")#.k`,
+		`groups.list`,
+		`items.#.name`,
+		`items.#(active==true).name`,
+		`items.#(active)#.name`,
+		`items.#(active==~true)#.name`,
+		`items.#(active==~false)#.name`,
+		`items.#(name!%"b*")#.name`,
+		`items.#(meta.n>=1)#.name`,
+		`items.#(tags.#(=="x"))#.name`,
+		`items.#( name == "c" )#.name`,
+		`groups.list.#(k>"p")#`,
+		`nums.#(>1)#`,
+		`nums.#(=="4")#`,
+		`nums.#(==true)#`,
+		`nums.#(==~null)#`,
+	} {
+		lazy := Must(New("lazy").Parse("{{range $i, $v := gjson `" + path + "`}}[{{$i}}:{{$v}}]{{else}}none{{end}}"))
+		eager := Must(New("eager").Parse(`{{range $i, $v := (gjson "` + strings.ReplaceAll(path, `"`, `\"`) + `")}}[{{$i}}:{{$v}}]{{else}}none{{end}}`))
+		var got, want bytes.Buffer
+		if err := lazy.Execute(&got, data); err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		if err := eager.Execute(&want, data); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: expected %q; got %q", path, want.String(), got.String())
+		}
+	}
+	tmpl := Must(New("break").Parse("{{range gjson `items.#(active==true)#.name`}}{{if eq . \"c\"}}{{break}}{{end}}{{.}}{{end}}"))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a" {
+		t.Errorf("break: expected %q; got %q", "a", buf.String())
+	}
+	tmpl = Must(New("missing").Option("missingkey=error").Parse(`{{range gjson "nope.#(a==1)#"}}{{.}}{{end}}`))
+	if err := tmpl.Execute(&buf, data); !errors.Is(err, ErrMissingPath) || !strings.Contains(err.Error(), `gjson path "nope.#(a==1)#" not found`) {
+		t.Errorf("missing: expected error; got %v", err)
+	}
+
+	// A raw string is a string, not a path.
+	buf.Reset()
+	tmpl = Must(New("raw").Parse("{{range $v := `items.#(active==true)#`}}{{$v}}{{else}}none{{end}}"))
+	if err := tmpl.Execute(&buf, data); err != nil || buf.String() != "items.#(active==true)#" {
+		t.Errorf("raw: expected the string; got %q, %v", buf.String(), err)
+	}
+
+	// A function named gjson is called as it is.
+	buf.Reset()
+	tmpl = Must(New("shadowed").Funcs(FuncMap{"gjson": func(p string) string { return "<" + p + ">" }}).Parse(`{{range $v := gjson "items.#(active==true)#"}}{{$v}}{{end}}`))
+	if want := "<items.#(active==true)#>"; tmpl.Execute(&buf, data) != nil || buf.String() != want {
+		t.Errorf("shadowed: expected %q; got %q", want, buf.String())
+	}
+}
+
+// TestConcurrentLookup tests lookups and executions concurrent with the
+// definition of new templates in the same set.
+func TestConcurrentLookup(t *testing.T) {
+	set := Must(New("root").Parse(`{{define "name"}}{{.name}}{{end}}{{template "name" .}}`))
+	data := []byte(`{"name":"Ada"}`)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			for range 100 {
+				buf.Reset()
+				if err := set.Lookup("root").Execute(&buf, data); err != nil || buf.String() != "Ada" {
+					t.Errorf("got %q, %v", buf.String(), err)
+					return
+				}
+				_ = set.DefinedTemplates()
+			}
+		}()
+	}
+	for i := range 50 {
+		if _, err := set.New(fmt.Sprintf("t%d", i)).Parse(`x`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if n := len(set.Templates()); n != 52 {
+		t.Errorf("expected 52 templates; got %d", n)
+	}
+}
+
+// TestMissingKeyOption tests the missingkey option on the ways of
+// addressing a value that is not in the data.
+func TestMissingKeyOption(t *testing.T) {
+	inputs := []string{
+		`{{.nope}}`,
+		`{{.Object.nope}}`,
+		`{{.Array.7}}`,
+		`{{.Array[-9]}}`,
+		`{{.Object["no pe"]}}`,
+		`{{$o := .Object}}{{$o.nope}}`,
+		`{{gjson "Object.nope"}}`,
+	}
+	for _, opt := range []string{"", "missingkey=default", "missingkey=invalid", "missingkey=zero", "missingkey=error"} {
+		for _, input := range inputs {
+			tmpl := New("missing")
+			if opt != "" {
+				tmpl.Option(opt)
+			}
+			tmpl = Must(tmpl.Parse(input))
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, baseTestJSON)
+			switch {
+			case opt == "missingkey=error" && !errors.Is(err, ErrMissingPath):
+				t.Errorf("%s with %s: expected ErrMissingPath; got %v", input, opt, err)
+			case opt != "missingkey=error" && err != nil:
+				t.Errorf("%s with %s: unexpected error: %s", input, opt, err)
+			case opt != "missingkey=error" && buf.String() != "":
+				t.Errorf("%s with %s: expected no output; got %q", input, opt, buf.String())
+			}
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unknown missingkey value")
+		}
+	}()
+	New("bad").Option("missingkey=bogus")
+}
+
+// TestNoValueOption tests the printing of missing values with the
+// novalue option.
+func TestNoValueOption(t *testing.T) {
+	tests := []struct {
+		opts          []string
+		input, output string
+	}{
+		{nil, `[{{.nope}}]`, "[]"},
+		{[]string{"novalue"}, `[{{.nope}}] [{{.Object.nope}}] [{{.String}}]`, "[<no value>] [<no value>] [hello]"},
+		{[]string{"novalue=MISSING"}, `[{{.nope}}] [{{.Null}}]`, "[MISSING] [null]"},
+		{[]string{"novalue=MISSING", "missingkey=zero"}, `[{{.Array.7}}]`, "[MISSING]"},
+		{[]string{"novalue", "novalue="}, `[{{.nope}}]`, "[]"},
+		{[]string{"novalue"}, `[{{$x := .nope}}{{if .nope}}y{{end}}]`, "[]"},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("novalue").Option(test.opts...).Parse(test.input))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+			t.Errorf("%s with %v: unexpected error: %s", test.input, test.opts, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%s with %v: expected %q; got %q", test.input, test.opts, test.output, buf.String())
+		}
+	}
+}
+
+// TestNullOption tests the printing of null values with the null option.
+func TestNullOption(t *testing.T) {
+	tests := []struct {
+		opts          []string
+		input, output string
+	}{
+		{nil, `[{{.Null}}]`, "[null]"},
+		{[]string{"null="}, `[{{.Null}}] [{{.Object}}]`, `[] [{"Name": "test", "Value": 123}]`},
+		{[]string{"null=~"}, `[{{.Null}}] [{{.nope}}] [{{.Bool}}]`, "[~] [] [true]"},
+		{[]string{"null=", "null=null"}, `[{{.Null}}]`, "[null]"},
+		{[]string{"null=-", "novalue=?"}, `[{{.Null}}{{.nope}}]`, "[-?]"},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("null").Option(test.opts...).Parse(test.input))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+			t.Errorf("%s with %v: unexpected error: %s", test.input, test.opts, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%s with %v: expected %q; got %q", test.input, test.opts, test.output, buf.String())
+		}
+	}
+}
+
+// TestStrictJSONOption tests the validation of the data with the
+// strictjson option.
+func TestStrictJSONOption(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"a": 1}`, ""},
+		{`[1, 2]`, ""},
+		{`{"a": 1} x`, `invalid character 'x' after top-level value at byte offset 10`},
+		{`{"a": 1,}`, `invalid character '}' looking for beginning of object key string at byte offset 9`},
+		{`[1`, `unexpected end of JSON input`},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("strict").Option("strictjson").Parse(`{{.a}}`))
+		err := tmpl.Execute(io.Discard, []byte(test.data))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.data, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error containing %q; got %v", test.data, test.err, err)
+		}
+	}
+
+	// Without the option, the start of the data is enough.
+	if err := Must(New("lax").Parse(`{{.a}}`)).Execute(io.Discard, []byte(`{"a": 1} x`)); err != nil {
+		t.Errorf("without strictjson: unexpected error: %s", err)
+	}
+}
+
+// TestExecuteResult tests executing templates with data parsed once.
+func TestExecuteResult(t *testing.T) {
+	data := gjson.ParseBytes(baseTestJSON)
+	tests := []struct {
+		input, output string
+		data          gjson.Result
+	}{
+		{`{{.String}} {{.Array.1}} {{$.Object.Name}}`, "hello 2 test", data},
+		{`{{range .}}{{.}};{{end}}`, "1;2;3;", data.Get("Array")},
+		{`{{.Name}}`, "test", data.Get("Object")},
+		{`[{{.}}]`, "[42]", data.Get("Number")},
+		{`[{{.}}]`, "[]", data.Get("nope")},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("result").Parse(test.input))
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteResult(&buf, test.data); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.input, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%s: expected %q; got %q", test.input, test.output, buf.String())
+		}
+	}
+
+	tmpl := Must(New("filtered").AddOutputFilter(func(out []byte) ([]byte, error) {
+		return bytes.ToUpper(out), nil
+	}).Parse(`{{.String}}`))
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteResult(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "HELLO" {
+		t.Errorf("filtered: expected %q; got %q", "HELLO", buf.String())
+	}
+}
+
+// TestExecuteStringBytes tests the methods returning the output.
+func TestExecuteStringBytes(t *testing.T) {
+	tmpl := Must(New("out").Parse(`{{.String}} {{.Number}}`))
+	for i := 0; i < 3; i++ {
+		str, err := tmpl.ExecuteString(baseTestJSON)
+		if err != nil || str != "hello 42" {
+			t.Errorf("ExecuteString: got %q, %v", str, err)
+		}
+		b, err := tmpl.ExecuteBytes(baseTestJSON)
+		if err != nil || string(b) != "hello 42" {
+			t.Errorf("ExecuteBytes: got %q, %v", b, err)
+		}
+	}
+	// The returned bytes must not be reused by later executions.
+	first, _ := tmpl.ExecuteBytes(baseTestJSON)
+	tmpl.ExecuteBytes([]byte(`{"String":"xxxxx","Number":0}`))
+	if string(first) != "hello 42" {
+		t.Errorf("ExecuteBytes output changed to %q", first)
+	}
+
+	bad := Must(New("bad").Parse(`partial{{fail "boom"}}`))
+	if str, err := bad.ExecuteString(baseTestJSON); err == nil || str != "" {
+		t.Errorf("ExecuteString: expected error and no output; got %q, %v", str, err)
+	}
+	if b, err := bad.ExecuteBytes([]byte("not json")); err == nil || b != nil {
+		t.Errorf("ExecuteBytes: expected error and no output; got %q, %v", b, err)
+	}
+}
+
+// TestRangeLimits tests the maxrange and maxiterations options.
+func TestRangeLimits(t *testing.T) {
+	data := []byte(`{"a":[1,2,3],"b":[4,5],"o":{"x":1,"y":2,"z":3}}`)
+	tests := []struct {
+		opt, input, output string
+		ok                 bool
+	}{
+		{"maxrange=3", `{{range .a}}{{.}}{{end}}`, "123", true},
+		{"maxrange=2", `{{range .a}}{{.}}{{end}}`, "", false},
+		{"maxrange=2", `{{range .b}}{{.}}{{end}}{{range .b}}{{.}}{{end}}`, "4545", true},
+		{"maxrange=2", `{{range .o}}{{.}}{{end}}`, "", false},
+		{"maxrange=2", `{{range 3}}{{.}}{{end}}`, "", false},
+		{"maxrange=2", "{{range gjson `a.#(>0)#`}}{{.}}{{end}}", "", false},
+		{"maxrange=2", `{{range .a}}{{if eq . 2}}{{break}}{{end}}{{.}}{{end}}`, "1", true},
+		{"maxiterations=5", `{{range .a}}{{.}}{{end}}{{range .b}}{{.}}{{end}}`, "12345", true},
+		{"maxiterations=4", `{{range .a}}{{.}}{{end}}{{range .b}}{{.}}{{end}}`, "", false},
+		{"maxiterations=6", `{{range .b}}{{range $.a}}{{.}}{{end}}{{end}}`, "", false},
+		{"maxiterations=4", `{{define "t"}}{{range .}}{{.}}{{end}}{{end}}{{template "t" .a}}{{template "t" .b}}`, "", false},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("limits").Option(test.opt).Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && !errors.Is(err, ErrBudgetExceeded):
+			t.Errorf("%s with %s: expected ErrBudgetExceeded; got %v", test.input, test.opt, err)
+		case test.ok && err != nil:
+			t.Errorf("%s with %s: unexpected error: %s", test.input, test.opt, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s with %s: expected %q; got %q", test.input, test.opt, test.output, buf.String())
+		}
+	}
+	// Each execution counts from zero.
+	tmpl := Must(New("repeat").Option("maxiterations=3").Parse(`{{range .a}}{{.}}{{end}}`))
+	for i := 0; i < 2; i++ {
+		if out, err := tmpl.ExecuteString(data); err != nil || out != "123" {
+			t.Errorf("execution %d: got %q, %v", i, out, err)
+		}
+	}
+}
+
 // TestGjsonTemplateFiles tests template file loading
 func TestGjsonTemplateFiles(t *testing.T) {
 	// This test depends on files in the testdata directory
@@ -366,6 +1266,306 @@ func TestGjsonTemplateFiles(t *testing.T) {
 	}
 }
 
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+// TestParseReader tests parsing templates read from an io.Reader.
+func TestParseReader(t *testing.T) {
+	tmpl, err := ParseReader("page", strings.NewReader(`{{.String}}:{{template "part" .Number}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.ParseReader("part", strings.NewReader(`[{{.}}]`)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hello:[42]"; buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+
+	// Parsing the template's own name replaces its contents.
+	if _, err := tmpl.ParseReader("page", strings.NewReader(`{{.Bool}}`)); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "true"; buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+
+	if _, err := ParseReader("bad", errReader{}); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("expected read error; got %v", err)
+	}
+	if _, err := ParseReader("bad", strings.NewReader(`{{.x`)); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+// TestDefineFromJSON tests defining templates from a JSON document.
+func TestDefineFromJSON(t *testing.T) {
+	cfg := []byte(`{
+		"route": "/api",
+		"partials": {
+			"header": "<h1>{{.String}}</h1>",
+			"footer": "<p>{{template \"year\" .}}</p>",
+			"year": "{{.Number}}"
+		}
+	}`)
+	tmpl := Must(New("page").Parse(`{{template "header" .}}|{{template "footer" .}}`))
+	if _, err := tmpl.DefineFromJSON(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<h1>hello</h1>|<p>42</p>"; buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+
+	for _, bad := range []string{
+		`{"partials": `,
+		`{"partials": ["x"]}`,
+		`{"partials": {"x": 1}}`,
+		`{"partials": {"x": "{{.a"}}`,
+	} {
+		if _, err := New("bad").DefineFromJSON([]byte(bad)); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+	if _, err := New("none").DefineFromJSON([]byte(`{"route": "/"}`)); err != nil {
+		t.Errorf("no partials: unexpected error %v", err)
+	}
+
+	// A failing configuration leaves the templates as they were.
+	if _, err := tmpl.DefineFromJSON([]byte(`{"partials": {"header": "new", "extra": "x", "footer": "{{.a"}}`)); err == nil {
+		t.Fatal("expected error")
+	}
+	buf.Reset()
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<h1>hello</h1>|<p>42</p>"; buf.String() != expected || tmpl.Lookup("extra") != nil {
+		t.Errorf("after error: expected %q and no extra template; got %q, %v", expected, buf.String(), tmpl.Lookup("extra"))
+	}
+}
+
+// TestDelimsPragma tests templates that set their own delimiters.
+func TestDelimsPragma(t *testing.T) {
+	set, err := ParseFiles("testdata/file2.tmpl", "testdata/delims.tmpl")
+	if err != nil {
+		t.Fatalf("error parsing files: %v", err)
+	}
+	if _, err := set.New("main").Parse(`{{template "delims.tmpl" .}}|{{template "raw" .Number}}|{{template "dot" .Number}}`); err != nil {
+		t.Fatalf("error parsing main: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, "main", baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	expected := "{\"greeting\": \"hello\", \"client\": \"{{ name }}\"}\n|{{.}}|42"
+	if buf.String() != expected {
+		t.Errorf("delims: expected %q got %q", expected, buf.String())
+	}
+
+	// The pragma may appear later in the text and more than once.
+	tmpl := Must(New("switch").Parse(`{{.String}}{{delims "<%" "%>"}}<%.Number%>{{x}}<%delims "{{" "}}"%>{{.Bool}}`))
+	buf.Reset()
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hello42{{x}}true"; buf.String() != expected {
+		t.Errorf("delims: expected %q got %q", expected, buf.String())
+	}
+
+	// The pragma may appear within the body of a definition, and applies
+	// to the rest of the text.
+	tmpl = Must(New("body").Parse(`{{define "json"}}{{.String}}{{delims "[[" "]]"}}{"n": [[.Number]]}[[end]]` +
+		`[[block "b" .]]{{x}}[[if .Bool]][[delims "<" ">"]]<.String><end><end><template "json" .>`))
+	buf.Reset()
+	if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{{x}}hellohello{"n": 42}`; buf.String() != expected {
+		t.Errorf("delims in body: expected %q got %q", expected, buf.String())
+	}
+}
+
+// TestExecuteBlock tests rendering named blocks independently.
+func TestExecuteBlock(t *testing.T) {
+	const text = `{{block "subject" .Object}}Object {{.Name}}{{end}}
+{{define "body"}}{{.String}} {{.Number}}{{end}}{{template "body" .}}
+{{define "html"}}<p>{{.String}}</p>{{end}}`
+	tmpl := Must(New("mail").Parse(text))
+	tests := []struct {
+		name, output string
+		ok           bool
+	}{
+		{"subject", "Object test", true},
+		{"body", "hello 42", true},
+		{"html", "<p>hello</p>", true},
+		{"mail", "Object test\nhello 42\n", true},
+		{"footer", "", false},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := tmpl.ExecuteBlock(&buf, test.name, baseTestJSON)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got %q", test.name, buf.String())
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.name, test.output, buf.String())
+		}
+	}
+}
+
+// TestLookupChain tests finding the first defined of several templates.
+func TestLookupChain(t *testing.T) {
+	set := Must(New("root").Parse(`{{define "default/email"}}default {{.String}}{{end}}` +
+		`{{define "tenant-x/email"}}tenant x {{.String}}{{end}}`))
+	set.New("tenant-y/email") // associated but not defined
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"tenant-x/email", "default/email"}, "tenant x hello"},
+		{[]string{"tenant-y/email", "default/email"}, "default hello"},
+		{[]string{"tenant-z/email", "default/email"}, "default hello"},
+		{[]string{"tenant-z/email"}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		tmpl := set.LookupChain(test.names...)
+		if tmpl == nil {
+			if test.want != "" {
+				t.Errorf("%q: expected a template; got nil", test.names)
+			}
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, baseTestJSON); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%q: expected %q; got %q", test.names, test.want, buf.String())
+		}
+	}
+}
+
+// TestExecuteNode tests rendering parts of a template.
+func TestExecuteNode(t *testing.T) {
+	const text = `{{define "header"}}<h1>{{.String}}</h1>{{end}}` +
+		`{{template "header" .}}` +
+		`{{if .Bool}}[{{.Number}}]{{else}}none{{end}}` +
+		`{{range .Array}}{{.}}{{else}}empty{{end}}` +
+		`{{try}}ok{{catch}}failed{{end}}`
+	tmpl := Must(New("page").Parse(text))
+	tests := []struct {
+		path, output string
+		ok           bool
+	}{
+		{"", "<h1>hello</h1>[42]123ok", true},
+		{"/", "<h1>hello</h1>[42]123ok", true},
+		{"header", "<h1>hello</h1>", true},
+		{"header/1", "hello", true},
+		{"page/1", "[42]", true},
+		{"/1/0", "[", true},
+		{"/1/1", "42", true},
+		{"/1/else", "none", true},
+		{"/2/else/0", "empty", true},
+		{"/3/catch", "failed", true},
+		{"/9", "", false},
+		{"/0/0", "", false},
+		{"/1/x", "", false},
+		{"/3/else", "", false},
+		{"footer", "", false},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := tmpl.ExecuteNode(&buf, baseTestJSON, test.path)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%q: expected error; got none", test.path)
+		case test.ok && err != nil:
+			t.Errorf("%q: unexpected error: %s", test.path, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%q: expected %q; got %q", test.path, test.output, buf.String())
+		}
+	}
+}
+
+// TestBinaryOutput tests the builtins that write decoded bytes.
+func TestBinaryOutput(t *testing.T) {
+	data := []byte(`{"b64": "H4sI/w==", "url": "H4sI_w", "hex": "1f8b 08ff", "num": 1}`)
+	tests := []struct {
+		input  string
+		output []byte
+		ok     bool
+	}{
+		{`{{b64decWrite .b64}}`, []byte{0x1f, 0x8b, 0x08, 0xff}, true},
+		{`{{.b64 | b64decWrite}}`, []byte{0x1f, 0x8b, 0x08, 0xff}, true},
+		{`{{b64decWrite .url}}`, []byte{0x1f, 0x8b, 0x08, 0xff}, true},
+		{`{{hexdecWrite .hex}}`, []byte{0x1f, 0x8b, 0x08, 0xff}, true},
+		{`{{hexdecWrite "00"}}{{b64decWrite "AA=="}}!`, []byte{0, 0, '!'}, true},
+		{`{{b64decWrite "not base64!"}}`, nil, false},
+		{`{{hexdecWrite "0g"}}`, nil, false},
+		{`{{hexdecWrite .num}}`, nil, false},
+		{`{{hexdecWrite}}`, nil, false},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("binary").Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got none", test.input)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.input, err)
+		case test.ok && !bytes.Equal(buf.Bytes(), test.output):
+			t.Errorf("%s: expected %x; got %x", test.input, test.output, buf.Bytes())
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	data := []byte(`{"s": "hello", "obj": {"a":1}, "num": 42}`)
+	tests := []struct {
+		input, output string
+		ok            bool
+	}{
+		{`{{crc32 .s}}`, "3610a686", true},
+		{`{{.s | crc32}}`, "3610a686", true},
+		{`{{crc32 .obj}}`, "561bacaf", true},
+		{`{{crc32 .num}}`, "3224b088", true},
+		{`{{crc32 .s "ieee"}}`, "3610a686", true},
+		{`{{crc32 .s "castagnoli"}}`, "9a71bb4c", true},
+		{`{{crc32 .s "sha1"}}`, "", false},
+		{`{{adler32 .s}}`, "062c0215", true},
+		{`{{adler32 ""}}`, "00000001", true},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("checksum").Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got none", test.input)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.input, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.input, test.output, buf.String())
+		}
+	}
+}
+
 // TestEvalFunctionSliceCap tests the potential issue with makeslice cap out of range
 // in the evalFunction method when capacity might be negative
 func TestEvalFunctionSliceCap(t *testing.T) {
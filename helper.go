@@ -8,10 +8,14 @@ package gjson_template
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+
+	"github.com/higress-group/gjson_template/parse"
+	"github.com/tidwall/gjson"
 )
 
 // Functions and methods to parse templates.
@@ -85,10 +89,85 @@ func parseFiles(t *Template, readFile func(string) (string, []byte, error), file
 		} else {
 			tmpl = t.New(name)
 		}
+		var meta *Metadata
+		if tmpl.option.frontMatter {
+			meta, s, err = splitFrontMatter(name, s, tmpl.leftDelim, tmpl.rightDelim)
+			if err != nil {
+				return nil, err
+			}
+		}
 		_, err = tmpl.Parse(s)
 		if err != nil {
 			return nil, err
 		}
+		tmpl.meta = meta
+	}
+	return t, nil
+}
+
+// ParseReader creates a new [Template] with the given name and parses the
+// template definitions read from r, so that template text arriving over the
+// network or from object storage can be parsed without first being copied
+// into a string. r is read to EOF before parsing; an error reading it is
+// returned, wrapped.
+func ParseReader(name string, r io.Reader) (*Template, error) {
+	return parseFiles(nil, readReader(r), name)
+}
+
+// ParseReader parses the template definitions read from r and associates
+// the resulting template, named name, with t. If name is t's name, the
+// definitions become the contents of t. If an error occurs, parsing stops
+// and the returned template is nil; otherwise it is t.
+func (t *Template) ParseReader(name string, r io.Reader) (*Template, error) {
+	t.init()
+	return parseFiles(t, readReader(r), name)
+}
+
+// DefineFromJSON parses the named templates declared in the JSON document
+// cfg and associates them with t, so that configuration can carry the
+// snippets its templates invoke. The templates are the string members of
+// the "partials" object:
+//
+//	{"partials": {"header": "<h1>{{.title}}</h1>", "footer": "..."}}
+//
+// defines templates named header and footer, invoked as
+// {{template "header" .}}. Other members of cfg are ignored. All the
+// partials are parsed before any is associated with t, so if an error
+// occurs, the returned template is nil and t is unchanged; otherwise it
+// is t.
+func (t *Template) DefineFromJSON(cfg []byte) (*Template, error) {
+	t.init()
+	if !gjson.ValidBytes(cfg) {
+		return nil, fmt.Errorf("template: DefineFromJSON: invalid JSON")
+	}
+	partials := gjson.GetBytes(cfg, "partials")
+	if !partials.Exists() {
+		return t, nil
+	}
+	if !partials.IsObject() {
+		return nil, fmt.Errorf("template: DefineFromJSON: partials must be an object; got %s", describe(partials))
+	}
+	var parsed []map[string]*parse.Tree
+	var err error
+	partials.ForEach(func(key, value gjson.Result) bool {
+		if value.Type != gjson.String {
+			err = fmt.Errorf("template: DefineFromJSON: partial %q must be a string; got %s", key.Str, describe(value))
+			return false
+		}
+		var trees map[string]*parse.Tree
+		trees, err = t.parseTrees(key.Str, value.Str)
+		parsed = append(parsed, trees)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, trees := range parsed {
+		for name, tree := range trees {
+			if _, err := t.AddParseTree(name, tree); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return t, nil
 }
@@ -169,6 +248,16 @@ func readFileOS(file string) (name string, b []byte, err error) {
 	return
 }
 
+func readReader(r io.Reader) func(string) (string, []byte, error) {
+	return func(name string) (string, []byte, error) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("template: reading %s: %w", name, err)
+		}
+		return name, b, nil
+	}
+}
+
 func readFileFS(fsys fs.FS) func(string) (string, []byte, error) {
 	return func(file string) (name string, b []byte, err error) {
 		name = path.Base(file)
@@ -0,0 +1,94 @@
+// This file contains the message catalogs used by the t builtin.
+
+package gjson_template
+
+import (
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// SetCatalog adds messages, a map from message key to localized text, to
+// the catalog of the language lang, a BCP 47 tag such as "en" or "zh-CN".
+// Messages with the same key as earlier ones replace them. The catalogs are
+// shared by all templates associated with t and are used by the t builtin:
+//
+//	{{t "greeting" .user}}
+//
+// looks up "greeting" in the catalog of the language set with
+// Option("lang=<tag>") or ExecOptions.Lang, falling back to less specific tags ("zh-Hant-TW",
+// then "zh-Hant", then "zh"). A message may contain placeholders: {0}, {1},
+// ... are replaced by the arguments following the key, and {name} by the
+// value at the GJSON path name in the first object argument. A key that is
+// in no catalog is printed as is, unless the missingkey=error option is set.
+// The return value is the template, so calls can be chained.
+func (t *Template) SetCatalog(lang string, messages map[string]string) *Template {
+	t.init()
+	t.muCatalogs.Lock()
+	defer t.muCatalogs.Unlock()
+	if t.catalogs == nil {
+		t.catalogs = make(map[string]map[string]string)
+	}
+	lang = normalizeLang(lang)
+	if t.catalogs[lang] == nil {
+		t.catalogs[lang] = make(map[string]string)
+	}
+	maps.Copy(t.catalogs[lang], messages)
+	return t
+}
+
+// message returns the message for key in the catalog of lang or of the
+// closest less specific language, and whether there is one.
+func (t *Template) message(lang, key string) (string, bool) {
+	t.muCatalogs.RLock()
+	defer t.muCatalogs.RUnlock()
+	lang = normalizeLang(lang)
+	for {
+		if msg, ok := t.catalogs[lang][key]; ok {
+			return msg, true
+		}
+		i := strings.LastIndexByte(lang, '-')
+		if i < 0 {
+			return "", false
+		}
+		lang = lang[:i]
+	}
+}
+
+// normalizeLang returns the language tag lang in the form used as a catalog
+// key: lower case, with subtags separated by hyphens.
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+// placeholder matches the {0} and {name} placeholders of a message.
+var placeholder = regexp.MustCompile(`\{([^{}\s]+)\}`)
+
+// formatMessage replaces the placeholders in msg with the values of args.
+// Placeholders that match no argument are left unchanged.
+func formatMessage(msg string, args []gjson.Result) string {
+	var named gjson.Result
+	for _, arg := range args {
+		if arg = asJSON(arg); arg.IsObject() {
+			named = arg
+			break
+		}
+	}
+	return placeholder.ReplaceAllStringFunc(msg, func(m string) string {
+		name := m[1 : len(m)-1]
+		var v gjson.Result
+		if i, err := strconv.Atoi(name); err == nil {
+			if i < 0 || i >= len(args) {
+				return m
+			}
+			v = args[i]
+		} else if v = named.Get(name); !v.Exists() {
+			return m
+		}
+		s, _ := gjsonPrintableValue(v)
+		return s
+	})
+}
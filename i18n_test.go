@@ -0,0 +1,116 @@
+package gjson_template
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var i18nTestJSON = []byte(`{"user":{"name":"Ada","unread":3},"when":"today"}`)
+
+var testCatalogs = map[string]map[string]string{
+	"en": {
+		"greeting": "Hello, {name}!",
+		"unread":   "You have {unread} new messages",
+		"ordered":  "{0} before {1}",
+		"only.en":  "English only",
+	},
+	"zh": {
+		"greeting": "{name}，你好！",
+		"unread":   "你有 {unread} 条新消息",
+	},
+	"zh-TW": {
+		"greeting": "{name}，您好！",
+	},
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name, lang, input, output string
+		ok                        bool
+	}{
+		{"named", "en", `{{t "greeting" .user}}`, "Hello, Ada!", true},
+		{"other language", "zh", `{{t "greeting" .user}}`, "Ada，你好！", true},
+		{"region", "zh-TW", `{{t "greeting" .user}}`, "Ada，您好！", true},
+		{"region fallback", "zh-TW", `{{t "unread" .user}}`, "你有 3 条新消息", true},
+		{"case and underscore", "ZH_tw", `{{t "greeting" .user}}`, "Ada，您好！", true},
+		{"positional", "en", `{{t "ordered" .when "tomorrow"}}`, "today before tomorrow", true},
+		{"piped", "en", `{{.user | t "greeting"}}`, "Hello, Ada!", true},
+		{"result piped", "en", `{{t "greeting" .user | upper}}`, "HELLO, ADA!", true},
+		{"missing placeholder", "en", `{{t "ordered" "a"}}`, "a before {1}", true},
+		{"missing key", "en", `{{t "nope"}}`, "nope", true},
+		{"missing language", "fr", `{{t "greeting" .user}}`, "greeting", true},
+		{"no fallback to other language", "zh", `{{t "only.en"}}`, "only.en", true},
+		{"no key", "en", `{{t}}`, "", false},
+		{"key not a string", "en", `{{t 1}}`, "", false},
+	}
+	for _, test := range tests {
+		tmpl := New(test.name).Option("lang=" + test.lang)
+		for lang, messages := range testCatalogs {
+			tmpl.SetCatalog(lang, messages)
+		}
+		if _, err := tmpl.Parse(test.input); err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, i18nTestJSON)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got none", test.name)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected execute error: %s", test.name, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.name, test.output, buf.String())
+		}
+	}
+}
+
+func TestTranslateStrict(t *testing.T) {
+	tmpl := Must(New("strict").Option("lang=en", "missingkey=error").
+		SetCatalog("en", testCatalogs["en"]).Parse(`{{t "nope"}}`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, i18nTestJSON); err == nil {
+		t.Errorf("expected error for missing message; got %q", buf.String())
+	}
+}
+
+// TestTranslateLang tests the language set for an execution, in place of
+// the lang option.
+func TestTranslateLang(t *testing.T) {
+	tmpl := New("lang").Option("lang=en").Option("missingkey=error")
+	for lang, messages := range testCatalogs {
+		tmpl.SetCatalog(lang, messages)
+	}
+	tmpl = Must(tmpl.Parse(`{{define "sub"}}{{t "greeting" .}}{{end}}{{template "sub" .user}}`))
+	for _, test := range []struct{ lang, output string }{
+		{"", "Hello, Ada!"},
+		{"zh", "Ada，你好！"},
+		{"zh-TW", "Ada，您好！"},
+	} {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteWithOptions(&buf, i18nTestJSON, ExecOptions{Lang: test.lang}); err != nil || buf.String() != test.output {
+			t.Errorf("%q: expected %q; got %q, %v", test.lang, test.output, buf.String(), err)
+		}
+	}
+	err := Must(tmpl.New("only").Parse(`{{t "only.en"}}`)).ExecuteWithOptions(&bytes.Buffer{}, i18nTestJSON, ExecOptions{Lang: "zh"})
+	if err == nil || !strings.Contains(err.Error(), `for language "zh"`) {
+		t.Errorf("expected error naming the language of the execution; got %v", err)
+	}
+}
+
+func TestCatalogClone(t *testing.T) {
+	tmpl := Must(New("clone").Option("lang=en").SetCatalog("en", testCatalogs["en"]).Parse(`{{t "ordered" 1 2}}`))
+	clone, err := tmpl.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone.SetCatalog("en", map[string]string{"ordered": "{1} after {0}"})
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, i18nTestJSON); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "1 before 2" {
+		t.Errorf("original: expected %q; got %q", "1 before 2", buf.String())
+	}
+}
@@ -0,0 +1,446 @@
+// This file contains the analysis of the data read by a template and the
+// incremental re-rendering built on it.
+
+package gjson_template
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/higress-group/gjson_template/parse"
+	"github.com/tidwall/gjson"
+)
+
+// volatileFuncs are the builtins whose results may change between calls
+// with the same arguments.
+var volatileFuncs = map[string]bool{
+	"ago":                      true,
+	"bcrypt":                   true,
+	"encryptAES":               true,
+	"genCA":                    true,
+	"genCAWithKey":             true,
+	"genPrivateKey":            true,
+	"genSelfSignedCert":        true,
+	"genSelfSignedCertWithKey": true,
+	"genSignedCert":            true,
+	"genSignedCertWithKey":     true,
+	"htpasswd":                 true,
+	"httpGet":                  true,
+	"now":                      true,
+	"randAlpha":                true,
+	"randAlphaNum":             true,
+	"randAscii":                true,
+	"randBytes":                true,
+	"randInt":                  true,
+	"randNumeric":              true,
+	"readFile":                 true,
+	"uuidv4":                   true,
+}
+
+// refs collects the paths of the data read by part of a template.
+type refs struct {
+	tmpl     *Template
+	paths    map[string]bool // GJSON paths read; "" is the whole data
+	volatile bool            // the output may change while the data does not
+	random   bool            // the execution's random source is used
+	seen     map[string]bool // templates and macros being walked
+
+	// visit, if not nil, is called with each path read and the node
+	// reading it, and with the paths ranged over, with ranged set.
+	visit func(n parse.Node, path string, ranged bool)
+}
+
+func newRefs(t *Template) *refs {
+	return &refs{tmpl: t, paths: make(map[string]bool), seen: make(map[string]bool)}
+}
+
+// sorted returns the collected paths in order.
+func (r *refs) sorted() []string {
+	paths := make([]string, 0, len(r.paths))
+	for p := range r.paths {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// A scope is where a value, such as dot, comes from.
+type scope struct {
+	path  string // GJSON path of the value within the data; "" for the data itself
+	known bool   // whether the value is at path; false for values computed otherwise
+}
+
+// dataScope is the scope of the data the template is executed with.
+var dataScope = scope{known: true}
+
+// join returns the path of p within the value of the scope.
+func (sc scope) join(p string) string {
+	switch {
+	case sc.path == "":
+		return p
+	case p == "":
+		return sc.path
+	}
+	return sc.path + "." + p
+}
+
+// ref records the path p within the value of the scope, if it is known,
+// as read by node n.
+func (r *refs) ref(n parse.Node, sc scope, p string) {
+	if sc.known {
+		r.paths[sc.join(p)] = true
+		if r.visit != nil {
+			r.visit(n, sc.join(p), false)
+		}
+	}
+}
+
+// pipeScope returns the scope of the value of pipe, which is known for a
+// lone field chain or dot.
+func pipeScope(pipe *parse.PipeNode, dot, dollar scope) scope {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return scope{}
+	}
+	switch n := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		if dot.known {
+			return scope{dot.join(fieldPath(n.Ident)), true}
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && dollar.known {
+			return scope{dollar.join(fieldPath(n.Ident[1:])), true}
+		}
+	}
+	return scope{}
+}
+
+// walk collects the references of node. dot and dollar are the scopes of
+// dot and $.
+func (r *refs) walk(node parse.Node, dot, dollar scope) {
+	switch node := node.(type) {
+	case nil:
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			r.walk(n, dot, dollar)
+		}
+	case *parse.ActionNode:
+		r.walk(node.Pipe, dot, dollar)
+	case *parse.IfNode:
+		r.walk(node.Pipe, dot, dollar)
+		r.walk(node.List, dot, dollar)
+		r.walk(node.ElseList, dot, dollar)
+	case *parse.RangeNode:
+		r.walk(node.Pipe, dot, dollar)
+		// The elements of an array at path p are at p.#.
+		elem := pipeScope(node.Pipe, dot, dollar)
+		if elem.known {
+			if r.visit != nil {
+				r.visit(node, elem.path, true)
+			}
+			elem.path = elem.join("#")
+		}
+		r.walk(node.List, elem, dollar)
+		r.walk(node.ElseList, dot, dollar)
+	case *parse.WithNode:
+		r.walk(node.Pipe, dot, dollar)
+		r.walk(node.List, pipeScope(node.Pipe, dot, dollar), dollar)
+		r.walk(node.ElseList, dot, dollar)
+	case *parse.ForNode:
+		r.walk(node.Pipe, dot, dollar)
+		r.walk(node.List, scope{}, dollar) // dot is the counter
+		r.walk(node.ElseList, dot, dollar)
+	case *parse.WhileNode:
+		r.walk(node.Pipe, dot, dollar)
+		r.walk(node.List, dot, dollar)
+		r.walk(node.ElseList, dot, dollar)
+	case *parse.CaptureNode:
+		r.walk(node.List, dot, dollar)
+	case *parse.TryNode:
+		r.walk(node.List, dot, dollar)
+		r.walk(node.CatchList, dot, dollar)
+	case *parse.TemplateNode:
+		r.walk(node.Pipe, dot, dollar)
+		// The invoked template reads only the value passed to it, so
+		// only its volatility matters.
+		if tmpl := r.tmpl.Lookup(node.Name); tmpl != nil && tmpl.Tree != nil {
+			r.walkTemplate("template "+node.Name, tmpl.Root, scope{}, scope{})
+		}
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			r.walkCommand(cmd, dot, dollar)
+		}
+	case *parse.ChainNode:
+		r.walk(node.Node, dot, dollar)
+	case *parse.DotNode:
+		r.ref(node, dot, "")
+	case *parse.FieldNode:
+		r.ref(node, dot, fieldPath(node.Ident))
+	case *parse.VariableNode:
+		if node.Ident[0] == "$" {
+			r.ref(node, dollar, fieldPath(node.Ident[1:]))
+		}
+	}
+}
+
+// walkCommand collects the references of a command, including those made
+// implicitly by builtins that read dot.
+func (r *refs) walkCommand(cmd *parse.CommandNode, dot, dollar scope) {
+	for _, arg := range cmd.Args {
+		r.walk(arg, dot, dollar)
+	}
+	fn, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return
+	}
+	// Functions taking the context may depend on more than their
+	// arguments, and those taking an *ExecInfo may read the whole data.
+	if ctx, info := r.tmpl.funcInputs(fn.Ident); ctx || info {
+		r.volatile = r.volatile || ctx
+		if info {
+			r.ref(fn, dataScope, "")
+		}
+	}
+	switch name := fn.Ident; {
+	case volatileFuncs[name]:
+		r.volatile = true
+	case name == "shuffle" || name == "sample":
+		r.random = true
+		r.volatile = r.volatile || !r.tmpl.option.seeded
+	case name == "gjson" && dot.known:
+		if len(cmd.Args) == 2 {
+			if path, ok := cmd.Args[1].(*parse.StringNode); ok {
+				// Paths with modifiers, pipes or multipaths are
+				// not relative to a prefix; the whole of dot covers them.
+				if dot.path == "" || !strings.ContainsAny(path.Text, "@|{[") {
+					r.ref(path, dot, path.Text)
+				} else {
+					r.ref(path, dot, "")
+				}
+				return
+			}
+		}
+		r.ref(fn, dot, "")
+	case (name == "jmespath" || name == "jsonptr") && len(cmd.Args) < 3:
+		r.ref(fn, dot, "")
+	case name == "call":
+		// A macro body runs with the dot and $ of its caller.
+		if len(cmd.Args) > 1 {
+			if macro, ok := cmd.Args[1].(*parse.StringNode); ok {
+				if tmpl := r.tmpl.Lookup(macro.Text); tmpl != nil && tmpl.Tree != nil {
+					r.walkTemplate(fmt.Sprintf("macro %s %v %v", macro.Text, dot, dollar), tmpl.Root, dot, dollar)
+					return
+				}
+			}
+		}
+		r.volatile = true
+	}
+}
+
+// funcInputs reports whether the function called name takes the context
+// of the execution and an *ExecInfo.
+func (t *Template) funcInputs(name string) (ctx, info bool) {
+	fn, _, ok := findFunction(name, t)
+	if !ok {
+		return false, false
+	}
+	typ := fn.Type()
+	if typ.NumIn() > 0 && typ.In(0) == contextType {
+		ctx = true
+	}
+	n := 0
+	if ctx {
+		n = 1
+	}
+	return ctx, typ.NumIn() > n && typ.In(n) == execInfoType
+}
+
+// walkTemplate walks the body of a template or macro once per key, so
+// recursive invocations terminate.
+func (r *refs) walkTemplate(key string, root *parse.ListNode, dot, dollar scope) {
+	if r.seen[key] {
+		return
+	}
+	r.seen[key] = true
+	r.walk(root, dot, dollar)
+}
+
+// ReferencedPaths returns the sorted GJSON paths of the data that
+// executing t may read, such as "user.name" for {{.user.name}}, so that
+// templates can be checked against the data they will be given before
+// they are deployed. Reading a value reads everything below it, so the
+// paths cover the data the output depends on; the empty path stands for
+// the whole data, read for instance by {{.}} or {{index . "key"}}.
+//
+// Field chains and constant gjson paths within {{with}} and {{range}}
+// actions over a field are reported relative to the data: "items.#.name"
+// for {{range .items}}{{.name}}{{end}}, with "#" standing for every
+// element. Paths read through variables are reported as the paths the
+// variables were set from, and functions are assumed to read only their
+// arguments, except those taking an *ExecInfo, which read the whole data.
+// The paths read by an invoked template are covered by the
+// value passed to it, and not reported.
+func (t *Template) ReferencedPaths() []string {
+	if t.Tree == nil || t.Root == nil {
+		return nil
+	}
+	r := newRefs(t)
+	r.walk(t.Root, dataScope, dataScope)
+	return r.sorted()
+}
+
+// An Incremental executes a template repeatedly on changing data,
+// re-executing only the parts whose data changed since the previous
+// execution and reusing the output of the others. It suits templates
+// executed often on data that mostly stays the same, such as configuration
+// rendered on every update.
+//
+// The parts are the top-level nodes of the template: its text and its
+// actions, with everything nested within them. A part is re-executed when
+// the value at one of its referenced paths (see [Template.ReferencedPaths])
+// changed, or when it calls a function such as now whose result may
+// change by itself. If the template declares variables at the top level,
+// or uses the random source of the shuffle and sample builtins, it is
+// executed as a single part. Each execution is reported to the observer
+// and tracer of the template as one execution, whatever the number of
+// parts re-executed.
+//
+// An Incremental may be used by multiple goroutines, but executions are
+// serialized. The template must not be modified after the first execution.
+type Incremental struct {
+	tmpl  *Template
+	mu    sync.Mutex
+	parts []*incrementalPart // nil until the first execution
+	data  []byte             // data of the last successful execution, or nil
+}
+
+// An incrementalPart is a part of the template with its cached output.
+type incrementalPart struct {
+	node     parse.Node
+	paths    []string
+	volatile bool
+	out      []byte
+}
+
+// Incremental returns an Incremental that executes t.
+func (t *Template) Incremental() *Incremental {
+	return &Incremental{tmpl: t}
+}
+
+// Execute applies the template to the specified JSON data and writes the
+// output to wr. The output is the same as that of [Template.Execute]. On
+// error, nothing is written and the next execution starts afresh.
+func (inc *Incremental) Execute(wr io.Writer, data []byte) error {
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	return inc.tmpl.execute(wr, data, execOptions{inc: inc})
+}
+
+// render executes the parts of the template whose data changed, with the
+// settings x, as execute does once it has started observing and tracing
+// the execution. The parts are executed as one execution: they are not
+// observed or traced separately, and share the count of iterations of
+// the maxiterations option.
+func (inc *Incremental) render(wr io.Writer, data []byte, x execOptions) error {
+	t := inc.tmpl
+	if t.Tree == nil || t.Root == nil {
+		return fmt.Errorf("template: %q is an incomplete or empty template", t.Name())
+	}
+	if inc.parts == nil {
+		inc.split()
+	}
+	part := execOptions{ctx: x.ctx, raw: true, seen: true, traced: true}
+	if t.option.maxIterations > 0 {
+		part.iterations = new(int)
+	}
+	var buf bytes.Buffer
+	for _, p := range inc.parts {
+		if inc.data != nil && !p.volatile && !changed(inc.data, data, p.paths) {
+			buf.Write(p.out)
+			continue
+		}
+		var out bytes.Buffer
+		part.node = p.node
+		if err := t.execute(&out, data, part); err != nil {
+			inc.data = nil
+			return err
+		}
+		p.out = out.Bytes()
+		buf.Write(p.out)
+	}
+	inc.data = bytes.Clone(data)
+	out := buf.Bytes()
+	if t.hasFilters() {
+		var err error
+		if out, err = t.filter(out); err != nil {
+			return err
+		}
+	}
+	_, err := wr.Write(out)
+	return err
+}
+
+// split divides the template into the parts executed separately.
+func (inc *Incremental) split() {
+	t := inc.tmpl
+	whole := newRefs(t)
+	whole.walk(t.Root, dataScope, dataScope)
+	single := whole.random
+	for _, n := range t.Root.Nodes {
+		if declares(n) {
+			single = true
+		}
+	}
+	if single {
+		inc.parts = []*incrementalPart{{node: t.Root, paths: whole.sorted(), volatile: whole.volatile}}
+		return
+	}
+	inc.parts = make([]*incrementalPart, 0, len(t.Root.Nodes))
+	for _, n := range t.Root.Nodes {
+		r := newRefs(t)
+		r.walk(n, dataScope, dataScope)
+		inc.parts = append(inc.parts, &incrementalPart{node: n, paths: r.sorted(), volatile: r.volatile})
+	}
+}
+
+// declares reports whether the top-level node n declares or assigns
+// variables that later nodes may read.
+func declares(n parse.Node) bool {
+	switch n := n.(type) {
+	case *parse.ActionNode:
+		return len(n.Pipe.Decl) > 0
+	case *parse.TemplateNode:
+		return n.Pipe != nil && len(n.Pipe.Decl) > 0
+	case *parse.CaptureNode:
+		return true
+	}
+	return false
+}
+
+// changed reports whether the value at any of paths differs between the
+// JSON documents old and new.
+func changed(old, new []byte, paths []string) bool {
+	for _, p := range paths {
+		if p == "" {
+			if !bytes.Equal(old, new) {
+				return true
+			}
+			continue
+		}
+		if gjson.GetBytes(old, p).Raw != gjson.GetBytes(new, p).Raw {
+			return true
+		}
+	}
+	return false
+}
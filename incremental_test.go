@@ -0,0 +1,177 @@
+package gjson_template
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestReferencedPaths(t *testing.T) {
+	tests := []struct {
+		name, input string
+		paths       []string
+	}{
+		{"text", `hello`, []string{}},
+		{"fields", `{{.user.name}} {{.user.age}} {{.user.name}}`, []string{"user.age", "user.name"}},
+		{"dot", `{{index . "a"}}`, []string{""}},
+		{"range body", `{{range .items}}{{.name}}{{$.title}}{{end}}`, []string{"items", "items.#.name", "title"}},
+		{"nested range", `{{range $.orders}}{{range .lines}}{{.sku}}{{end}}{{end}}`, []string{"orders", "orders.#.lines", "orders.#.lines.#.sku"}},
+		{"range pipeline", `{{range .items | len}}{{.name}}{{end}}`, []string{"items"}},
+		{"for body", `{{for 0 3}}{{.}}{{end}}`, []string{}},
+		{"with else", `{{with .a}}{{.b}}{{else}}{{.c}}{{end}}`, []string{"a", "a.b", "c"}},
+		{"variable", `{{$u := .user}}{{$u.name}} {{$.id}}`, []string{"id", "user"}},
+		{"function args", `{{printf "%s-%d" .a (len .b)}}`, []string{"a", "b"}},
+		{"gjson", `{{gjson "users.#.name"}}`, []string{"users.#.name"}},
+		{"gjson in range", `{{range .x}}{{gjson "y"}}{{end}}`, []string{"x", "x.#.y"}},
+		{"gjson modifier in with", `{{with .x}}{{gjson "@reverse"}}{{end}}`, []string{"x"}},
+		{"template", `{{define "t"}}{{.name}}{{$.id}}{{end}}{{template "t" .user}}`, []string{"user"}},
+		{"macro", `{{macro "m" $p}}{{.title}}{{$p}}{{end}}{{call "m" .name}}`, []string{"name", "title"}},
+		{"recursive template", `{{define "r"}}{{range .children}}{{template "r" .}}{{end}}{{end}}{{template "r" .tree}}`, []string{"tree"}},
+	}
+	for _, test := range tests {
+		tmpl, err := New(test.name).Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		if got := tmpl.ReferencedPaths(); !slices.Equal(got, test.paths) {
+			t.Errorf("%s: expected %q; got %q", test.name, test.paths, got)
+		}
+	}
+}
+
+// TestRefsFuncInputs tests the references of functions reading more than
+// their arguments.
+func TestRefsFuncInputs(t *testing.T) {
+	tmpl := New("inputs").Funcs(FuncMap{
+		"whole":   func(info *ExecInfo) string { return info.Root.Raw },
+		"tenant":  func(ctx context.Context) string { return "" },
+		"both":    func(ctx context.Context, info *ExecInfo) string { return "" },
+		"pure":    func(s string) string { return s },
+		"dotInfo": func(info *ExecInfo, s string) string { return s },
+	})
+	tests := []struct {
+		input    string
+		paths    []string
+		volatile bool
+	}{
+		{`{{pure .a}}`, []string{"a"}, false},
+		{`{{with .a}}{{whole}}{{end}}`, []string{"", "a"}, false},
+		{`{{dotInfo .a}}`, []string{"", "a"}, false},
+		{`{{tenant}}`, []string{}, true},
+		{`{{both}}`, []string{""}, true},
+		{`{{readFile "x"}}`, []string{}, true},
+		{`{{httpGet "x"}}`, []string{}, true},
+	}
+	for _, test := range tests {
+		tmpl := Must(Must(tmpl.Clone()).Parse(test.input))
+		r := newRefs(tmpl)
+		r.walk(tmpl.Root, dataScope, dataScope)
+		if got := r.sorted(); !slices.Equal(got, test.paths) || r.volatile != test.volatile {
+			t.Errorf("%s: expected %q, volatile %v; got %q, %v", test.input, test.paths, test.volatile, got, r.volatile)
+		}
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	var calls []string
+	tmpl := Must(New("inc").Funcs(FuncMap{
+		"trace": func(s string) string {
+			calls = append(calls, s)
+			return s
+		},
+	}).Parse(`{{trace .a}}-{{range .list}}{{trace .}}{{end}}-{{if now}}{{trace "now"}}{{end}}`))
+	inc := tmpl.Incremental()
+	steps := []struct {
+		data   string
+		output string
+		calls  []string
+	}{
+		{`{"a":"x","list":["1","2"]}`, "x-12-now", []string{"x", "1", "2", "now"}},
+		{`{"a":"x","list":["1","2"]}`, "x-12-now", []string{"now"}},
+		{`{"a":"y","list":["1","2"]}`, "y-12-now", []string{"y", "now"}},
+		{`{"a":"y","list":["1","3"],"other":1}`, "y-13-now", []string{"1", "3", "now"}},
+	}
+	for i, step := range steps {
+		calls = nil
+		var buf bytes.Buffer
+		if err := inc.Execute(&buf, []byte(step.data)); err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+		if buf.String() != step.output {
+			t.Errorf("step %d: expected %q; got %q", i, step.output, buf.String())
+		}
+		if !slices.Equal(calls, step.calls) {
+			t.Errorf("step %d: expected calls %q; got %q", i, step.calls, calls)
+		}
+	}
+}
+
+func TestIncrementalSinglePart(t *testing.T) {
+	var n int
+	tmpl := Must(New("single").Funcs(FuncMap{
+		"count": func() int { n++; return n },
+	}).Parse(`{{$x := .a}}{{count}}-{{$x}}-{{.b}}`))
+	inc := tmpl.Incremental()
+	for i, step := range []struct{ data, output string }{
+		{`{"a":1,"b":2}`, "1-1-2"},
+		{`{"a":1,"b":2}`, "1-1-2"},
+		{`{"a":1,"b":3}`, "2-1-3"},
+	} {
+		var buf bytes.Buffer
+		if err := inc.Execute(&buf, []byte(step.data)); err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+		if buf.String() != step.output {
+			t.Errorf("step %d: expected %q; got %q", i, step.output, buf.String())
+		}
+	}
+}
+
+func TestIncrementalError(t *testing.T) {
+	tmpl := Must(New("err").Option("missingkey=error").Parse(`{{.a}}{{.b}}`))
+	inc := tmpl.Incremental()
+	var buf bytes.Buffer
+	if err := inc.Execute(&buf, []byte(`{"a":1}`)); err == nil {
+		t.Fatalf("expected error; got %q", buf.String())
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no output on error; got %q", buf.String())
+	}
+	if err := inc.Execute(&buf, []byte(`{"a":1,"b":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "12" {
+		t.Errorf("expected %q; got %q", "12", buf.String())
+	}
+}
+
+// TestIncrementalObserved tests that an incremental execution is observed
+// as one execution of the whole template, and that its parts share the
+// limit of the maxiterations option.
+func TestIncrementalObserved(t *testing.T) {
+	r := &statsRecorder{}
+	tmpl := Must(New("inc").SetObserver(r).Parse(`a={{.a}} b={{.b}}`))
+	inc := tmpl.Incremental()
+	for i, data := range []string{`{"a":1,"b":2}`, `{"a":1,"b":3}`} {
+		var buf bytes.Buffer
+		if err := inc.Execute(&buf, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.stats) != i+1 {
+			t.Fatalf("execution %d: observed %d executions; want %d", i, len(r.stats), i+1)
+		}
+		if s := r.stats[i]; s.Template != "inc" || s.Bytes != buf.Len() {
+			t.Errorf("execution %d: observed %+v for output %q", i, s, buf.String())
+		}
+	}
+
+	tmpl = Must(New("limited").Option("maxiterations=3").Parse(`{{range .a}}{{end}}{{range .b}}{{end}}`))
+	err := tmpl.Incremental().Execute(io.Discard, []byte(`{"a":[1,2],"b":[3,4]}`))
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded; got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// This file contains the pre-processing of JSON with comments.
+
+package gjson_template
+
+import "bytes"
+
+// stripJSONC returns data with the comments and trailing commas of JSONC
+// and JSON5 replaced by spaces, so that it is plain JSON with the same
+// byte offsets. Comments are // to the end of the line and /* to */, and
+// trailing commas are those before a closing } or ]. Commas and slashes
+// within strings are kept. If data has none, it is returned as is.
+func stripJSONC(data []byte) []byte {
+	if !bytes.ContainsAny(data, "/,") {
+		return data
+	}
+	var out []byte // copy of data, made at the first change
+	blank := func(from, to int) {
+		if out == nil {
+			out = bytes.Clone(data)
+		}
+		for i := from; i < to; i++ {
+			if out[i] != '\n' && out[i] != '\r' {
+				out[i] = ' '
+			}
+		}
+	}
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/':
+			if end := commentEnd(data, i); end > i {
+				blank(i, end)
+				i = end - 1
+			}
+		case c == ',':
+			if j := skipJSONCSpace(data, i+1); j < len(data) && (data[j] == '}' || data[j] == ']') {
+				blank(i, i+1)
+			}
+		}
+	}
+	if out == nil {
+		return data
+	}
+	return out
+}
+
+// commentEnd returns the offset just after the comment starting at i in
+// data, or i if there is no complete comment there.
+func commentEnd(data []byte, i int) int {
+	switch {
+	case bytes.HasPrefix(data[i:], []byte("//")):
+		if n := bytes.IndexByte(data[i:], '\n'); n >= 0 {
+			return i + n
+		}
+		return len(data)
+	case bytes.HasPrefix(data[i:], []byte("/*")):
+		if n := bytes.Index(data[i+2:], []byte("*/")); n >= 0 {
+			return i + 2 + n + 2
+		}
+	}
+	return i
+}
+
+// skipJSONCSpace returns the offset of the first byte of data at or after
+// i that is neither white space nor within a comment.
+func skipJSONCSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '/':
+			end := commentEnd(data, i)
+			if end == i {
+				return i
+			}
+			i = end
+		default:
+			return i
+		}
+	}
+	return i
+}
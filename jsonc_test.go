@@ -0,0 +1,56 @@
+package gjson_template
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{`{"a": 1, // one` + "\n" + `}`, `{"a": 1` + strings.Repeat(" ", 8) + "\n}"},
+		{`/* head */ [1, 2, ]`, `           [1, 2  ]`},
+		{"[1 /* x\ny */, 2,/**/]", "[1     \n    , 2     ]"},
+		{`{"url": "http://x", "s": "a,]"}`, `{"url": "http://x", "s": "a,]"}`},
+		{`{"q": "\"//", "n": 1,}`, `{"q": "\"//", "n": 1 }`},
+		{`[1, /* open`, `[1, /* open`},
+		{`{"a": [1,,]}`, `{"a": [1, ]}`},
+	}
+	for _, test := range tests {
+		if got := string(stripJSONC([]byte(test.in))); got != test.out {
+			t.Errorf("%q: expected %q; got %q", test.in, test.out, got)
+		}
+	}
+}
+
+func TestJSONCOption(t *testing.T) {
+	data := []byte(`{
+	// The upstream service.
+	"upstream": {
+		"host": "api.internal", /* default port */
+		"port": 8080,
+	},
+	"routes": ["/a", "/b",],
+}`)
+	tmpl := Must(New("conf").Option("jsonc", "strictjson").Parse(`{{.upstream.host}}:{{.upstream.port}} {{len .routes}}`))
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "api.internal:8080 2" {
+		t.Errorf("expected %q; got %q", "api.internal:8080 2", buf.String())
+	}
+
+	// Offsets in errors are those of the original data.
+	err := tmpl.Execute(io.Discard, []byte(`{/* c */ "a": 1 x}`))
+	if err == nil || !strings.Contains(err.Error(), "at byte offset 17") {
+		t.Errorf("expected an error at byte offset 17; got %v", err)
+	}
+
+	if err := Must(New("strict").Option("strictjson").Parse(`{{.a}}`)).Execute(io.Discard, []byte(`{"a": 1,}`)); err == nil {
+		t.Error("expected an error without the jsonc option")
+	}
+}
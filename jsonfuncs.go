@@ -0,0 +1,854 @@
+// This file contains the builtins that transform JSON values.
+
+package gjson_template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/tidwall/gjson"
+)
+
+// renameKeys returns a copy of the object obj with keys renamed according to
+// mapping, an object from old key to new key. A mapping key may be a dotted
+// path such as "user.name", in which case the last key of the path is renamed
+// in place. Keys that are not present in obj are ignored.
+func renameKeys(obj, mapping gjson.Result) (gjson.Result, error) {
+	obj, mapping = asJSON(obj), asJSON(mapping)
+	if !obj.IsObject() {
+		return gjson.Result{}, fmt.Errorf("renameKeys of non-object %s", describe(obj))
+	}
+	if !mapping.IsObject() {
+		return gjson.Result{}, fmt.Errorf("renameKeys mapping must be an object; got %s", describe(mapping))
+	}
+	raw := obj.Raw
+	var err error
+	mapping.ForEach(func(from, to gjson.Result) bool {
+		if to.Type != gjson.String {
+			err = fmt.Errorf("renameKeys: new name for %q must be a string", from.String())
+			return false
+		}
+		raw = renameAt(raw, splitPath(from.String()), to.String())
+		return true
+	})
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	return gjson.Parse(raw), nil
+}
+
+// defaults returns a copy of the object obj in which keys missing from obj
+// are filled in from def. Keys already present in obj keep their values,
+// even if they are null.
+func defaults(obj, def gjson.Result) (gjson.Result, error) {
+	return fillDefaults("defaults", obj, def, false)
+}
+
+// defaultsDeep is like defaults but recurses into objects present in both
+// obj and def, filling in missing keys at every level.
+func defaultsDeep(obj, def gjson.Result) (gjson.Result, error) {
+	return fillDefaults("defaultsDeep", obj, def, true)
+}
+
+func fillDefaults(name string, obj, def gjson.Result, deep bool) (gjson.Result, error) {
+	obj, def = asJSON(obj), asJSON(def)
+	if !obj.Exists() || obj.Type == gjson.Null {
+		obj = gjson.Parse("{}")
+	}
+	if !obj.IsObject() {
+		return gjson.Result{}, fmt.Errorf("%s of non-object %s", name, describe(obj))
+	}
+	if !def.IsObject() {
+		return gjson.Result{}, fmt.Errorf("%s defaults must be an object; got %s", name, describe(def))
+	}
+	return gjson.Parse(mergeDefaults(obj, def, deep)), nil
+}
+
+// mergeDefaults returns the raw object obj with the missing members of def
+// appended, recursing into nested objects if deep is set.
+func mergeDefaults(obj, def gjson.Result, deep bool) string {
+	raw := buildObject(obj, func(key string, val gjson.Result) (string, string, bool) {
+		if deep && val.IsObject() {
+			if d := def.Get(gjson.Escape(key)); d.IsObject() {
+				return key, mergeDefaults(val, d, deep), true
+			}
+		}
+		return key, val.Raw, true
+	})
+	var b strings.Builder
+	def.ForEach(func(k, val gjson.Result) bool {
+		if !obj.Get(gjson.Escape(k.String())).Exists() {
+			b.WriteByte(',')
+			b.WriteString(jsonString(k.String()))
+			b.WriteByte(':')
+			b.WriteString(val.Raw)
+		}
+		return true
+	})
+	return appendMembers(raw, b.String())
+}
+
+// entries returns the members of the object obj as an array of
+// {"key":k,"value":v} objects, in order. A missing value has no entries.
+func entries(obj gjson.Result) (gjson.Result, error) {
+	obj = asJSON(obj)
+	if !obj.Exists() {
+		return gjson.Parse("[]"), nil
+	}
+	if !obj.IsObject() {
+		return gjson.Result{}, fmt.Errorf("entries of non-object %s", describe(obj))
+	}
+	var elems []string
+	obj.ForEach(func(k, v gjson.Result) bool {
+		elems = append(elems, `{"key":`+jsonString(k.Str)+`,"value":`+v.Raw+`}`)
+		return true
+	})
+	return gjson.Parse("[" + strings.Join(elems, ",") + "]"), nil
+}
+
+// fromEntries returns the object made of the entries in the array arr, the
+// reverse of entries. Each entry is an object with a string key and a
+// value, which is null if missing. If keys repeat, the last value is kept,
+// at the position of the first.
+func fromEntries(arr gjson.Result) (gjson.Result, error) {
+	arr = asJSON(arr)
+	if !arr.IsArray() {
+		return gjson.Result{}, fmt.Errorf("fromEntries of non-array %s", describe(arr))
+	}
+	var keys, values []string
+	pos := make(map[string]int)
+	var err error
+	arr.ForEach(func(i, e gjson.Result) bool {
+		key := e.Get("key")
+		if !e.IsObject() || key.Type != gjson.String {
+			err = fmt.Errorf("fromEntries: entry %d must be an object with a string key; got %s", i.Int(), describe(e))
+			return false
+		}
+		value := "null"
+		if v := e.Get("value"); v.Exists() {
+			value = v.Raw
+		}
+		if j, ok := pos[key.Str]; ok {
+			values[j] = value
+			return true
+		}
+		pos[key.Str] = len(keys)
+		keys = append(keys, key.Str)
+		values = append(values, value)
+		return true
+	})
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(jsonString(k))
+		b.WriteByte(':')
+		b.WriteString(values[i])
+	}
+	b.WriteByte('}')
+	return gjson.Parse(b.String()), nil
+}
+
+// page returns page n, counting from 0, of the array arr split into pages
+// of size elements. The last page may be shorter, and pages past the end
+// are empty.
+func page(arr gjson.Result, n, size int) (gjson.Result, error) {
+	elems, err := pageElems("page", arr, size)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if n < 0 {
+		return gjson.Result{}, fmt.Errorf("page number must not be negative; got %d", n)
+	}
+	start := len(elems)
+	if n <= len(elems)/size {
+		start = n * size
+	}
+	end := min(start+size, len(elems))
+	return gjson.Parse(rawArray(elems[start:end])), nil
+}
+
+// pageCount returns the number of pages of size elements of the array arr.
+func pageCount(arr gjson.Result, size int) (int, error) {
+	elems, err := pageElems("pageCount", arr, size)
+	if err != nil {
+		return 0, err
+	}
+	return (len(elems) + size - 1) / size, nil
+}
+
+// pageElems returns the elements of arr, paged by the named function in
+// pages of the given size. A missing value has no elements.
+func pageElems(name string, arr gjson.Result, size int) ([]gjson.Result, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("%s size must be positive; got %d", name, size)
+	}
+	arr = asJSON(arr)
+	if arr.Exists() && !arr.IsArray() {
+		return nil, fmt.Errorf("%s of non-array %s", name, describe(arr))
+	}
+	return arr.Array(), nil
+}
+
+// jsonPatch applies the RFC 6902 JSON Patch patches, an array of operation
+// objects, to doc and returns the patched document. The patch is applied
+// atomically: if any operation fails, an error is returned.
+func jsonPatch(doc, patches gjson.Result) (gjson.Result, error) {
+	doc, patches = asJSON(doc), asJSON(patches)
+	if !doc.Exists() {
+		return gjson.Result{}, fmt.Errorf("jsonPatch of missing value")
+	}
+	if !patches.IsArray() {
+		return gjson.Result{}, fmt.Errorf("jsonPatch patches must be an array; got %s", describe(patches))
+	}
+	raw := doc.Raw
+	for i, op := range patches.Array() {
+		var err error
+		if raw, err = applyPatchOp(raw, op); err != nil {
+			return gjson.Result{}, fmt.Errorf("jsonPatch: operation %d: %w", i, err)
+		}
+	}
+	return gjson.Parse(raw), nil
+}
+
+// applyPatchOp applies a single JSON Patch operation to the raw document.
+func applyPatchOp(raw string, op gjson.Result) (string, error) {
+	if !op.IsObject() {
+		return "", fmt.Errorf("operation must be an object; got %s", describe(op))
+	}
+	path := op.Get("path")
+	if path.Type != gjson.String {
+		return "", fmt.Errorf("missing path")
+	}
+	toks, err := pointerTokens(path.Str)
+	if err != nil {
+		return "", err
+	}
+	from := func() ([]string, gjson.Result, error) {
+		f := op.Get("from")
+		if f.Type != gjson.String {
+			return nil, gjson.Result{}, fmt.Errorf("missing from")
+		}
+		ftoks, err := pointerTokens(f.Str)
+		if err != nil {
+			return nil, gjson.Result{}, err
+		}
+		v, ok := lookupPointer(gjson.Parse(raw), ftoks)
+		if !ok {
+			return nil, gjson.Result{}, fmt.Errorf("path %q not found", f.Str)
+		}
+		return ftoks, v, nil
+	}
+	switch name := op.Get("op").String(); name {
+	case "add", "replace":
+		value := op.Get("value")
+		if !value.Exists() {
+			return "", fmt.Errorf("missing value")
+		}
+		return editPointer(raw, toks, name, value.Raw)
+	case "remove":
+		return editPointer(raw, toks, "remove", "")
+	case "move":
+		ftoks, v, err := from()
+		if err != nil {
+			return "", err
+		}
+		if len(toks) > len(ftoks) && slices.Equal(toks[:len(ftoks)], ftoks) {
+			return "", fmt.Errorf("cannot move %q into one of its children", path.Str)
+		}
+		if raw, err = editPointer(raw, ftoks, "remove", ""); err != nil {
+			return "", err
+		}
+		return editPointer(raw, toks, "add", v.Raw)
+	case "copy":
+		_, v, err := from()
+		if err != nil {
+			return "", err
+		}
+		return editPointer(raw, toks, "add", v.Raw)
+	case "test":
+		v, ok := lookupPointer(gjson.Parse(raw), toks)
+		if !ok {
+			return "", fmt.Errorf("test: path %q not found", path.Str)
+		}
+		if !jsonEqual(v, op.Get("value")) {
+			return "", fmt.Errorf("test: value at %q is %s", path.Str, v.Raw)
+		}
+		return raw, nil
+	default:
+		return "", fmt.Errorf("unknown op %q", name)
+	}
+}
+
+// mergePatch applies the RFC 7386 JSON Merge Patch patch to doc: members of
+// patch replace those of doc, objects are merged recursively, and null
+// members delete the corresponding key.
+func mergePatch(doc, patch gjson.Result) gjson.Result {
+	doc, patch = asJSON(doc), asJSON(patch)
+	return gjson.Parse(mergePatchRaw(doc, patch))
+}
+
+func mergePatchRaw(target, patch gjson.Result) string {
+	if !patch.IsObject() {
+		return patch.Raw
+	}
+	if !target.IsObject() {
+		target = gjson.Parse("{}")
+	}
+	out := buildObject(target, func(key string, val gjson.Result) (string, string, bool) {
+		p := patch.Get(gjson.Escape(key))
+		switch {
+		case !p.Exists():
+			return key, val.Raw, true
+		case p.Type == gjson.Null:
+			return "", "", false
+		}
+		return key, mergePatchRaw(val, p), true
+	})
+	var b strings.Builder
+	patch.ForEach(func(k, p gjson.Result) bool {
+		if p.Type != gjson.Null && !target.Get(gjson.Escape(k.String())).Exists() {
+			b.WriteByte(',')
+			b.WriteString(jsonString(k.String()))
+			b.WriteByte(':')
+			b.WriteString(mergePatchRaw(gjson.Result{}, p))
+		}
+		return true
+	})
+	return appendMembers(out, b.String())
+}
+
+// jmespathCache holds compiled JMESPath expressions keyed by their source.
+// It is bounded, since expressions may be computed from the data.
+var jmespathCache = newLRUCache[*jmespath.JMESPath](256)
+
+// jmespathSearch evaluates the JMESPath expression expr against data.
+func jmespathSearch(expr string, data gjson.Result) (gjson.Result, error) {
+	jp, ok := jmespathCache.get(expr)
+	if !ok {
+		var err error
+		if jp, err = jmespath.Compile(expr); err != nil {
+			return gjson.Result{}, err
+		}
+		jmespathCache.add(expr, jp)
+	}
+	var v any
+	if data.Exists() {
+		if err := json.Unmarshal([]byte(data.Raw), &v); err != nil {
+			return gjson.Result{}, err
+		}
+	}
+	out, err := jp.Search(v)
+	if err != nil || out == nil {
+		return gjson.Result{}, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(out); err != nil {
+		return gjson.Result{}, err
+	}
+	return gjson.Parse(strings.TrimSuffix(buf.String(), "\n")), nil
+}
+
+// jsonDiff returns the differences between the JSON values a and b as an
+// object of three arrays: added lists the values present only in b, as
+// {"path":p,"value":v}, removed those present only in a, likewise, and
+// changed the values that differ, as {"path":p,"old":v1,"new":v2}. Objects
+// are compared member by member and arrays element by element, so the
+// paths, GJSON paths relative to a and b, lead to the innermost
+// differences; the empty path stands for the values themselves.
+func jsonDiff(a, b gjson.Result) gjson.Result {
+	var d differ
+	d.diff("", asJSON(a), asJSON(b))
+	return gjson.Parse(`{"added":[` + strings.Join(d.added, ",") +
+		`],"removed":[` + strings.Join(d.removed, ",") +
+		`],"changed":[` + strings.Join(d.changed, ",") + `]}`)
+}
+
+// A differ collects the raw entries of a diff.
+type differ struct {
+	added, removed, changed []string
+}
+
+func (d *differ) diff(path string, a, b gjson.Result) {
+	switch {
+	case !a.Exists() && !b.Exists():
+	case !a.Exists():
+		d.added = append(d.added, `{"path":`+jsonString(path)+`,"value":`+b.Raw+`}`)
+	case !b.Exists():
+		d.removed = append(d.removed, `{"path":`+jsonString(path)+`,"value":`+a.Raw+`}`)
+	case a.IsObject() && b.IsObject():
+		a.ForEach(func(k, v gjson.Result) bool {
+			d.diff(joinPath(path, gjson.Escape(k.Str)), v, b.Get(gjson.Escape(k.Str)))
+			return true
+		})
+		b.ForEach(func(k, v gjson.Result) bool {
+			if !a.Get(gjson.Escape(k.Str)).Exists() {
+				d.diff(joinPath(path, gjson.Escape(k.Str)), gjson.Result{}, v)
+			}
+			return true
+		})
+	case a.IsArray() && b.IsArray():
+		as, bs := a.Array(), b.Array()
+		for i := range max(len(as), len(bs)) {
+			var av, bv gjson.Result
+			if i < len(as) {
+				av = as[i]
+			}
+			if i < len(bs) {
+				bv = bs[i]
+			}
+			d.diff(joinPath(path, strconv.Itoa(i)), av, bv)
+		}
+	case !jsonEqual(a, b):
+		d.changed = append(d.changed, `{"path":`+jsonString(path)+`,"old":`+a.Raw+`,"new":`+b.Raw+`}`)
+	}
+}
+
+// joinPath appends the escaped key to the GJSON path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonPointer returns the value referenced by the RFC 6901 JSON Pointer ptr
+// in data. It returns a missing value if ptr does not resolve. A URI fragment
+// pointer such as "#/a/b" is also accepted.
+func jsonPointer(ptr string, data gjson.Result) (gjson.Result, error) {
+	if frag, ok := strings.CutPrefix(ptr, "#"); ok {
+		unescaped, err := url.PathUnescape(frag)
+		if err != nil {
+			return gjson.Result{}, fmt.Errorf("invalid JSON pointer %q: %v", ptr, err)
+		}
+		ptr = unescaped
+	}
+	toks, err := pointerTokens(ptr)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	v, _ := lookupPointer(data, toks)
+	return v, nil
+}
+
+// buildPath returns the GJSON path made of the given keys, escaping the
+// characters of string keys that have a meaning in paths, such as dots,
+// wildcards and query syntax, so each key matches only itself. Integers
+// are array indexes.
+func buildPath(keys ...gjson.Result) (string, error) {
+	comps, err := pathKeys(keys)
+	if err != nil {
+		return "", err
+	}
+	return fieldPath(comps), nil
+}
+
+// getValue returns the value reached from value by following keys, as in
+// {{get .users $id "name"}}. Keys are the same as for buildPath, except
+// that negative indexes count back from the end of an array. A key that is
+// not found gives a missing value.
+func getValue(value gjson.Result, keys ...gjson.Result) (gjson.Result, error) {
+	comps, err := pathKeys(keys)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if len(comps) == 0 {
+		return value, nil
+	}
+	return value.Get(fieldPath(resolveNegative(value, comps))), nil
+}
+
+// pathKeys returns the unescaped path components for keys, which must be
+// non-empty strings or integers.
+func pathKeys(keys []gjson.Result) ([]string, error) {
+	comps := make([]string, len(keys))
+	for i, k := range keys {
+		switch {
+		case k.Type == gjson.String && k.Str != "":
+			comps[i] = k.Str
+		case k.Type == gjson.Number && k.Num == float64(int64(k.Num)):
+			comps[i] = strconv.FormatInt(int64(k.Num), 10)
+		default:
+			return nil, fmt.Errorf("path key %d must be a non-empty string or an integer; got %s", i, describe(k))
+		}
+	}
+	return comps, nil
+}
+
+// pathEscape returns key with the characters that have a meaning in GJSON
+// paths escaped, so it can be embedded in a path built from strings.
+func pathEscape(key string) string {
+	return gjson.Escape(key)
+}
+
+// shuffleJSON returns the elements of the array arr in an order drawn from r.
+// For compatibility with Sprig, a string has its characters shuffled.
+func shuffleJSON(r *rand.Rand, arr gjson.Result) (gjson.Result, error) {
+	arr = asJSON(arr)
+	switch {
+	case arr.IsArray():
+		elems := arr.Array()
+		r.Shuffle(len(elems), func(i, j int) { elems[i], elems[j] = elems[j], elems[i] })
+		return gjson.Parse(rawArray(elems)), nil
+	case arr.Type == gjson.String:
+		runes := []rune(arr.Str)
+		r.Shuffle(len(runes), func(i, j int) { runes[i], runes[j] = runes[j], runes[i] })
+		return gjson.Parse(jsonString(string(runes))), nil
+	case !arr.Exists() || arr.Type == gjson.Null:
+		return arr, nil
+	}
+	return gjson.Result{}, fmt.Errorf("cannot shuffle %s", describe(arr))
+}
+
+// sampleJSON returns an array of n elements chosen at random from the array
+// arr, in their original order. If arr has fewer than n elements, all are
+// returned. The arguments may be given in either order, so that the array
+// can be piped in.
+func sampleJSON(r *rand.Rand, arr, n gjson.Result) (gjson.Result, error) {
+	if arr.Type == gjson.Number {
+		arr, n = n, arr
+	}
+	arr = asJSON(arr)
+	if !arr.IsArray() {
+		return gjson.Result{}, fmt.Errorf("sample of non-array %s", describe(arr))
+	}
+	if n.Type != gjson.Number || n.Num < 0 || n.Num != float64(int(n.Num)) {
+		return gjson.Result{}, fmt.Errorf("sample size must be a non-negative integer; got %s", describe(n))
+	}
+	elems := arr.Array()
+	k := min(int(n.Num), len(elems))
+	// Choose k indexes, then emit them in order.
+	picked := r.Perm(len(elems))[:k]
+	slices.Sort(picked)
+	chosen := make([]gjson.Result, k)
+	for i, p := range picked {
+		chosen[i] = elems[p]
+	}
+	return gjson.Parse(rawArray(chosen)), nil
+}
+
+// pointerTokens splits an RFC 6901 JSON Pointer into its unescaped
+// reference tokens. The empty pointer refers to the whole document.
+func pointerTokens(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if ptr[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with /", ptr)
+	}
+	toks := strings.Split(ptr[1:], "/")
+	for i, tok := range toks {
+		toks[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+	}
+	return toks, nil
+}
+
+// lookupPointer returns the value referenced by the pointer tokens in v.
+func lookupPointer(v gjson.Result, toks []string) (gjson.Result, bool) {
+	for _, tok := range toks {
+		switch {
+		case v.IsObject():
+			v = v.Get(gjson.Escape(tok))
+		case v.IsArray():
+			i, ok := arrayIndex(tok, len(v.Array()))
+			if !ok || i == len(v.Array()) {
+				return gjson.Result{}, false
+			}
+			v = v.Array()[i]
+		default:
+			return gjson.Result{}, false
+		}
+		if !v.Exists() {
+			return gjson.Result{}, false
+		}
+	}
+	return v, v.Exists()
+}
+
+// arrayIndex parses an array index reference token for an array of length n.
+// The token "-" refers to the position after the last element, n.
+func arrayIndex(tok string, n int) (int, bool) {
+	if tok == "-" {
+		return n, true
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || i > n {
+		return 0, false
+	}
+	return i, true
+}
+
+// editPointer performs the "add", "replace" or "remove" operation on the
+// location referenced by the pointer tokens in the raw document, returning
+// the new document.
+func editPointer(raw string, toks []string, op, value string) (string, error) {
+	v := gjson.Parse(raw)
+	if len(toks) == 0 {
+		if op == "remove" {
+			return "", fmt.Errorf("cannot remove the whole document")
+		}
+		return value, nil
+	}
+	tok := toks[0]
+	switch {
+	case v.IsObject():
+		found := v.Get(gjson.Escape(tok)).Exists()
+		if !found && (len(toks) > 1 || op != "add") {
+			return "", fmt.Errorf("key %q not found", tok)
+		}
+		var err error
+		out := buildObject(v, func(key string, val gjson.Result) (string, string, bool) {
+			if key != tok || err != nil {
+				return key, val.Raw, true
+			}
+			if len(toks) > 1 {
+				var child string
+				child, err = editPointer(val.Raw, toks[1:], op, value)
+				return key, child, true
+			}
+			if op == "remove" {
+				return "", "", false
+			}
+			return key, value, true
+		})
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return appendMembers(out, ","+jsonString(tok)+":"+value), nil
+		}
+		return out, nil
+	case v.IsArray():
+		n := len(v.Array())
+		i, ok := arrayIndex(tok, n)
+		if !ok || (i == n && (len(toks) > 1 || op != "add")) {
+			return "", fmt.Errorf("array index %q out of range", tok)
+		}
+		var err error
+		out := buildArray(v, func(j int, val gjson.Result) (string, bool) {
+			if j != i || err != nil {
+				return val.Raw, true
+			}
+			switch {
+			case len(toks) > 1:
+				var child string
+				child, err = editPointer(val.Raw, toks[1:], op, value)
+				return child, true
+			case op == "add":
+				return value + "," + val.Raw, true
+			case op == "remove":
+				return "", false
+			}
+			return value, true
+		})
+		if err != nil {
+			return "", err
+		}
+		if i == n {
+			if n == 0 {
+				return "[" + value + "]", nil
+			}
+			return out[:len(out)-1] + "," + value + "]", nil
+		}
+		return out, nil
+	}
+	return "", fmt.Errorf("cannot index %s with %q", describe(v), tok)
+}
+
+// jsonEqual reports whether a and b hold equal JSON values, ignoring
+// formatting and the order of object members.
+func jsonEqual(a, b gjson.Result) bool {
+	if a.Exists() != b.Exists() {
+		return false
+	}
+	return reflect.DeepEqual(a.Value(), b.Value())
+}
+
+// asJSON returns the JSON value encoded in v if v is a string holding a JSON
+// object or array, such as the result of toJson or a string constant in the
+// template. Otherwise it returns v unchanged.
+func asJSON(v gjson.Result) gjson.Result {
+	if v.Type != gjson.String {
+		return v
+	}
+	s := strings.TrimSpace(v.Str)
+	if (strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")) && gjson.Valid(s) {
+		return gjson.Parse(s)
+	}
+	return v
+}
+
+// renameAt renames the key addressed by path in the raw JSON value to name.
+func renameAt(raw string, path []string, name string) string {
+	v := gjson.Parse(raw)
+	if len(path) == 0 {
+		return raw
+	}
+	if len(path) == 1 {
+		if !v.IsObject() {
+			return raw
+		}
+		return buildObject(v, func(key string, val gjson.Result) (string, string, bool) {
+			if key == path[0] {
+				return name, val.Raw, true
+			}
+			return key, val.Raw, true
+		})
+	}
+	switch {
+	case v.IsObject():
+		return buildObject(v, func(key string, val gjson.Result) (string, string, bool) {
+			if key == path[0] {
+				return key, renameAt(val.Raw, path[1:], name), true
+			}
+			return key, val.Raw, true
+		})
+	case v.IsArray():
+		i, err := strconv.Atoi(path[0])
+		if err != nil {
+			return raw
+		}
+		return buildArray(v, func(j int, val gjson.Result) (string, bool) {
+			if j == i {
+				return renameAt(val.Raw, path[1:], name), true
+			}
+			return val.Raw, true
+		})
+	}
+	return raw
+}
+
+// buildObject rebuilds the object v, passing each member to fn. fn returns
+// the key and raw value to emit and whether to keep the member at all.
+// Member order is preserved.
+func buildObject(v gjson.Result, fn func(key string, val gjson.Result) (string, string, bool)) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	n := 0
+	v.ForEach(func(k, val gjson.Result) bool {
+		key, raw, ok := fn(k.String(), val)
+		if !ok {
+			return true
+		}
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(jsonString(key))
+		b.WriteByte(':')
+		b.WriteString(raw)
+		n++
+		return true
+	})
+	b.WriteByte('}')
+	return b.String()
+}
+
+// buildArray rebuilds the array v, passing each element to fn. fn returns
+// the raw value to emit and whether to keep the element at all.
+func buildArray(v gjson.Result, fn func(i int, val gjson.Result) (string, bool)) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	n := 0
+	for i, val := range v.Array() {
+		raw, ok := fn(i, val)
+		if !ok {
+			continue
+		}
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(raw)
+		n++
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// rawArray returns a raw JSON array of the given elements.
+func rawArray(elems []gjson.Result) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, elem := range elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(elem.Raw)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// appendMembers appends members, a list of raw object members each preceded
+// by a comma, to the compact raw object obj built by buildObject.
+func appendMembers(obj, members string) string {
+	switch {
+	case members == "":
+		return obj
+	case obj == "{}":
+		return "{" + members[1:] + "}"
+	}
+	return obj[:len(obj)-1] + members + "}"
+}
+
+// jsonString returns s encoded as a JSON string literal. Unlike
+// json.Marshal, it does not escape HTML characters.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// splitPath splits a dotted path into its keys. A backslash escapes the
+// following character, so `fav\.movie` is the single key "fav.movie", as in
+// gjson paths.
+func splitPath(path string) []string {
+	var keys []string
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && i+1 < len(path):
+			i++
+			b.WriteByte(path[i])
+		case c == '.':
+			keys = append(keys, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(keys, b.String())
+}
+
+// describe returns a short description of v for use in error messages.
+func describe(v gjson.Result) string {
+	if !v.Exists() {
+		return "missing value"
+	}
+	if v.IsArray() {
+		return "array"
+	}
+	if v.IsObject() {
+		return "object"
+	}
+	return strings.ToLower(v.Type.String()) + " " + v.Raw
+}
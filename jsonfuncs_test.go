@@ -0,0 +1,263 @@
+package gjson_template
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// JSON data for the JSON transformation builtins
+var jsonFuncsTestJSON = []byte(`{
+	"user": {"first_name": "Tom", "last_name": "Anderson", "address": {"zip":"10001"}},
+	"users": [{"first_name":"Dale"},{"first_name":"Jane"}],
+	"mapping": {"first_name": "firstName", "last_name": "lastName"},
+	"config": {"timeout":5,"retry":{"count":3},"debug":null},
+	"fallback": {"timeout":30,"retry":{"count":1,"backoff":"exp"},"debug":true,"region":"us"},
+	"doc": {"a":{"b":1},"list":[1,2,3],"x/y":"slash"},
+	"prefs": {"fav.movie":"Up","fav":{"movie":"Heat"}},
+	"patch": [
+		{"op":"test","path":"/a/b","value":1},
+		{"op":"add","path":"/a/c","value":[true]},
+		{"op":"replace","path":"/a/b","value":2},
+		{"op":"remove","path":"/list/0"},
+		{"op":"add","path":"/list/-","value":4},
+		{"op":"add","path":"/list/0","value":0},
+		{"op":"copy","from":"/x~1y","path":"/copied"},
+		{"op":"move","from":"/a/c","path":"/moved"}
+	],
+	"merge": {"a":{"b":null,"d":{"e":null,"f":1}},"list":[9],"x/y":null,"new":"n"}
+}`)
+
+var jsonFuncsTests = []gjsonExecTest{
+	// renameKeys
+	{"renameKeys", "{{renameKeys .user .mapping}}", `{"firstName":"Tom","lastName":"Anderson","address":{"zip":"10001"}}`, jsonFuncsTestJSON, true},
+	{"renameKeys string mapping", `{{renameKeys .user "{\"first_name\":\"given\"}"}}`, `{"given":"Tom","last_name":"Anderson","address":{"zip":"10001"}}`, jsonFuncsTestJSON, true},
+	{"renameKeys path", `{{(renameKeys . "{\"user.address.zip\":\"postalCode\"}").user.address}}`, `{"postalCode":"10001"}`, jsonFuncsTestJSON, true},
+	{"renameKeys array path", `{{(renameKeys . "{\"users.1.first_name\":\"name\"}").users}}`, `[{"first_name":"Dale"},{"name":"Jane"}]`, jsonFuncsTestJSON, true},
+	{"renameKeys missing key", `{{len (renameKeys .user "{\"nope\":\"x\"}")}}`, "3", jsonFuncsTestJSON, true},
+	{"renameKeys field access", "{{(renameKeys .user .mapping).firstName}}", "Tom", jsonFuncsTestJSON, true},
+	{"renameKeys non-object", "{{renameKeys .users .mapping}}", "", jsonFuncsTestJSON, false},
+	{"renameKeys bad mapping", `{{renameKeys .user "{\"first_name\":1}"}}`, "", jsonFuncsTestJSON, false},
+
+	// defaults
+	{"defaults", "{{defaults .config .fallback}}", `{"timeout":5,"retry":{"count":3},"debug":null,"region":"us"}`, jsonFuncsTestJSON, true},
+	{"defaultsDeep", "{{defaultsDeep .config .fallback}}", `{"timeout":5,"retry":{"count":3,"backoff":"exp"},"debug":null,"region":"us"}`, jsonFuncsTestJSON, true},
+	{"defaults missing obj", "{{(defaults .nope .fallback).region}}", "us", jsonFuncsTestJSON, true},
+	{"defaults empty obj", `{{defaults "{}" .config}}`, `{"timeout":5,"retry":{"count":3},"debug":null}`, jsonFuncsTestJSON, true},
+	{"defaults null obj", "{{(defaults .config.debug .fallback).region}}", "us", jsonFuncsTestJSON, true},
+	{"defaults non-object", "{{defaults .users .fallback}}", "", jsonFuncsTestJSON, false},
+
+	// diff
+	{"diff", `{{diff .doc .merge}}`, `{"added":[{"path":"a.d","value":{"e":null,"f":1}},{"path":"new","value":"n"}],"removed":[{"path":"list.1","value":2},{"path":"list.2","value":3}],"changed":[{"path":"a.b","old":1,"new":null},{"path":"list.0","old":1,"new":9},{"path":"x\\/y","old":"slash","new":null}]}`, jsonFuncsTestJSON, true},
+	{"diff equal", `{{diff .config "{\"debug\":null,\"retry\":{\"count\":3},\"timeout\":5.0}"}}`, `{"added":[],"removed":[],"changed":[]}`, jsonFuncsTestJSON, true},
+	{"diff root", `{{(diff .user.first_name .users).changed}}`, `[{"path":"","old":"Tom","new":[{"first_name":"Dale"},{"first_name":"Jane"}]}]`, jsonFuncsTestJSON, true},
+	{"diff missing", `{{(diff .nope .user.address).added}}`, `[{"path":"","value":{"zip":"10001"}}]`, jsonFuncsTestJSON, true},
+	{"diff dotted key", `{{range (diff .prefs "{}").removed}}{{.path}};{{end}}`, `fav\.movie;fav;`, jsonFuncsTestJSON, true},
+
+	// entries, fromEntries
+	{"entries", `{{entries .user.address}}`, `[{"key":"zip","value":"10001"}]`, jsonFuncsTestJSON, true},
+	{"entries range", `{{range entries .config}}{{.key}}={{.value}};{{end}}`, `timeout=5;retry={"count":3};debug=null;`, jsonFuncsTestJSON, true},
+	{"entries missing", `{{entries .nope}}`, `[]`, jsonFuncsTestJSON, true},
+	{"entries non-object", `{{entries .users}}`, "", jsonFuncsTestJSON, false},
+	{"fromEntries round trip", `{{fromEntries (entries .config)}}`, `{"timeout":5,"retry":{"count":3},"debug":null}`, jsonFuncsTestJSON, true},
+	{"fromEntries", `{{fromEntries "[{\"key\":\"a\",\"value\":1},{\"key\":\"b\"},{\"key\":\"a\",\"value\":2}]"}}`, `{"a":2,"b":null}`, jsonFuncsTestJSON, true},
+	{"fromEntries empty", `{{fromEntries "[]"}}`, `{}`, jsonFuncsTestJSON, true},
+	{"fromEntries bad key", `{{fromEntries "[{\"key\":1}]"}}`, "", jsonFuncsTestJSON, false},
+	{"fromEntries non-array", `{{fromEntries .user}}`, "", jsonFuncsTestJSON, false},
+
+	// page, pageCount
+	{"page", `{{page .doc.list 0 2}} {{page .doc.list 1 2}} {{page .doc.list 2 2}}`, `[1,2] [3] []`, jsonFuncsTestJSON, true},
+	{"page range", `{{$l := .doc.list}}{{for $i := 0 (pageCount $l 2)}}<{{range page $l $i 2}}{{.}}{{end}}>{{end}}`, `<12><3>`, jsonFuncsTestJSON, true},
+	{"page large number", `{{page .doc.list 1000000000 2}}`, `[]`, jsonFuncsTestJSON, true},
+	{"page missing", `{{page .nope 0 5}} {{pageCount .nope 5}}`, `[] 0`, jsonFuncsTestJSON, true},
+	{"pageCount", `{{pageCount .doc.list 1}} {{pageCount .doc.list 2}} {{pageCount .doc.list 3}} {{pageCount .doc.list 4}}`, `3 2 1 1`, jsonFuncsTestJSON, true},
+	{"page negative", `{{page .doc.list -1 2}}`, "", jsonFuncsTestJSON, false},
+	{"page zero size", `{{page .doc.list 0 0}}`, "", jsonFuncsTestJSON, false},
+	{"pageCount non-array", `{{pageCount .user 2}}`, "", jsonFuncsTestJSON, false},
+
+	// jsonPatch
+	{"jsonPatch", "{{jsonPatch .doc .patch}}", `{"a":{"b":2},"list":[0,2,3,4],"x/y":"slash","copied":"slash","moved":[true]}`, jsonFuncsTestJSON, true},
+	{"jsonPatch replace root", `{{jsonPatch .doc "[{\"op\":\"replace\",\"path\":\"\",\"value\":{}}]"}}`, `{}`, jsonFuncsTestJSON, true},
+	{"jsonPatch test fails", `{{jsonPatch .doc "[{\"op\":\"test\",\"path\":\"/a/b\",\"value\":2}]"}}`, "", jsonFuncsTestJSON, false},
+	{"jsonPatch missing path", `{{jsonPatch .doc "[{\"op\":\"remove\",\"path\":\"/nope\"}]"}}`, "", jsonFuncsTestJSON, false},
+	{"jsonPatch index out of range", `{{jsonPatch .doc "[{\"op\":\"add\",\"path\":\"/list/9\",\"value\":1}]"}}`, "", jsonFuncsTestJSON, false},
+	{"jsonPatch unknown op", `{{jsonPatch .doc "[{\"op\":\"frob\",\"path\":\"/a\"}]"}}`, "", jsonFuncsTestJSON, false},
+	{"jsonPatch not array", "{{jsonPatch .doc .doc}}", "", jsonFuncsTestJSON, false},
+
+	// mergePatch
+	{"mergePatch", "{{mergePatch .doc .merge}}", `{"a":{"d":{"f":1}},"list":[9],"new":"n"}`, jsonFuncsTestJSON, true},
+	{"mergePatch non-object patch", `{{mergePatch .doc "[1]"}}`, `[1]`, jsonFuncsTestJSON, true},
+	{"mergePatch non-object doc", `{{mergePatch .doc.list "{\"a\":1}"}}`, `{"a":1}`, jsonFuncsTestJSON, true},
+	{"mergePatch empty patch", `{{mergePatch .doc "{}"}}`, `{"a":{"b":1},"list":[1,2,3],"x/y":"slash"}`, jsonFuncsTestJSON, true},
+
+	// jmespath
+	{"jmespath dot", `{{jmespath "user.first_name"}}`, "Tom", jsonFuncsTestJSON, true},
+	{"jmespath projection", `{{jmespath "users[*].first_name"}}`, `["Dale","Jane"]`, jsonFuncsTestJSON, true},
+	{"jmespath filter", "{{jmespath \"doc.list[?@ > `1`]\"}}", `[2,3]`, jsonFuncsTestJSON, true},
+	{"jmespath value", `{{jmespath "[0].first_name" .users}}`, "Dale", jsonFuncsTestJSON, true},
+	{"jmespath pipeline", `{{.config | jmespath "retry.count"}}`, "3", jsonFuncsTestJSON, true},
+	{"jmespath function", `{{jmespath "length(users)"}}`, "2", jsonFuncsTestJSON, true},
+	{"jmespath in range", `{{range jmespath "users[*].first_name"}}{{.}};{{end}}`, "Dale;Jane;", jsonFuncsTestJSON, true},
+	{"jmespath no match", `{{jmespath "nope"}}`, "", jsonFuncsTestJSON, true},
+	{"jmespath syntax error", `{{jmespath "users[?"}}`, "", jsonFuncsTestJSON, false},
+
+	// jsonptr
+	{"jsonptr", `{{jsonptr "/user/address/zip"}}`, "10001", jsonFuncsTestJSON, true},
+	{"jsonptr array", `{{jsonptr "/users/1/first_name"}}`, "Jane", jsonFuncsTestJSON, true},
+	{"jsonptr escaped", `{{jsonptr "/doc/x~1y"}}`, "slash", jsonFuncsTestJSON, true},
+	{"jsonptr fragment", `{{jsonptr "#/doc/x~1y"}}`, "slash", jsonFuncsTestJSON, true},
+	{"jsonptr root", `{{jsonptr "" .doc.a}}`, `{"b":1}`, jsonFuncsTestJSON, true},
+	{"jsonptr value", `{{jsonptr "/0" .doc.list}}`, "1", jsonFuncsTestJSON, true},
+	{"jsonptr pipeline", `{{.doc | jsonptr "/a/b"}}`, "1", jsonFuncsTestJSON, true},
+	{"jsonptr missing", `{{jsonptr "/users/2"}}`, "", jsonFuncsTestJSON, true},
+	{"jsonptr leading zero", `{{jsonptr "/users/01"}}`, "", jsonFuncsTestJSON, true},
+	{"jsonptr invalid", `{{jsonptr "users"}}`, "", jsonFuncsTestJSON, false},
+	{"jsonptr bad fragment", `{{jsonptr "#%zz"}}`, "", jsonFuncsTestJSON, false},
+
+	// shuffle, sample
+	{"shuffle one", `{{shuffle "[\"x\"]"}}`, `["x"]`, jsonFuncsTestJSON, true},
+	{"shuffle missing", "{{shuffle .nope}}", "", jsonFuncsTestJSON, true},
+	{"shuffle object", "{{shuffle .user}}", "", jsonFuncsTestJSON, false},
+	{"sample non-array", "{{sample .user 1}}", "", jsonFuncsTestJSON, false},
+	{"sample negative", "{{sample .users -1}}", "", jsonFuncsTestJSON, false},
+
+	// path
+	{"path", `{{gjson (path "users" 1 "first_name")}}`, "Jane", jsonFuncsTestJSON, true},
+	{"path escaped", `{{path "a.b" "*" "#(x==1)" "p|q"}}`, `a\.b.\*.\#\(x\=\=1\).p\|q`, jsonFuncsTestJSON, true},
+	{"path dotted key", `{{gjson (path "prefs" "fav.movie")}}`, "Up", jsonFuncsTestJSON, true},
+	{"path slash", `{{gjson (path "doc" "x/y")}}`, "slash", jsonFuncsTestJSON, true},
+	{"path wildcard key", `{{gjson (path "doc" "*")}}`, "", jsonFuncsTestJSON, true},
+	{"path empty key", `{{path "doc" ""}}`, "", jsonFuncsTestJSON, false},
+	{"path float key", `{{path "users" 1.5}}`, "", jsonFuncsTestJSON, false},
+	{"path object key", `{{path .user}}`, "", jsonFuncsTestJSON, false},
+	{"get", `{{$i := 1}}{{get .users $i "first_name"}}`, "Jane", jsonFuncsTestJSON, true},
+	{"get chain", `{{$k := "fav.movie"}}{{(get .prefs $k)}} {{(get . "user" "address").zip}}`, "Up 10001", jsonFuncsTestJSON, true},
+	{"get negative", `{{(get .users -1).first_name}}`, "Jane", jsonFuncsTestJSON, true},
+	{"get string index", `{{get .users "0" "first_name"}}`, "Dale", jsonFuncsTestJSON, true},
+	{"get no keys", `{{get .user.first_name}}`, "Tom", jsonFuncsTestJSON, true},
+	{"get missing", `{{get .users 5 "first_name"}}`, "", jsonFuncsTestJSON, true},
+	{"get in range", `{{range $i, $_ := .users}}{{(get $.users $i).first_name}};{{end}}`, "Dale;Jane;", jsonFuncsTestJSON, true},
+	{"get bad key", `{{get .users .user}}`, "", jsonFuncsTestJSON, false},
+	{"pathEscape", `{{pathEscape "fav.movie*?#"}}`, `fav\.movie\*\?\#`, jsonFuncsTestJSON, true},
+	{"pathEscape plain", `{{pathEscape "first_name"}}`, "first_name", jsonFuncsTestJSON, true},
+	{"pathEscape lookup", `{{gjson (printf "doc.%s" (pathEscape "x/y"))}}`, "slash", jsonFuncsTestJSON, true},
+	{"pathEscape variable", `{{$k := "fav.movie"}}{{gjson (printf "prefs.%s" (pathEscape $k))}} {{gjson (printf "prefs.%s" $k)}}`, "Up Heat", jsonFuncsTestJSON, true},
+}
+
+func TestJSONFuncs(t *testing.T) {
+	testGjsonExecute(t, jsonFuncsTests)
+}
+
+func TestJSONPointerError(t *testing.T) {
+	_, err := jsonPointer("#%zz", gjson.Parse(`{}`))
+	if err == nil || !strings.Contains(err.Error(), `invalid JSON pointer "#%zz"`) {
+		t.Errorf("expected the pointer in the error; got %v", err)
+	}
+}
+
+func TestShuffleSample(t *testing.T) {
+	data := []byte(`{"reviews": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]}`)
+	exec := func(text string, opt ...string) string {
+		t.Helper()
+		tmpl, err := New("random").Option(opt...).Parse(text)
+		if err != nil {
+			t.Fatalf("parse error: %s", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Fatalf("execute error: %s", err)
+		}
+		return buf.String()
+	}
+
+	// Shuffling permutes the elements.
+	got := gjson.Parse(exec("{{shuffle .reviews}}")).Array()
+	var sum int64
+	for _, v := range got {
+		sum += v.Int()
+	}
+	if len(got) != 10 || sum != 55 {
+		t.Errorf("shuffle: got %v", got)
+	}
+
+	// Samples keep the original order.
+	got = gjson.Parse(exec("{{.reviews | sample 3}}")).Array()
+	if len(got) != 3 || got[0].Int() >= got[1].Int() || got[1].Int() >= got[2].Int() {
+		t.Errorf("sample: got %v", got)
+	}
+	if out := exec("{{sample .reviews 20}}"); out != "[1,2,3,4,5,6,7,8,9,10]" {
+		t.Errorf("sample all: got %s", out)
+	}
+	if out := exec("{{sample .reviews 0}}"); out != "[]" {
+		t.Errorf("sample none: got %s", out)
+	}
+
+	// With a seed, executions are reproducible.
+	const text = "{{shuffle .reviews}} {{sample .reviews 4}} {{shuffle `abcdef`}}"
+	first := exec(text, "seed=42")
+	for i := 0; i < 5; i++ {
+		if out := exec(text, "seed=42"); out != first {
+			t.Fatalf("seeded execution %d: got %s; want %s", i, out, first)
+		}
+	}
+	if out := exec(text, "seed=7"); out == first {
+		t.Errorf("different seeds produced the same output %s", out)
+	}
+}
+
+func TestExecuteJSON(t *testing.T) {
+	data := []byte(`{"trace": "t-1", "items": [{"id": 1, "name": "a\"b"}, {"id": 2}], "cfg": {"x": true}}`)
+	tests := []struct {
+		name, input, output string
+		opt                 []string
+		ok                  bool
+	}{
+		{"nested", `{{emit "response.headers.x-id" .trace}}`, `{"response":{"headers":{"x-id":"t-1"}}}`, nil, true},
+		{"append", `{{range .items}}{{emit "ids.-1" .id}}{{end}}`, `{"ids":[1,2]}`, nil, true},
+		{"escaping", `{{range $i, $e := .items}}{{emit (printf "names.%d" $i) .name}}{{end}}`, `{"names":["a\"b",null]}`, nil, true},
+		{"piped", `{{.cfg | emit "config"}}`, `{"config":{"x": true}}`, nil, true},
+		{"constants", `{{emit "n" 2}}{{emit "s" "x"}}{{emit "b" false}}`, `{"n":2,"s":"x","b":false}`, nil, true},
+		{"text discarded", `text {{emit "a" 1}} more`, `{"a":1}`, nil, true},
+		{"template", `{{define "t"}}{{emit "from" .}}{{end}}{{template "t" .trace}}`, `{"from":"t-1"}`, nil, true},
+		{"nothing", `text`, `{}`, nil, true},
+		{"missing", `{{emit "m" .nope}}`, `{"m":null}`, nil, true},
+		{"missing error", `{{emit "m" .nope}}`, ``, []string{"missingkey=error"}, false},
+		{"path not string", `{{emit 1 2}}`, ``, nil, false},
+		{"wrong args", `{{emit "a"}}`, ``, nil, false},
+	}
+	for _, test := range tests {
+		tmpl, err := New(test.name).Option(test.opt...).Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		out, err := tmpl.ExecuteJSON(data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got %s", test.name, out)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.ok && string(out) != test.output:
+			t.Errorf("%s: expected %s; got %s", test.name, test.output, out)
+		}
+	}
+
+	// emit is only allowed when building a document.
+	tmpl := Must(New("text").Parse(`{{emit "a" 1}}`))
+	if err := tmpl.Execute(io.Discard, data); err == nil || !strings.Contains(err.Error(), "emit is only allowed in templates executed with ExecuteJSON") {
+		t.Errorf("emit in Execute: expected error; got %v", err)
+	}
+
+	// The emitFunc builtin only declares the signature of emit, which is
+	// evaluated specially; it is never called, even with the wrong number
+	// of arguments.
+	for _, text := range []string{`{{emit "a"}}`, `{{emit "a" 1 2}}`, `{{1 | emit "a" 2}}`} {
+		_, err := Must(New("args").Parse(text)).ExecuteJSON(data)
+		if err == nil || !strings.Contains(err.Error(), "wrong number of args for emit") {
+			t.Errorf("%s: expected an argument count error; got %v", text, err)
+		}
+	}
+}
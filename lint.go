@@ -0,0 +1,203 @@
+// This file contains the static checks of Lint.
+
+package gjson_template
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/higress-group/gjson_template/parse"
+)
+
+// An Issue is a problem found in a template without executing it.
+type Issue struct {
+	Location string // Position of the problem, as "name:line:col".
+	Message  string
+}
+
+func (i Issue) String() string {
+	return i.Location + ": " + i.Message
+}
+
+// Lint checks the templates associated with t for likely mistakes and
+// returns the problems found, ordered by template name and position:
+//
+//   - calls of functions that are neither builtins nor added with
+//     [Template.Funcs], as Check reports;
+//   - constant GJSON paths of gjson calls, such as {{gjson `a.#(b==1`}},
+//     that are obviously invalid, such as with unbalanced brackets or an
+//     empty component;
+//   - {{if}} and {{with}} actions whose condition is a constant, so that
+//     one of their branches can never run.
+//
+// Templates that declare functions added after parsing should be parsed
+// with the latefuncs option, so that Parse does not stop at them.
+func (t *Template) Lint() []Issue {
+	if t.common == nil {
+		return nil
+	}
+	tmpls := t.templates()
+	trees := make([]*parse.Tree, 0, len(tmpls))
+	for _, tmpl := range tmpls {
+		if tmpl.Tree != nil {
+			trees = append(trees, tmpl.Tree)
+		}
+	}
+	slices.SortFunc(trees, func(a, b *parse.Tree) int { return strings.Compare(a.Name, b.Name) })
+	builtin := builtins()
+	t.muFuncs.RLock()
+	defer t.muFuncs.RUnlock()
+	var issues []Issue
+	for _, tree := range trees {
+		var found []lintIssue
+		report := func(n parse.Node, format string, args ...any) {
+			found = append(found, lintIssue{n.Position(), n, fmt.Sprintf(format, args...)})
+		}
+		walkCommands(tree.Root, func(cmd *parse.CommandNode, _ bool) {
+			for _, arg := range cmd.Args {
+				if fn, ok := arg.(*parse.IdentifierNode); ok && t.parseFuncs[fn.Ident] == nil && builtin[fn.Ident] == nil {
+					report(fn, "function %q not defined", fn.Ident)
+				}
+			}
+			if path, n, ok := constantPath(cmd); ok {
+				if err := checkPath(path); err != "" {
+					report(n, "invalid gjson path %q: %s", path, err)
+				}
+			}
+		})
+		lintBranches(tree.Root, report)
+		slices.SortStableFunc(found, func(a, b lintIssue) int { return int(a.pos - b.pos) })
+		for _, f := range found {
+			location, _ := tree.ErrorContext(f.node)
+			issues = append(issues, Issue{Location: location, Message: f.msg})
+		}
+	}
+	return issues
+}
+
+// lintIssue is an Issue before its location is formatted.
+type lintIssue struct {
+	pos  parse.Pos
+	node parse.Node
+	msg  string
+}
+
+// constantPath returns the GJSON path of cmd, if it is a call of gjson
+// with a constant path, and the node holding it.
+func constantPath(cmd *parse.CommandNode) (string, parse.Node, bool) {
+	if fn, ok := cmd.Args[0].(*parse.IdentifierNode); ok && fn.Ident == "gjson" && len(cmd.Args) == 2 {
+		if s, ok := cmd.Args[1].(*parse.StringNode); ok {
+			return s.Text, s, true
+		}
+	}
+	return "", nil, false
+}
+
+// checkPath returns a description of what makes the GJSON path obviously
+// invalid, or "" if nothing does.
+func checkPath(path string) string {
+	if path == "" {
+		return "empty path"
+	}
+	var stack []byte
+	inString := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\':
+			i++
+		case inString:
+			if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '(' || c == '[' || c == '{':
+			stack = append(stack, c)
+		case c == ')' || c == ']' || c == '}':
+			open := map[byte]byte{')': '(', ']': '[', '}': '{'}[c]
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return fmt.Sprintf("unbalanced %q", c)
+			}
+			stack = stack[:len(stack)-1]
+		case len(stack) == 0 && (c == '.' || c == '|'):
+			if i == len(path)-1 {
+				return fmt.Sprintf("trailing %q", c)
+			}
+			if i > 0 && (path[i-1] == '.' || path[i-1] == '|') && !strings.HasPrefix(path, "..") {
+				return "empty component"
+			}
+		}
+	}
+	switch {
+	case inString:
+		return "unterminated string"
+	case len(stack) > 0:
+		return fmt.Sprintf("unclosed %q", stack[len(stack)-1])
+	}
+	return ""
+}
+
+// lintBranches reports the branches of {{if}} and {{with}} actions within
+// node that can never run because the condition is a constant.
+func lintBranches(node parse.Node, report func(parse.Node, string, ...any)) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			lintBranches(n, report)
+		}
+	case *parse.IfNode:
+		lintCondition("if", node, node.Pipe, node.ElseList, report)
+		lintBranches(node.List, report)
+		lintBranches(node.ElseList, report)
+	case *parse.WithNode:
+		lintCondition("with", node, node.Pipe, node.ElseList, report)
+		lintBranches(node.List, report)
+		lintBranches(node.ElseList, report)
+	case *parse.RangeNode:
+		lintBranches(node.List, report)
+		lintBranches(node.ElseList, report)
+	case *parse.ForNode:
+		lintBranches(node.List, report)
+		lintBranches(node.ElseList, report)
+	case *parse.WhileNode:
+		lintBranches(node.List, report)
+		lintBranches(node.ElseList, report)
+	case *parse.CaptureNode:
+		lintBranches(node.List, report)
+	case *parse.TryNode:
+		lintBranches(node.List, report)
+		lintBranches(node.CatchList, report)
+	}
+}
+
+// lintCondition reports the unreachable branch of a control action whose
+// condition is a constant.
+func lintCondition(keyword string, node parse.Node, pipe *parse.PipeNode, elseList *parse.ListNode, report func(parse.Node, string, ...any)) {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return
+	}
+	var truth bool
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.BoolNode:
+		truth = arg.True
+	case *parse.NumberNode:
+		truth = arg.Text != "0" && !(arg.IsFloat && arg.Float64 == 0)
+	case *parse.StringNode:
+		truth = arg.Text != ""
+	case *parse.NilNode:
+		truth = false
+	default:
+		return
+	}
+	switch {
+	case truth && elseList != nil:
+		report(elseList, "{{else}} branch is unreachable: the condition of %s is always true", keyword)
+	case !truth:
+		report(node, "%s body is unreachable: the condition is always false", keyword)
+	}
+}
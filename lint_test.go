@@ -0,0 +1,41 @@
+package gjson_template
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		issues []string
+	}{
+		{"clean", `{{if .a}}{{gjson "items.#(n>1)#.id"}}{{else}}{{upper .b}}{{end}}`, nil},
+		{"undefined", `{{frob .a}} {{frob .b | nope}}`, []string{
+			`undefined:1:2: function "frob" not defined`,
+			`undefined:1:14: function "frob" not defined`,
+			`undefined:1:24: function "nope" not defined`,
+		}},
+		{"paths", "{{gjson `items.#(n>1`}}{{gjson \"a..b\"}}{{gjson \"a.\"}}{{gjson \"..0\"}}{{gjson `a.{x,y}`}}", []string{
+			`paths:1:8: invalid gjson path "items.#(n>1": unclosed '('`,
+			`paths:1:31: invalid gjson path "a..b": empty component`,
+			`paths:1:47: invalid gjson path "a.": trailing '.'`,
+		}},
+		{"branches", `{{if true}}a{{else}}b{{end}}{{with ""}}c{{end}}{{if 1}}d{{end}}{{if .x}}{{else if false}}e{{end}}`, []string{
+			`branches:1:20: {{else}} branch is unreachable: the condition of if is always true`,
+			`branches:1:35: with body is unreachable: the condition is always false`,
+			`branches:1:82: if body is unreachable: the condition is always false`,
+		}},
+	}
+	for _, test := range tests {
+		tmpl := Must(New(test.name).Option("latefuncs").Parse(test.input))
+		var got []string
+		for _, issue := range tmpl.Lint() {
+			got = append(got, issue.String())
+		}
+		if !slices.Equal(got, test.issues) {
+			t.Errorf("%s: expected\n\t%q\ngot\n\t%q", test.name, test.issues, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// This file contains the loading of templates from external sources.
+
+package gjson_template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// A Loader returns the text of templates that are not yet defined, so that
+// {{template}} actions can invoke templates kept in a ConfigMap, database
+// or object store without parsing all of them up front. Load receives the
+// context of the execution, as passed to ExecuteContext, and returns an
+// error matching [fs.ErrNotExist] with errors.Is if there is no template
+// with the given name. Load may be called concurrently by executions in
+// different goroutines, for different names.
+type Loader interface {
+	Load(ctx context.Context, name string) ([]byte, error)
+}
+
+// The LoaderFunc type is an adapter to allow the use of ordinary functions
+// as Loaders.
+type LoaderFunc func(ctx context.Context, name string) ([]byte, error)
+
+// Load calls f(ctx, name).
+func (f LoaderFunc) Load(ctx context.Context, name string) ([]byte, error) {
+	return f(ctx, name)
+}
+
+// SetLoader sets the loader of the template and the templates associated
+// with it, or removes it if l is nil. When a {{template}} action or
+// ExecuteTemplate names a template that is not defined, the text returned
+// by the loader is parsed into a template of that name associated with t,
+// which later executions reuse. The text may not define other templates
+// with {{define}} or {{block}}. The loader must be set before the
+// templates are executed. The return value is the template, so calls can
+// be chained.
+func (t *Template) SetLoader(l Loader) *Template {
+	t.init()
+	t.loader = l
+	return t
+}
+
+// A loadCall is a load of a template in progress. Executions needing the
+// same template wait for it instead of loading it again.
+type loadCall struct {
+	done chan struct{} // closed when the load is finished
+	tmpl *Template
+	err  error
+}
+
+// lookupOrLoad returns the template with the given name associated with t,
+// loading it with the loader if it is not defined. It returns nil and no
+// error if there is no such template.
+func (t *Template) lookupOrLoad(ctx context.Context, name string) (*Template, error) {
+	if tmpl := t.Lookup(name); tmpl != nil || t.common == nil || t.loader == nil {
+		return tmpl, nil
+	}
+	t.muLoad.Lock()
+	if tmpl := t.Lookup(name); tmpl != nil {
+		t.muLoad.Unlock()
+		return tmpl, nil
+	}
+	if call := t.loads[name]; call != nil {
+		t.muLoad.Unlock()
+		<-call.done
+		return call.tmpl, call.err
+	}
+	call := &loadCall{done: make(chan struct{})}
+	if t.loads == nil {
+		t.loads = make(map[string]*loadCall)
+	}
+	t.loads[name] = call
+	t.muLoad.Unlock()
+
+	call.tmpl, call.err = t.load(ctx, name)
+	t.muLoad.Lock()
+	delete(t.loads, name)
+	t.muLoad.Unlock()
+	close(call.done)
+	return call.tmpl, call.err
+}
+
+// load loads, parses and installs the template with the given name.
+func (t *Template) load(ctx context.Context, name string) (*Template, error) {
+	b, err := t.loader.Load(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("template: loading %q: %w", name, err)
+	}
+	// The text is parsed apart from the templates being executed, so that
+	// it cannot replace them.
+	trees, err := t.parseTrees(name, string(b))
+	if err != nil {
+		return nil, err
+	}
+	for other := range trees {
+		if other != name {
+			return nil, fmt.Errorf("template: loading %q: loaded text defines template %q", name, other)
+		}
+	}
+	return t.AddParseTree(name, trees[name])
+}
@@ -0,0 +1,105 @@
+package gjson_template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mapLoader loads templates from a map, counting the loads.
+type mapLoader struct {
+	mu    sync.Mutex
+	texts map[string]string
+	loads int
+}
+
+func (l *mapLoader) Load(_ context.Context, name string) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loads++
+	text, ok := l.texts[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(text), nil
+}
+
+func TestLoader(t *testing.T) {
+	l := &mapLoader{texts: map[string]string{
+		"header": `<h1>{{.title}}</h1>{{template "sub" .}}`,
+		"sub":    `<h2>{{.sub}}</h2>`,
+		"bad":    `{{.x`,
+		"defs":   `{{define "sub"}}replaced{{end}}{{.title}}`,
+	}}
+	tmpl := Must(New("page").SetLoader(l).Parse(`{{template "header" .}}body`))
+	data := []byte(`{"title":"T","sub":"S"}`)
+	for range 3 {
+		out, err := tmpl.ExecuteString(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "<h1>T</h1><h2>S</h2>body"; out != want {
+			t.Fatalf("expected %q; got %q", want, out)
+		}
+	}
+	if l.loads != 2 {
+		t.Errorf("expected 2 loads; got %d", l.loads)
+	}
+
+	var buf strings.Builder
+	if err := tmpl.ExecuteTemplate(&buf, "sub", data); err != nil || buf.String() != "<h2>S</h2>" {
+		t.Errorf("ExecuteTemplate: got %q, %v", buf.String(), err)
+	}
+
+	missing := Must(tmpl.New("missing").Parse(`{{template "nope" .}}`))
+	if _, err := missing.ExecuteString(data); !errors.Is(err, ErrUnknownFunction) {
+		t.Errorf("expected ErrUnknownFunction; got %v", err)
+	}
+	bad := Must(tmpl.New("usesbad").Parse(`{{template "bad" .}}`))
+	if _, err := bad.ExecuteString(data); err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Errorf("expected parse error of bad; got %v", err)
+	}
+	defs := Must(tmpl.New("usesdefs").Parse(`{{template "defs" .}}`))
+	if _, err := defs.ExecuteString(data); err == nil || !strings.Contains(err.Error(), `defines template "sub"`) {
+		t.Errorf("expected error for define in loaded text; got %v", err)
+	}
+	if out, err := tmpl.ExecuteString(data); err != nil || out != "<h1>T</h1><h2>S</h2>body" {
+		t.Errorf("template replaced by loaded text: got %q, %v", out, err)
+	}
+
+	type key struct{}
+	failing := Must(New("f").SetLoader(LoaderFunc(func(ctx context.Context, name string) ([]byte, error) {
+		return nil, fmt.Errorf("store unavailable for %v", ctx.Value(key{}))
+	})).Parse(`{{template "x" .}}`))
+	ctx := context.WithValue(context.Background(), key{}, "tenant")
+	err := failing.ExecuteContext(ctx, io.Discard, data)
+	if err == nil || !strings.Contains(err.Error(), `loading "x": store unavailable for tenant`) {
+		t.Errorf("expected load error; got %v", err)
+	}
+}
+
+// TestLoaderParallel tests that a template loaded by parallel executions
+// is loaded once.
+func TestLoaderParallel(t *testing.T) {
+	l := &mapLoader{texts: map[string]string{"part": `{{.n}}`}}
+	tmpl := Must(New("main").SetLoader(l).Parse(`[{{template "part" .}}]`))
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out, err := tmpl.ExecuteString([]byte(`{"n":1}`)); err != nil || out != "[1]" {
+				t.Errorf("got %q, %v", out, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if l.loads != 1 {
+		t.Errorf("expected 1 load; got %d", l.loads)
+	}
+}
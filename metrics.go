@@ -0,0 +1,103 @@
+// This file contains the default collection of execution metrics.
+
+package gjson_template
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// durationBuckets and bytesBuckets are the upper bounds of the histogram
+// buckets of ExpvarMetrics, in seconds and bytes.
+var (
+	durationBuckets = []float64{0.0001, 0.001, 0.01, 0.1, 1}
+	bytesBuckets    = []float64{256, 4096, 65536, 1 << 20}
+)
+
+// ExpvarMetrics is an ExecObserver publishing metrics of the executions
+// with the expvar package, so that they are served on /debug/vars without
+// further dependencies. Set it with [Template.SetObserver]; the
+// prommetrics package provides an observer for Prometheus instead.
+//
+// The published variable is a map from template names to maps of:
+//
+//	executions           number of executions
+//	errors               number of failed executions
+//	duration_seconds     total duration of the executions
+//	output_bytes         total size of the outputs
+//	duration_histogram   number of executions by duration
+//	output_histogram     number of executions by output size
+//
+// The histograms map upper bounds, such as "0.001" or "+Inf", to the
+// number of executions at most that long or large, as in Prometheus.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	mu        sync.Mutex // protects templates
+	templates map[string]*templateMetrics
+}
+
+type templateMetrics struct {
+	executions, errors, bytes expvar.Int
+	duration                  expvar.Float
+	durations, sizes          *expvar.Map
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics publishing its metrics as the
+// expvar variable name. Like expvar.Publish, it panics if the name is
+// already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{
+		vars:      expvar.NewMap(name),
+		templates: make(map[string]*templateMetrics),
+	}
+}
+
+// ObserveExec implements ExecObserver.
+func (m *ExpvarMetrics) ObserveExec(s ExecStats) {
+	tm := m.template(s.Template)
+	tm.executions.Add(1)
+	if s.Err != nil {
+		tm.errors.Add(1)
+	}
+	tm.duration.Add(s.Duration.Seconds())
+	tm.bytes.Add(int64(s.Bytes))
+	observeBuckets(tm.durations, durationBuckets, s.Duration.Seconds())
+	observeBuckets(tm.sizes, bytesBuckets, float64(s.Bytes))
+}
+
+// template returns the metrics of the template named name, publishing
+// them on first use.
+func (m *ExpvarMetrics) template(name string) *templateMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tm := m.templates[name]; tm != nil {
+		return tm
+	}
+	tm := &templateMetrics{
+		durations: new(expvar.Map).Init(),
+		sizes:     new(expvar.Map).Init(),
+	}
+	v := new(expvar.Map).Init()
+	v.Set("executions", &tm.executions)
+	v.Set("errors", &tm.errors)
+	v.Set("duration_seconds", &tm.duration)
+	v.Set("output_bytes", &tm.bytes)
+	v.Set("duration_histogram", tm.durations)
+	v.Set("output_histogram", tm.sizes)
+	m.templates[name] = tm
+	m.vars.Set(name, v)
+	return tm
+}
+
+// observeBuckets counts the value x in the cumulative histogram h with
+// the upper bounds bounds.
+func observeBuckets(h *expvar.Map, bounds []float64, x float64) {
+	for _, b := range bounds {
+		if x <= b {
+			h.Add(strconv.FormatFloat(b, 'f', -1, 64), 1)
+		}
+	}
+	h.Add("+Inf", 1)
+}
@@ -0,0 +1,43 @@
+package gjson_template
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"testing"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	m := NewExpvarMetrics("gjson_template_test")
+	tmpl := Must(New("greet").SetObserver(m).Parse(`hello {{.name}}`))
+	Must(tmpl.New("fail").Parse(`{{fail "no"}}`))
+	for range 2 {
+		if err := tmpl.Execute(io.Discard, []byte(`{"name":"ann"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tmpl.ExecuteTemplate(io.Discard, "fail", []byte(`{}`)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	var vars map[string]struct {
+		Executions        int            `json:"executions"`
+		Errors            int            `json:"errors"`
+		OutputBytes       int            `json:"output_bytes"`
+		DurationHistogram map[string]int `json:"duration_histogram"`
+		OutputHistogram   map[string]int `json:"output_histogram"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("gjson_template_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	greet, fail := vars["greet"], vars["fail"]
+	if greet.Executions != 2 || greet.Errors != 0 || greet.OutputBytes != 18 {
+		t.Errorf("greet: got %+v", greet)
+	}
+	if greet.DurationHistogram["+Inf"] != 2 || greet.OutputHistogram["256"] != 2 || greet.OutputHistogram["+Inf"] != 2 {
+		t.Errorf("greet histograms: got %+v", greet)
+	}
+	if fail.Executions != 1 || fail.Errors != 1 {
+		t.Errorf("fail: got %+v", fail)
+	}
+}
@@ -0,0 +1,73 @@
+// This file contains the builtins that map between file extensions and
+// MIME types.
+
+package gjson_template
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// mimeByExt returns the MIME type of the file extension ext, such as ".png"
+// or "png", or of the extension of a file name such as "photo.PNG". Text
+// types include their charset, as in "text/html; charset=utf-8". Unknown
+// extensions yield "", so that a default can be supplied with the default
+// function. Besides the types built into Go, the types of the system's
+// mime.types files are known.
+func mimeByExt(ext gjson.Result) string {
+	s, _ := gjsonPrintableValue(ext)
+	if !ext.Exists() || ext.Type == gjson.Null || s == "" {
+		return ""
+	}
+	if e := path.Ext(s); e != "" {
+		s = e
+	} else {
+		s = "." + s
+	}
+	return mime.TypeByExtension(strings.ToLower(s))
+}
+
+// extByMime returns the usual file extension, with its leading dot, of the
+// MIME type typ, ignoring any parameters such as charset. Unknown types
+// yield "".
+func extByMime(typ gjson.Result) (string, error) {
+	s, _ := gjsonPrintableValue(typ)
+	if !typ.Exists() || typ.Type == gjson.Null || s == "" {
+		return "", nil
+	}
+	media, _, err := mime.ParseMediaType(s)
+	if err != nil {
+		return "", fmt.Errorf("extByMime: invalid MIME type %q", s)
+	}
+	if ext, ok := mimeExtensions[media]; ok {
+		return ext, nil
+	}
+	exts, err := mime.ExtensionsByType(media)
+	if err != nil || len(exts) == 0 {
+		return "", nil
+	}
+	return exts[0], nil
+}
+
+// mimeExtensions holds the usual extension of the MIME types with several,
+// which mime.ExtensionsByType lists in alphabetical order.
+var mimeExtensions = map[string]string{
+	"application/javascript":   ".js",
+	"application/json":         ".json",
+	"application/octet-stream": ".bin",
+	"application/xml":          ".xml",
+	"audio/mpeg":               ".mp3",
+	"image/jpeg":               ".jpg",
+	"image/svg+xml":            ".svg",
+	"image/tiff":               ".tiff",
+	"text/html":                ".html",
+	"text/javascript":          ".js",
+	"text/markdown":            ".md",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+	"video/mpeg":               ".mpeg",
+}
@@ -0,0 +1,28 @@
+package gjson_template
+
+import "testing"
+
+// JSON data for the MIME type builtins
+var mimeFuncsTestJSON = []byte(`{
+	"file": {"name": "Report.PDF", "type": "image/jpeg"},
+	"html": "text/html; charset=utf-8"
+}`)
+
+var mimeFuncsTests = []gjsonExecTest{
+	{"mimeByExt", `{{mimeByExt ".png"}}`, "image/png", mimeFuncsTestJSON, true},
+	{"mimeByExt no dot", `{{mimeByExt "json"}}`, "application/json", mimeFuncsTestJSON, true},
+	{"mimeByExt file name", `{{mimeByExt .file.name}}`, "application/pdf", mimeFuncsTestJSON, true},
+	{"mimeByExt charset", `{{mimeByExt "index.html"}}`, "text/html; charset=utf-8", mimeFuncsTestJSON, true},
+	{"mimeByExt unknown", `{{mimeByExt ".nosuchext" | default "application/octet-stream"}}`, "application/octet-stream", mimeFuncsTestJSON, true},
+	{"mimeByExt missing", `{{mimeByExt .nope}}`, "", mimeFuncsTestJSON, true},
+	{"extByMime", `{{extByMime "image/png"}}`, ".png", mimeFuncsTestJSON, true},
+	{"extByMime preferred", `{{extByMime .file.type}}`, ".jpg", mimeFuncsTestJSON, true},
+	{"extByMime params", `{{extByMime .html}}`, ".html", mimeFuncsTestJSON, true},
+	{"extByMime unknown", `{{extByMime "application/x-nosuchtype"}}`, "", mimeFuncsTestJSON, true},
+	{"extByMime missing", `{{extByMime .nope}}`, "", mimeFuncsTestJSON, true},
+	{"extByMime invalid", `{{extByMime "not a type"}}`, "", mimeFuncsTestJSON, false},
+}
+
+func TestMimeFuncs(t *testing.T) {
+	testGjsonExecute(t, mimeFuncsTests)
+}
@@ -0,0 +1,42 @@
+// This file contains the execution of templates with several JSON
+// documents.
+
+package gjson_template
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/tidwall/gjson"
+)
+
+// ExecuteMulti applies the template to several JSON documents at once,
+// each exposed under its key in docs, and writes the output to wr. For
+// example, with the documents "request", "response" and "config", the
+// template can read {{.request.headers.host}} and {{.config.timeout}}
+// without the documents being merged beforehand. Each document must be
+// valid JSON, and may be any JSON value.
+func (t *Template) ExecuteMulti(wr io.Writer, docs map[string][]byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range slices.Sorted(maps.Keys(docs)) {
+		doc := docs[key]
+		if t.option.jsonc {
+			doc = stripJSONC(doc)
+		}
+		if !gjson.ValidBytes(doc) {
+			return fmt.Errorf("template: %s: document %q is not valid JSON", t.Name(), key)
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(jsonString(key))
+		buf.WriteByte(':')
+		buf.Write(doc)
+	}
+	buf.WriteByte('}')
+	return t.execute(wr, buf.Bytes(), execOptions{})
+}
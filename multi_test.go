@@ -0,0 +1,40 @@
+package gjson_template
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExecuteMulti(t *testing.T) {
+	tmpl := Must(New("route").Parse(`{{.request.headers.host}} {{.config.timeout}} {{.status}} {{len .ids}} {{range $k, $v := .}}{{$k}},{{end}}`))
+	docs := map[string][]byte{
+		"request": []byte(`{"headers": {"host": "example.com"}}`),
+		"config":  []byte(`{"timeout": "5s"}`),
+		"status":  []byte(`200`),
+		"ids":     []byte(` [1, 2, 3] `),
+	}
+	var buf strings.Builder
+	if err := tmpl.ExecuteMulti(&buf, docs); err != nil {
+		t.Fatal(err)
+	}
+	if want := "example.com 5s 200 3 config,ids,request,status,"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := Must(New("empty").Parse(`{{len .}}`)).ExecuteMulti(&buf, nil); err != nil || buf.String() != "0" {
+		t.Errorf("no documents: got %q, %v", buf.String(), err)
+	}
+
+	docs["config"] = []byte(`{"timeout": `)
+	if err := tmpl.ExecuteMulti(io.Discard, docs); err == nil || !strings.Contains(err.Error(), `document "config" is not valid JSON`) {
+		t.Errorf("expected an invalid document error; got %v", err)
+	}
+
+	docs["config"] = []byte(`{"timeout": "1s", /* c */}`)
+	buf.Reset()
+	if err := tmpl.Option("jsonc").ExecuteMulti(&buf, docs); err != nil || !strings.Contains(buf.String(), " 1s ") {
+		t.Errorf("jsonc: got %q, %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,73 @@
+// This file contains the observation of executions, for metrics.
+
+package gjson_template
+
+import (
+	"io"
+	"time"
+)
+
+// ExecStats describes a finished execution of a template, or, given to a
+// Tracer, a finished {{template}} invocation within one.
+type ExecStats struct {
+	Template string        // Name of the executed template.
+	Duration time.Duration // Time taken by the execution, output filters included.
+	Bytes    int           // Size of the output, or of the document of ExecuteJSON.
+	Err      error         // Error of the execution, or nil.
+
+	// DataBytes is the size of the JSON data, or of the value passed to
+	// a template invoked by {{template}}.
+	DataBytes int
+}
+
+// An ExecObserver is notified of the executions of templates, for example
+// to collect metrics. ObserveExec may be called concurrently by
+// executions in different goroutines.
+type ExecObserver interface {
+	ObserveExec(ExecStats)
+}
+
+// SetObserver sets the observer notified of the executions of the template
+// and the templates associated with it, or removes it if o is nil. Each
+// call of an Execute method is one execution; a Pipeline executes each of
+// its stages. The observer must be set before the templates are executed.
+// The return value is the template, so calls can be chained.
+func (t *Template) SetObserver(o ExecObserver) *Template {
+	t.init()
+	t.observer = o
+	return t
+}
+
+// observe executes t as execute does and reports the execution to the
+// observer.
+func (t *Template) observe(wr io.Writer, data []byte, x execOptions) error {
+	x.seen = true
+	var n func() int
+	switch {
+	case x.doc != nil:
+		n = func() int { return len(*x.doc) }
+	case x.smap != nil:
+		// The source map counts the output, and requires wr to be its
+		// writer.
+		start := x.smap.w.n
+		n = func() int { return x.smap.w.n - start }
+	default:
+		cw := &countingWriter{w: wr}
+		wr = cw
+		n = func() int { return cw.n }
+	}
+	dataBytes := len(data)
+	if x.dot != nil {
+		dataBytes = len(x.dot.Raw)
+	}
+	start := time.Now()
+	err := t.execute(wr, data, x)
+	t.observer.ObserveExec(ExecStats{
+		Template:  t.Name(),
+		Duration:  time.Since(start),
+		Bytes:     n(),
+		DataBytes: dataBytes,
+		Err:       err,
+	})
+	return err
+}
@@ -0,0 +1,66 @@
+package gjson_template
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// statsRecorder records the executions it observes.
+type statsRecorder struct {
+	mu    sync.Mutex
+	stats []ExecStats
+}
+
+func (r *statsRecorder) ObserveExec(s ExecStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = append(r.stats, s)
+}
+
+func TestSetObserver(t *testing.T) {
+	r := &statsRecorder{}
+	tmpl := Must(New("root").SetObserver(r).Parse(`{{define "sub"}}[{{.a}}]{{end}}a={{.a}}{{template "sub" .}}`))
+	data := []byte(`{"a":"xyz"}`)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.ExecuteTemplate(&buf, "sub", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Execute(&buf, []byte(`not json`)); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := tmpl.ExecuteJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.ExecuteSourceMap(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	filtered := Must(tmpl.Clone()).AddOutputFilter(TrimTrailingSpace)
+	if err := filtered.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name  string
+		bytes int
+		err   bool
+	}{
+		{"root", 10, false},
+		{"sub", 5, false},
+		{"root", 0, true},
+		{"root", 2, false}, // the "{}" document
+		{"root", 10, false},
+		{"root", 10, false},
+	}
+	if len(r.stats) != len(want) {
+		t.Fatalf("expected %d executions; got %d: %+v", len(want), len(r.stats), r.stats)
+	}
+	for i, w := range want {
+		s := r.stats[i]
+		if s.Template != w.name || s.Bytes != w.bytes || (s.Err != nil) != w.err || s.Duration <= 0 {
+			t.Errorf("execution %d: expected %+v; got %+v", i, w, s)
+		}
+	}
+}
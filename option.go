@@ -6,7 +6,16 @@
 
 package gjson_template
 
-import "strings"
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/gjson_template/parse"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
 
 // missingKeyAction defines how to respond to indexing a map with a key that is not present.
 type missingKeyAction int
@@ -18,7 +27,45 @@ const (
 )
 
 type option struct {
-	missingKey missingKeyAction
+	missingKey    missingKeyAction
+	seeded        bool              // whether seed is set
+	seed          uint64            // seed for the random builtins
+	trimBlocks    bool              // remove the first newline after a block action
+	lstripBlocks  bool              // remove the indentation before a block action
+	lang          string            // language of the messages of the t builtin
+	color         string            // "always", "never", or "" to honor NO_COLOR
+	floatFmt      *floatFormat      // formatting of non-integer numbers, or nil
+	postprocess   string            // "minify", "pretty", or "" for none
+	encoding      encoding.Encoding // encoding of the output, or nil for UTF-8
+	safeNav       bool              // missing intermediate values are not errors
+	lateFuncs     bool              // do not check that functions are defined when parsing
+	strictVars    bool              // reject redeclared and undeclared variables when parsing
+	maxDepth      int               // maximum depth of template invocations, or 0 for maxExecDepth
+	maxRange      int               // maximum iterations of one range loop, or 0 for no limit
+	maxIterations int               // maximum iterations of all range loops of an execution, or 0
+	noValue       string            // printed for missing values
+	null          *string           // printed for null, or nil for "null"
+	strictJSON    bool              // reject data that is not valid JSON
+	jsonc         bool              // strip comments and trailing commas from the data
+	frontMatter   bool              // read the front matter of template files
+}
+
+// parseMode returns the parser mode implementing the options.
+func (o *option) parseMode() parse.Mode {
+	var mode parse.Mode
+	if o.trimBlocks {
+		mode |= parse.TrimBlocks
+	}
+	if o.lstripBlocks {
+		mode |= parse.LstripBlocks
+	}
+	if o.lateFuncs {
+		mode |= parse.SkipFuncCheck
+	}
+	if o.strictVars {
+		mode |= parse.StrictVars
+	}
+	return mode
 }
 
 // Option sets options for the template. Options are described by
@@ -33,12 +80,181 @@ type option struct {
 //
 //	"missingkey=default" or "missingkey=invalid"
 //		The default behavior: Do nothing and continue execution.
-//		If printed, the result of the index operation is the string
-//		"<no value>".
+//		If printed, the result of the index operation is empty, or
+//		the text of the novalue option.
 //	"missingkey=zero"
 //		The operation returns the zero value for the map type's element.
 //	"missingkey=error"
 //		Execution stops immediately with an error.
+//
+// novalue: Make missing values visible in the output, so that missing
+// data stands out during development.
+//
+//	"novalue"
+//		Missing values print as "<no value>", as in text/template.
+//	"novalue=<text>"
+//		Missing values print as text. "novalue=" restores the default,
+//		which prints nothing.
+//
+// strictjson: Check that the data given to the Execute methods is valid
+// JSON before executing. Without it, only the start of the data is
+// checked, so data with malformed or trailing content may render wrong
+// values. Invalid data fails with an error giving the byte offset of the
+// problem.
+//
+//	"strictjson"
+//
+// jsonc: Accept data with the comments and trailing commas of JSONC and
+// JSON5, as in human-edited configuration, by replacing them with spaces
+// before the data is parsed. Comments are // to the end of the line and
+// /* to */. Byte offsets in errors, such as those of strictjson, are those
+// of the original data.
+//
+//	"jsonc"
+//
+// frontmatter: Make ParseFiles, ParseGlob, ParseFS and ParseReader read
+// the front matter at the top of each file (see [Metadata]). Without it,
+// files starting with a line of three dashes, such as YAML documents, are
+// parsed as they are.
+//
+//	"frontmatter"
+//
+// null: Control how JSON null values are printed, since HTML and YAML
+// outputs expect different things.
+//
+//	"null=<text>"
+//		Null values print as text. "null=" prints nothing, and
+//		"null=null" restores the default, which prints "null".
+//		Nulls within printed objects and arrays are unchanged.
+//
+// safenav: With missingkey=error, let a field chain such as .a.b.c yield
+// a missing value instead of an error when an intermediate value, here
+// .a or .a.b, is missing or null. A key missing from a value that exists
+// is still an error, so strict templates can mark whole subtrees as
+// optional without wrapping them in {{if}} blocks.
+//
+//	"safenav"
+//
+// maxdepth: Limit how deeply templates and macros may invoke each other.
+//
+//	"maxdepth=<n>"
+//		Execution stops with an error when an invocation would exceed a
+//		depth of n, a positive integer, or the default if n is 0. The
+//		default is 100000, or 1000 on wasm. A lower limit bounds the
+//		work of untrusted templates; a higher one suits templates that
+//		recursively render deep trees, within the limits of the
+//		goroutine stack. [Template.MaxDepth] sets the same limit.
+//
+// maxrange and maxiterations: Limit the iterations of range loops, so that
+// data with huge arrays or objects cannot make an execution run for long.
+//
+//	"maxrange=<n>"
+//		Execution stops with an error when one range loop would run
+//		more than n times.
+//	"maxiterations=<n>"
+//		Execution stops with an error when the range loops of the
+//		execution, including those of the templates it invokes, would
+//		run more than n times in total.
+//
+// The limits are positive integers. By default there is no limit.
+//
+// seed: Make the random builtins shuffle and sample deterministic.
+//
+//	"seed=<n>"
+//		Each execution draws from a random source seeded with the
+//		unsigned integer n, so executing the template twice on the
+//		same data produces the same output. By default the source is
+//		seeded randomly.
+//
+// trimblocks and lstripblocks: Control the white space around block
+// actions, with the semantics of the Jinja options of the same names. A
+// block action is a comment, an action starting with a keyword such as
+// {{if}}, {{range}}, {{else}} or {{end}}, or a variable declaration or
+// assignment. These options affect parsing, so they must be set before
+// Parse is called.
+//
+//	"trimblocks"
+//		The first newline after a block action is removed.
+//	"lstripblocks"
+//		Spaces and tabs from the start of a line to a block action are
+//		removed.
+//
+// Together they let block actions sit on lines of their own, indented
+// with the surrounding text, without leaving blank lines in the output.
+//
+// latefuncs: Let Parse accept calls of functions that are not defined yet,
+// for functions added with [Template.Funcs] after parsing. Call
+// [Template.Check] once they are added to find calls of functions that are
+// still undefined; otherwise these are reported only when executed.
+//
+//	"latefuncs"
+//
+// strictvars: Make Parse reject uses of variables that are likely
+// mistakes: declaring with := a variable that is already declared, which
+// would shadow $ or a loop variable, and assigning with = to a variable
+// that is not declared, or reading in an {{else}} branch a variable
+// declared in the branch before it, which is never set there. Reading a
+// variable after the end of its block is an error in any case, but the
+// message then says so. Chained {{else if}} and {{else with}} actions may
+// declare the variables of the condition before them again, as in
+// {{with $x := .a}}...{{else with $x := .b}}, which ends their scope.
+//
+//	"strictvars"
+//
+// floatfmt: Control how numbers with a fraction or an exponent are
+// printed, and how the float results of functions such as add or div are
+// written. By default numbers from the data are printed as they appear
+// there, and float results have six decimals.
+//
+//	"floatfmt=prec:2,trim,sci:6"
+//		A comma-separated list of settings. "prec:N" writes N digits
+//		after the decimal point; without it, as many as needed are
+//		written. "trim" removes trailing zeros after the decimal point.
+//		"sci:N" uses scientific notation for numbers of magnitude at
+//		least 1eN or less than 1e-N.
+//
+// postprocess: Reformat the output of templates producing JSON, removing
+// the white space left by actions such as {{if}} and {{range}}. The output
+// must be valid JSON, or execution fails with an error and nothing is
+// written. Output filters (see [Template.AddOutputFilter]) run first.
+//
+//	"postprocess=minify"
+//		All insignificant white space is removed.
+//	"postprocess=pretty"
+//		The output is indented with two spaces, one member or element
+//		per line.
+//	"postprocess=none"
+//		The default: the output is written as is.
+//
+// encoding: Encode the output in a character set other than UTF-8, for
+// downstream systems that do not accept UTF-8. The output is buffered and
+// encoded after the output filters and the postprocess option; if it
+// contains a character the character set cannot represent, execution fails
+// with an error and nothing is written. The document built by ExecuteJSON
+// is not encoded.
+//
+//	"encoding=<name>"
+//		The IANA name or alias of the character set, such as "GBK",
+//		"Shift_JIS" or "ISO-8859-1". "encoding=UTF-8" restores the
+//		default.
+//
+// color: Control the terminal styling of the color, bold and style
+// builtins.
+//
+//	"color=auto"
+//		The default behavior: Styles are applied unless the NO_COLOR
+//		environment variable is set to a non-empty value.
+//	"color=always"
+//		Styles are always applied.
+//	"color=never"
+//		Styles are never applied; the builtins return the text as is.
+//
+// lang: Select the language of the t builtin.
+//
+//	"lang=<tag>"
+//		Messages are looked up in the catalog of the BCP 47 language
+//		tag, such as "en" or "zh-CN"; see [Template.SetCatalog].
+//		ExecOptions.Lang selects another language for one execution.
 func (t *Template) Option(opt ...string) *Template {
 	t.init()
 	for _, s := range opt {
@@ -47,6 +263,66 @@ func (t *Template) Option(opt ...string) *Template {
 	return t
 }
 
+// MaxDepth limits how deeply templates and macros may invoke each other
+// when t and the templates associated with it are executed. It is
+// equivalent to Option("maxdepth=n"): a limit of 0 restores the default,
+// which depends on the platform, and a negative limit panics. Embedders
+// with small stacks, such as wasm plugins, can lower it, and servers
+// rendering deep trees can raise it. ExecOptions.MaxDepth overrides it for
+// one execution. The return value is the template, so calls can be
+// chained.
+func (t *Template) MaxDepth(n int) *Template {
+	return t.Option("maxdepth=" + strconv.Itoa(n))
+}
+
+// A floatFormat controls how non-integer numbers are written.
+type floatFormat struct {
+	prec int  // digits after the decimal point, or -1 for as many as needed
+	trim bool // remove trailing zeros after the decimal point
+	sci  int  // use scientific notation for decimal exponents >= sci or < -sci; 0 for never
+}
+
+// parseFloatFormat parses the value of the floatfmt option, a
+// comma-separated list of "prec:N", "trim", and "sci:N".
+func parseFloatFormat(value string) (*floatFormat, bool) {
+	f := &floatFormat{prec: -1}
+	for _, item := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(item), ":")
+		n, err := strconv.Atoi(arg)
+		switch {
+		case name == "prec" && err == nil && n >= 0:
+			f.prec = n
+		case name == "sci" && err == nil && n > 0:
+			f.sci = n
+		case name == "trim" && arg == "":
+			f.trim = true
+		default:
+			return nil, false
+		}
+	}
+	return f, true
+}
+
+// format returns x written according to f.
+func (f *floatFormat) format(x float64) string {
+	verb := byte('f')
+	if f.sci > 0 && x != 0 && !math.IsInf(x, 0) && !math.IsNaN(x) {
+		if exp := int(math.Floor(math.Log10(math.Abs(x)))); exp >= f.sci || exp < -f.sci {
+			verb = 'e'
+		}
+	}
+	s := strconv.FormatFloat(x, verb, f.prec, 64)
+	if f.trim && f.prec > 0 {
+		mant, exp, _ := strings.Cut(s, "e")
+		mant = strings.TrimSuffix(strings.TrimRight(mant, "0"), ".")
+		if exp != "" {
+			return mant + "e" + exp
+		}
+		return mant
+	}
+	return s
+}
+
 func (t *Template) setOption(opt string) {
 	if opt == "" {
 		panic("empty option string")
@@ -66,7 +342,103 @@ func (t *Template) setOption(opt string) {
 				t.option.missingKey = mapError
 				return
 			}
+		case "color":
+			switch value {
+			case "always", "never":
+				t.option.color = value
+				return
+			case "auto":
+				t.option.color = ""
+				return
+			}
+		case "lang":
+			if value != "" {
+				t.option.lang = value
+				return
+			}
+		case "floatfmt":
+			if f, ok := parseFloatFormat(value); ok {
+				t.option.floatFmt = f
+				return
+			}
+		case "postprocess":
+			switch value {
+			case "minify", "pretty":
+				t.option.postprocess = value
+				return
+			case "none":
+				t.option.postprocess = ""
+				return
+			}
+		case "encoding":
+			if e, err := ianaindex.IANA.Encoding(value); err == nil && e != nil {
+				if e == unicode.UTF8 {
+					e = nil
+				}
+				t.option.encoding = e
+				return
+			}
+		case "maxdepth":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				t.option.maxDepth = n
+				return
+			}
+		case "maxrange":
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				t.option.maxRange = n
+				return
+			}
+		case "maxiterations":
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				t.option.maxIterations = n
+				return
+			}
+		case "novalue":
+			t.option.noValue = value
+			return
+		case "null":
+			if value == "null" {
+				t.option.null = nil
+			} else {
+				t.option.null = &value
+			}
+			return
+		case "seed":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				t.option.seed = n
+				t.option.seeded = true
+				return
+			}
 		}
 	}
+	switch opt {
+	case "trimblocks":
+		t.option.trimBlocks = true
+		return
+	case "lstripblocks":
+		t.option.lstripBlocks = true
+		return
+	case "safenav":
+		t.option.safeNav = true
+		return
+	case "latefuncs":
+		t.option.lateFuncs = true
+		return
+	case "strictvars":
+		t.option.strictVars = true
+		return
+	case "novalue":
+		t.option.noValue = "<no value>"
+		return
+	case "strictjson":
+		t.option.strictJSON = true
+		return
+	case "jsonc":
+		t.option.jsonc = true
+		return
+	case "frontmatter":
+		t.option.frontMatter = true
+		return
+	}
 	panic("unrecognized option: " + opt)
 }
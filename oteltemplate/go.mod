@@ -0,0 +1,11 @@
+module github.com/higress-group/gjson_template/oteltemplate
+
+go 1.24.1
+
+require (
+	github.com/higress-group/gjson_template v0.0.0
+	go.opentelemetry.io/otel v1.38.0
+	go.opentelemetry.io/otel/trace v1.38.0
+)
+
+replace github.com/higress-group/gjson_template => ../
@@ -0,0 +1,72 @@
+// Package oteltemplate traces template executions with OpenTelemetry. A
+// Tracer starts a span for each execution of the templates it is set on,
+// and for each of their {{template}} invocations, as a child of the span
+// in the context given to ExecuteContext:
+//
+//	tmpl := template.Must(template.New("route").
+//		SetTracer(oteltemplate.NewTracer(nil)).
+//		Parse(text))
+//	err := tmpl.ExecuteContext(ctx, w, data)
+//
+// It is a module of its own, so that importing the template package does
+// not require OpenTelemetry.
+package oteltemplate
+
+import (
+	"context"
+
+	template "github.com/higress-group/gjson_template"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The attributes of the spans.
+const (
+	TemplateKey  = attribute.Key("template.name")
+	InvokedKey   = attribute.Key("template.invoked")
+	BytesKey     = attribute.Key("template.output_bytes")
+	DataBytesKey = attribute.Key("template.data_bytes")
+)
+
+// A Tracer is a template.Tracer starting OpenTelemetry spans named
+// "template.execute" for executions and "template.invoke" for {{template}}
+// invocations. The spans have the attributes template.name,
+// template.invoked, template.output_bytes and template.data_bytes, and
+// record the error the execution or invocation stopped with, if any.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer starting spans with the tracer provider tp,
+// or the global one if tp is nil.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: tp.Tracer("github.com/higress-group/gjson_template")}
+}
+
+// StartSpan implements template.Tracer.
+func (t *Tracer) StartSpan(ctx context.Context, name string, invoked bool) (context.Context, func(template.ExecStats)) {
+	spanName := "template.execute"
+	if invoked {
+		spanName = "template.invoke"
+	}
+	ctx, span := t.tracer.Start(ctx, spanName, trace.WithAttributes(
+		TemplateKey.String(name),
+		InvokedKey.Bool(invoked),
+	))
+	return ctx, func(stats template.ExecStats) {
+		span.SetAttributes(
+			BytesKey.Int(stats.Bytes),
+			DataBytesKey.Int(stats.DataBytes),
+		)
+		if stats.Err != nil {
+			span.RecordError(stats.Err)
+			span.SetStatus(codes.Error, stats.Err.Error())
+		}
+		span.End()
+	}
+}
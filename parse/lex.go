@@ -6,6 +6,7 @@ package parse
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -63,15 +64,22 @@ const (
 	itemKeyword  // used only to delimit the keywords
 	itemBlock    // block keyword
 	itemBreak    // break keyword
+	itemCapture  // capture keyword
+	itemCatch    // catch keyword
 	itemContinue // continue keyword
 	itemDot      // the cursor, spelled '.'
 	itemDefine   // define keyword
+	itemDelims   // delims keyword
 	itemElse     // else keyword
 	itemEnd      // end keyword
+	itemFor      // for keyword
 	itemIf       // if keyword
+	itemMacro    // macro keyword
 	itemNil      // the untyped nil constant, easiest to treat as a keyword
 	itemRange    // range keyword
 	itemTemplate // template keyword
+	itemTry      // try keyword
+	itemWhile    // while keyword
 	itemWith     // with keyword
 )
 
@@ -79,17 +87,29 @@ var key = map[string]itemType{
 	".":        itemDot,
 	"block":    itemBlock,
 	"break":    itemBreak,
+	"capture":  itemCapture,
+	"catch":    itemCatch,
 	"continue": itemContinue,
 	"define":   itemDefine,
+	"delims":   itemDelims,
 	"else":     itemElse,
 	"end":      itemEnd,
+	"for":      itemFor,
 	"if":       itemIf,
+	"macro":    itemMacro,
 	"range":    itemRange,
 	"nil":      itemNil,
 	"template": itemTemplate,
+	"try":      itemTry,
+	"while":    itemWhile,
 	"with":     itemWith,
 }
 
+// funcKeys are the keywords that are not keywords in templates parsed with
+// a function of the same name, so that templates written for functions
+// named like them keep working.
+var funcKeys = []string{"break", "capture", "catch", "continue", "delims", "for", "macro", "try", "while"}
+
 const eof = -1
 
 // Trimming spaces.
@@ -123,14 +143,16 @@ type lexer struct {
 	startLine    int    // start line of this item
 	item         item   // item to return to parser
 	insideAction bool   // are we inside an action?
+	blockAction  bool   // is the current action a block action to be trimmed?
 	options      lexOptions
 }
 
 // lexOptions control behavior of the lexer. All default to false.
 type lexOptions struct {
-	emitComment bool // emit itemComment tokens.
-	breakOK     bool // break keyword allowed
-	continueOK  bool // continue keyword allowed
+	emitComment  bool            // emit itemComment tokens.
+	funcKeys     map[string]bool // keywords lexed as identifiers, as functions have their names
+	trimBlocks   bool            // remove the first newline after a block action
+	lstripBlocks bool            // remove the indentation before a block action
 }
 
 // next returns the next rune in the input.
@@ -276,6 +298,8 @@ func lexText(l *lexer) stateFn {
 			delimEnd := l.pos + Pos(len(l.leftDelim))
 			if hasLeftTrimMarker(l.input[delimEnd:]) {
 				trimLength = rightTrimLength(l.input[l.start:l.pos])
+			} else if l.options.lstripBlocks && l.isBlockAction(delimEnd) {
+				trimLength = l.indentLength()
 			}
 			l.pos -= trimLength
 			l.line += strings.Count(l.input[l.start:l.pos], "\n")
@@ -313,6 +337,62 @@ func (l *lexer) atRightDelim() (delim, trimSpaces bool) {
 	return false, false
 }
 
+// indentLength returns the length of the spaces and tabs between the start of
+// the line and the current position, or 0 if there is other text before it.
+func (l *lexer) indentLength() Pos {
+	text := l.input[l.start:l.pos]
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		text = text[i+1:]
+	} else if l.start > 0 && l.input[l.start-1] != '\n' {
+		return 0
+	}
+	if strings.Trim(text, " \t") != "" {
+		return 0
+	}
+	return Pos(len(text))
+}
+
+// isBlockAction reports whether the action whose text starts at pos, just
+// after the left delimiter, is a block action for the trimblocks and
+// lstripblocks modes: a comment, a keyword action such as {{if}} or {{end}},
+// or a variable declaration or assignment.
+func (l *lexer) isBlockAction(pos Pos) bool {
+	s := l.input[pos:]
+	if hasLeftTrimMarker(s) {
+		s = s[trimMarkerLen:]
+	}
+	s = strings.TrimLeft(s, spaceChars)
+	if strings.HasPrefix(s, leftComment) {
+		return true
+	}
+	if strings.HasPrefix(s, "$") {
+		s = strings.TrimLeft(s[1:], "_0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		s = strings.TrimLeft(s, " \t")
+		return strings.HasPrefix(s, ":=") || strings.HasPrefix(s, "=")
+	}
+	n := strings.IndexFunc(s, func(r rune) bool { return !isAlphaNumeric(r) })
+	if n < 0 {
+		n = len(s)
+	}
+	item := key[s[:n]]
+	return item > itemKeyword && item != itemDot && item != itemNil
+}
+
+// trimBlockNewline skips the newline following the right delimiter of a
+// block action in trimblocks mode.
+func (l *lexer) trimBlockNewline() {
+	if !l.blockAction {
+		return
+	}
+	l.blockAction = false
+	if strings.HasPrefix(l.input[l.pos:], "\r\n") {
+		l.pos += 2
+	} else if strings.HasPrefix(l.input[l.pos:], "\n") {
+		l.pos++
+	}
+	l.ignore()
+}
+
 // leftTrimLength returns the length of the spaces at the beginning of the string.
 func leftTrimLength(s string) Pos {
 	return Pos(len(s) - len(strings.TrimLeft(s, spaceChars)))
@@ -322,6 +402,7 @@ func leftTrimLength(s string) Pos {
 // (The text to be trimmed has already been emitted.)
 func lexLeftDelim(l *lexer) stateFn {
 	l.pos += Pos(len(l.leftDelim))
+	l.blockAction = l.options.trimBlocks && l.isBlockAction(l.pos)
 	trimSpace := hasLeftTrimMarker(l.input[l.pos:])
 	afterMarker := Pos(0)
 	if trimSpace {
@@ -362,6 +443,7 @@ func lexComment(l *lexer) stateFn {
 		l.pos += leftTrimLength(l.input[l.pos:])
 	}
 	l.ignore()
+	l.trimBlockNewline()
 	if l.options.emitComment {
 		return l.emitItem(i)
 	}
@@ -381,6 +463,7 @@ func lexRightDelim(l *lexer) stateFn {
 		l.pos += leftTrimLength(l.input[l.pos:])
 		l.ignore()
 	}
+	l.trimBlockNewline()
 	l.insideAction = false
 	return l.emitItem(i)
 }
@@ -422,9 +505,11 @@ func lexInsideAction(l *lexer) stateFn {
 		return lexChar
 	case r == '.':
 		// special look-ahead for ".field" so we don't break l.backup().
+		// A digit after the dot starts a number, unless the dot continues
+		// a chain such as .skills.0, in which case it is an array index.
 		if l.pos < Pos(len(l.input)) {
 			r := l.input[l.pos]
-			if r < '0' || '9' < r {
+			if r < '0' || '9' < r || l.inChain() {
 				return lexField
 			}
 		}
@@ -491,7 +576,7 @@ func lexIdentifier(l *lexer) stateFn {
 			switch {
 			case key[word] > itemKeyword:
 				item := key[word]
-				if item == itemBreak && !l.options.breakOK || item == itemContinue && !l.options.continueOK {
+				if l.options.funcKeys[word] {
 					return l.emit(itemIdentifier)
 				}
 				return l.emit(item)
@@ -531,6 +616,13 @@ func lexFieldOrVariable(l *lexer, typ itemType) stateFn {
 		return l.emit(itemDot)
 	}
 	var r rune
+	if typ == itemField && l.peek() == '-' {
+		// A negative index such as .items.-1 counts from the end.
+		l.next()
+		if r = l.peek(); r < '0' || '9' < r {
+			return l.errorf("bad character %#U", '-')
+		}
+	}
 	for {
 		r = l.next()
 		if !isAlphaNumeric(r) {
@@ -538,12 +630,63 @@ func lexFieldOrVariable(l *lexer, typ itemType) stateFn {
 			break
 		}
 	}
+	for l.peek() == '[' {
+		if !l.scanIndex() {
+			return l.errorf("bad index in %s", l.input[l.start:l.pos])
+		}
+		r = l.peek()
+	}
 	if !l.atTerminator() {
 		return l.errorf("bad character %#U", r)
 	}
 	return l.emit(typ)
 }
 
+// scanIndex scans a bracketed index following a field or variable name:
+// an integer such as [0] or [-1], or a quoted key such as ["a.b"].
+func (l *lexer) scanIndex() bool {
+	l.next() // '['
+	switch r := l.next(); {
+	case r == '"' || r == '`':
+		start := l.pos - 1
+		for {
+			c := l.next()
+			if c == '\\' && r == '"' {
+				c = l.next()
+			} else if c == r {
+				break
+			}
+			if c == eof || c == '\n' && r == '"' {
+				return false
+			}
+		}
+		if _, err := strconv.Unquote(l.input[start:l.pos]); err != nil {
+			return false
+		}
+	case r == '-' || '0' <= r && r <= '9':
+		if r == '-' && !unicode.IsDigit(l.peek()) {
+			return false
+		}
+		for '0' <= l.peek() && l.peek() <= '9' {
+			l.next()
+		}
+	default:
+		return false
+	}
+	return l.next() == ']'
+}
+
+// inChain reports whether the '.' just scanned directly follows a field,
+// variable, identifier or parenthesized expression, so that a numeric
+// segment after it is an index in the chain rather than a number.
+func (l *lexer) inChain() bool {
+	if l.start == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(l.input[:l.start])
+	return r == ')' || r == ']' || r == '$' || isAlphaNumeric(r)
+}
+
 // atTerminator reports whether the input is at valid termination character to
 // appear after an identifier. Breaks .X.Y into two pieces. Also catches cases
 // like "$x+2" not being acceptable without a space, in case we decide one
@@ -554,7 +697,7 @@ func (l *lexer) atTerminator() bool {
 		return true
 	}
 	switch r {
-	case eof, '.', ',', '|', ':', ')', '(':
+	case eof, '.', ',', '|', ':', '=', ')', '(':
 		return true
 	}
 	return strings.HasPrefix(l.input[l.pos:], l.rightDelim)
@@ -73,6 +73,11 @@ const (
 	NodeComment                    // A comment.
 	NodeBreak                      // A break action.
 	NodeContinue                   // A continue action.
+	NodeCapture                    // A capture action.
+	nodeCatch                      // A catch action. Not added to tree.
+	NodeTry                        // A try action.
+	NodeFor                        // A for action.
+	NodeWhile                      // A while action.
 )
 
 // Nodes.
@@ -397,7 +402,7 @@ type VariableNode struct {
 }
 
 func (t *Tree) newVariable(pos Pos, ident string) *VariableNode {
-	return &VariableNode{tr: t, NodeType: NodeVariable, Pos: pos, Ident: strings.Split(ident, ".")}
+	return &VariableNode{tr: t, NodeType: NodeVariable, Pos: pos, Ident: splitIdent(ident)}
 }
 
 func (v *VariableNode) String() string {
@@ -407,11 +412,9 @@ func (v *VariableNode) String() string {
 }
 
 func (v *VariableNode) writeTo(sb *strings.Builder) {
-	for i, id := range v.Ident {
-		if i > 0 {
-			sb.WriteByte('.')
-		}
-		sb.WriteString(id)
+	sb.WriteString(v.Ident[0])
+	for _, id := range v.Ident[1:] {
+		writeSegment(sb, id, false)
 	}
 }
 
@@ -502,7 +505,7 @@ type FieldNode struct {
 }
 
 func (t *Tree) newField(pos Pos, ident string) *FieldNode {
-	return &FieldNode{tr: t, NodeType: NodeField, Pos: pos, Ident: strings.Split(ident[1:], ".")} // [1:] to drop leading period
+	return &FieldNode{tr: t, NodeType: NodeField, Pos: pos, Ident: splitIdent(ident[1:])} // [1:] to drop leading period
 }
 
 func (f *FieldNode) String() string {
@@ -512,9 +515,8 @@ func (f *FieldNode) String() string {
 }
 
 func (f *FieldNode) writeTo(sb *strings.Builder) {
-	for _, id := range f.Ident {
-		sb.WriteByte('.')
-		sb.WriteString(id)
+	for i, id := range f.Ident {
+		writeSegment(sb, id, i == 0)
 	}
 }
 
@@ -550,7 +552,7 @@ func (c *ChainNode) Add(field string) {
 	if field == "" {
 		panic("empty field")
 	}
-	c.Field = append(c.Field, field)
+	c.Field = append(c.Field, splitIdent(field)...)
 }
 
 func (c *ChainNode) String() string {
@@ -567,10 +569,109 @@ func (c *ChainNode) writeTo(sb *strings.Builder) {
 	} else {
 		c.Node.writeTo(sb)
 	}
-	for _, field := range c.Field {
+	for i, field := range c.Field {
+		writeSegment(sb, field, i == 0)
+	}
+}
+
+// splitIdent splits a field or variable name such as a.b["c.d"][0] into its
+// segments. Bracketed keys become a single segment, unquoted if quoted.
+func splitIdent(ident string) []string {
+	var segs []string
+	for {
+		if strings.HasPrefix(ident, "[") {
+			end := indexEnd(ident)
+			key := ident[1:end]
+			if key != "" && (key[0] == '"' || key[0] == '`') {
+				key, _ = strconv.Unquote(key)
+			}
+			segs = append(segs, key)
+			ident = ident[end+1:]
+		} else {
+			i := strings.IndexAny(ident, ".[")
+			if i < 0 {
+				i = len(ident)
+			}
+			segs = append(segs, ident[:i])
+			ident = ident[i:]
+		}
+		ident = strings.TrimPrefix(ident, ".")
+		if ident == "" {
+			return segs
+		}
+	}
+}
+
+// indexEnd returns the position of the ']' closing the bracketed index that
+// s starts with. The lexer has already checked that the index is well formed.
+func indexEnd(s string) int {
+	i := 1
+	switch s[i] {
+	case '"':
+		for i++; s[i] != '"'; i++ {
+			if s[i] == '\\' {
+				i++
+			}
+		}
+		i++
+	case '`':
+		i += 2 + strings.IndexByte(s[2:], '`')
+	}
+	return i + strings.IndexByte(s[i:], ']')
+}
+
+// writeSegment writes one segment of a field chain. Segments that are not
+// plain identifiers or indexes are written in bracket form. If first is set,
+// the segment starts the chain, so a digit cannot follow the period directly
+// and a bracket needs a period before it.
+func writeSegment(sb *strings.Builder, seg string, first bool) {
+	switch {
+	case isIndexSegment(seg) && (!first || seg[0] == '-'), isNameSegment(seg) && (!first || !isDigit(seg[0])):
 		sb.WriteByte('.')
-		sb.WriteString(field)
+		sb.WriteString(seg)
+		return
 	}
+	if first {
+		sb.WriteByte('.')
+	}
+	sb.WriteByte('[')
+	if isIndexSegment(seg) {
+		sb.WriteString(seg)
+	} else {
+		sb.WriteString(strconv.Quote(seg))
+	}
+	sb.WriteByte(']')
+}
+
+// isIndexSegment reports whether seg is an array index such as 0 or -1.
+func isIndexSegment(seg string) bool {
+	digits := strings.TrimPrefix(seg, "-")
+	if digits == "" {
+		return false
+	}
+	for i := 0; i < len(digits); i++ {
+		if !isDigit(digits[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameSegment reports whether seg can be written after a period as is.
+func isNameSegment(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	for _, r := range seg {
+		if !isAlphaNumeric(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
 }
 
 func (c *ChainNode) tree() *Tree {
@@ -840,7 +941,51 @@ func (e *elseNode) Copy() Node {
 	return e.tr.newElse(e.Pos, e.Line)
 }
 
-// BranchNode is the common representation of if, range, and with.
+// catchNode represents a {{catch}} action. Does not appear in the final tree.
+type catchNode struct {
+	NodeType
+	Pos
+	tr       *Tree
+	Line     int           // The line number in the input. Deprecated: Kept for compatibility.
+	Variable *VariableNode // The variable bound to the error, or nil.
+}
+
+func (t *Tree) newCatch(pos Pos, line int, v *VariableNode) *catchNode {
+	return &catchNode{tr: t, NodeType: nodeCatch, Pos: pos, Line: line, Variable: v}
+}
+
+func (c *catchNode) Type() NodeType {
+	return nodeCatch
+}
+
+func (c *catchNode) String() string {
+	var sb strings.Builder
+	c.writeTo(&sb)
+	return sb.String()
+}
+
+func (c *catchNode) writeTo(sb *strings.Builder) {
+	sb.WriteString("{{catch")
+	if c.Variable != nil {
+		sb.WriteByte(' ')
+		c.Variable.writeTo(sb)
+	}
+	sb.WriteString("}}")
+}
+
+func (c *catchNode) tree() *Tree {
+	return c.tr
+}
+
+func (c *catchNode) Copy() Node {
+	var v *VariableNode
+	if c.Variable != nil {
+		v = c.Variable.Copy().(*VariableNode)
+	}
+	return c.tr.newCatch(c.Pos, c.Line, v)
+}
+
+// BranchNode is the common representation of if, range, with, and for.
 type BranchNode struct {
 	NodeType
 	Pos
@@ -866,6 +1011,8 @@ func (b *BranchNode) writeTo(sb *strings.Builder) {
 		name = "range"
 	case NodeWith:
 		name = "with"
+	case NodeFor:
+		name = "for"
 	default:
 		panic("unknown branch type")
 	}
@@ -894,6 +1041,8 @@ func (b *BranchNode) Copy() Node {
 		return b.tr.newRange(b.Pos, b.Line, b.Pipe, b.List, b.ElseList)
 	case NodeWith:
 		return b.tr.newWith(b.Pos, b.Line, b.Pipe, b.List, b.ElseList)
+	case NodeFor:
+		return b.tr.newFor(b.Pos, b.Line, b.Pipe, b.List, b.ElseList)
 	default:
 		panic("unknown branch type")
 	}
@@ -946,6 +1095,92 @@ func (c *ContinueNode) String() string              { return "{{continue}}" }
 func (c *ContinueNode) tree() *Tree                 { return c.tr }
 func (c *ContinueNode) writeTo(sb *strings.Builder) { sb.WriteString("{{continue}}") }
 
+// CaptureNode represents a {{capture}} action, which renders its body into a
+// variable instead of the output.
+type CaptureNode struct {
+	NodeType
+	Pos
+	tr       *Tree
+	Line     int           // The line number in the input. Deprecated: Kept for compatibility.
+	Variable *VariableNode // The variable that receives the rendered text.
+	List     *ListNode     // The body to render.
+}
+
+func (t *Tree) newCapture(pos Pos, line int, v *VariableNode, list *ListNode) *CaptureNode {
+	return &CaptureNode{tr: t, NodeType: NodeCapture, Pos: pos, Line: line, Variable: v, List: list}
+}
+
+func (c *CaptureNode) String() string {
+	var sb strings.Builder
+	c.writeTo(&sb)
+	return sb.String()
+}
+
+func (c *CaptureNode) writeTo(sb *strings.Builder) {
+	sb.WriteString("{{capture ")
+	c.Variable.writeTo(sb)
+	sb.WriteString("}}")
+	c.List.writeTo(sb)
+	sb.WriteString("{{end}}")
+}
+
+func (c *CaptureNode) tree() *Tree {
+	return c.tr
+}
+
+func (c *CaptureNode) Copy() Node {
+	return c.tr.newCapture(c.Pos, c.Line, c.Variable.Copy().(*VariableNode), c.List.CopyList())
+}
+
+// TryNode represents a {{try}} action, which confines execution errors in
+// its body to the node.
+type TryNode struct {
+	NodeType
+	Pos
+	tr        *Tree
+	Line      int           // The line number in the input. Deprecated: Kept for compatibility.
+	List      *ListNode     // What to execute.
+	Variable  *VariableNode // The variable bound to the error in CatchList, or nil.
+	CatchList *ListNode     // What to execute if List fails (nil if absent).
+}
+
+func (t *Tree) newTry(pos Pos, line int, list *ListNode, v *VariableNode, catchList *ListNode) *TryNode {
+	return &TryNode{tr: t, NodeType: NodeTry, Pos: pos, Line: line, List: list, Variable: v, CatchList: catchList}
+}
+
+func (t *TryNode) String() string {
+	var sb strings.Builder
+	t.writeTo(&sb)
+	return sb.String()
+}
+
+func (t *TryNode) writeTo(sb *strings.Builder) {
+	sb.WriteString("{{try}}")
+	t.List.writeTo(sb)
+	if t.CatchList != nil {
+		sb.WriteString("{{catch")
+		if t.Variable != nil {
+			sb.WriteByte(' ')
+			t.Variable.writeTo(sb)
+		}
+		sb.WriteString("}}")
+		t.CatchList.writeTo(sb)
+	}
+	sb.WriteString("{{end}}")
+}
+
+func (t *TryNode) tree() *Tree {
+	return t.tr
+}
+
+func (t *TryNode) Copy() Node {
+	var v *VariableNode
+	if t.Variable != nil {
+		v = t.Variable.Copy().(*VariableNode)
+	}
+	return t.tr.newTry(t.Pos, t.Line, t.List.CopyList(), v, t.CatchList.CopyList())
+}
+
 // RangeNode represents a {{range}} action and its commands.
 type RangeNode struct {
 	BranchNode
@@ -972,6 +1207,65 @@ func (w *WithNode) Copy() Node {
 	return w.tr.newWith(w.Pos, w.Line, w.Pipe.CopyPipe(), w.List.CopyList(), w.ElseList.CopyList())
 }
 
+// ForNode represents a {{for}} action and its commands. Its pipeline is a
+// single command whose arguments are the start, end, and optional step of
+// the loop.
+type ForNode struct {
+	BranchNode
+}
+
+func (t *Tree) newFor(pos Pos, line int, pipe *PipeNode, list, elseList *ListNode) *ForNode {
+	return &ForNode{BranchNode{tr: t, NodeType: NodeFor, Pos: pos, Line: line, Pipe: pipe, List: list, ElseList: elseList}}
+}
+
+func (f *ForNode) Copy() Node {
+	return f.tr.newFor(f.Pos, f.Line, f.Pipe.CopyPipe(), f.List.CopyList(), f.ElseList.CopyList())
+}
+
+// WhileNode represents a {{while}} action and its commands.
+type WhileNode struct {
+	NodeType
+	Pos
+	tr       *Tree
+	Line     int       // The line number in the input. Deprecated: Kept for compatibility.
+	Pipe     *PipeNode // The condition, evaluated before each iteration.
+	Max      int       // The maximum number of iterations.
+	List     *ListNode // What to execute while the condition is non-empty.
+	ElseList *ListNode // What to execute if the condition is initially empty (nil if absent).
+}
+
+func (t *Tree) newWhile(pos Pos, line int, pipe *PipeNode, max int, list, elseList *ListNode) *WhileNode {
+	return &WhileNode{tr: t, NodeType: NodeWhile, Pos: pos, Line: line, Pipe: pipe, Max: max, List: list, ElseList: elseList}
+}
+
+func (w *WhileNode) String() string {
+	var sb strings.Builder
+	w.writeTo(&sb)
+	return sb.String()
+}
+
+func (w *WhileNode) writeTo(sb *strings.Builder) {
+	sb.WriteString("{{while ")
+	w.Pipe.writeTo(sb)
+	sb.WriteString(" max=")
+	sb.WriteString(strconv.Itoa(w.Max))
+	sb.WriteString("}}")
+	w.List.writeTo(sb)
+	if w.ElseList != nil {
+		sb.WriteString("{{else}}")
+		w.ElseList.writeTo(sb)
+	}
+	sb.WriteString("{{end}}")
+}
+
+func (w *WhileNode) tree() *Tree {
+	return w.tr
+}
+
+func (w *WhileNode) Copy() Node {
+	return w.tr.newWhile(w.Pos, w.Line, w.Pipe.CopyPipe(), w.Max, w.List.CopyList(), w.ElseList.CopyList())
+}
+
 // TemplateNode represents a {{template}} action.
 type TemplateNode struct {
 	NodeType
@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"fmt"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -22,16 +23,21 @@ type Tree struct {
 	ParseName string    // name of the top-level template during parsing, for error messages.
 	Root      *ListNode // top-level root of the tree.
 	Mode      Mode      // parsing mode.
+	Params    []string  // parameter names of a macro, without the '$'; nil for other templates.
+	Vars      []string  // names of variables, such as "$id", defined before the template starts.
 	text      string    // text parsed to create the template (or its parent)
 	// Parsing only; cleared after parse.
 	funcs      []map[string]any
 	lex        *lexer
 	token      [3]item // three-token lookahead for parser.
 	peekCount  int
-	vars       []string // variables defined at the moment.
+	vars       []string        // variables defined at the moment.
+	endedVars  map[string]bool // variables whose scope has ended, in StrictVars mode.
 	treeSet    map[string]*Tree
 	actionLine int // line of left delim starting action
 	rangeDepth int
+	whileOpts  bool // whether a max=N option may end the current command
+	whileMax   int  // the max=N option of the while being parsed, or 0
 }
 
 // A mode value is a set of flags (or 0). Modes control parser behavior.
@@ -40,6 +46,9 @@ type Mode uint
 const (
 	ParseComments Mode = 1 << iota // parse comments and add them to AST
 	SkipFuncCheck                  // do not check that functions are defined
+	TrimBlocks                     // remove the first newline after a block action
+	LstripBlocks                   // remove spaces and tabs before a block action at the start of a line
+	StrictVars                     // reject redeclared variables and assignments to undeclared ones
 )
 
 // Copy returns a copy of the [Tree]. Any parsing state is discarded.
@@ -51,6 +60,8 @@ func (t *Tree) Copy() *Tree {
 		Name:      t.Name,
 		ParseName: t.ParseName,
 		Root:      t.Root.CopyList(),
+		Params:    t.Params,
+		Vars:      t.Vars,
 		text:      t.text,
 	}
 }
@@ -157,6 +168,46 @@ func (t *Tree) ErrorContext(n Node) (location, context string) {
 	return fmt.Sprintf("%s:%d:%d", tree.ParseName, lineNum, byteNum), context
 }
 
+// maxExcerpt is the length beyond which ErrorExcerpt cuts lines.
+const maxExcerpt = 72
+
+// ErrorExcerpt returns the line and byte column of the node in the input
+// text, as ErrorContext reports them, and an excerpt of the text: the line
+// holding the node, followed by a line with a caret under the node. Long
+// lines are cut around the node.
+func (t *Tree) ErrorExcerpt(n Node) (line, col int, excerpt string) {
+	pos := int(n.Position())
+	tree := n.tree()
+	if tree == nil {
+		tree = t
+	}
+	start := strings.LastIndex(tree.text[:pos], "\n") + 1
+	end := strings.IndexByte(tree.text[pos:], '\n')
+	if end < 0 {
+		end = len(tree.text)
+	} else {
+		end += pos
+	}
+	line = 1 + strings.Count(tree.text[:pos], "\n")
+	col = pos - start
+	prefix, suffix := "", ""
+	if pos-start > maxExcerpt/2 {
+		start, prefix = pos-maxExcerpt/2, "..."
+	}
+	if end-start > maxExcerpt {
+		end, suffix = start+maxExcerpt, "..."
+	}
+	text := tree.text[start:end]
+	// Keep tabs so that the caret lines up with the text.
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, prefix+tree.text[start:pos])
+	return line, col, prefix + text + suffix + "\n" + indent + "^"
+}
+
 // errorf formats the error and terminates processing.
 func (t *Tree) errorf(format string, args ...any) {
 	t.Root = nil
@@ -220,13 +271,22 @@ func (t *Tree) recover(errp *error) {
 func (t *Tree) startParse(funcs []map[string]any, lex *lexer, treeSet map[string]*Tree) {
 	t.Root = nil
 	t.lex = lex
-	t.vars = []string{"$"}
+	t.vars = append([]string{"$"}, t.Vars...)
+	t.endedVars = nil
 	t.funcs = funcs
 	t.treeSet = treeSet
 	lex.options = lexOptions{
-		emitComment: t.Mode&ParseComments != 0,
-		breakOK:     !t.hasFunction("break"),
-		continueOK:  !t.hasFunction("continue"),
+		emitComment:  t.Mode&ParseComments != 0,
+		trimBlocks:   t.Mode&TrimBlocks != 0,
+		lstripBlocks: t.Mode&LstripBlocks != 0,
+	}
+	for _, name := range funcKeys {
+		if t.hasFunction(name) {
+			if lex.options.funcKeys == nil {
+				lex.options.funcKeys = make(map[string]bool)
+			}
+			lex.options.funcKeys[name] = true
+		}
 	}
 }
 
@@ -234,6 +294,7 @@ func (t *Tree) startParse(funcs []map[string]any, lex *lexer, treeSet map[string
 func (t *Tree) stopParse() {
 	t.lex = nil
 	t.vars = nil
+	t.endedVars = nil
 	t.funcs = nil
 	t.treeSet = nil
 }
@@ -272,8 +333,10 @@ func IsEmptyTree(n Node) bool {
 	case nil:
 		return true
 	case *ActionNode:
+	case *CaptureNode:
 	case *CommentNode:
 		return true
+	case *ForNode:
 	case *IfNode:
 	case *ListNode:
 		for _, node := range n.Nodes {
@@ -286,6 +349,8 @@ func IsEmptyTree(n Node) bool {
 	case *TemplateNode:
 	case *TextNode:
 		return len(bytes.TrimSpace(n.Text)) == 0
+	case *TryNode:
+	case *WhileNode:
 	case *WithNode:
 	default:
 		panic("unknown node: " + n.String())
@@ -301,19 +366,29 @@ func (t *Tree) parse() {
 	for t.peek().typ != itemEOF {
 		if t.peek().typ == itemLeftDelim {
 			delim := t.next()
-			if t.nextNonSpace().typ == itemDefine {
+			typ := t.nextNonSpace().typ
+			if typ == itemDelims {
+				t.parseDelims()
+				continue
+			}
+			if typ == itemDefine || typ == itemMacro {
 				newT := New("definition") // name will be updated once we know it.
 				newT.text = t.text
 				newT.Mode = t.Mode
+				newT.Vars = t.Vars
 				newT.ParseName = t.ParseName
 				newT.startParse(t.funcs, t.lex, t.treeSet)
-				newT.parseDefinition()
+				if typ == itemMacro {
+					newT.parseMacro()
+				} else {
+					newT.parseDefinition()
+				}
 				continue
 			}
 			t.backup2(delim)
 		}
 		switch n := t.textOrAction(); n.Type() {
-		case nodeEnd, nodeElse:
+		case nodeEnd, nodeElse, nodeCatch:
 			t.errorf("unexpected %s", n)
 		default:
 			t.Root.append(n)
@@ -342,6 +417,63 @@ func (t *Tree) parseDefinition() {
 	t.stopParse()
 }
 
+// parseDelims parses a {{delims "left" "right"}} action, which sets the
+// action delimiters for the rest of the text. The "delims" keyword has
+// already been scanned.
+func (t *Tree) parseDelims() {
+	const context = "delims clause"
+	var delims [2]string
+	for i := range delims {
+		token := t.expectOneOf(itemString, itemRawString, context)
+		s, err := strconv.Unquote(token.val)
+		if err != nil {
+			t.error(err)
+		}
+		if strings.TrimSpace(s) == "" {
+			t.errorf("empty delimiter in %s", context)
+		}
+		delims[i] = s
+	}
+	t.expect(itemRightDelim, context)
+	t.lex.leftDelim, t.lex.rightDelim = delims[0], delims[1]
+}
+
+// parseMacro parses a {{macro "name" param...}} ... {{end}} template
+// definition and installs the definition in t.treeSet. The parameters are
+// variables in the body. The "macro" keyword has already been scanned.
+func (t *Tree) parseMacro() {
+	const context = "macro clause"
+	name := t.expectOneOf(itemString, itemRawString, context)
+	var err error
+	t.Name, err = strconv.Unquote(name.val)
+	if err != nil {
+		t.error(err)
+	}
+	t.Params = []string{}
+	for {
+		token := t.nextNonSpace()
+		if token.typ == itemRightDelim {
+			break
+		}
+		if token.typ != itemIdentifier && token.typ != itemVariable || token.val == "$" {
+			t.unexpected(token, context)
+		}
+		param := strings.TrimPrefix(token.val, "$")
+		if slices.Contains(t.Params, param) {
+			t.errorf("duplicate parameter %s in %s", param, context)
+		}
+		t.Params = append(t.Params, param)
+		t.vars = append(t.vars, "$"+param)
+	}
+	var end Node
+	t.Root, end = t.itemList()
+	if end.Type() != nodeEnd {
+		t.errorf("unexpected %s in %s", end, context)
+	}
+	t.add()
+	t.stopParse()
+}
+
 // itemList:
 //
 //	textOrAction*
@@ -351,8 +483,11 @@ func (t *Tree) itemList() (list *ListNode, next Node) {
 	list = t.newList(t.peekNonSpace().pos)
 	for t.peekNonSpace().typ != itemEOF {
 		n := t.textOrAction()
+		if n == nil {
+			continue // a delims action
+		}
 		switch n.Type() {
-		case nodeEnd, nodeElse:
+		case nodeEnd, nodeElse, nodeCatch:
 			return list, n
 		}
 		list.append(n)
@@ -391,24 +526,38 @@ func (t *Tree) clearActionLine() {
 //
 // Left delim is past. Now get actions.
 // First word could be a keyword such as range.
+// A delims action yields no node.
 func (t *Tree) action() (n Node) {
 	switch token := t.nextNonSpace(); token.typ {
 	case itemBlock:
 		return t.blockControl()
+	case itemDelims:
+		t.parseDelims()
+		return nil
 	case itemBreak:
 		return t.breakControl(token.pos, token.line)
+	case itemCapture:
+		return t.captureControl(token.pos, token.line)
+	case itemCatch:
+		return t.catchControl(token.pos, token.line)
 	case itemContinue:
 		return t.continueControl(token.pos, token.line)
 	case itemElse:
 		return t.elseControl()
 	case itemEnd:
 		return t.endControl()
+	case itemFor:
+		return t.forControl()
 	case itemIf:
 		return t.ifControl()
 	case itemRange:
 		return t.rangeControl()
 	case itemTemplate:
 		return t.templateControl()
+	case itemTry:
+		return t.tryControl(token.pos, token.line)
+	case itemWhile:
+		return t.whileControl()
 	case itemWith:
 		return t.withControl()
 	}
@@ -448,12 +597,42 @@ func (t *Tree) continueControl(pos Pos, line int) Node {
 	return t.newContinue(pos, line)
 }
 
+// Capture:
+//
+//	{{capture $x}} itemList {{end}}
+//
+// Capture keyword is past. The variable is declared in the enclosing scope
+// once the {{end}} is reached, so it is not visible inside itemList.
+func (t *Tree) captureControl(pos Pos, line int) Node {
+	const context = "capture clause"
+	token := t.nextNonSpace()
+	if token.typ != itemVariable {
+		t.unexpected(token, context)
+	}
+	v := t.newVariable(token.pos, token.val)
+	if len(v.Ident) != 1 || v.Ident[0] == "$" {
+		t.errorf("invalid variable %s in %s", token.val, context)
+	}
+	if token := t.nextNonSpace(); token.typ != itemRightDelim {
+		t.unexpected(token, context)
+	}
+	n := len(t.vars)
+	list, end := t.itemList()
+	if end.Type() != nodeEnd {
+		t.errorf("unexpected %s in %s", end, context)
+	}
+	t.popVars(n)
+	t.declareVar(v.Ident[0])
+	return t.newCapture(pos, line, v, list)
+}
+
 // Pipeline:
 //
 //	declarations? command ('|' command)*
 func (t *Tree) pipeline(context string, end itemType) (pipe *PipeNode) {
 	token := t.peekNonSpace()
 	pipe = t.newPipeline(token.pos, token.line, nil)
+	n := len(t.vars)
 	// Are there declarations or assignments?
 decls:
 	if v := t.peekNonSpace(); v.typ == itemVariable {
@@ -490,6 +669,7 @@ decls:
 			t.backup2(v)
 		}
 	}
+	t.checkDecls(pipe, n)
 	for {
 		switch token := t.nextNonSpace(); token.typ {
 		case end:
@@ -522,19 +702,27 @@ func (t *Tree) checkPipeline(pipe *PipeNode, context string) {
 }
 
 func (t *Tree) parseControl(context string) (pos Pos, line int, pipe *PipeNode, list, elseList *ListNode) {
-	defer t.popVars(len(t.vars))
+	start := len(t.vars)
+	defer t.popVars(start)
 	pipe = t.pipeline(context, itemRightDelim)
-	if context == "range" {
+	n := len(t.vars)
+	if context == "range" || context == "for" {
 		t.rangeDepth++
 	}
 	var next Node
 	list, next = t.itemList()
-	if context == "range" {
+	if context == "range" || context == "for" {
 		t.rangeDepth--
 	}
 	switch next.Type() {
 	case nodeEnd: //done
+	case nodeCatch:
+		t.errorf("unexpected %s in %s", next, context)
 	case nodeElse:
+		if t.Mode&StrictVars != 0 {
+			// The variables declared in list are not set in elseList.
+			t.popVars(n)
+		}
 		// Special case for "else if" and "else with".
 		// If the "else" is followed immediately by an "if" or "with",
 		// the elseControl will have left the "if" or "with" token pending. Treat
@@ -545,6 +733,12 @@ func (t *Tree) parseControl(context string) (pos Pos, line int, pipe *PipeNode,
 		//  {{with a}}_{{else}}{{with b}}_{{end}}{{end}}.
 		// To do this, parse the "if" or "with" as usual and stop at it {{end}};
 		// the subsequent{{end}} is assumed. This technique works even for long if-else-if chains.
+		chained := context == "if" && t.peek().typ == itemIf || context == "with" && t.peek().typ == itemWith
+		if chained && t.Mode&StrictVars != 0 {
+			// The chained control may declare variables of the same
+			// names as pipe, as in {{with $x := a}}_{{else with $x := b}}.
+			t.popVars(start)
+		}
 		if context == "if" && t.peek().typ == itemIf {
 			t.next() // Consume the "if" token.
 			elseList = t.newList(next.Position())
@@ -584,6 +778,97 @@ func (t *Tree) rangeControl() Node {
 	return r
 }
 
+// For:
+//
+//	{{for start end}} itemList {{end}}
+//	{{for start end step}} itemList {{end}}
+//	{{for start end step}} itemList {{else}} itemList {{end}}
+//
+// For keyword is past. The pipeline may declare a variable for the counter,
+// as in {{for $i := 0 10 2}}.
+func (t *Tree) forControl() Node {
+	f := t.newFor(t.parseControl("for"))
+	if len(f.Pipe.Cmds) != 1 {
+		t.errorf("for takes a single command")
+	}
+	args := f.Pipe.Cmds[0].Args
+	if args[0].Type() == NodeIdentifier || len(args) < 2 || len(args) > 3 {
+		t.errorf("for requires start, end, and optional step operands; got %s", f.Pipe.Cmds[0])
+	}
+	return f
+}
+
+// While:
+//
+//	{{while pipeline max=N}} itemList {{end}}
+//	{{while pipeline max=N}} itemList {{else}} itemList {{end}}
+//
+// While keyword is past. The iteration limit N is mandatory.
+func (t *Tree) whileControl() Node {
+	const context = "while"
+	defer t.popVars(len(t.vars))
+	t.whileOpts, t.whileMax = true, 0
+	pipe := t.pipeline(context, itemRightDelim)
+	limit := t.whileMax
+	t.whileOpts, t.whileMax = false, 0
+	if len(pipe.Decl) > 0 {
+		t.errorf("while cannot declare variables")
+	}
+	if limit == 0 {
+		t.errorf("while requires an iteration limit, as in {{while %s max=100}}", pipe)
+	}
+	t.rangeDepth++
+	n := len(t.vars)
+	list, next := t.itemList()
+	t.rangeDepth--
+	var elseList *ListNode
+	switch next.Type() {
+	case nodeEnd: //done
+	case nodeElse:
+		if t.Mode&StrictVars != 0 {
+			t.popVars(n)
+		}
+		elseList, next = t.itemList()
+		if next.Type() != nodeEnd {
+			t.errorf("expected end; found %s", next)
+		}
+	default:
+		t.errorf("unexpected %s in %s", next, context)
+	}
+	return t.newWhile(pipe.Position(), pipe.Line, pipe, limit, list, elseList)
+}
+
+// whileLimit parses the max=N option that ends the pipeline of a while, if
+// it is next, and reports whether it was.
+func (t *Tree) whileLimit() bool {
+	token := t.peekNonSpace()
+	if token.typ != itemIdentifier || token.val != "max" {
+		return false
+	}
+	t.next()
+	if t.peek().typ != itemAssign {
+		t.backup2(token)
+		return false
+	}
+	t.next()
+	num := t.next()
+	if num.typ != itemNumber {
+		t.unexpected(num, "while limit")
+	}
+	n, err := t.newNumber(num.pos, num.val, num.typ)
+	if err != nil {
+		t.error(err)
+	}
+	if !n.IsInt || n.Int64 <= 0 {
+		t.errorf("invalid while limit %s", num.val)
+	}
+	if next := t.peekNonSpace(); next.typ != itemRightDelim {
+		t.unexpected(next, "while limit")
+	}
+	t.whileMax = int(n.Int64)
+	return true
+}
+
 // With:
 //
 //	{{with pipeline}} itemList {{end}}
@@ -603,6 +888,59 @@ func (t *Tree) endControl() Node {
 	return t.newEnd(t.expect(itemRightDelim, "end").pos)
 }
 
+// Try:
+//
+//	{{try}} itemList {{end}}
+//	{{try}} itemList {{catch}} itemList {{end}}
+//	{{try}} itemList {{catch $err}} itemList {{end}}
+//
+// Try keyword is past.
+func (t *Tree) tryControl(pos Pos, line int) Node {
+	const context = "try clause"
+	t.expect(itemRightDelim, context)
+	n := len(t.vars)
+	defer t.popVars(n)
+	list, next := t.itemList()
+	if next.Type() == nodeElse {
+		t.errorf("unexpected %s in %s", next, context)
+	}
+	var v *VariableNode
+	var catchList *ListNode
+	if c, ok := next.(*catchNode); ok {
+		t.popVars(n)
+		v = c.Variable
+		if v != nil {
+			t.declareVar(v.Ident[0])
+		}
+		catchList, next = t.itemList()
+		if next.Type() != nodeEnd {
+			t.errorf("unexpected %s in %s", next, context)
+		}
+	}
+	return t.newTry(pos, line, list, v, catchList)
+}
+
+// Catch:
+//
+//	{{catch}}
+//	{{catch $err}}
+//
+// Catch keyword is past.
+func (t *Tree) catchControl(pos Pos, line int) Node {
+	const context = "catch"
+	var v *VariableNode
+	if token := t.nextNonSpace(); token.typ == itemVariable {
+		v = t.newVariable(token.pos, token.val)
+		if len(v.Ident) != 1 || v.Ident[0] == "$" {
+			t.errorf("invalid variable %s in %s", token.val, context)
+		}
+	} else {
+		t.backup()
+	}
+	t.expect(itemRightDelim, context)
+	return t.newCatch(pos, line, v)
+}
+
 // Else:
 //
 //	{{else}}
@@ -637,6 +975,7 @@ func (t *Tree) blockControl() Node {
 	block := New(name) // name will be updated once we know it.
 	block.text = t.text
 	block.Mode = t.Mode
+	block.Vars = t.Vars
 	block.ParseName = t.ParseName
 	block.startParse(t.funcs, t.lex, t.treeSet)
 	var end Node
@@ -693,6 +1032,9 @@ func (t *Tree) command() *CommandNode {
 	cmd := t.newCommand(t.peekNonSpace().pos)
 	for {
 		t.peekNonSpace() // skip leading spaces.
+		if t.whileOpts && t.whileLimit() {
+			break
+		}
 		operand := t.operand()
 		if operand != nil {
 			cmd.append(operand)
@@ -814,9 +1156,47 @@ func (t *Tree) hasFunction(name string) bool {
 
 // popVars trims the variable list to the specified length
 func (t *Tree) popVars(n int) {
+	if t.Mode&StrictVars != 0 {
+		for _, name := range t.vars[n:] {
+			if t.endedVars == nil {
+				t.endedVars = make(map[string]bool)
+			}
+			t.endedVars[name] = true
+		}
+	}
 	t.vars = t.vars[:n]
 }
 
+// declareVar adds a variable declared outside a pipeline, such as by
+// capture or catch. In StrictVars mode, it errors if the variable is
+// already defined.
+func (t *Tree) declareVar(name string) {
+	if t.Mode&StrictVars != 0 && slices.Contains(t.vars, name) {
+		t.errorf("variable %s is already declared", name)
+	}
+	t.vars = append(t.vars, name)
+}
+
+// checkDecls checks, in StrictVars mode, the declarations and assignments
+// of pipe against the first n variables, those defined before it: a
+// declaration must not shadow one of them, and an assignment must refer to
+// one of them.
+func (t *Tree) checkDecls(pipe *PipeNode, n int) {
+	if t.Mode&StrictVars == 0 {
+		return
+	}
+	for _, v := range pipe.Decl {
+		name := v.Ident[0]
+		defined := slices.Contains(t.vars[:n], name)
+		switch {
+		case pipe.IsAssign && !defined:
+			t.errorf("assignment to undeclared variable %s", name)
+		case !pipe.IsAssign && defined:
+			t.errorf("variable %s is already declared; use = to assign to it", name)
+		}
+	}
+}
+
 // useVar returns a node for a variable reference. It errors if the
 // variable is not defined.
 func (t *Tree) useVar(pos Pos, name string) Node {
@@ -826,6 +1206,9 @@ func (t *Tree) useVar(pos Pos, name string) Node {
 			return v
 		}
 	}
+	if t.endedVars[v.Ident[0]] {
+		t.errorf("variable %q is out of scope: the block declaring it has ended", v.Ident[0])
+	}
 	t.errorf("undefined variable %q", v.Ident[0])
 	return nil
 }
@@ -0,0 +1,57 @@
+// This file contains the chaining of templates into pipelines.
+
+package gjson_template
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/tidwall/gjson"
+)
+
+// A Pipeline chains templates into a multi-step transformation: the output
+// of each stage, which must be a JSON object or array, is the data of the
+// next, and the output of the last stage is the output of the pipeline.
+//
+//	p := gjson_template.Pipeline{normalize, enrich, render}
+//	err := p.Execute(os.Stdout, data)
+type Pipeline []*Template
+
+// Execute applies the stages of the pipeline in turn, starting with the
+// specified JSON data, and writes the output of the last stage to wr. If a
+// stage fails, nothing is written.
+func (p Pipeline) Execute(wr io.Writer, data []byte) error {
+	return p.ExecuteContext(context.Background(), wr, data)
+}
+
+// ExecuteContext is like Execute, but passes ctx to the functions of each
+// stage as [Template.ExecuteContext] does, and stops between stages once
+// ctx is done.
+func (p Pipeline) ExecuteContext(ctx context.Context, wr io.Writer, data []byte) error {
+	if len(p) == 0 {
+		return fmt.Errorf("template: empty pipeline")
+	}
+	var buf bytes.Buffer
+	for i, t := range p {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if i > 0 {
+			if !gjson.ValidBytes(buf.Bytes()) {
+				return fmt.Errorf("template: pipeline stage %d (%s): output is not valid JSON", i-1, p[i-1].Name())
+			}
+			if out := gjson.ParseBytes(buf.Bytes()); !out.IsObject() && !out.IsArray() {
+				return fmt.Errorf("template: pipeline stage %d (%s): output is not a JSON object or array", i-1, p[i-1].Name())
+			}
+			data = bytes.Clone(buf.Bytes())
+			buf.Reset()
+		}
+		if err := t.execute(&buf, data, execOptions{ctx: ctx}); err != nil {
+			return fmt.Errorf("pipeline stage %d: %w", i, err)
+		}
+	}
+	_, err := wr.Write(buf.Bytes())
+	return err
+}
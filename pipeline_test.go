@@ -0,0 +1,65 @@
+package gjson_template
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	normalize := Must(New("normalize").Parse(`{"users":[{{range $i, $u := .people}}{{if $i}},{{end}}{"name":{{toJson (upper $u.name)}},"age":{{$u.age}}}{{end}}]}`))
+	adults := Must(New("adults").Parse(`{"adults":{{gjson "users.#(age>=18)#.name"}}}`))
+	render := Must(New("render").Parse(`{{range .adults}}- {{.}}
+{{end}}`))
+	data := []byte(`{"people":[{"name":"ada","age":36},{"name":"tim","age":9},{"name":"bob","age":18}]}`)
+	var buf bytes.Buffer
+	if err := (Pipeline{normalize, adults, render}).Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := "- ADA\n- BOB\n"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := (Pipeline{render}).Execute(&buf, []byte(`{"adults":["x"]}`)); err != nil || buf.String() != "- x\n" {
+		t.Errorf("single stage: got %q, %v", buf.String(), err)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	text := Must(New("text").Parse(`not json`))
+	scalar := Must(New("scalar").Parse(`"text"`))
+	ok := Must(New("ok").Parse(`{}`))
+	fail := Must(New("fail").Parse(`{{fail "boom"}}`))
+	data := []byte(`{}`)
+	for _, test := range []struct {
+		name string
+		p    Pipeline
+		err  string
+	}{
+		{"empty", Pipeline{}, "empty pipeline"},
+		{"invalid JSON", Pipeline{text, ok}, "pipeline stage 0 (text): output is not valid JSON"},
+		{"scalar", Pipeline{scalar, ok}, "template: pipeline stage 0 (scalar): output is not a JSON object or array"},
+		{"failing stage", Pipeline{ok, fail}, "pipeline stage 1: template: fail:1:7"},
+	} {
+		var buf bytes.Buffer
+		err := test.p.Execute(&buf, data)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q; got %v", test.name, test.err, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: expected no output; got %q", test.name, buf.String())
+		}
+	}
+	// The error of a failing stage is prefixed once with "template:".
+	if err := (Pipeline{fail}).Execute(&bytes.Buffer{}, data); err == nil || strings.Count(err.Error(), "template:") != 1 {
+		t.Errorf("failing stage: got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (Pipeline{ok, ok}).ExecuteContext(ctx, &bytes.Buffer{}, data); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: expected context.Canceled; got %v", err)
+	}
+}
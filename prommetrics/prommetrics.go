@@ -0,0 +1,126 @@
+// Package prommetrics exposes metrics of template executions to
+// Prometheus. A Collector observes the executions of the templates it is
+// set on, and is registered with a Prometheus registry:
+//
+//	c := prommetrics.NewCollector("")
+//	prometheus.MustRegister(c)
+//	tmpl := template.Must(template.New("route").SetObserver(c).Parse(text))
+//
+// All metrics are labeled with the name of the executed template.
+package prommetrics
+
+import (
+	"context"
+	"errors"
+
+	template "github.com/higress-group/gjson_template"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A Collector is a prometheus.Collector and a template.ExecObserver
+// collecting the following metrics, whose names are prefixed with the
+// namespace given to NewCollector:
+//
+//	executions_total                 counter of executions
+//	execution_errors_total           counter of failed executions, by type
+//	execution_duration_seconds       histogram of execution latencies
+//	execution_output_bytes           histogram of output sizes
+//
+// The error types are "canceled" for executions stopped by their context;
+// "missing_path", "unknown_function", "type_mismatch", "budget_exceeded"
+// and "max_depth" for the errors of those kinds, such as
+// template.ErrMissingPath; "exec" for the other errors of the template and
+// its functions; and "other", such as for invalid data or failed writes.
+type Collector struct {
+	executions *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	bytes      *prometheus.HistogramVec
+}
+
+// NewCollector returns a Collector whose metric names are prefixed with
+// namespace, "gjson_template" if empty.
+func NewCollector(namespace string) *Collector {
+	if namespace == "" {
+		namespace = "gjson_template"
+	}
+	return &Collector{
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "executions_total",
+			Help:      "Number of template executions.",
+		}, []string{"template"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "execution_errors_total",
+			Help:      "Number of failed template executions, by error type.",
+		}, []string{"template", "type"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "execution_duration_seconds",
+			Help:      "Duration of template executions.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10), // 10µs to 2.6s
+		}, []string{"template"}),
+		bytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "execution_output_bytes",
+			Help:      "Size of the output of template executions.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 10), // 64B to 16MiB
+		}, []string{"template"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.executions.Describe(ch)
+	c.errors.Describe(ch)
+	c.duration.Describe(ch)
+	c.bytes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.executions.Collect(ch)
+	c.errors.Collect(ch)
+	c.duration.Collect(ch)
+	c.bytes.Collect(ch)
+}
+
+// ObserveExec implements template.ExecObserver.
+func (c *Collector) ObserveExec(s template.ExecStats) {
+	c.executions.WithLabelValues(s.Template).Inc()
+	if s.Err != nil {
+		c.errors.WithLabelValues(s.Template, errorType(s.Err)).Inc()
+	}
+	c.duration.WithLabelValues(s.Template).Observe(s.Duration.Seconds())
+	c.bytes.WithLabelValues(s.Template).Observe(float64(s.Bytes))
+}
+
+// errorType returns the type label of the error of an execution.
+func errorType(err error) string {
+	var execErr template.ExecError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case !errors.As(err, &execErr):
+		return "other"
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			return k.name
+		}
+	}
+	return "exec"
+}
+
+// errorKinds are the type labels of the kinds of execution errors.
+var errorKinds = []struct {
+	kind error
+	name string
+}{
+	{template.ErrMissingPath, "missing_path"},
+	{template.ErrUnknownFunction, "unknown_function"},
+	{template.ErrTypeMismatch, "type_mismatch"},
+	{template.ErrBudgetExceeded, "budget_exceeded"},
+	{template.ErrMaxDepth, "max_depth"},
+}
@@ -0,0 +1,71 @@
+package prommetrics
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	template "github.com/higress-group/gjson_template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector("")
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	tmpl := template.Must(template.New("greet").SetObserver(c).Option("missingkey=error").
+		Parse(`{{define "name"}}{{.name}}{{end}}hello {{template "name" .}}`))
+
+	for range 3 {
+		if err := tmpl.Execute(io.Discard, []byte(`{"name":"Ada"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tmpl.ExecuteTemplate(io.Discard, "name", []byte(`{}`)); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := tmpl.Execute(io.Discard, []byte(`"x"`)); err == nil {
+		t.Fatal("expected error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := template.Must(template.New("slow").SetObserver(c).Funcs(template.FuncMap{
+		"wait": func(ctx context.Context) (string, error) { return "", ctx.Err() },
+	}).Parse(`{{wait}}`))
+	if err := slow.ExecuteContext(ctx, io.Discard, []byte(`{}`)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	want := `
+# HELP gjson_template_execution_errors_total Number of failed template executions, by error type.
+# TYPE gjson_template_execution_errors_total counter
+gjson_template_execution_errors_total{template="greet",type="other"} 1
+gjson_template_execution_errors_total{template="name",type="missing_path"} 1
+gjson_template_execution_errors_total{template="slow",type="canceled"} 1
+# HELP gjson_template_executions_total Number of template executions.
+# TYPE gjson_template_executions_total counter
+gjson_template_executions_total{template="greet"} 4
+gjson_template_executions_total{template="name"} 1
+gjson_template_executions_total{template="slow"} 1
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"gjson_template_executions_total", "gjson_template_execution_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "gjson_template_execution_duration_seconds"); n != 3 {
+		t.Errorf("expected 3 duration histograms; got %d", n)
+	}
+
+	// Failed executions count as output of 0 bytes.
+	var m dto.Metric
+	if err := c.bytes.WithLabelValues("greet").(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if h := m.GetHistogram(); h.GetSampleCount() != 4 || h.GetSampleSum() != float64(3*len("hello Ada")) {
+		t.Errorf("expected 4 output sizes summing to %d; got %d summing to %g", 3*len("hello Ada"), h.GetSampleCount(), h.GetSampleSum())
+	}
+}
@@ -0,0 +1,40 @@
+// Package prototemplate executes templates with protocol buffer messages
+// as their data. The messages are converted to JSON with protojson, so
+// templates address fields by their JSON names, which honor the json_name
+// options of the message definitions:
+//
+//	tmpl := template.Must(template.New("reply").Parse(`{{.userId}}: {{.displayName}}`))
+//	err := prototemplate.Execute(tmpl, w, msg)
+//
+// It is a module of its own, so that importing the template package does
+// not require protocol buffers.
+package prototemplate
+
+import (
+	"fmt"
+	"io"
+
+	template "github.com/higress-group/gjson_template"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Execute applies the template to the JSON form of msg, as produced by
+// protojson with its default options, and writes the output to wr. Fields
+// with their default values are omitted from the JSON, so they are
+// missing values in the template; see ExecuteWith to include them.
+func Execute(t *template.Template, wr io.Writer, msg proto.Message) error {
+	return ExecuteWith(t, wr, msg, protojson.MarshalOptions{})
+}
+
+// ExecuteWith is like Execute, but converts msg to JSON with opts, for
+// example with EmitUnpopulated set so that fields with their default
+// values are present, or with UseProtoNames set so that templates address
+// fields by their names in the message definition.
+func ExecuteWith(t *template.Template, wr io.Writer, msg proto.Message, opts protojson.MarshalOptions) error {
+	data, err := opts.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("template: %s: converting message to JSON: %w", t.Name(), err)
+	}
+	return t.Execute(wr, data)
+}
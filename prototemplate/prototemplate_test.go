@@ -0,0 +1,54 @@
+package prototemplate
+
+import (
+	"strings"
+	"testing"
+
+	template "github.com/higress-group/gjson_template"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestExecute(t *testing.T) {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("user_id"),
+		JsonName: proto.String("userId"),
+		Number:   proto.Int32(1),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	method := &apipb.Method{Name: "Get"}
+	payload, err := structpb.NewStruct(map[string]any{"user": map[string]any{"name": "Ann"}, "tags": []any{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, text string
+		msg        proto.Message
+		opts       protojson.MarshalOptions
+		output     string
+	}{
+		{"json names", `{{.name}} {{.jsonName}} {{.number}} {{.label}} [{{.typeName}}]`, field, protojson.MarshalOptions{}, "user_id userId 1 LABEL_OPTIONAL []"},
+		{"proto names", `{{.json_name}}`, field, protojson.MarshalOptions{UseProtoNames: true}, "userId"},
+		{"populated", `{{.name}} [{{.requestTypeUrl}}] [{{.responseStreaming}}]`, method, protojson.MarshalOptions{}, "Get [] []"},
+		{"unpopulated", `{{.name}} [{{.requestTypeUrl}}] [{{.responseStreaming}}]`, method, protojson.MarshalOptions{EmitUnpopulated: true}, "Get [] [false]"},
+		{"struct", `{{.user.name}}{{range .tags}},{{.}}{{end}}`, payload, protojson.MarshalOptions{}, "Ann,a,b"},
+	}
+	for _, test := range tests {
+		tmpl := template.Must(template.New(test.name).Parse(test.text))
+		var buf strings.Builder
+		if err := ExecuteWith(tmpl, &buf, test.msg, test.opts); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%s: expected %q; got %q", test.name, test.output, buf.String())
+		}
+	}
+
+	tmpl := template.Must(template.New("default").Parse(`{{.jsonName}}`))
+	var buf strings.Builder
+	if err := Execute(tmpl, &buf, field); err != nil || buf.String() != "userId" {
+		t.Errorf("Execute: got %q, %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,311 @@
+// This file contains the lazy iteration of range over GJSON queries.
+
+package gjson_template
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/gjson_template/parse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/match"
+)
+
+// A lazyQuery is a GJSON path of the form path.#(cond)#.rest, whose
+// matches are visited in place.
+type lazyQuery struct {
+	path, base, rest string
+	cond             queryCondition
+}
+
+// A queryCondition is the condition of a GJSON query, such as active==true
+// in #(active==true)#, evaluated as GJSON evaluates it.
+type queryCondition struct {
+	path  string // path of the value compared within each element
+	op    string // comparison operator, or "" to test existence
+	value string // value compared to, unquoted
+}
+
+// lazyQuery returns the query of a range pipeline consisting of a single
+// call of the gjson builtin with a constant path containing a query for
+// all matches, as in {{range gjson "items.#(active==true)#"}}.
+func (s *state) lazyQuery(pipe *parse.PipeNode) (lazyQuery, bool) {
+	if len(pipe.Cmds) != 1 {
+		return lazyQuery{}, false
+	}
+	path, _, ok := constantPath(pipe.Cmds[0])
+	if !ok || s.userFunc("gjson") {
+		return lazyQuery{}, false
+	}
+	base, cond, rest, ok := splitQuery(path)
+	return lazyQuery{path: path, base: base, rest: rest, cond: parseCondition(cond)}, ok
+}
+
+// splitQuery splits a path of the form base.#(condition)#.rest, where base
+// and rest may be empty, at its first query for all matches. It reports
+// false if the path has no such query, or if the query is followed by
+// anything but a path applied to each match.
+func splitQuery(path string) (base, cond, rest string, ok bool) {
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+			continue
+		case '#':
+		default:
+			continue
+		}
+		if i+1 >= len(path) || path[i+1] != '(' || i > 0 && path[i-1] != '.' {
+			continue
+		}
+		end := queryEnd(path, i+1)
+		if end < 0 || end+1 >= len(path) || path[end+1] != '#' {
+			return "", "", "", false
+		}
+		base = strings.TrimSuffix(path[:i], ".")
+		cond = path[i+2 : end]
+		rest = path[end+2:]
+		if rest != "" {
+			if rest[0] != '.' {
+				return "", "", "", false
+			}
+			rest = rest[1:]
+		}
+		return base, cond, rest, true
+	}
+	return "", "", "", false
+}
+
+// queryEnd returns the index of the parenthesis closing the one at
+// path[open], skipping quoted strings, or -1 if there is none.
+func queryEnd(path string, open int) int {
+	depth := 0
+	for i := open; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '"':
+			for i++; i < len(path) && path[i] != '"'; i++ {
+				if path[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// rangeQuery calls fn with the index and value of each element of the
+// array at q.base in dot matching q.cond, with q.rest applied to it, as
+// they would appear in the result of the path of q. The elements are
+// visited in place, without building the result. It returns the number of
+// calls.
+func (s *state) rangeQuery(dot gjson.Result, q lazyQuery, fn func(index, elem gjson.Result)) int {
+	arr := dot
+	if q.base != "" {
+		arr = dot.Get(q.base)
+	}
+	if !arr.Exists() && s.strictMissing() {
+		s.kindErrorf(ErrMissingPath, "gjson path %q not found in data", q.path)
+	}
+	if !arr.IsArray() {
+		return 0
+	}
+	n := 0
+	arr.ForEach(func(_, elem gjson.Result) bool {
+		if !q.cond.matches(elem) {
+			return true
+		}
+		if q.rest != "" {
+			if elem = elem.Get(q.rest); !elem.Exists() {
+				return true
+			}
+		}
+		fn(gjson.Parse(strconv.Itoa(n)), elem)
+		n++
+		return true
+	})
+	return n
+}
+
+// parseCondition parses the condition of a GJSON query, the text between
+// #( and ), as GJSON does.
+func parseCondition(cond string) queryCondition {
+	depth := 0
+	for i := 0; i < len(cond); i++ {
+		switch c := cond[i]; {
+		case depth == 0 && (c == '!' || c == '=' || c == '<' || c == '>' || c == '%'):
+			return newCondition(trimSpace(cond[:i]), trimSpace(cond[i:]))
+		case c == '\\':
+			i++
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == '"':
+			for i++; i < len(cond) && cond[i] != '"'; i++ {
+				if cond[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return queryCondition{path: trimSpace(cond)}
+}
+
+// newCondition returns the condition comparing the value at path with the
+// operator and value of comparison, such as "==true".
+func newCondition(path, comparison string) queryCondition {
+	size := 0
+	v := comparison
+	switch {
+	case len(v) == 1:
+		size = 1
+	case v[:2] == "!=" || v[:2] == "!%" || v[:2] == "<=" || v[:2] == ">=":
+		size = 2
+	case v[:2] == "==":
+		v = v[1:]
+		size = 1
+	case v[0] == '<' || v[0] == '>' || v[0] == '=' || v[0] == '%':
+		size = 1
+	}
+	op, value := v[:size], trimSpace(v[size:])
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = gjson.Parse(value).Str
+	}
+	return queryCondition{path: path, op: op, value: value}
+}
+
+// trimSpace trims the bytes up to and including spaces from both ends of
+// s, as GJSON does around the parts of a condition.
+func trimSpace(s string) string {
+	return strings.TrimFunc(s, func(r rune) bool { return r <= ' ' })
+}
+
+// matches reports whether the element elem of the queried array meets the
+// condition.
+func (c queryCondition) matches(elem gjson.Result) bool {
+	value := elem
+	switch {
+	case elem.Type == gjson.JSON:
+		value = elem.Get(c.path)
+	case c.path != "":
+		return false
+	}
+	want := c.value
+	if strings.HasPrefix(want, "~") {
+		// Compare the truthiness of the value.
+		var ish, ok bool
+		switch want[1:] {
+		case "*":
+			ish, ok = value.Exists(), true
+		case "null":
+			ish, ok = value.Type == gjson.Null, true
+		case "true":
+			ish, ok = truthy(value, true), true
+		case "false":
+			ish, ok = value.Type == gjson.Null || truthy(value, false), true
+		}
+		want, value = "true", gjson.Result{Type: gjson.False}
+		if !ok {
+			want, value = "", gjson.Result{}
+		} else if ish {
+			value.Type = gjson.True
+		}
+	}
+	if !value.Exists() {
+		return false
+	}
+	if c.op == "" {
+		return true
+	}
+	switch value.Type {
+	case gjson.String:
+		switch c.op {
+		case "=":
+			return value.Str == want
+		case "!=":
+			return value.Str != want
+		case "<":
+			return value.Str < want
+		case "<=":
+			return value.Str <= want
+		case ">":
+			return value.Str > want
+		case ">=":
+			return value.Str >= want
+		case "%":
+			return matchPattern(value.Str, want)
+		case "!%":
+			return !matchPattern(value.Str, want)
+		}
+	case gjson.Number:
+		n, _ := strconv.ParseFloat(want, 64)
+		switch c.op {
+		case "=":
+			return value.Num == n
+		case "!=":
+			return value.Num != n
+		case "<":
+			return value.Num < n
+		case "<=":
+			return value.Num <= n
+		case ">":
+			return value.Num > n
+		case ">=":
+			return value.Num >= n
+		}
+	case gjson.True:
+		switch c.op {
+		case "=":
+			return want == "true"
+		case "!=":
+			return want != "true"
+		case ">":
+			return want == "false"
+		case ">=":
+			return true
+		}
+	case gjson.False:
+		switch c.op {
+		case "=":
+			return want == "false"
+		case "!=":
+			return want != "false"
+		case "<":
+			return want == "true"
+		case "<=":
+			return true
+		}
+	}
+	return false
+}
+
+// truthy reports whether value is a boolean, a string parsing as one, or a
+// number that is nonzero, and equal to truth in that sense.
+func truthy(value gjson.Result, truth bool) bool {
+	switch value.Type {
+	case gjson.True, gjson.False:
+		return value.Type == gjson.True == truth
+	case gjson.String:
+		b, err := strconv.ParseBool(strings.ToLower(value.Str))
+		return err == nil && b == truth
+	case gjson.Number:
+		return (value.Num != 0) == truth
+	}
+	return false
+}
+
+// matchPattern reports whether str matches the wildcard pattern of the %
+// operator, with the complexity limit GJSON uses.
+func matchPattern(str, pattern string) bool {
+	matched, _ := match.MatchLimit(str, pattern, 10000)
+	return matched
+}
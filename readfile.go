@@ -0,0 +1,61 @@
+// This file contains the readFile builtin, which reads files below the
+// directory set with SetFileRoot.
+
+package gjson_template
+
+import (
+	"io"
+	"os"
+
+	"github.com/tidwall/gjson"
+)
+
+// SetFileRoot enables the readFile builtin in the template and the
+// templates associated with it, which is disabled by default, for the files
+// below the directory dir:
+//
+//	{{readFile "snippets/license.txt"}}
+//
+// returns the contents of the file at the given slash-separated path,
+// relative to dir, as a string. Paths that lead out of dir, including
+// through symbolic links, are errors. The return value is the template, so
+// calls can be chained.
+func (t *Template) SetFileRoot(dir string) *Template {
+	t.init()
+	t.fileRoot = dir
+	return t
+}
+
+// evalReadFile implements {{readFile path}}.
+func (s *state) evalReadFile(vals []gjson.Result) gjson.Result {
+	if len(vals) != 1 {
+		s.errorf("wrong number of args for readFile: want 1 got %d", len(vals))
+	}
+	dir := s.tmpl.fileRoot
+	if dir == "" {
+		s.errorf("readFile is not enabled; see Template.SetFileRoot")
+	}
+	if vals[0].Type != gjson.String {
+		s.errorf("readFile requires a string path; got %s", describe(vals[0]))
+	}
+	b, err := readFileIn(dir, vals[0].Str)
+	if err != nil {
+		s.errorf("readFile: %w", err)
+	}
+	return gjson.Parse(jsonString(string(b)))
+}
+
+// readFileIn reads the file at name, relative to dir and confined to it.
+func readFileIn(dir, name string) ([]byte, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,65 @@
+package gjson_template
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	files := map[string]string{
+		filepath.Join(dir, "header.txt"):          "// Licensed under \"MIT\".\n",
+		filepath.Join(dir, "snippets", "foo.txt"): "foo",
+		secret: "secret",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"snippet": "snippets/foo.txt"}`)
+
+	tests := []struct {
+		input, output string
+		ok            bool
+	}{
+		{`{{readFile "header.txt"}}x`, "// Licensed under \"MIT\".\nx", true},
+		{`{{readFile .snippet}}`, "foo", true},
+		{`{{.snippet | readFile | upper}}`, "FOO", true},
+		{`{{len (readFile "snippets/../header.txt")}}`, "25", true},
+		{`{{readFile "missing.txt"}}`, "", false},
+		{`{{readFile "../secret.txt"}}`, "", false},
+		{`{{readFile "link.txt"}}`, "", false},
+		{`{{readFile "` + filepath.ToSlash(secret) + `"}}`, "", false},
+		{`{{readFile 1}}`, "", false},
+	}
+	for _, test := range tests {
+		tmpl := Must(New("read").SetFileRoot(dir).Parse(test.input))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got none", test.input)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.input, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.input, test.output, buf.String())
+		}
+	}
+
+	err := Must(New("off").Parse(`{{readFile "header.txt"}}`)).Execute(&bytes.Buffer{}, data)
+	if err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("expected readFile to be disabled; got %v", err)
+	}
+}
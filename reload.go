@@ -0,0 +1,131 @@
+// This file contains the reloading of templates parsed from files.
+
+package gjson_template
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A ReloadableSet holds templates parsed from files, as ParseGlob parses
+// them, and parses them again when the files change, so that long-running
+// services pick up edits of their templates without a restart. The
+// templates are replaced as a whole: an execution uses either the old or
+// the new set, never a mix of them. A ReloadableSet may be used
+// concurrently by multiple goroutines.
+type ReloadableSet struct {
+	base     *Template
+	patterns []string
+
+	mu     sync.Mutex           // serializes reloads
+	stamps map[string]fileStamp // files of the current set
+	cur    atomic.Pointer[Template]
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloadableSet parses the files matching the glob patterns into a
+// clone of base, which provides the functions, options and delimiters of
+// the templates and gives the set its name, and returns a set that parses
+// them again into a new clone when they change. The files are matched
+// according to the semantics of [filepath.Match], and each pattern must
+// match at least one file.
+func NewReloadableSet(base *Template, patterns ...string) (*ReloadableSet, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("template: no patterns in call to NewReloadableSet")
+	}
+	rs := &ReloadableSet{base: base, patterns: patterns}
+	if _, err := rs.Reload(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Template returns the current templates. The returned template is not
+// changed by later reloads, so one execution, or a series of them, can
+// hold on to it.
+func (rs *ReloadableSet) Template() *Template {
+	return rs.cur.Load()
+}
+
+// Reload parses the files again if they changed since they were last
+// parsed: if one was modified, added or removed. It reports whether the
+// templates were replaced. If parsing fails, the current templates are
+// kept and the error is returned.
+func (rs *ReloadableSet) Reload() (bool, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	filenames, stamps, err := rs.stat()
+	if err != nil {
+		return false, err
+	}
+	if rs.cur.Load() != nil && maps.Equal(stamps, rs.stamps) {
+		return false, nil
+	}
+	t, err := rs.base.Clone()
+	if err != nil {
+		return false, err
+	}
+	if _, err := parseFiles(t, readFileOS, filenames...); err != nil {
+		return false, err
+	}
+	rs.stamps = stamps
+	rs.cur.Store(t)
+	return true, nil
+}
+
+// stat returns the files matching the patterns, in order, and their stamps.
+func (rs *ReloadableSet) stat() ([]string, map[string]fileStamp, error) {
+	var filenames []string
+	for _, pattern := range rs.patterns {
+		list, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(list) == 0 {
+			return nil, nil, fmt.Errorf("template: pattern matches no files: %#q", pattern)
+		}
+		filenames = append(filenames, list...)
+	}
+	stamps := make(map[string]fileStamp, len(filenames))
+	for _, name := range slices.Compact(slices.Clone(filenames)) {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		stamps[name] = fileStamp{fi.ModTime(), fi.Size()}
+	}
+	return filenames, stamps, nil
+}
+
+// Watch calls Reload every interval until ctx is done, and returns the
+// error of ctx. Errors of Reload are passed to onError, if not nil, and
+// the templates that parsed last are kept. Watch is typically run in its
+// own goroutine:
+//
+//	go set.Watch(ctx, 2*time.Second, func(err error) { log.Print(err) })
+func (rs *ReloadableSet) Watch(ctx context.Context, interval time.Duration, onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := rs.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package gjson_template
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadableSet(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	execute := func(tmpl *Template) string {
+		t.Helper()
+		out, err := tmpl.ExecuteString([]byte(`{"name":"Ann"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	write("main.tmpl", `hello {{template "name.tmpl" .}}`)
+	write("name.tmpl", `{{upper .name}}`)
+
+	base := New("main.tmpl").Funcs(FuncMap{"upper": strings.ToUpper})
+	rs, err := NewReloadableSet(base, filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := rs.Template()
+	if got := execute(old); got != "hello ANN" {
+		t.Fatalf("got %q", got)
+	}
+	if changed, err := rs.Reload(); changed || err != nil {
+		t.Fatalf("unchanged files: got %v, %v", changed, err)
+	}
+
+	write("name.tmpl", `{{.name}}!`)
+	if changed, err := rs.Reload(); !changed || err != nil {
+		t.Fatalf("changed file: got %v, %v", changed, err)
+	}
+	if got := execute(rs.Template()); got != "hello Ann!" {
+		t.Errorf("after reload: got %q", got)
+	}
+	if got := execute(old); got != "hello ANN" {
+		t.Errorf("old set changed: got %q", got)
+	}
+	if base.Lookup("name.tmpl") != nil {
+		t.Errorf("base template modified")
+	}
+
+	write("name.tmpl", `{{.name`)
+	if _, err := rs.Reload(); err == nil {
+		t.Fatal("expected parse error")
+	}
+	if got := execute(rs.Template()); got != "hello Ann!" {
+		t.Errorf("after failed reload: got %q", got)
+	}
+
+	write("name.tmpl", `[{{.name}}]`)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rs.Watch(ctx, time.Millisecond, nil) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for execute(rs.Template()) != "hello [Ann]" {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not reload")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Watch returned %v", err)
+	}
+}
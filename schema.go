@@ -0,0 +1,196 @@
+// This file contains the validation of templates against a JSON Schema.
+
+package gjson_template
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/gjson_template/parse"
+	"github.com/tidwall/gjson"
+)
+
+// ValidateSchema checks the paths of the data that t reads (see
+// [Template.ReferencedPaths]) against the JSON Schema schema describing
+// the data, and returns the problems found, ordered by position, so that
+// mismatches between a template and the data it will be given are found
+// before it is deployed:
+//
+//   - paths that cannot exist, because they name a property of an object
+//     whose schema has "additionalProperties": false and does not declare
+//     it, or a field of a value whose type is not an object or array;
+//   - {{range}} actions over a value typed as a string, boolean or null.
+//
+// The schema keywords understood are type, properties,
+// additionalProperties, items and $ref to a location in the same
+// document. Paths below a schema using other keywords to describe its
+// value, such as anyOf, and GJSON paths with queries or modifiers are not
+// checked. If schema is not a valid JSON object, the returned issue says so.
+func (t *Template) ValidateSchema(schema []byte) []Issue {
+	if !gjson.ValidBytes(schema) || !gjson.ParseBytes(schema).IsObject() {
+		return []Issue{{Location: t.Name(), Message: "schema is not a valid JSON object"}}
+	}
+	if t.Tree == nil || t.Root == nil {
+		return nil
+	}
+	v := &schemaValidator{doc: gjson.ParseBytes(schema)}
+	var found []lintIssue
+	seen := make(map[string]bool)
+	r := newRefs(t)
+	r.visit = func(n parse.Node, path string, ranged bool) {
+		msg := v.check(path, ranged)
+		location, _ := t.ErrorContext(n)
+		if msg == "" || seen[location+msg] {
+			return
+		}
+		seen[location+msg] = true
+		found = append(found, lintIssue{n.Position(), n, msg})
+	}
+	r.walk(t.Root, dataScope, dataScope)
+	slices.SortStableFunc(found, func(a, b lintIssue) int { return int(a.pos - b.pos) })
+	issues := make([]Issue, len(found))
+	for i, f := range found {
+		location, _ := t.ErrorContext(f.node)
+		issues[i] = Issue{Location: location, Message: f.msg}
+	}
+	return issues
+}
+
+// A schemaValidator resolves paths of the data in a JSON Schema.
+type schemaValidator struct {
+	doc gjson.Result // the schema document
+}
+
+// check returns a description of the problem with reading, or ranging
+// over, the value at path, or "" if there is none.
+func (v *schemaValidator) check(path string, ranged bool) string {
+	if path == "" && !ranged {
+		return ""
+	}
+	comps, ok := splitGJSONPath(path)
+	if !ok {
+		return ""
+	}
+	schema := v.doc
+	for i, comp := range comps {
+		schema = v.deref(schema)
+		types := schemaTypes(schema)
+		switch {
+		case types != nil && !slices.Contains(types, "object") && !slices.Contains(types, "array"):
+			return fmt.Sprintf("path %q cannot exist: %s has type %s", path, describePath(comps[:i]), strings.Join(types, " or "))
+		case comp == "#" || isIndex(comp):
+			if types != nil && !slices.Contains(types, "array") {
+				return fmt.Sprintf("path %q cannot exist: %s is an object, not an array", path, describePath(comps[:i]))
+			}
+			schema = schema.Get("items")
+		default:
+			prop := schema.Get("properties." + gjson.Escape(comp))
+			if prop.Exists() {
+				schema = prop
+				break
+			}
+			extra := schema.Get("additionalProperties")
+			if extra.Type == gjson.False && schema.Get("properties").Exists() {
+				return fmt.Sprintf("path %q cannot exist: %s has no property %q", path, describePath(comps[:i]), comp)
+			}
+			schema = extra
+		}
+		if !schema.IsObject() {
+			// Nothing is known of the value.
+			return ""
+		}
+	}
+	if !ranged {
+		return ""
+	}
+	types := schemaTypes(v.deref(schema))
+	for _, typ := range []string{"string", "boolean", "null"} {
+		if slices.Equal(types, []string{typ}) {
+			return fmt.Sprintf("range over %s, which has type %s", describePath(comps), typ)
+		}
+	}
+	return ""
+}
+
+// deref returns the schema referred to by the $ref of schema, if it has
+// one to a location in the same document.
+func (v *schemaValidator) deref(schema gjson.Result) gjson.Result {
+	for range 32 { // bounds cycles of references
+		ref := schema.Get(`\$ref`)
+		if ref.Type != gjson.String || !strings.HasPrefix(ref.Str, "#") {
+			return schema
+		}
+		pointer := strings.TrimPrefix(ref.Str, "#")
+		target, err := jsonPointer(pointer, v.doc)
+		if err != nil || !target.IsObject() {
+			return schema
+		}
+		schema = target
+	}
+	return schema
+}
+
+// schemaTypes returns the types a schema allows, or nil if it does not say.
+func schemaTypes(schema gjson.Result) []string {
+	typ := schema.Get("type")
+	switch {
+	case typ.Type == gjson.String:
+		return []string{typ.Str}
+	case typ.IsArray():
+		var types []string
+		for _, t := range typ.Array() {
+			types = append(types, t.String())
+		}
+		return types
+	}
+	return nil
+}
+
+// splitGJSONPath splits a GJSON path made only of keys, indexes and #
+// into its components. It reports false for paths using other syntax,
+// such as queries or modifiers.
+func splitGJSONPath(path string) ([]string, bool) {
+	var comps []string
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				b.WriteByte(path[i])
+			}
+		case '.':
+			comps = append(comps, b.String())
+			b.Reset()
+		case '*', '?', '@', '|', '(', ')', '[', ']', '{', '}', '!', '=', '<', '>', '%':
+			return nil, false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	comps = append(comps, b.String())
+	for i, comp := range comps {
+		if strings.HasPrefix(comp, "#") && comp != "#" || comp == "#" && i == len(comps)-1 {
+			return nil, false // a query, or the length of an array
+		}
+	}
+	return comps, true
+}
+
+// isIndex reports whether the path component is an array index, which
+// may be negative to count from the end.
+func isIndex(comp string) bool {
+	_, err := strconv.Atoi(comp)
+	return err == nil
+}
+
+// describePath returns a description of the value at the components of a
+// path, for messages.
+func describePath(comps []string) string {
+	if len(comps) == 0 {
+		return "the data"
+	}
+	return fmt.Sprintf("%q", strings.Join(comps, "."))
+}
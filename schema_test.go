@@ -0,0 +1,66 @@
+package gjson_template
+
+import (
+	"slices"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string"},
+		"tags": {"type": "array", "items": {"type": "string"}},
+		"user": {"$ref": "#/definitions/user"},
+		"meta": {"type": "object"},
+		"count": {"type": ["integer", "null"]}
+	},
+	"definitions": {
+		"user": {
+			"type": "object",
+			"additionalProperties": false,
+			"properties": {"email": {"type": "string"}, "roles": {"type": "array"}}
+		}
+	}
+}`
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		issues []string
+	}{
+		{"valid", `{{.name}}{{range .tags}}{{.}}{{end}}{{.user.email}}{{.meta.anything.below}}{{.tags.0}}`, nil},
+		{"unknown property", `{{.nmae}} {{.user.mail}}`, []string{
+			`unknown property:1:2: path "nmae" cannot exist: the data has no property "nmae"`,
+			`unknown property:1:17: path "user.mail" cannot exist: "user" has no property "mail"`,
+		}},
+		{"field of scalar", `{{.name.first}}{{.count.x}}`, []string{
+			`field of scalar:1:7: path "name.first" cannot exist: "name" has type string`,
+			`field of scalar:1:23: path "count.x" cannot exist: "count" has type integer or null`,
+		}},
+		{"range over string", `{{range .name}}{{.}}{{end}}{{range .tags}}{{.x}}{{end}}`, []string{
+			`range over string:1:8: range over "name", which has type string`,
+			`range over string:1:44: path "tags.#.x" cannot exist: "tags.#" has type string`,
+		}},
+		{"with", `{{with .user}}{{.roles}}{{.role}}{{end}}`, []string{
+			`with:1:26: path "user.role" cannot exist: "user" has no property "role"`,
+		}},
+		{"gjson", "{{gjson `user.nope`}}{{gjson `tags.#(==\"x\")`}}", []string{
+			`gjson:1:8: path "user.nope" cannot exist: "user" has no property "nope"`,
+		}},
+	}
+	for _, test := range tests {
+		tmpl := Must(New(test.name).Parse(test.input))
+		var got []string
+		for _, issue := range tmpl.ValidateSchema([]byte(testSchema)) {
+			got = append(got, issue.String())
+		}
+		if !slices.Equal(got, test.issues) {
+			t.Errorf("%s: expected\n\t%q\ngot\n\t%q", test.name, test.issues, got)
+		}
+	}
+	if issues := Must(New("x").Parse(`{{.a}}`)).ValidateSchema([]byte(`[1]`)); len(issues) != 1 {
+		t.Errorf("expected an issue for an invalid schema; got %v", issues)
+	}
+}
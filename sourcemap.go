@@ -0,0 +1,109 @@
+// This file contains the source maps relating output to template nodes.
+
+package gjson_template
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/higress-group/gjson_template/parse"
+)
+
+// A SourceSpan is a range of the output of an execution and the template
+// node that wrote it: a piece of template text or an action.
+type SourceSpan struct {
+	Start, End int    // byte offsets of the span in the output; End is exclusive
+	Template   string // name of the template containing the node
+	Location   string // location of the node, as "name:line:col" in error messages
+	Node       string // the text of the node, as in error messages
+}
+
+// A SourceMap lists the spans of an output in order. Output written by a
+// {{template}} invocation or within a {{try}} is attributed to the nodes
+// it comes from; output of a macro call or a capture variable is attributed
+// to the action printing it.
+type SourceMap []SourceSpan
+
+// Lookup returns the span containing the byte at offset in the output.
+func (m SourceMap) Lookup(offset int) (SourceSpan, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].End > offset })
+	if i < len(m) && m[i].Start <= offset {
+		return m[i], true
+	}
+	return SourceSpan{}, false
+}
+
+// ExecuteSourceMap is like [Template.Execute], but also returns the source
+// map of the output, so that a location in it, such as one reported by a
+// tool validating the output, can be traced back to the template. On
+// error, the map covers the output written so far. Templates with output
+// filters, which may change the output arbitrarily, are not supported.
+func (t *Template) ExecuteSourceMap(wr io.Writer, data []byte) (SourceMap, error) {
+	if t.hasFilters() {
+		return nil, fmt.Errorf("template: %s: source maps are not supported with output filters", t.Name())
+	}
+	m := &sourceMap{w: &countingWriter{w: wr}}
+	err := t.execute(m.w, data, execOptions{smap: m})
+	return m.spans, err
+}
+
+// A sourceMap records the spans of the output written to w.
+type sourceMap struct {
+	w     *countingWriter
+	spans SourceMap
+}
+
+// A countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// mapStart returns the offset in the output at which the next node
+// starts, or -1 if the output is not being mapped. Output written to a
+// buffer, such as that of a capture, is not.
+func (s *state) mapStart() int {
+	if m := s.smap; m != nil && s.wr == m.w {
+		return m.w.n
+	}
+	return -1
+}
+
+// mapEnd records the span of the output of node, which started at the
+// offset start returned by mapStart.
+func (s *state) mapEnd(node parse.Node, start int) {
+	if start < 0 || s.smap.w.n == start {
+		return
+	}
+	location, context := s.tmpl.ErrorContext(node)
+	s.smap.spans = append(s.smap.spans, SourceSpan{
+		Start:    start,
+		End:      s.smap.w.n,
+		Template: s.tmpl.Name(),
+		Location: location,
+		Node:     context,
+	})
+}
+
+// writeBuffered writes out, the buffered output of a {{try}} whose spans
+// were recorded in inner, if any, adding them to the source map.
+func (s *state) writeBuffered(out string, inner *sourceMap) {
+	m := s.smap
+	if inner != nil && m != nil && s.wr == m.w {
+		for _, span := range inner.spans {
+			span.Start += m.w.n
+			span.End += m.w.n
+			m.spans = append(m.spans, span)
+		}
+	}
+	if _, err := io.WriteString(s.wr, out); err != nil {
+		s.writeError(err)
+	}
+}
@@ -0,0 +1,76 @@
+package gjson_template
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExecuteSourceMap(t *testing.T) {
+	tmpl := Must(New("cfg").Parse(`{{define "port"}}port: {{.}}
+{{end}}name: {{.name}}
+{{template "port" .port}}{{try}}x{{fail "no"}}{{catch}}{{"fallback"}}{{end}}{{try}}[{{.name}}]{{end}}{{capture $c}}{{.name}}{{end}}{{$c}}`))
+	var buf bytes.Buffer
+	m, err := tmpl.ExecuteSourceMap(&buf, []byte(`{"name":"api","port":80}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if want := "name: api\nport: 80\nfallback[api]api"; out != want {
+		t.Fatalf("expected %q; got %q", want, out)
+	}
+	type span struct{ text, tmpl, loc, node string }
+	want := []span{
+		{"name: ", "cfg", "cfg:2:7", "name: "},
+		{"api", "cfg", "cfg:2:15", "{{.name}}"},
+		{"\n", "cfg", "cfg:2:22", "\n"},
+		{"port: ", "port", "cfg:1:17", "port: "},
+		{"80", "port", "cfg:1:25", "{{.}}"},
+		{"\n", "port", "cfg:1:28", "\n"},
+		{"fallback", "cfg", "cfg:3:57", `{{"fallback"}}`},
+		{"[", "cfg", "cfg:3:83", "["},
+		{"api", "cfg", "cfg:3:86", "{{.name}}"},
+		{"]", "cfg", "cfg:3:93", "]"},
+		{"api", "cfg", "cfg:3:133", "{{$c}}"},
+	}
+	if len(m) != len(want) {
+		t.Fatalf("expected %d spans; got %d: %+v", len(want), len(m), m)
+	}
+	end := 0
+	for i, s := range m {
+		got := span{out[s.Start:s.End], s.Template, s.Location, s.Node}
+		if got != want[i] {
+			t.Errorf("span %d: expected %+v; got %+v", i, want[i], got)
+		}
+		if s.Start != end {
+			t.Errorf("span %d: expected start %d; got %d", i, end, s.Start)
+		}
+		end = s.End
+	}
+	if end != len(out) {
+		t.Errorf("spans end at %d; output has %d bytes", end, len(out))
+	}
+
+	if s, ok := m.Lookup(strings.Index(out, "80") + 1); !ok || s.Node != "{{.}}" {
+		t.Errorf("Lookup: expected the {{.}} span; got %+v, %t", s, ok)
+	}
+	if _, ok := m.Lookup(len(out)); ok {
+		t.Errorf("Lookup past the end: expected no span")
+	}
+}
+
+func TestExecuteSourceMapError(t *testing.T) {
+	tmpl := Must(New("err").Option("missingkey=error").Parse(`a{{.a}}{{.b}}`))
+	var buf bytes.Buffer
+	m, err := tmpl.ExecuteSourceMap(&buf, []byte(`{"a":1}`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(m) != 2 || m[1].End != buf.Len() {
+		t.Errorf("expected spans of the output so far %q; got %+v", buf.String(), m)
+	}
+	tmpl = Must(New("filtered").AddOutputFilter(TrimTrailingSpace).Parse(`x`))
+	if _, err := tmpl.ExecuteSourceMap(&buf, []byte(`{}`)); err == nil {
+		t.Error("expected error with output filters")
+	}
+}
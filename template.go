@@ -7,22 +7,39 @@ package gjson_template
 import (
 	"maps"
 	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/higress-group/gjson_template/parse"
 )
 
 // common holds the information shared by related templates.
 type common struct {
-	tmpl   map[string]*Template // Map from name to defined templates.
-	muTmpl sync.RWMutex         // protects tmpl
+	// Map from name to defined templates. The map is replaced, never
+	// modified, so that lookups take no lock.
+	tmpl   atomic.Pointer[map[string]*Template]
+	muTmpl sync.Mutex // serializes the replacements of tmpl
 	option option
 	// We use two maps, one for parsing and one for execution.
 	// This separation makes the API cleaner since it doesn't
 	// expose reflection to the client.
-	muFuncs    sync.RWMutex // protects parseFuncs and execFuncs
+	muFuncs    sync.RWMutex // protects parseFuncs, execFuncs and typedFuncs
 	parseFuncs FuncMap
 	execFuncs  map[string]reflect.Value
+	typedFuncs map[string]typedFunc         // functions called without reflection
+	muCatalogs sync.RWMutex                 // protects catalogs
+	catalogs   map[string]map[string]string // messages by language, for the t builtin
+	filters    []OutputFilter               // applied to the output of executions
+	http       *HTTPConfig                  // enables httpGet; nil if disabled
+	fileRoot   string                       // directory of readFile; "" if disabled
+	observer   ExecObserver                 // notified of executions; may be nil
+	tracer     Tracer                       // traces executions; may be nil
+	loader     Loader                       // loads undefined templates; may be nil
+	separator  string                       // written between the documents of ExecuteEach
+	vars       []string                     // variables declared with DeclareVars, with their $
+	muLoad     sync.Mutex                   // protects loads
+	loads      map[string]*loadCall         // loads in progress, by template name
 }
 
 // Template is the representation of a parsed template. The *parse.Tree
@@ -34,6 +51,7 @@ type Template struct {
 	*common
 	leftDelim  string
 	rightDelim string
+	meta       *Metadata // front matter of the file, or nil
 }
 
 // New allocates a new, undefined template with the given name.
@@ -72,7 +90,6 @@ func (t *Template) New(name string) *Template {
 func (t *Template) init() {
 	if t.common == nil {
 		c := new(common)
-		c.tmpl = make(map[string]*Template)
 		c.parseFuncs = make(FuncMap)
 		c.execFuncs = make(map[string]reflect.Value)
 		t.common = c
@@ -91,21 +108,36 @@ func (t *Template) Clone() (*Template, error) {
 	if t.common == nil {
 		return nt, nil
 	}
-	t.muTmpl.RLock()
-	defer t.muTmpl.RUnlock()
-	for k, v := range t.tmpl {
+	tmpls := make(map[string]*Template)
+	for k, v := range t.templates() {
 		if k == t.name {
-			nt.tmpl[t.name] = nt
+			tmpls[t.name] = nt
 			continue
 		}
 		// The associated templates share nt's common structure.
-		tmpl := v.copy(nt.common)
-		nt.tmpl[k] = tmpl
+		tmpls[k] = v.copy(nt.common)
 	}
+	nt.tmpl.Store(&tmpls)
 	t.muFuncs.RLock()
 	defer t.muFuncs.RUnlock()
 	maps.Copy(nt.parseFuncs, t.parseFuncs)
 	maps.Copy(nt.execFuncs, t.execFuncs)
+	if t.typedFuncs != nil {
+		nt.typedFuncs = maps.Clone(t.typedFuncs)
+	}
+	nt.filters = slices.Clone(t.filters)
+	nt.http = t.http
+	nt.fileRoot = t.fileRoot
+	nt.observer = t.observer
+	nt.tracer = t.tracer
+	nt.loader = t.loader
+	nt.separator = t.separator
+	nt.vars = slices.Clone(t.vars)
+	t.muCatalogs.RLock()
+	defer t.muCatalogs.RUnlock()
+	for lang, messages := range t.catalogs {
+		nt.SetCatalog(lang, messages)
+	}
 	return nt, nil
 }
 
@@ -117,6 +149,7 @@ func (t *Template) copy(c *common) *Template {
 		common:     c,
 		leftDelim:  t.leftDelim,
 		rightDelim: t.rightDelim,
+		meta:       t.meta,
 	}
 }
 
@@ -133,7 +166,7 @@ func (t *Template) AddParseTree(name string, tree *parse.Tree) (*Template, error
 		nt = t.New(name)
 	}
 	// Even if nt == t, we need to install it in the common.tmpl map.
-	if t.associate(nt, tree) || nt.Tree == nil {
+	if !t.associate(nt, tree) && nt.Tree == nil {
 		nt.Tree = tree
 	}
 	return nt, nil
@@ -145,10 +178,9 @@ func (t *Template) Templates() []*Template {
 		return nil
 	}
 	// Return a slice so we don't expose the map.
-	t.muTmpl.RLock()
-	defer t.muTmpl.RUnlock()
-	m := make([]*Template, 0, len(t.tmpl))
-	for _, v := range t.tmpl {
+	tmpls := t.templates()
+	m := make([]*Template, 0, len(tmpls))
+	for _, v := range tmpls {
 		m = append(m, v)
 	}
 	return m
@@ -158,6 +190,8 @@ func (t *Template) Templates() []*Template {
 // subsequent calls to [Template.Parse], [Template.ParseFiles], or [Template.ParseGlob]. Nested template
 // definitions will inherit the settings. An empty delimiter stands for the
 // corresponding default: {{ or }}.
+// A template text may also change its own delimiters with a
+// {{delims "left" "right"}} action; see the package documentation.
 // The return value is the template, so calls can be chained.
 func (t *Template) Delims(left, right string) *Template {
 	t.init()
@@ -170,7 +204,8 @@ func (t *Template) Delims(left, right string) *Template {
 // It must be called before the template is parsed.
 // It panics if a value in the map is not a function with appropriate return
 // type or if the name cannot be used syntactically as a function in a template.
-// It is legal to overwrite elements of the map. The return value is the template,
+// It is legal to overwrite elements of the map, and functions named after
+// builtins take precedence over them. The return value is the template,
 // so calls can be chained.
 func (t *Template) Funcs(funcMap FuncMap) *Template {
 	t.init()
@@ -178,6 +213,9 @@ func (t *Template) Funcs(funcMap FuncMap) *Template {
 	defer t.muFuncs.Unlock()
 	addValueFuncs(t.execFuncs, funcMap)
 	addFuncs(t.parseFuncs, funcMap)
+	for name := range funcMap {
+		delete(t.typedFuncs, name)
+	}
 	return t
 }
 
@@ -187,9 +225,32 @@ func (t *Template) Lookup(name string) *Template {
 	if t.common == nil {
 		return nil
 	}
-	t.muTmpl.RLock()
-	defer t.muTmpl.RUnlock()
-	return t.tmpl[name]
+	return t.templates()[name]
+}
+
+// templates returns the map from name to defined templates. It must not be
+// modified.
+func (c *common) templates() map[string]*Template {
+	if m := c.tmpl.Load(); m != nil {
+		return *m
+	}
+	return nil
+}
+
+// LookupChain returns the first of the templates with the given names that
+// is associated with t and has a definition, so that overrides can take
+// precedence over defaults:
+//
+//	tmpl := set.LookupChain("tenant-x/email", "default/email")
+//
+// It returns nil if there is none.
+func (t *Template) LookupChain(names ...string) *Template {
+	for _, name := range names {
+		if tmpl := t.Lookup(name); tmpl != nil && tmpl.Tree != nil {
+			return tmpl
+		}
+	}
+	return nil
 }
 
 // Parse parses text as a template body for t.
@@ -202,11 +263,15 @@ func (t *Template) Lookup(name string) *Template {
 // is considered empty and will not replace an existing template's body.
 // This allows using Parse to add new named template definitions without
 // overwriting the main template body.
+//
+// Calls of printf with a constant format string are checked: the number of
+// arguments must match the verbs of the format, and constant arguments
+// must suit their verbs, so that mistakes such as {{printf "%d" "x"}} are
+// reported by Parse rather than printed as %!d(string=x). Calls of a
+// printf or sprintf added with Funcs before parsing are not checked.
 func (t *Template) Parse(text string) (*Template, error) {
 	t.init()
-	t.muFuncs.RLock()
-	trees, err := parse.Parse(t.name, text, t.leftDelim, t.rightDelim, t.parseFuncs, builtins())
-	t.muFuncs.RUnlock()
+	trees, err := t.parseTrees(t.name, text)
 	if err != nil {
 		return nil, err
 	}
@@ -219,18 +284,52 @@ func (t *Template) Parse(text string) (*Template, error) {
 	return t, nil
 }
 
+// parseTrees parses text as the body of the template with the given name,
+// with the delimiters, functions and options of t, and returns the trees
+// it defines, without associating them with t.
+func (t *Template) parseTrees(name, text string) (map[string]*parse.Tree, error) {
+	t.muFuncs.RLock()
+	trees := make(map[string]*parse.Tree)
+	tree := parse.New(name)
+	tree.Mode = t.option.parseMode()
+	tree.Vars = t.vars
+	_, err := tree.Parse(text, t.leftDelim, t.rightDelim, trees, t.parseFuncs, builtins())
+	added := func(name string) bool { return t.parseFuncs[name] != nil }
+	defer t.muFuncs.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, tree := range trees {
+		if err := checkTree(tree, added); err != nil {
+			return nil, err
+		}
+	}
+	return trees, nil
+}
+
 // associate installs the new template into the group of templates associated
 // with t. The two are already known to share the common structure.
-// The boolean return value reports whether to store this tree as t.Tree.
+// The boolean return value reports whether the tree was stored as new.Tree,
+// which is done before new is installed so that concurrent lookups find it
+// complete.
 func (t *Template) associate(new *Template, tree *parse.Tree) bool {
 	if new.common != t.common {
 		panic("internal error: associate not common")
 	}
-	if old := t.tmpl[new.name]; old != nil && parse.IsEmptyTree(tree.Root) && old.Tree != nil {
+	tmpls := t.templates()
+	if old := tmpls[new.name]; old != nil && parse.IsEmptyTree(tree.Root) && old.Tree != nil {
 		// If a template by that name exists,
 		// don't replace it with an empty template.
 		return false
 	}
-	t.tmpl[new.name] = new
+	new.Tree = tree
+	if tmpls[new.name] != new {
+		tmpls = maps.Clone(tmpls)
+		if tmpls == nil {
+			tmpls = make(map[string]*Template)
+		}
+		tmpls[new.name] = new
+		t.tmpl.Store(&tmpls)
+	}
 	return true
 }
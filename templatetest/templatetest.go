@@ -0,0 +1,165 @@
+// Package templatetest provides golden-file tests of templates: a template
+// is executed with each fixture JSON file and its output compared with
+// the golden file next to the fixture.
+//
+//	func TestInvoice(t *testing.T) {
+//		tmpl := template.Must(template.ParseFiles("invoice.tmpl"))
+//		templatetest.Golden(t, tmpl, "testdata/invoice/*.json", templatetest.Options{})
+//	}
+//
+// The golden file of testdata/invoice/paid.json is
+// testdata/invoice/paid.golden. Running the tests with
+//
+//	go test -templatetest.update
+//
+// writes the outputs to the golden files instead of comparing them.
+package templatetest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	template "github.com/higress-group/gjson_template"
+)
+
+var update = flag.Bool("templatetest.update", false, "write template outputs to golden files")
+
+// Options configures Golden.
+type Options struct {
+	// Template is the name of the template to execute; the template given
+	// to Golden if empty.
+	Template string
+
+	// Update writes the outputs to the golden files instead of comparing
+	// them, as the -templatetest.update flag does.
+	Update bool
+
+	// TrimSpace ignores leading and trailing white space of the output.
+	TrimSpace bool
+
+	// TrimLines ignores trailing white space at the end of lines, and
+	// \r\n line endings.
+	TrimLines bool
+
+	// CollapseSpace ignores differences in white space: runs of white
+	// space compare equal to a single space. It implies TrimSpace.
+	CollapseSpace bool
+}
+
+// Golden executes tmpl with each JSON file matching the glob pattern
+// fixtures and compares the output with the golden file, the file with the
+// same name and the extension .golden instead of .json, in a subtest named
+// after the fixture. A missing golden file is an error unless updating.
+func Golden(t *testing.T, tmpl *template.Template, fixtures string, opts Options) {
+	t.Helper()
+	files, err := filepath.Glob(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("templatetest: pattern matches no files: %#q", fixtures)
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), func(t *testing.T) {
+			t.Helper()
+			if err := check(tmpl, file, opts); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// GoldenFile returns the name of the golden file of the fixture file.
+func GoldenFile(fixture string) string {
+	return strings.TrimSuffix(fixture, filepath.Ext(fixture)) + ".golden"
+}
+
+// Render executes tmpl, or the template of tmpl named by opts.Template,
+// with the JSON data of the fixture file, and returns the output.
+func Render(tmpl *template.Template, fixture string, opts Options) (string, error) {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if opts.Template != "" {
+		err = tmpl.ExecuteTemplate(&buf, opts.Template, data)
+	} else {
+		err = tmpl.Execute(&buf, data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", fixture, err)
+	}
+	return buf.String(), nil
+}
+
+// check renders the fixture and compares the output with its golden file,
+// or updates the golden file.
+func check(tmpl *template.Template, fixture string, opts Options) error {
+	got, err := Render(tmpl, fixture, opts)
+	if err != nil {
+		return err
+	}
+	golden := GoldenFile(fixture)
+	if opts.Update || *update {
+		return os.WriteFile(golden, []byte(got), 0o644)
+	}
+	b, err := os.ReadFile(golden)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s: missing golden file; run the test with -templatetest.update to create it", golden)
+	}
+	if err != nil {
+		return err
+	}
+	return compare(golden, string(b), got, opts)
+}
+
+// compare returns an error describing the first difference between the
+// golden output want and the output got, once normalized according to
+// opts, or nil if there is none.
+func compare(golden, want, got string, opts Options) error {
+	want, got = normalize(want, opts), normalize(got, opts)
+	if want == got {
+		return nil
+	}
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	i := 0
+	for i < len(wantLines) && i < len(gotLines) && wantLines[i] == gotLines[i] {
+		i++
+	}
+	line := func(lines []string) string {
+		if i < len(lines) {
+			return fmt.Sprintf("%q", lines[i])
+		}
+		return "end of output"
+	}
+	return fmt.Errorf("%s:%d: output differs from golden file\n\twant: %s\n\tgot:  %s", golden, i+1, line(wantLines), line(gotLines))
+}
+
+// spaces matches runs of white space.
+var spaces = regexp.MustCompile(`\s+`)
+
+// normalize returns s normalized according to opts.
+func normalize(s string, opts Options) string {
+	if opts.TrimLines {
+		lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t\r")
+		}
+		s = strings.Join(lines, "\n")
+	}
+	if opts.CollapseSpace {
+		s = spaces.ReplaceAllString(s, " ")
+	}
+	if opts.TrimSpace || opts.CollapseSpace {
+		s = strings.TrimSpace(s)
+	}
+	return s
+}
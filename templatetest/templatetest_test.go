@@ -0,0 +1,80 @@
+package templatetest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	template "github.com/higress-group/gjson_template"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"ada.json":    `{"name":"Ada"}`,
+		"ada.golden":  "Hello Ada!\n",
+		"bob.json":    `{"name":"Bob"}`,
+		"bob.golden":  "Hello Bob!\n",
+		"other.json":  `{"name":"Eve"}`,
+		"ignored.txt": "not a fixture",
+	})
+	tmpl := template.Must(template.New("greet").Parse("Hello {{.name}}!\n{{define \"short\"}}{{.name}}{{end}}"))
+	Golden(t, tmpl, filepath.Join(dir, "[ab]*.json"), Options{})
+
+	other := filepath.Join(dir, "other.json")
+	if err := check(tmpl, other, Options{}); err == nil || !strings.Contains(err.Error(), "missing golden file") {
+		t.Errorf("expected missing golden file error; got %v", err)
+	}
+	if err := check(tmpl, other, Options{Template: "short", Update: true}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(GoldenFile(other)); err != nil || string(b) != "Eve" {
+		t.Errorf("expected updated golden file %q; got %q, %v", "Eve", b, err)
+	}
+	if err := check(tmpl, other, Options{Template: "short"}); err != nil {
+		t.Errorf("after update: %v", err)
+	}
+	err := check(tmpl, other, Options{})
+	if err == nil || !strings.Contains(err.Error(), `other.golden:1: output differs`) || !strings.Contains(err.Error(), `"Hello Eve!"`) {
+		t.Errorf("expected difference on line 1; got %v", err)
+	}
+	if err := check(tmpl, filepath.Join(dir, "missing.json"), Options{}); err == nil {
+		t.Error("expected error for missing fixture")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		want, got string
+		opts      Options
+		ok        bool
+	}{
+		{"a\nb\n", "a\nb\n", Options{}, true},
+		{"a\nb\n", "a\nb", Options{}, false},
+		{"a\nb\n", "a\nb", Options{TrimSpace: true}, true},
+		{"a  \nb\n", "a\r\nb\n", Options{TrimLines: true}, true},
+		{"a  \nb\n", "a\nb\n", Options{}, false},
+		{"<p>\n  a  b\n</p>", "<p> a b </p>\n", Options{CollapseSpace: true}, true},
+		{"a b", "ab", Options{CollapseSpace: true}, false},
+	}
+	for _, test := range tests {
+		err := compare("x.golden", test.want, test.got, test.opts)
+		if (err == nil) != test.ok {
+			t.Errorf("compare(%q, %q, %+v) = %v", test.want, test.got, test.opts, err)
+		}
+	}
+	err := compare("x.golden", "a\nb\nc", "a\nb", Options{})
+	if err == nil || !strings.Contains(err.Error(), "x.golden:3:") || !strings.Contains(err.Error(), "end of output") {
+		t.Errorf("expected difference at line 3; got %v", err)
+	}
+}
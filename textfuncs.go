@@ -0,0 +1,543 @@
+// This file contains the builtins that format text for human readers.
+
+package gjson_template
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/tidwall/gjson"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+	"golang.org/x/text/unicode/norm"
+)
+
+// joinNatural joins the elements of the array arr as in an English list,
+// "a, b and c". The optional arguments override the separator (default ",")
+// and the word before the last element (default " and "). A space is
+// written after the separator unless it already ends in white space.
+func joinNatural(arr gjson.Result, seps ...string) (string, error) {
+	arr = asJSON(arr)
+	if !arr.Exists() || arr.Type == gjson.Null {
+		return "", nil
+	}
+	if !arr.IsArray() {
+		return "", fmt.Errorf("joinNatural of non-array %s", describe(arr))
+	}
+	if len(seps) > 2 {
+		return "", fmt.Errorf("joinNatural takes at most 2 separators; got %d", len(seps))
+	}
+	sep, last := ",", " and "
+	if len(seps) > 0 {
+		sep = seps[0]
+	}
+	if len(seps) > 1 {
+		last = seps[1]
+	}
+	if sep == "" || !unicode.IsSpace(rune(sep[len(sep)-1])) {
+		sep += " "
+	}
+	elems := arr.Array()
+	var b strings.Builder
+	for i, elem := range elems {
+		switch {
+		case i == 0:
+		case i == len(elems)-1:
+			b.WriteString(last)
+		default:
+			b.WriteString(sep)
+		}
+		s, _ := gjsonPrintableValue(elem)
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+// numberFormat formats the number value with the digit grouping and decimal
+// mark of locale, a BCP 47 language tag such as "en-US" or "de". The
+// optional decimals argument fixes the number of fraction digits; by
+// default as many are written as needed to represent the value.
+func numberFormat(value gjson.Result, locale string, decimals ...int) (string, error) {
+	if value.Type == gjson.String {
+		value = gjson.Parse(strings.TrimSpace(value.Str))
+	}
+	if value.Type != gjson.Number {
+		return "", fmt.Errorf("numberFormat of non-number %s", describe(value))
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", fmt.Errorf("numberFormat: invalid locale %q", locale)
+	}
+	if len(decimals) > 1 {
+		return "", fmt.Errorf("numberFormat takes at most one decimals argument; got %d", len(decimals))
+	}
+	digits := 0
+	if len(decimals) == 1 {
+		digits = decimals[0]
+		if digits < 0 {
+			return "", fmt.Errorf("numberFormat: negative decimals %d", digits)
+		}
+	} else if _, frac, ok := strings.Cut(strconv.FormatFloat(value.Num, 'f', -1, 64), "."); ok {
+		digits = len(frac)
+	}
+	p := message.NewPrinter(tag)
+	return p.Sprint(number.Decimal(value.Num, number.MinFractionDigits(digits), number.MaxFractionDigits(digits))), nil
+}
+
+// padLeft pads the textual representation of value on the left to width
+// characters with fill, a single character defaulting to a space. Values
+// that are already at least width characters long are returned unchanged.
+// Padding beyond maxRepeatLen bytes is an ErrBudgetExceeded error.
+func padLeft(value gjson.Result, width int, fill ...string) (string, error) {
+	return pad("padLeft", value, width, fill, true)
+}
+
+// padRight is like padLeft but pads on the right.
+func padRight(value gjson.Result, width int, fill ...string) (string, error) {
+	return pad("padRight", value, width, fill, false)
+}
+
+func pad(name string, value gjson.Result, width int, fill []string, left bool) (string, error) {
+	if len(fill) > 1 {
+		return "", fmt.Errorf("%s takes at most one fill argument; got %d", name, len(fill))
+	}
+	c := " "
+	if len(fill) == 1 {
+		c = fill[0]
+		if utf8.RuneCountInString(c) != 1 {
+			return "", fmt.Errorf("%s fill must be a single character; got %q", name, c)
+		}
+	}
+	s, _ := gjsonPrintableValue(value)
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return s, nil
+	}
+	if n > maxRepeatLen/len(c) {
+		return "", errorOfKind(ErrBudgetExceeded, fmt.Errorf("%s padding would exceed %d bytes", name, maxRepeatLen))
+	}
+	if left {
+		return strings.Repeat(c, n) + s, nil
+	}
+	return s + strings.Repeat(c, n), nil
+}
+
+// wordwrap wraps the text of value so that no line is longer than width
+// characters, breaking lines at white space. Existing line breaks are kept,
+// and words longer than width are placed on a line of their own.
+func wordwrap(value gjson.Result, width int) (string, error) {
+	if width < 1 {
+		return "", fmt.Errorf("wordwrap width must be positive; got %d", width)
+	}
+	s, _ := gjsonPrintableValue(value)
+	var b strings.Builder
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		n := 0 // length of the current output line
+		for _, word := range strings.Fields(line) {
+			w := utf8.RuneCountInString(word)
+			switch {
+			case n == 0:
+			case n+1+w > width:
+				b.WriteByte('\n')
+				n = 0
+			default:
+				b.WriteByte(' ')
+				n++
+			}
+			b.WriteString(word)
+			n += w
+		}
+	}
+	return b.String(), nil
+}
+
+// table renders the array of objects arr as a text table with the given
+// columns, a comma-separated list of GJSON paths into each object that
+// also serve as headers. Columns holding only numbers are aligned right,
+// the others left. The options are "markdown", for a Markdown table rather
+// than one aligned with spaces, and "maxwidth=N", which shortens cells
+// longer than N characters with an ellipsis.
+func table(arr gjson.Result, columns string, opts ...string) (string, error) {
+	arr = asJSON(arr)
+	if arr.Exists() && !arr.IsArray() {
+		return "", fmt.Errorf("table of non-array %s", describe(arr))
+	}
+	markdown, maxWidth := false, 0
+	for _, opt := range opts {
+		switch key, value, _ := strings.Cut(opt, "="); key {
+		case "markdown":
+			markdown = true
+			continue
+		case "maxwidth":
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				maxWidth = n
+				continue
+			}
+		}
+		return "", fmt.Errorf("table: invalid option %q", opt)
+	}
+	var paths []string
+	for _, c := range strings.Split(columns, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			paths = append(paths, c)
+		}
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("table needs at least one column")
+	}
+	cell := func(s string) string {
+		s = strings.Join(strings.Fields(s), " ")
+		if markdown {
+			s = strings.ReplaceAll(s, "|", `\|`)
+		}
+		if maxWidth > 0 && utf8.RuneCountInString(s) > maxWidth {
+			s = string([]rune(s)[:maxWidth-1]) + "…"
+		}
+		return s
+	}
+	rows := [][]string{make([]string, len(paths))}
+	for i, p := range paths {
+		rows[0][i] = cell(p)
+	}
+	numeric := make([]bool, len(paths))
+	for i := range numeric {
+		numeric[i] = arr.Exists()
+	}
+	arr.ForEach(func(_, elem gjson.Result) bool {
+		row := make([]string, len(paths))
+		for i, p := range paths {
+			v := elem.Get(p)
+			if v.Exists() && v.Type != gjson.Number {
+				numeric[i] = false
+			}
+			s, _ := gjsonPrintableValue(v)
+			if !v.Exists() {
+				s = ""
+			}
+			row[i] = cell(s)
+		}
+		rows = append(rows, row)
+		return true
+	})
+	widths := make([]int, len(paths))
+	for _, row := range rows {
+		for i, s := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(s))
+		}
+	}
+	if markdown {
+		for i := range widths {
+			widths[i] = max(widths[i], 3)
+		}
+	}
+	var b strings.Builder
+	writeRow := func(row []string) {
+		var line strings.Builder
+		if markdown {
+			line.WriteString("|")
+		}
+		for i, s := range row {
+			switch {
+			case markdown:
+				line.WriteByte(' ')
+			case i > 0:
+				line.WriteString("  ")
+			}
+			n := widths[i] - utf8.RuneCountInString(s)
+			if numeric[i] {
+				line.WriteString(strings.Repeat(" ", n))
+				line.WriteString(s)
+			} else {
+				line.WriteString(s)
+				line.WriteString(strings.Repeat(" ", n))
+			}
+			if markdown {
+				line.WriteString(" |")
+			}
+		}
+		b.WriteString(strings.TrimRight(line.String(), " "))
+		b.WriteByte('\n')
+	}
+	writeRow(rows[0])
+	rule := make([]string, len(paths))
+	for i, w := range widths {
+		rule[i] = strings.Repeat("-", w)
+		if markdown && numeric[i] {
+			rule[i] = rule[i][1:] + ":"
+		}
+	}
+	writeRow(rule)
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return b.String(), nil
+}
+
+// mask partially masks the textual representation of value, a piece of
+// personal data, according to kind: "email" keeps the first character of
+// the local part and the domain, as in j***@example.com; "phone" keeps the
+// last 4 digits, and "card" the last 4 digits of a card number, replacing
+// the other digits with '*' and keeping separators. Any other kind is a
+// regular expression whose matches are replaced with '*'.
+func mask(value gjson.Result, kind string) (string, error) {
+	s, _ := gjsonPrintableValue(value)
+	if !value.Exists() || value.Type == gjson.Null {
+		return "", nil
+	}
+	switch kind {
+	case "email":
+		local, domain, ok := strings.Cut(s, "@")
+		if local == "" {
+			return s, nil
+		}
+		r, _ := utf8.DecodeRuneInString(local)
+		if !ok {
+			return string(r) + "***", nil
+		}
+		return string(r) + "***@" + domain, nil
+	case "phone", "card":
+		return maskDigits(s, 4), nil
+	}
+	re, err := maskPattern(kind)
+	if err != nil {
+		return "", fmt.Errorf("mask: invalid kind or pattern %q: %v", kind, err)
+	}
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(m))
+	}), nil
+}
+
+// maskDigits replaces all digits of s but the last keep with '*'.
+func maskDigits(s string, keep int) string {
+	n := 0
+	for _, r := range s {
+		if '0' <= r && r <= '9' {
+			n++
+		}
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if '0' <= r && r <= '9' {
+			if n > keep {
+				r = '*'
+			}
+			n--
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// maskPatterns holds the compiled custom patterns of mask, keyed by their
+// source. It is bounded, since patterns may be computed from the data.
+var maskPatterns = newLRUCache[*regexp.Regexp](256)
+
+func maskPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := maskPatterns.get(pattern); ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	maskPatterns.add(pattern, re)
+	return re, nil
+}
+
+// normalize returns the textual representation of value in the Unicode
+// normalization form given, "NFC" by default, or "NFD", "NFKC" or "NFKD",
+// so that strings spelled with different code points compare equal.
+func normalize(value gjson.Result, form ...string) (string, error) {
+	if len(form) > 1 {
+		return "", fmt.Errorf("normalize takes at most 1 form; got %d", len(form))
+	}
+	f := norm.NFC
+	if len(form) == 1 {
+		switch strings.ToUpper(form[0]) {
+		case "NFC":
+		case "NFD":
+			f = norm.NFD
+		case "NFKC":
+			f = norm.NFKC
+		case "NFKD":
+			f = norm.NFKD
+		default:
+			return "", fmt.Errorf("normalize: unknown form %q", form[0])
+		}
+	}
+	s, _ := gjsonPrintableValue(value)
+	if !value.Exists() || value.Type == gjson.Null {
+		return "", nil
+	}
+	return f.String(s), nil
+}
+
+// deaccent returns the textual representation of value with diacritics
+// removed, as in "Crème Brûlée" to "Creme Brulee", for stable slugs and
+// identifiers. Letters that do not decompose into a base letter and marks,
+// such as 'ß' and 'ø', are replaced by their usual ASCII spelling.
+func deaccent(value gjson.Result) string {
+	s, _ := gjsonPrintableValue(value)
+	if !value.Exists() || value.Type == gjson.Null {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if t, ok := deaccentLetters[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return norm.NFC.String(b.String())
+}
+
+// deaccentLetters holds the ASCII spelling of the Latin letters without a
+// canonical decomposition.
+var deaccentLetters = map[rune]string{
+	'ß': "ss", 'ẞ': "SS",
+	'æ': "ae", 'Æ': "AE",
+	'œ': "oe", 'Œ': "OE",
+	'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D",
+	'ð': "d", 'Ð': "D",
+	'ł': "l", 'Ł': "L",
+	'þ': "th", 'Þ': "TH",
+	'ı': "i", 'ħ': "h", 'Ħ': "H",
+}
+
+// titleLocale returns the textual representation of value with the first
+// letter of each word in title case and the others in lower case, following
+// the rules of locale, a BCP 47 language tag such as "tr" or "nl": in
+// Turkish, "istanbul" becomes "İstanbul", and in Dutch, "ijssel" becomes
+// "IJssel".
+func titleLocale(value gjson.Result, locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", fmt.Errorf("titleLocale: invalid locale %q", locale)
+	}
+	s, _ := gjsonPrintableValue(value)
+	if !value.Exists() || value.Type == gjson.Null {
+		return "", nil
+	}
+	return cases.Title(tag).String(s), nil
+}
+
+// levenshtein returns the edit distance between the textual
+// representations of a and b: the number of characters to insert, delete or
+// substitute to turn one into the other.
+func levenshtein(a, b gjson.Result) int {
+	return editDistance(textOf(a), textOf(b))
+}
+
+// similarity returns how similar the textual representations of a and b
+// are, from 0 for nothing in common to 1 for equal strings: 1 minus their
+// edit distance divided by the length of the longer one.
+func similarity(a, b gjson.Result) float64 {
+	s, t := []rune(textOf(a)), []rune(textOf(b))
+	n := max(len(s), len(t))
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(string(s), string(t)))/float64(n)
+}
+
+// textOf returns the textual representation of v, or "" if v is missing or
+// null.
+func textOf(v gjson.Result) string {
+	if !v.Exists() || v.Type == gjson.Null {
+		return ""
+	}
+	s, _ := gjsonPrintableValue(v)
+	return s
+}
+
+// editDistance returns the Levenshtein distance between s and t, counted in
+// characters.
+func editDistance(s, t string) int {
+	a, b := []rune(s), []rune(t)
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i, r := range a {
+		prev := row[0]
+		row[0] = i + 1
+		for j, q := range b {
+			cost := 1
+			if r == q {
+				cost = 0
+			}
+			prev, row[j+1] = row[j+1], min(row[j+1]+1, row[j]+1, prev+cost)
+		}
+	}
+	return row[len(b)]
+}
+
+// repeat returns the textual representation of value repeated count times.
+// The count is the second argument, as in "repeat str n", unless it is not
+// a number and the first is, so that Sprig's "repeat n str" works too; if
+// both are numbers, the second is the count.
+func repeat(value, count gjson.Result) (string, error) {
+	if count.Type != gjson.Number && value.Type == gjson.Number {
+		value, count = count, value
+	}
+	if count.Type != gjson.Number || count.Num != float64(int(count.Num)) {
+		return "", fmt.Errorf("repeat count must be an integer; got %s", describe(count))
+	}
+	n := int(count.Num)
+	if n < 0 {
+		return "", fmt.Errorf("repeat count must not be negative; got %d", n)
+	}
+	s, _ := gjsonPrintableValue(value)
+	if len(s) > 0 && n > maxRepeatLen/len(s) {
+		return "", errorOfKind(ErrBudgetExceeded, fmt.Errorf("repeat result would exceed %d bytes", maxRepeatLen))
+	}
+	return strings.Repeat(s, n), nil
+}
+
+// maxRepeatLen bounds the output of repeat, and the padding of padLeft and
+// padRight, so that a stray count or width in the data cannot exhaust
+// memory.
+const maxRepeatLen = 1 << 20
+
+// semverCompare reports whether version satisfies the semantic version
+// constraint, such as ">=1.2.x" or "^2.0". Unlike Sprig's function of the
+// same name, which it replaces, version may also be a JSON number like 2 or
+// 1.5, as often found in configuration data.
+func semverCompare(constraint string, version gjson.Result) (bool, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	var s string
+	switch version.Type {
+	case gjson.String:
+		s = version.Str
+	case gjson.Number:
+		s = version.Raw
+	default:
+		return false, fmt.Errorf("semverCompare of non-version %s", describe(version))
+	}
+	v, err := semver.NewVersion(s)
+	if err != nil {
+		return false, err
+	}
+	return c.Check(v), nil
+}
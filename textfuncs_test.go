@@ -0,0 +1,157 @@
+package gjson_template
+
+import "testing"
+
+// JSON data for the text formatting builtins
+var textFuncsTestJSON = []byte(`{
+	"names": ["Alice", "Bob", "Carol"],
+	"pair": ["Alice", "Bob"],
+	"one": ["Alice"],
+	"none": [],
+	"mixed": [1, true, "x"],
+	"amount": 1234567.89,
+	"count": 1234567,
+	"small": -0.5,
+	"numstr": "9876.5",
+	"id": 42,
+	"city": "Zürich",
+	"text": "The quick brown fox jumps over the lazy dog",
+	"paragraphs": "First line here\nsecond  line is a bit longer",
+	"version": "v1.4.2",
+	"apiVersion": 2,
+	"email": "jane.doe@example.com",
+	"phone": "+1 (555) 123-4567",
+	"card": "4111 1111 1111 1111",
+	"people": [
+		{"name": "Ada Lovelace", "age": 36, "city": {"name": "London"}},
+		{"name": "Bob|Jr", "age": 7},
+		{"name": "Carol\nAnn", "age": 101, "city": {"name": "Zürich"}}
+	]
+}`)
+
+var textFuncsTests = []gjsonExecTest{
+	// joinNatural
+	{"joinNatural", `{{joinNatural .names "," " and "}}`, "Alice, Bob and Carol", textFuncsTestJSON, true},
+	{"joinNatural defaults", `{{joinNatural .names}}`, "Alice, Bob and Carol", textFuncsTestJSON, true},
+	{"joinNatural oxford", `{{joinNatural .names ", " ", and "}}`, "Alice, Bob, and Carol", textFuncsTestJSON, true},
+	{"joinNatural pair", `{{joinNatural .pair "," " or "}}`, "Alice or Bob", textFuncsTestJSON, true},
+	{"joinNatural one", `{{joinNatural .one}}`, "Alice", textFuncsTestJSON, true},
+	{"joinNatural empty", `{{joinNatural .none}}`, "", textFuncsTestJSON, true},
+	{"joinNatural missing", `{{joinNatural .nope}}`, "", textFuncsTestJSON, true},
+	{"joinNatural mixed", `{{joinNatural .mixed ";"}}`, "1; true and x", textFuncsTestJSON, true},
+	{"joinNatural pipeline", `{{.names | joinNatural}}`, "Alice, Bob and Carol", textFuncsTestJSON, true},
+	{"joinNatural non-array", `{{joinNatural "abc"}}`, "", textFuncsTestJSON, false},
+
+	// numberFormat
+	{"numberFormat en", `{{numberFormat .amount "en-US"}}`, "1,234,567.89", textFuncsTestJSON, true},
+	{"numberFormat de", `{{numberFormat .amount "de-DE"}}`, "1.234.567,89", textFuncsTestJSON, true},
+	{"numberFormat fr", `{{numberFormat .amount "fr"}}`, "1\u00a0234\u00a0567,89", textFuncsTestJSON, true},
+	{"numberFormat en-IN", `{{numberFormat .count "en-IN"}}`, "12,34,567", textFuncsTestJSON, true},
+	{"numberFormat decimals", `{{numberFormat .count "en" 2}}`, "1,234,567.00", textFuncsTestJSON, true},
+	{"numberFormat rounding", `{{numberFormat .amount "de" 1}}`, "1.234.567,9", textFuncsTestJSON, true},
+	{"numberFormat negative", `{{numberFormat .small "de"}}`, "-0,5", textFuncsTestJSON, true},
+	{"numberFormat string", `{{numberFormat .numstr "de"}}`, "9.876,5", textFuncsTestJSON, true},
+	{"numberFormat non-number", `{{numberFormat .names "en"}}`, "", textFuncsTestJSON, false},
+	{"numberFormat bad locale", `{{numberFormat .amount "not a locale"}}`, "", textFuncsTestJSON, false},
+
+	// padLeft, padRight
+	{"padLeft", `[{{padLeft .city 8}}]`, "[  Zürich]", textFuncsTestJSON, true},
+	{"padRight", `[{{padRight .city 8}}]`, "[Zürich  ]", textFuncsTestJSON, true},
+	{"padLeft zeros", `{{padLeft .id 6 "0"}}`, "000042", textFuncsTestJSON, true},
+	{"padRight dots", `{{padRight "Total" 10 "."}}`, "Total.....", textFuncsTestJSON, true},
+	{"padLeft unicode fill", `{{padLeft .id 4 "·"}}`, "··42", textFuncsTestJSON, true},
+	{"padLeft too long", `{{padLeft .city 3}}`, "Zürich", textFuncsTestJSON, true},
+	{"padLeft missing", `[{{padLeft .nope 3}}]`, "[   ]", textFuncsTestJSON, true},
+	{"padLeft bad fill", `{{padLeft .id 6 "ab"}}`, "", textFuncsTestJSON, false},
+	{"padLeft too wide", `{{padLeft .id 2000000000}}`, "", textFuncsTestJSON, false},
+	{"padRight too wide", `{{padRight .id 2000000 "·"}}`, "", textFuncsTestJSON, false},
+
+	// wordwrap
+	{"wordwrap", `{{wordwrap .text 15}}`, "The quick brown\nfox jumps over\nthe lazy dog", textFuncsTestJSON, true},
+	{"wordwrap wide", `{{wordwrap .text 100}}`, "The quick brown fox jumps over the lazy dog", textFuncsTestJSON, true},
+	{"wordwrap long word", `{{wordwrap "a supercalifragilistic b" 5}}`, "a\nsupercalifragilistic\nb", textFuncsTestJSON, true},
+	{"wordwrap newlines", `{{wordwrap .paragraphs 12}}`, "First line\nhere\nsecond line\nis a bit\nlonger", textFuncsTestJSON, true},
+	{"wordwrap bad width", `{{wordwrap .text 0}}`, "", textFuncsTestJSON, false},
+
+	// repeat
+	{"repeat", `{{repeat "-" 5}}`, "-----", textFuncsTestJSON, true},
+	{"repeat sprig order", `{{repeat 3 "ab"}}`, "ababab", textFuncsTestJSON, true},
+	{"repeat pipeline", `{{"=" | repeat 4}}`, "====", textFuncsTestJSON, true},
+	{"repeat numbers", `{{repeat .id 2}}`, "4242", textFuncsTestJSON, true},
+	{"repeat numbers sprig order", `{{repeat 2 .id}}`, "222222222222222222222222222222222222222222", textFuncsTestJSON, true},
+	{"repeat zero", `[{{repeat .city 0}}]`, "[]", textFuncsTestJSON, true},
+	{"repeat negative", `{{repeat "x" -1}}`, "", textFuncsTestJSON, false},
+	{"repeat non-integer", `{{repeat "x" "y"}}`, "", textFuncsTestJSON, false},
+	{"repeat too long", `{{repeat "x" 100000000}}`, "", textFuncsTestJSON, false},
+
+	// semverCompare
+	{"semverCompare", `{{semverCompare ">=1.2.x" .version}}`, "true", textFuncsTestJSON, true},
+	{"semverCompare if", `{{if semverCompare "^1.5" .version}}new{{else}}old{{end}}`, "old", textFuncsTestJSON, true},
+	{"semverCompare range", `{{semverCompare ">=1.0, <2.0" .version}}`, "true", textFuncsTestJSON, true},
+	{"semverCompare number", `{{semverCompare "~2" .apiVersion}}`, "true", textFuncsTestJSON, true},
+	{"semverCompare pipeline", `{{.version | semverCompare "<1.4.2"}}`, "false", textFuncsTestJSON, true},
+	{"semverCompare bad version", `{{semverCompare ">1" .city}}`, "", textFuncsTestJSON, false},
+	{"semverCompare bad constraint", `{{semverCompare "=>>1" .version}}`, "", textFuncsTestJSON, false},
+	{"semverCompare missing", `{{semverCompare ">1" .nope}}`, "", textFuncsTestJSON, false},
+
+	// mask
+	{"mask email", `{{mask .email "email"}}`, "j***@example.com", textFuncsTestJSON, true},
+	{"mask email no at", `{{mask "jane" "email"}}`, "j***", textFuncsTestJSON, true},
+	{"mask phone", `{{mask .phone "phone"}}`, "+* (***) ***-4567", textFuncsTestJSON, true},
+	{"mask card", `{{mask .card "card"}}`, "**** **** **** 1111", textFuncsTestJSON, true},
+	{"mask number", `{{mask .id "card"}}`, "42", textFuncsTestJSON, true},
+	{"mask pattern", `{{mask .text "[aeiou]"}}`, "Th* q**ck br*wn f*x j*mps *v*r th* l*zy d*g", textFuncsTestJSON, true},
+	{"mask pattern unicode", `{{mask .city "ü.*"}}`, "Z*****", textFuncsTestJSON, true},
+	{"mask missing", `{{mask .nope "email"}}`, "", textFuncsTestJSON, true},
+	{"mask bad pattern", `{{mask .city "("}}`, "", textFuncsTestJSON, false},
+
+	// normalize and deaccent
+	{"normalize nfc", `{{len (normalize "Cre\u0300me")}}`, "6", textFuncsTestJSON, true},
+	{"normalize nfd", `{{len (normalize "Crème" "NFD")}}`, "7", textFuncsTestJSON, true},
+	{"normalize nfkc", `{{normalize "ﬁle" "nfkc"}}`, "file", textFuncsTestJSON, true},
+	{"normalize missing", `{{normalize .nope}}`, "", textFuncsTestJSON, true},
+	{"normalize bad form", `{{normalize "x" "NFX"}}`, "", textFuncsTestJSON, false},
+	{"deaccent", `{{deaccent "Crème Brûlée"}}`, "Creme Brulee", textFuncsTestJSON, true},
+	{"deaccent letters", `{{deaccent "Straße Øresund Łódź"}}`, "Strasse Oresund Lodz", textFuncsTestJSON, true},
+	{"deaccent field", `{{deaccent .city}}`, "Zurich", textFuncsTestJSON, true},
+	{"deaccent missing", `{{deaccent .nope}}`, "", textFuncsTestJSON, true},
+
+	// titleLocale
+	{"titleLocale tr", `{{titleLocale "istanbul ılık" "tr"}}`, "İstanbul Ilık", textFuncsTestJSON, true},
+	{"titleLocale tr upper", `{{titleLocale "İZMİR" "tr"}}`, "İzmir", textFuncsTestJSON, true},
+	{"titleLocale en", `{{titleLocale "istanbul" "en"}}`, "Istanbul", textFuncsTestJSON, true},
+	{"titleLocale nl", `{{titleLocale "ijssel" "nl"}}`, "IJssel", textFuncsTestJSON, true},
+	{"titleLocale missing", `{{titleLocale .nope "tr"}}`, "", textFuncsTestJSON, true},
+	{"titleLocale bad locale", `{{titleLocale "x" "not a tag"}}`, "", textFuncsTestJSON, false},
+
+	// levenshtein and similarity
+	{"levenshtein", `{{levenshtein "kitten" "sitting"}}`, "3", textFuncsTestJSON, true},
+	{"levenshtein equal", `{{levenshtein .city .city}}`, "0", textFuncsTestJSON, true},
+	{"levenshtein runes", `{{levenshtein "Zürich" "Zurich"}}`, "1", textFuncsTestJSON, true},
+	{"levenshtein empty", `{{levenshtein .nope "abc"}}`, "3", textFuncsTestJSON, true},
+	{"levenshtein number", `{{levenshtein 123 "124"}}`, "1", textFuncsTestJSON, true},
+	{"similarity", `{{similarity "color" "colour"}}`, "0.833333", textFuncsTestJSON, true},
+	{"similarity equal", `{{similarity "" .nope}}`, "1.000000", textFuncsTestJSON, true},
+	{"similarity disjoint", `{{similarity "abc" "xyz"}}`, "0.000000", textFuncsTestJSON, true},
+	{"similarity rank", `{{range .people}}{{if gt (similarity .name "Bob Jr") 0.8}}{{.name}}{{end}}{{end}}`, "Bob|Jr", textFuncsTestJSON, true},
+
+	// table
+	{"table", `{{table .people "name,age,city.name"}}`, "name          age  city.name\n" +
+		"------------  ---  ---------\n" +
+		"Ada Lovelace   36  London\n" +
+		"Bob|Jr          7\n" +
+		"Carol Ann     101  Zürich\n", textFuncsTestJSON, true},
+	{"table markdown", `{{table .people "name, age" "markdown" "maxwidth=8"}}`, "| name     | age |\n" +
+		"| -------- | --: |\n" +
+		"| Ada Lov… |  36 |\n" +
+		"| Bob\\|Jr  |   7 |\n" +
+		"| Carol A… | 101 |\n", textFuncsTestJSON, true},
+	{"table empty", `{{table .none "a,b"}}`, "a  b\n-  -\n", textFuncsTestJSON, true},
+	{"table no columns", `{{table .people " , "}}`, "", textFuncsTestJSON, false},
+	{"table bad option", `{{table .people "name" "maxwidth=0"}}`, "", textFuncsTestJSON, false},
+	{"table non-array", `{{table .city "name"}}`, "", textFuncsTestJSON, false},
+}
+
+func TestTextFuncs(t *testing.T) {
+	testGjsonExecute(t, textFuncsTests)
+}
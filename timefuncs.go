@@ -0,0 +1,301 @@
+// This file contains the builtins that operate on timestamps.
+
+package gjson_template
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// timeLayouts are the timestamp formats accepted in string values, tried in
+// order.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// timestamp is a time parsed from a JSON value, remembering how it was
+// written so that results can be written the same way.
+type timestamp struct {
+	time.Time
+	layout string // layout of a string value, or "" for a unix time
+}
+
+// parseTimestamp parses v, which must be a string in one of timeLayouts or a
+// number of seconds since the Unix epoch.
+func parseTimestamp(v gjson.Result) (timestamp, error) {
+	switch v.Type {
+	case gjson.Number:
+		sec, frac := math.Modf(v.Num)
+		return timestamp{Time: time.Unix(int64(sec), int64(frac*1e9)).UTC()}, nil
+	case gjson.String:
+		s := strings.TrimSpace(v.Str)
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return timestamp{Time: t, layout: layout}, nil
+			}
+		}
+		return timestamp{}, fmt.Errorf("cannot parse %q as an RFC 3339 timestamp", v.Str)
+	}
+	return timestamp{}, fmt.Errorf("%s is not a timestamp", describe(v))
+}
+
+// result returns t written in the same form as the value it was parsed from.
+func (t timestamp) result() gjson.Result {
+	if t.layout == "" {
+		if t.Nanosecond() == 0 {
+			return gjson.Parse(strconv.FormatInt(t.Unix(), 10))
+		}
+		return gjson.Parse(strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64))
+	}
+	return gjson.Parse(jsonString(t.Format(t.layout)))
+}
+
+// dayUnits matches the day and week units that time.ParseDuration lacks.
+var dayUnits = regexp.MustCompile(`([0-9]*\.?[0-9]+)([dw])`)
+
+// parseDuration parses v as a duration. Strings use the syntax of
+// time.ParseDuration extended with the units "d" (24h) and "w" (7d), such as
+// "1d12h"; numbers are a count of seconds.
+func parseDuration(v gjson.Result) (time.Duration, error) {
+	switch v.Type {
+	case gjson.Number:
+		return time.Duration(v.Num * float64(time.Second)), nil
+	case gjson.String:
+		s := dayUnits.ReplaceAllStringFunc(strings.TrimSpace(v.Str), func(m string) string {
+			sub := dayUnits.FindStringSubmatch(m)
+			n, _ := strconv.ParseFloat(sub[1], 64)
+			if sub[2] == "w" {
+				n *= 7
+			}
+			return strconv.FormatFloat(n*24, 'f', -1, 64) + "h"
+		})
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v.Str)
+		}
+		return d, nil
+	}
+	return 0, fmt.Errorf("%s is not a duration", describe(v))
+}
+
+// dateAdd returns the timestamp ts moved forward by the duration d. The
+// result is written in the same form as ts: an RFC 3339 string stays a
+// string in the same layout and zone, and a unix time stays a number.
+func dateAdd(ts, d gjson.Result) (gjson.Result, error) {
+	return shiftTimestamp("dateAdd", ts, d, 1)
+}
+
+// dateSub is like dateAdd but moves ts backward.
+func dateSub(ts, d gjson.Result) (gjson.Result, error) {
+	return shiftTimestamp("dateSub", ts, d, -1)
+}
+
+func shiftTimestamp(name string, ts, d gjson.Result, sign time.Duration) (gjson.Result, error) {
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return gjson.Result{}, fmt.Errorf("%s: %v", name, err)
+	}
+	dur, err := parseDuration(d)
+	if err != nil {
+		return gjson.Result{}, fmt.Errorf("%s: %v", name, err)
+	}
+	t.Time = t.Add(sign * dur)
+	return t.result(), nil
+}
+
+// durationUnits are the units accepted by dateDiff.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// dateDiff returns the time elapsed from start to end as a number of
+// seconds, or of the optional unit ("ms", "s", "m", "h", "d", "w", ...).
+// The result is negative if end is before start.
+func dateDiff(start, end gjson.Result, unit ...string) (gjson.Result, error) {
+	t0, err := parseTimestamp(start)
+	if err != nil {
+		return gjson.Result{}, fmt.Errorf("dateDiff: %v", err)
+	}
+	t1, err := parseTimestamp(end)
+	if err != nil {
+		return gjson.Result{}, fmt.Errorf("dateDiff: %v", err)
+	}
+	u := time.Second
+	if len(unit) > 1 {
+		return gjson.Result{}, fmt.Errorf("dateDiff takes at most one unit argument; got %d", len(unit))
+	}
+	if len(unit) == 1 {
+		var ok bool
+		if u, ok = durationUnits[unit[0]]; !ok {
+			return gjson.Result{}, fmt.Errorf("dateDiff: unknown unit %q", unit[0])
+		}
+	}
+	diff := float64(t1.Sub(t0.Time)) / float64(u)
+	return gjson.Parse(strconv.FormatFloat(diff, 'f', -1, 64)), nil
+}
+
+// inTimezone returns the timestamp ts converted to the IANA time zone zone,
+// such as "Asia/Shanghai" or "UTC", as an RFC 3339 string. The instant is
+// unchanged; only the wall clock and offset differ. Zones are looked up in
+// the system time zone database; programs running where there is none,
+// such as WebAssembly, should import time/tzdata.
+func inTimezone(ts gjson.Result, zone string) (string, error) {
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return "", fmt.Errorf("inTimezone: %v", err)
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return "", fmt.Errorf("inTimezone: unknown time zone %q", zone)
+	}
+	return t.In(loc).Format(time.RFC3339Nano), nil
+}
+
+// dateLocale formats the date of the timestamp ts for people reading the
+// language locale, a BCP 47 tag such as "en-US" or "zh-CN", with localized
+// month and weekday names and the locale's ordering of the fields. The
+// optional style is "short", "medium" (the default), "long", or "full".
+// Region tags without formats of their own use those of the language, so
+// "fr-CA" is written as "fr". The date is that of the timestamp's own zone.
+func dateLocale(ts gjson.Result, locale string, style ...string) (string, error) {
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return "", fmt.Errorf("dateLocale: %v", err)
+	}
+	if len(style) > 1 {
+		return "", fmt.Errorf("dateLocale takes at most one style argument; got %d", len(style))
+	}
+	s := "medium"
+	if len(style) == 1 {
+		s = style[0]
+	}
+	i := slices.Index(dateStyles, s)
+	if i < 0 {
+		return "", fmt.Errorf("dateLocale: unknown style %q", s)
+	}
+	tag := normalizeLang(locale)
+	f, ok := dateFormats[tag]
+	for !ok {
+		n := strings.LastIndexByte(tag, '-')
+		if n < 0 {
+			return "", fmt.Errorf("dateLocale: unsupported locale %q", locale)
+		}
+		tag = tag[:n]
+		f, ok = dateFormats[tag]
+	}
+	return f.format(t.Time, f.patterns[i]), nil
+}
+
+// dateStyles are the styles accepted by dateLocale, in the order of the
+// patterns of a dateFormat.
+var dateStyles = []string{"short", "medium", "long", "full"}
+
+// A dateFormat holds the names and patterns used to write dates in a
+// locale. In patterns, {d} and {dd} are the day of the month, {M} and {MM}
+// the month number, {MMM} and {MMMM} the abbreviated and full month name,
+// {yy} and {yyyy} the year, and {EEEE} the name of the weekday; the doubled
+// numeric forms are zero-padded.
+type dateFormat struct {
+	months      [12]string
+	shortMonths [12]string
+	weekdays    [7]string // starting with Sunday
+	patterns    [4]string // short, medium, long, full
+}
+
+var datePattern = regexp.MustCompile(`\{(d|dd|M|MM|MMM|MMMM|yy|yyyy|EEEE)\}`)
+
+func (f *dateFormat) format(t time.Time, pattern string) string {
+	return datePattern.ReplaceAllStringFunc(pattern, func(m string) string {
+		switch m {
+		case "{d}":
+			return strconv.Itoa(t.Day())
+		case "{dd}":
+			return fmt.Sprintf("%02d", t.Day())
+		case "{M}":
+			return strconv.Itoa(int(t.Month()))
+		case "{MM}":
+			return fmt.Sprintf("%02d", int(t.Month()))
+		case "{MMM}":
+			return f.shortMonths[t.Month()-1]
+		case "{MMMM}":
+			return f.months[t.Month()-1]
+		case "{yy}":
+			return fmt.Sprintf("%02d", t.Year()%100)
+		case "{yyyy}":
+			return strconv.Itoa(t.Year())
+		case "{EEEE}":
+			return f.weekdays[t.Weekday()]
+		}
+		return m
+	})
+}
+
+var (
+	englishMonths      = [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+	englishShortMonths = [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	englishWeekdays    = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	cjkMonths          = [12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"}
+)
+
+// dateFormats are the date formats of the locales supported by dateLocale,
+// following the Unicode CLDR.
+var dateFormats = map[string]*dateFormat{
+	"en": {
+		months:      englishMonths,
+		shortMonths: englishShortMonths,
+		weekdays:    englishWeekdays,
+		patterns:    [4]string{"{M}/{d}/{yy}", "{MMM} {d}, {yyyy}", "{MMMM} {d}, {yyyy}", "{EEEE}, {MMMM} {d}, {yyyy}"},
+	},
+	"en-gb": {
+		months:      englishMonths,
+		shortMonths: englishShortMonths,
+		weekdays:    englishWeekdays,
+		patterns:    [4]string{"{dd}/{MM}/{yyyy}", "{d} {MMM} {yyyy}", "{d} {MMMM} {yyyy}", "{EEEE} {d} {MMMM} {yyyy}"},
+	},
+	"de": {
+		months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		weekdays:    [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		patterns:    [4]string{"{dd}.{MM}.{yy}", "{dd}.{MM}.{yyyy}", "{d}. {MMMM} {yyyy}", "{EEEE}, {d}. {MMMM} {yyyy}"},
+	},
+	"es": {
+		months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		weekdays:    [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		patterns:    [4]string{"{d}/{M}/{yy}", "{d} {MMM} {yyyy}", "{d} de {MMMM} de {yyyy}", "{EEEE}, {d} de {MMMM} de {yyyy}"},
+	},
+	"fr": {
+		months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		weekdays:    [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		patterns:    [4]string{"{dd}/{MM}/{yyyy}", "{d} {MMM} {yyyy}", "{d} {MMMM} {yyyy}", "{EEEE} {d} {MMMM} {yyyy}"},
+	},
+	"ja": {
+		months:      cjkMonths,
+		shortMonths: cjkMonths,
+		weekdays:    [7]string{"日曜日", "月曜日", "火曜日", "水曜日", "木曜日", "金曜日", "土曜日"},
+		patterns:    [4]string{"{yyyy}/{MM}/{dd}", "{yyyy}/{MM}/{dd}", "{yyyy}年{M}月{d}日", "{yyyy}年{M}月{d}日{EEEE}"},
+	},
+	"zh": {
+		months:      cjkMonths,
+		shortMonths: cjkMonths,
+		weekdays:    [7]string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"},
+		patterns:    [4]string{"{yyyy}/{M}/{d}", "{yyyy}年{M}月{d}日", "{yyyy}年{M}月{d}日", "{yyyy}年{M}月{d}日{EEEE}"},
+	},
+}
@@ -0,0 +1,63 @@
+package gjson_template
+
+import "testing"
+
+// JSON data for the date and time builtins
+var timeFuncsTestJSON = []byte(`{
+	"issued": "2025-03-30T10:00:00Z",
+	"issuedLocal": "2025-03-30T10:00:00+08:00",
+	"issuedUnix": 1743328800,
+	"day": "2025-03-30",
+	"ttl": 3600,
+	"expires": "2025-04-06T10:00:00Z"
+}`)
+
+var timeFuncsTests = []gjsonExecTest{
+	// dateAdd, dateSub
+	{"dateAdd", `{{dateAdd .issued "90m"}}`, "2025-03-30T11:30:00Z", timeFuncsTestJSON, true},
+	{"dateAdd seconds", `{{dateAdd .issued .ttl}}`, "2025-03-30T11:00:00Z", timeFuncsTestJSON, true},
+	{"dateAdd days", `{{dateAdd .issued "1d12h"}}`, "2025-03-31T22:00:00Z", timeFuncsTestJSON, true},
+	{"dateAdd weeks", `{{dateAdd .day "2w"}}`, "2025-04-13", timeFuncsTestJSON, true},
+	{"dateAdd keeps zone", `{{dateAdd .issuedLocal "1h"}}`, "2025-03-30T11:00:00+08:00", timeFuncsTestJSON, true},
+	{"dateAdd unix", `{{dateAdd .issuedUnix .ttl}}`, "1743332400", timeFuncsTestJSON, true},
+	{"dateAdd negative", `{{dateAdd .issued "-1d"}}`, "2025-03-29T10:00:00Z", timeFuncsTestJSON, true},
+	{"dateSub", `{{dateSub .issued "30s"}}`, "2025-03-30T09:59:30Z", timeFuncsTestJSON, true},
+	{"dateAdd bad timestamp", `{{dateAdd "yesterday" "1h"}}`, "", timeFuncsTestJSON, false},
+	{"dateAdd bad duration", `{{dateAdd .issued "soon"}}`, "", timeFuncsTestJSON, false},
+
+	// dateDiff
+	{"dateDiff", `{{dateDiff .issued .expires}}`, "604800", timeFuncsTestJSON, true},
+	{"dateDiff unit", `{{dateDiff .issued .expires "d"}}`, "7", timeFuncsTestJSON, true},
+	{"dateDiff fraction", `{{dateDiff .issued "2025-03-30T11:30:00Z" "h"}}`, "1.5", timeFuncsTestJSON, true},
+	{"dateDiff mixed", `{{dateDiff .issuedUnix .issued}}`, "0", timeFuncsTestJSON, true},
+	{"dateDiff zones", `{{dateDiff .issuedLocal .issued "h"}}`, "8", timeFuncsTestJSON, true},
+	{"dateDiff negative", `{{dateDiff .expires .issued "w"}}`, "-1", timeFuncsTestJSON, true},
+	{"dateDiff compare", `{{if gt (dateDiff .issued .expires) 0.0}}valid{{end}}`, "valid", timeFuncsTestJSON, true},
+	{"dateDiff bad unit", `{{dateDiff .issued .expires "fortnight"}}`, "", timeFuncsTestJSON, false},
+
+	// inTimezone
+	{"inTimezone", `{{inTimezone .issued "Asia/Shanghai"}}`, "2025-03-30T18:00:00+08:00", timeFuncsTestJSON, true},
+	{"inTimezone utc", `{{inTimezone .issuedLocal "UTC"}}`, "2025-03-30T02:00:00Z", timeFuncsTestJSON, true},
+	{"inTimezone unix", `{{inTimezone .issuedUnix "America/New_York"}}`, "2025-03-30T06:00:00-04:00", timeFuncsTestJSON, true},
+	{"inTimezone unknown zone", `{{inTimezone .issued "Mars/Olympus"}}`, "", timeFuncsTestJSON, false},
+
+	// dateLocale
+	{"dateLocale", `{{dateLocale .issued "en-US"}}`, "Mar 30, 2025", timeFuncsTestJSON, true},
+	{"dateLocale short", `{{dateLocale .issued "en" "short"}}`, "3/30/25", timeFuncsTestJSON, true},
+	{"dateLocale full", `{{dateLocale .issued "en" "full"}}`, "Sunday, March 30, 2025", timeFuncsTestJSON, true},
+	{"dateLocale en-GB", `{{dateLocale .issued "en-GB" "long"}}`, "30 March 2025", timeFuncsTestJSON, true},
+	{"dateLocale zh-CN", `{{dateLocale .issued "zh-CN" "long"}}`, "2025年3月30日", timeFuncsTestJSON, true},
+	{"dateLocale zh full", `{{dateLocale .issued "zh_Hans_CN" "full"}}`, "2025年3月30日星期日", timeFuncsTestJSON, true},
+	{"dateLocale ja", `{{dateLocale .day "ja" "short"}}`, "2025/03/30", timeFuncsTestJSON, true},
+	{"dateLocale de", `{{dateLocale .issued "de-DE" "full"}}`, "Sonntag, 30. März 2025", timeFuncsTestJSON, true},
+	{"dateLocale fr", `{{dateLocale .issued "fr-CA" "medium"}}`, "30 mars 2025", timeFuncsTestJSON, true},
+	{"dateLocale es", `{{dateLocale .issued "es" "long"}}`, "30 de marzo de 2025", timeFuncsTestJSON, true},
+	{"dateLocale zone", `{{dateLocale "2025-03-30T23:30:00-05:00" "en" "long"}}`, "March 30, 2025", timeFuncsTestJSON, true},
+	{"dateLocale unix", `{{dateLocale .issuedUnix "en" "short"}}`, "3/30/25", timeFuncsTestJSON, true},
+	{"dateLocale unknown locale", `{{dateLocale .issued "tlh"}}`, "", timeFuncsTestJSON, false},
+	{"dateLocale unknown style", `{{dateLocale .issued "en" "tiny"}}`, "", timeFuncsTestJSON, false},
+}
+
+func TestTimeFuncs(t *testing.T) {
+	testGjsonExecute(t, timeFuncsTests)
+}
@@ -0,0 +1,106 @@
+// This file contains the tracing of executions and template invocations.
+
+package gjson_template
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/higress-group/gjson_template/parse"
+	"github.com/tidwall/gjson"
+)
+
+// A Tracer traces the executions of templates and their {{template}}
+// invocations, for example as OpenTelemetry spans; the oteltemplate module
+// provides one. StartSpan starts a span for the execution of the template
+// named name, or for an invocation of it if invoked is true, as a child of
+// the span in ctx. It returns the context of the functions called and
+// templates invoked within the span, and a function ending the span with
+// the statistics of the execution or invocation. StartSpan may be called
+// concurrently by executions in different goroutines.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, invoked bool) (context.Context, func(ExecStats))
+}
+
+// SetTracer sets the tracer of the executions of the template and the
+// templates associated with it, and of their {{template}} invocations, or
+// removes it if tr is nil. The executions are children of the span in the
+// context given to ExecuteContext. The tracer must be set before the
+// templates are executed. The return value is the template, so calls can
+// be chained.
+func (t *Template) SetTracer(tr Tracer) *Template {
+	t.init()
+	t.tracer = tr
+	return t
+}
+
+// trace executes t as execute does within a span of the tracer.
+func (t *Template) trace(wr io.Writer, data []byte, x execOptions) error {
+	x.traced = true
+	if x.ctx == nil {
+		x.ctx = context.Background()
+	}
+	ctx, end := t.tracer.StartSpan(x.ctx, t.Name(), false)
+	x.ctx = ctx
+	var n func() int
+	switch {
+	case x.doc != nil:
+		n = func() int { return len(*x.doc) }
+	case x.smap != nil:
+		start := x.smap.w.n
+		n = func() int { return x.smap.w.n - start }
+	default:
+		cw := &countingWriter{w: wr}
+		wr = cw
+		n = func() int { return cw.n }
+	}
+	dataBytes := len(data)
+	if x.dot != nil {
+		dataBytes = len(x.dot.Raw)
+	}
+	start := time.Now()
+	err := t.execute(wr, data, x)
+	end(ExecStats{
+		Template:  t.Name(),
+		Duration:  time.Since(start),
+		Bytes:     n(),
+		DataBytes: dataBytes,
+		Err:       err,
+	})
+	return err
+}
+
+// traceTemplate walks the invoked template tmpl with the state s, which
+// is set up for the invocation, within a span of the tracer.
+func (s *state) traceTemplate(dot gjson.Result, tmpl *Template, root *parse.ListNode) {
+	ctx, end := s.tmpl.tracer.StartSpan(s.ctx, tmpl.Name(), true)
+	s.ctx = ctx
+	// The source map relies on the identity of its counting writer.
+	cw, ok := s.wr.(*countingWriter)
+	if !ok {
+		cw = &countingWriter{w: s.wr}
+		s.wr = cw
+	}
+	startBytes, start := cw.n, time.Now()
+	defer func() {
+		stats := ExecStats{
+			Template:  tmpl.Name(),
+			Duration:  time.Since(start),
+			Bytes:     cw.n - startBytes,
+			DataBytes: len(dot.Raw),
+		}
+		r := recover()
+		switch e := r.(type) {
+		case ExecError:
+			stats.Err = e
+		case writeError:
+			stats.Err = e.Err
+		}
+		end(stats)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	s.walk(dot, root)
+}
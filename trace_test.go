@@ -0,0 +1,78 @@
+package gjson_template
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// spanKey is the context key of the name of the current span.
+type spanKey struct{}
+
+// recordingTracer records the spans it ends, as "parent>name invoked bytes/dataBytes err".
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+func (r *recordingTracer) StartSpan(ctx context.Context, name string, invoked bool) (context.Context, func(ExecStats)) {
+	parent, _ := ctx.Value(spanKey{}).(string)
+	return context.WithValue(ctx, spanKey{}, name), func(s ExecStats) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.spans = append(r.spans, fmt.Sprintf("%s>%s %t %d/%d %v", parent, s.Template, invoked, s.Bytes, s.DataBytes, s.Err != nil))
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tr := &recordingTracer{}
+	tmpl := Must(New("page").SetTracer(tr).Option("missingkey=error").Funcs(FuncMap{
+		"span": func(ctx context.Context) string { s, _ := ctx.Value(spanKey{}).(string); return s },
+	}).Parse(`{{define "item"}}[{{.}}|{{span}}]{{end}}{{range .items}}{{template "item" .}}{{end}}{{span}}`))
+
+	ctx := context.WithValue(context.Background(), spanKey{}, "request")
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteContext(ctx, &buf, []byte(`{"items":["a","bc"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[a|item][bc|item]page"; buf.String() != want {
+		t.Errorf("expected %q; got %q", want, buf.String())
+	}
+	want := []string{
+		"page>item true 8/3 false",
+		"page>item true 9/4 false",
+		"request>page false 21/20 false",
+	}
+	if !slices.Equal(tr.spans, want) {
+		t.Errorf("expected spans\n\t%q\ngot\n\t%q", want, tr.spans)
+	}
+
+	tr.spans = nil
+	err := Must(tmpl.New("bad").Parse(`x{{template "item" .missing}}`)).Execute(&buf, []byte(`{}`))
+	if !errors.Is(err, ErrMissingPath) {
+		t.Fatalf("expected ErrMissingPath; got %v", err)
+	}
+	if want := []string{">bad false 1/2 true"}; !slices.Equal(tr.spans, want) {
+		t.Errorf("expected spans %q; got %q", want, tr.spans)
+	}
+
+	tr.spans = nil
+	err = Must(tmpl.New("inner").Parse(`{{define "fail"}}{{.nope}}{{end}}{{template "fail" .}}`)).Execute(&buf, []byte(`{}`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if want := []string{"inner>fail true 0/2 true", ">inner false 0/2 true"}; !slices.Equal(tr.spans, want) {
+		t.Errorf("expected spans %q; got %q", want, tr.spans)
+	}
+
+	// Spans of source-mapped executions keep the output mapped.
+	tr.spans = nil
+	m, err := tmpl.ExecuteSourceMap(&buf, []byte(`{"items":["a"]}`))
+	if err != nil || len(m) == 0 || m[0].Template != "item" {
+		t.Errorf("source map: got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,212 @@
+// This file contains the registration of typed functions, which are called
+// without reflection.
+
+package gjson_template
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// Value is the set of Go types that functions registered with
+// [RegisterFunc1] and its variants take and return. Arguments are
+// converted from JSON as follows: a bool needs a JSON boolean, an int,
+// int64 or float64 needs a JSON number (an integer one for int and int64),
+// a string gets the text of a JSON string or the raw JSON of any other
+// value, and a [gjson.Result] gets the value as is. Results are converted
+// back to JSON the same way as those of [FuncMap] functions.
+type Value interface {
+	bool | int | int64 | float64 | string | gjson.Result
+}
+
+// A typedFunc is a registered typed function, adapted to take and return
+// JSON values. Its result is one of the Value types.
+type typedFunc func(args []gjson.Result) (any, error)
+
+// RegisterFunc0 adds to t the function fn, which takes no arguments, under
+// the given name. It returns t, so calls can be chained.
+func RegisterFunc0[R Value](t *Template, name string, fn func() R) *Template {
+	return t.addTypedFunc(name, fn, func(args []gjson.Result) (any, error) {
+		if err := checkArgCount(args, 0); err != nil {
+			return nil, err
+		}
+		return fn(), nil
+	})
+}
+
+// RegisterFunc1 adds to t the function fn, which takes one argument, under
+// the given name. Unlike functions added with [Template.Funcs], fn is
+// called without reflection, and its signature is checked by the compiler:
+//
+//	gjson_template.RegisterFunc1(tmpl, "double", func(n int) int { return 2 * n })
+//
+// It returns t, so calls can be chained.
+func RegisterFunc1[T1, R Value](t *Template, name string, fn func(T1) R) *Template {
+	return t.addTypedFunc(name, fn, func(args []gjson.Result) (any, error) {
+		if err := checkArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		a1, err := fromJSON[T1](args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(a1), nil
+	})
+}
+
+// RegisterFunc2 adds to t the function fn, which takes two arguments, under
+// the given name. It returns t, so calls can be chained.
+func RegisterFunc2[T1, T2, R Value](t *Template, name string, fn func(T1, T2) R) *Template {
+	return t.addTypedFunc(name, fn, func(args []gjson.Result) (any, error) {
+		if err := checkArgCount(args, 2); err != nil {
+			return nil, err
+		}
+		a1, err := fromJSON[T1](args, 0)
+		if err != nil {
+			return nil, err
+		}
+		a2, err := fromJSON[T2](args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fn(a1, a2), nil
+	})
+}
+
+// RegisterFunc3 adds to t the function fn, which takes three arguments,
+// under the given name. It returns t, so calls can be chained.
+func RegisterFunc3[T1, T2, T3, R Value](t *Template, name string, fn func(T1, T2, T3) R) *Template {
+	return t.addTypedFunc(name, fn, func(args []gjson.Result) (any, error) {
+		if err := checkArgCount(args, 3); err != nil {
+			return nil, err
+		}
+		a1, err := fromJSON[T1](args, 0)
+		if err != nil {
+			return nil, err
+		}
+		a2, err := fromJSON[T2](args, 1)
+		if err != nil {
+			return nil, err
+		}
+		a3, err := fromJSON[T3](args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fn(a1, a2, a3), nil
+	})
+}
+
+// addTypedFunc adds the typed function fn, adapted as call, to t. It
+// replaces any function of the same name added with Funcs.
+func (t *Template) addTypedFunc(name string, fn any, call typedFunc) *Template {
+	if !goodName(name) {
+		panic(fmt.Errorf("function name %q is not a valid identifier", name))
+	}
+	t.init()
+	t.muFuncs.Lock()
+	defer t.muFuncs.Unlock()
+	if t.typedFuncs == nil {
+		t.typedFuncs = make(map[string]typedFunc)
+	}
+	t.typedFuncs[name] = call
+	t.parseFuncs[name] = fn
+	delete(t.execFuncs, name)
+	return t
+}
+
+// findTypedFunc returns the typed function with the given name, or nil.
+func (t *Template) findTypedFunc(name string) typedFunc {
+	if t.common == nil {
+		return nil
+	}
+	t.muFuncs.RLock()
+	defer t.muFuncs.RUnlock()
+	return t.typedFuncs[name]
+}
+
+// safeTypedCall runs fn, and recovers from a panic in it, returning it
+// as an error.
+func safeTypedCall(fn typedFunc, args []gjson.Result) (v any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	return fn(args)
+}
+
+// checkArgCount returns an error if args does not have n elements.
+func checkArgCount(args []gjson.Result, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("wrong number of args: want %d got %d", n, len(args))
+	}
+	return nil
+}
+
+// fromJSON converts args[i] to the Go type T.
+func fromJSON[T Value](args []gjson.Result, i int) (T, error) {
+	var x T
+	v := args[i]
+	bad := func(want string) (T, error) {
+		return x, errorOfKind(ErrTypeMismatch, fmt.Errorf("arg %d: expected %s; got %s", i, want, describe(v)))
+	}
+	switch p := any(&x).(type) {
+	case *bool:
+		if v.Type != gjson.True && v.Type != gjson.False {
+			return bad("boolean")
+		}
+		*p = v.Bool()
+	case *int:
+		if v.Type != gjson.Number || v.Num != float64(int(v.Num)) {
+			return bad("integer")
+		}
+		*p = int(v.Int())
+	case *int64:
+		if v.Type != gjson.Number || v.Num != float64(int64(v.Num)) {
+			return bad("integer")
+		}
+		*p = v.Int()
+	case *float64:
+		if v.Type != gjson.Number {
+			return bad("number")
+		}
+		*p = v.Num
+	case *string:
+		if v.Type == gjson.String {
+			*p = v.Str
+		} else {
+			*p = v.Raw
+		}
+	case *gjson.Result:
+		*p = v
+	}
+	return x, nil
+}
+
+// typedResult converts the result of a typed function to JSON.
+func (s *state) typedResult(v any) gjson.Result {
+	switch v := v.(type) {
+	case bool:
+		return gjson.Parse(strconv.FormatBool(v))
+	case int:
+		return gjson.Parse(strconv.Itoa(v))
+	case int64:
+		return gjson.Parse(strconv.FormatInt(v, 10))
+	case float64:
+		if f := s.tmpl.option.floatFmt; f != nil {
+			return gjson.Parse(f.format(v))
+		}
+		return gjson.Parse(fmt.Sprintf("%f", v))
+	case string:
+		return gjson.Parse(jsonString(v))
+	case gjson.Result:
+		return v
+	}
+	return gjson.Result{}
+}
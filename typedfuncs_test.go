@@ -0,0 +1,85 @@
+package gjson_template
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+var typedFuncsTestJSON = []byte(`{"n": 21, "x": 1.5, "s": "go", "ok": true, "obj": {"a": 1}, "list": [1, 2, 3]}`)
+
+func newTypedFuncsTemplate(name string) *Template {
+	tmpl := New(name)
+	RegisterFunc0(tmpl, "answer", func() int { return 42 })
+	RegisterFunc1(tmpl, "double", func(n int) int { return 2 * n })
+	RegisterFunc1(tmpl, "half", func(x float64) float64 { return x / 2 })
+	RegisterFunc1(tmpl, "shout", func(s string) string { return strings.ToUpper(s) + "!" })
+	RegisterFunc1(tmpl, "flip", func(b bool) bool { return !b })
+	RegisterFunc1(tmpl, "count", func(v gjson.Result) int64 { return int64(len(v.Array())) })
+	RegisterFunc2(tmpl, "repeatStr", func(s string, n int) string { return strings.Repeat(s, n) })
+	RegisterFunc3(tmpl, "clamp", func(x, lo, hi float64) float64 { return max(lo, min(x, hi)) })
+	RegisterFunc1(tmpl, "boom", func(s string) string { panic("boom: " + s) })
+	return tmpl
+}
+
+func TestTypedFuncs(t *testing.T) {
+	tests := []gjsonExecTest{
+		{"no args", `{{answer}}`, "42", typedFuncsTestJSON, true},
+		{"int", `{{double .n}}`, "42", typedFuncsTestJSON, true},
+		{"piped", `{{.n | double | double}}`, "84", typedFuncsTestJSON, true},
+		{"float", `{{half .x}}`, "0.750000", typedFuncsTestJSON, true},
+		{"string", `{{shout .s}}`, "GO!", typedFuncsTestJSON, true},
+		{"raw JSON as string", `{{shout .obj}}`, `{"A": 1}!`, typedFuncsTestJSON, true},
+		{"bool", `{{flip .ok}}`, "false", typedFuncsTestJSON, true},
+		{"gjson.Result", `{{count .list}}`, "3", typedFuncsTestJSON, true},
+		{"two args", `{{repeatStr .s 3}}`, "gogogo", typedFuncsTestJSON, true},
+		{"three args", `{{clamp 7 0 .x}}`, "1.500000", typedFuncsTestJSON, true},
+		{"result to builtin", `{{double .n | printf "%03d"}}`, "042", typedFuncsTestJSON, true},
+		{"wrong count", `{{double 1 2}}`, "", typedFuncsTestJSON, false},
+		{"not an integer", `{{double .x}}`, "", typedFuncsTestJSON, false},
+		{"not a number", `{{half .s}}`, "", typedFuncsTestJSON, false},
+		{"not a bool", `{{flip 1}}`, "", typedFuncsTestJSON, false},
+		{"panic", `{{boom "x"}}`, "", typedFuncsTestJSON, false},
+	}
+	for _, test := range tests {
+		tmpl, err := newTypedFuncsTemplate(test.name).Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: parse error: %s", test.name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, test.data)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error; got none", test.name)
+		case test.ok && err != nil:
+			t.Errorf("%s: unexpected execute error: %s", test.name, err)
+		case test.ok && buf.String() != test.output:
+			t.Errorf("%s: expected %q; got %q", test.name, test.output, buf.String())
+		}
+	}
+}
+
+func TestTypedFuncReplacesFuncs(t *testing.T) {
+	tmpl := New("replace").Funcs(FuncMap{"f": func() string { return "funcs" }})
+	RegisterFunc0(tmpl, "f", func() string { return "typed" })
+	clone, err := tmpl.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone.Funcs(FuncMap{"f": func() string { return "funcs again" }})
+	for _, test := range []struct {
+		tmpl *Template
+		want string
+	}{{tmpl, "typed"}, {clone, "funcs again"}} {
+		var buf bytes.Buffer
+		if err := Must(test.tmpl.Parse(`{{f}}`)).Execute(&buf, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("expected %q; got %q", test.want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// This file contains the variables given to executions.
+
+package gjson_template
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// DeclareVars declares variables, such as "traceID" or "$traceID", that
+// executions give to the templates with [Template.ExecuteWithVars], so
+// that Parse accepts uses of them such as {{$traceID}}. They must be
+// declared before the templates using them are parsed. The variables are
+// defined in all the templates of an execution, including those invoked
+// with {{template}} and macros, and are missing values in executions that
+// do not give them. The return value is the template, so calls can be
+// chained.
+func (t *Template) DeclareVars(names ...string) *Template {
+	t.init()
+	for _, name := range names {
+		name = "$" + strings.TrimPrefix(name, "$")
+		if !goodName(name[1:]) {
+			panic(fmt.Sprintf("template: DeclareVars: %q is not a valid variable name", name))
+		}
+		if !slices.Contains(t.vars, name) {
+			t.vars = append(t.vars, name)
+		}
+	}
+	return t
+}
+
+// ExecuteWithVars is like Execute, but defines the variables of vars, such
+// as per-request values like a trace ID or tenant, without them being
+// added to the data. It is shorthand for [Template.ExecuteWithOptions]
+// with the Vars of [ExecOptions], which can be combined with the other
+// settings of an execution.
+func (t *Template) ExecuteWithVars(wr io.Writer, data []byte, vars map[string]any) error {
+	return t.ExecuteWithOptions(wr, data, ExecOptions{Vars: vars})
+}
+
+// givenVars returns the variables of vars given to an execution. The keys
+// must be the names of variables declared with DeclareVars, and the values
+// are converted to JSON with encoding/json, unless they are gjson.Results.
+func (t *Template) givenVars(vars map[string]any) ([]variable, error) {
+	if len(vars) == 0 {
+		return nil, nil
+	}
+	given := make([]variable, 0, len(vars))
+	for _, key := range slices.Sorted(maps.Keys(vars)) {
+		name := "$" + strings.TrimPrefix(key, "$")
+		switch {
+		case !goodName(name[1:]):
+			return nil, fmt.Errorf("template: %s: %q is not a valid variable name", t.Name(), key)
+		case t.common == nil || !slices.Contains(t.vars, name):
+			return nil, fmt.Errorf("template: %s: variable %s is not declared with DeclareVars", t.Name(), name)
+		}
+		value, err := varResult(vars[key])
+		if err != nil {
+			return nil, fmt.Errorf("template: %s: variable %s: %w", t.Name(), key, err)
+		}
+		given = append(given, variable{name, value})
+	}
+	return given, nil
+}
+
+// varResult returns the JSON value of a variable given to an execution.
+func varResult(v any) (gjson.Result, error) {
+	if r, ok := v.(gjson.Result); ok {
+		return r, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	return gjson.ParseBytes(b), nil
+}
+
+// execVars returns the variables of an execution, besides $: those given
+// to it, and the other variables declared with DeclareVars, as missing
+// values.
+func (t *Template) execVars(given []variable) []variable {
+	if t.common == nil || len(t.vars) == 0 && len(given) == 0 {
+		return nil
+	}
+	vars := slices.Clone(given)
+	for _, name := range t.vars {
+		if !slices.ContainsFunc(given, func(v variable) bool { return v.name == name }) {
+			vars = append(vars, variable{name: name})
+		}
+	}
+	return vars
+}
@@ -0,0 +1,90 @@
+package gjson_template
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestExecuteWithVars(t *testing.T) {
+	tmpl := Must(New("page").DeclareVars("traceID", "$tenant").Parse(
+		`{{define "footer"}}[{{$traceID}}]{{end}}` +
+			`{{macro "who"}}{{$tenant.name}}{{end}}` +
+			`{{.msg}} {{call "who"}} {{if $tenant}}yes{{else}}no{{end}}{{template "footer"}}`))
+	tests := []struct {
+		vars map[string]any
+		want string
+	}{
+		{map[string]any{"traceID": "abc", "tenant": map[string]string{"name": "acme"}}, "hi acme yes[abc]"},
+		{map[string]any{"$traceID": 7, "tenant": gjson.Parse(`{"name":"x"}`)}, "hi x yes[7]"},
+		{map[string]any{"traceID": "abc"}, "hi  no[abc]"},
+		{nil, "hi  no[]"},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := tmpl.ExecuteWithVars(&b, []byte(`{"msg":"hi"}`), test.vars); err != nil {
+			t.Errorf("%v: %v", test.vars, err)
+			continue
+		}
+		if b.String() != test.want {
+			t.Errorf("%v: got %q; want %q", test.vars, b.String(), test.want)
+		}
+	}
+	if out, err := tmpl.ExecuteString([]byte(`{"msg":"hi"}`)); err != nil || out != "hi  no[]" {
+		t.Errorf("Execute: got %q, %v", out, err)
+	}
+
+	for _, test := range []struct {
+		vars map[string]any
+		err  string
+	}{
+		{map[string]any{"traceID": make(chan int)}, "variable traceID"},
+		{map[string]any{"trace-id": 1}, `"trace-id" is not a valid variable name`},
+		{map[string]any{"$": 1}, `"$" is not a valid variable name`},
+		{map[string]any{"user": 1}, "variable $user is not declared"},
+	} {
+		err := tmpl.ExecuteWithVars(&strings.Builder{}, []byte(`{}`), test.vars)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error %q; got %v", test.vars, test.err, err)
+		}
+	}
+}
+
+// TestExecOptionsVars tests that variables combine with the other settings
+// of an execution.
+func TestExecOptionsVars(t *testing.T) {
+	tmpl := Must(New("page").DeclareVars("traceID").Parse(`[{{$traceID}}] {{.msg}}`))
+	strict := true
+	var b strings.Builder
+	err := tmpl.ExecuteWithOptions(&b, []byte(`{"msg":"hi"}`), ExecOptions{Vars: map[string]any{"traceID": "abc"}, StrictMode: &strict})
+	if err != nil || b.String() != "[abc] hi" {
+		t.Errorf("got %q, %v", b.String(), err)
+	}
+	err = tmpl.ExecuteWithOptions(&strings.Builder{}, []byte(`{}`), ExecOptions{Vars: map[string]any{"traceID": "abc"}, StrictMode: &strict})
+	if !errors.Is(err, ErrMissingPath) {
+		t.Errorf("strict: expected ErrMissingPath; got %v", err)
+	}
+}
+
+func TestDeclareVars(t *testing.T) {
+	if _, err := New("x").Parse(`{{$traceID}}`); err == nil {
+		t.Error("expected error for undeclared variable")
+	}
+	tmpl := New("x").DeclareVars("traceID")
+	clone := Must(tmpl.Clone())
+	if _, err := clone.Parse(`{{$traceID}}`); err != nil {
+		t.Errorf("clone: %v", err)
+	}
+	for _, name := range []string{"$", "", "a-b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected panic", name)
+				}
+			}()
+			New("x").DeclareVars(name)
+		}()
+	}
+}